@@ -21,13 +21,431 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+const (
+	// Label with the name of the stack the objects created for it belong to
+	StackLabel = "stack.tf-operator.io"
+
+	// Label with the content hash of the immutable ConfigMaps holding a
+	// revision of a stack's configuration
+	ConfigHashLabel = "tfconfig.tf-operator.io/hash"
+
+	// Label with the content hash of the immutable Secrets holding a revision
+	// of a stack's tfvars
+	TfVarsHashLabel = "tfvars.tf-operator.io/hash"
+
+	// Label with the number of the stack's run executed by a Job, set in the
+	// Job, its pods and the ConfigMap archiving its log
+	RunLabel = "run.tf-operator.io/number"
+
+	// Label with the number of the version of a stack's state held by a
+	// Secret in its state history
+	StateVersionLabel = "tfstate.tf-operator.io/version"
+
+	// Finalizer for destroying the stack's resources before deleting it
+	StackFinalizer = "tf.tf-operator.io/destroy"
+
+	// Annotation for deleting a stack without destroying its resources
+	OrphanResourcesAnnotation = "tf.tf-operator.io/orphan-resources"
+
+	// Annotation for deleting the ConfigMaps and Secrets with the stack's
+	// configuration and tfvars revisions when the stack is deleted. Otherwise,
+	// they are kept.
+	CascadeAnnotation = "tf.tf-operator.io/cascade"
+
+	// Annotation requesting a single run of the stack with the options of a
+	// RunRequest, as json. It is removed once the run starts
+	RunRequestAnnotation = "tf.tf-operator.io/run-request"
+)
+
 // StackSpec defines the desired state of Stack
 type StackSpec struct {
-	// Reference to the config map with the configuration file(s)
-	TfConfig corev1.LocalObjectReference `json:"tfconfig"`
+	// Reference to the config map with the configuration file(s).
+	// Either tfconfig or source must be specified
+	// +optional
+	TfConfig corev1.LocalObjectReference `json:"tfconfig,omitempty"`
+
+	// Remote source of the configuration file(s), as an alternative to tfconfig
+	// +optional
+	Source *StackSource `json:"source,omitempty"`
 
 	// Reference to secrect with tfvars
 	TfVars corev1.LocalObjectReference `json:"tfvars"`
+
+	// Stacks that must be Ready before this stack is applied
+	// +optional
+	DependsOn []corev1.LocalObjectReference `json:"dependsOn,omitempty"`
+
+	// Sources of variables injected in the stack in addition to the tfvars
+	// +optional
+	VarsFrom []VarsFromSource `json:"varsFrom,omitempty"`
+
+	// Number of configuration and tfvars revisions kept for rolling back.
+	// Defaults to 10
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+
+	// Number of versions of the stack's state kept for rolling back.
+	// Defaults to 10
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	StateHistoryLimit *int32 `json:"stateHistoryLimit,omitempty"`
+
+	// Existing resources to import into the stack's state before planning,
+	// as resource addresses mapped to their IDs in the provider. Resources
+	// already in the state are not imported
+	// +optional
+	Imports map[string]string `json:"imports,omitempty"`
+
+	// Run terraform plan instead of apply, without changing the stack's
+	// resources. Stacks adopting existing resources are plan-only until
+	// their plan is confirmed
+	// +optional
+	PlanOnly bool `json:"planOnly,omitempty"`
+
+	// Encryption at rest of the stack's state, its versions and its
+	// sensitive outputs. Requires the manager's state backend, as the runs
+	// can't decrypt the state, unless the stack configures its own backend,
+	// which then keeps the state
+	// +optional
+	Encryption *StackEncryption `json:"encryption,omitempty"`
+
+	// Terraform backend keeping the stack's state, such as s3 or pg, instead
+	// of the stack's tfstate Secret. The history and locks of the state are
+	// then the backend's
+	// +optional
+	Backend *StackBackend `json:"backend,omitempty"`
+
+	// Terraform workspace of the stack's state, selected before planning and
+	// created if it doesn't exist, so stacks sharing a configuration keep
+	// separate states. The configuration gets it as terraform.workspace. Not
+	// supported by the manager's state backend
+	// +optional
+	Workspace string `json:"workspace,omitempty"`
+}
+
+// StackEncryption defines the keys encrypting the stack's state and sensitive
+// outputs. Each is encrypted with AES-256-GCM using its own data key, stored
+// with it wrapped by a key encryption key.
+type StackEncryption struct {
+	// Reference to a Secret with the key encryption keys, each of 32 bytes
+	// with its ID as key, and the ID of the one wrapping new data keys in
+	// the "active" key. The other keys unwrap the data keys wrapped before
+	// rotating the active key
+	KeySecret corev1.LocalObjectReference `json:"keySecret"`
+}
+
+// StackBackend defines the terraform backend keeping the stack's state. The
+// runs use it through a backend override, initialized with its settings.
+type StackBackend struct {
+	// Type of the backend, such as s3 or pg
+	// +kubebuilder:validation:MinLength=1
+	Type string `json:"type"`
+
+	// Settings of the backend, passed to terraform init as backend config
+	// +optional
+	Config map[string]string `json:"config,omitempty"`
+
+	// Settings of the backend taken from Secrets, such as its credentials
+	// +optional
+	ConfigFrom []BackendConfigSource `json:"configFrom,omitempty"`
+}
+
+// BackendConfigSource takes a setting of a backend from a Secret
+type BackendConfigSource struct {
+	// Name of the setting
+	Name string `json:"name"`
+
+	// Key of a Secret in the stack's namespace with the setting's value
+	SecretKeyRef corev1.SecretKeySelector `json:"secretKeyRef"`
+}
+
+// StackSource defines a remote source for the configuration files.
+// Only one type of source can be specified
+type StackSource struct {
+	// Git repository
+	// +optional
+	Git *GitSource `json:"git,omitempty"`
+
+	// tar.gz archive served over http
+	// +optional
+	Archive *ArchiveSource `json:"archive,omitempty"`
+
+	// Artifact in an OCI registry
+	// +optional
+	OCI *OCISource `json:"oci,omitempty"`
+}
+
+// GitSource defines a git repository with the configuration files. At most
+// one of branch, tag or commit can be specified. If none is, the repository's
+// HEAD is used.
+type GitSource struct {
+	// URL of the repository. Supports https, ssh and file protocols
+	URL string `json:"url"`
+
+	// Branch to follow
+	// +optional
+	Branch string `json:"branch,omitempty"`
+
+	// Tag to use
+	// +optional
+	Tag string `json:"tag,omitempty"`
+
+	// Commit to use, as a full sha
+	// +optional
+	Commit string `json:"commit,omitempty"`
+
+	// Subdirectory of the repository with the configuration files.
+	// Defaults to the repository's root
+	// +optional
+	Subdirectory string `json:"subdirectory,omitempty"`
+
+	// Reference to a Secret with the credentials for the repository, with keys
+	// username and password for https or ssh-privatekey and known_hosts for ssh
+	// +optional
+	SecretRef *corev1.LocalObjectReference `json:"secretRef,omitempty"`
+
+	// Interval for polling for new commits. Defaults to 5m
+	// +optional
+	PollInterval *metav1.Duration `json:"pollInterval,omitempty"`
+}
+
+// ArchiveSource defines a tar.gz archive with the configuration files
+type ArchiveSource struct {
+	// URL of the archive
+	URL string `json:"url"`
+
+	// Expected sha256 of the archive, hex encoded
+	SHA256 string `json:"sha256"`
+
+	// Reference to a Secret with keys username and password for accessing
+	// the archive using basic authentication
+	// +optional
+	SecretRef *corev1.LocalObjectReference `json:"secretRef,omitempty"`
+}
+
+// OCISource defines an artifact in an OCI registry with a tar.gz layer
+// containing the configuration files
+type OCISource struct {
+	// Reference to the artifact, as registry/repository:tag or
+	// registry/repository@digest
+	Reference string `json:"reference"`
+
+	// Digest the artifact's manifest must have. If not specified, the
+	// reference is polled for new digests
+	// +optional
+	Digest string `json:"digest,omitempty"`
+
+	// Access the registry using http instead of https
+	// +optional
+	Insecure bool `json:"insecure,omitempty"`
+
+	// Reference to a Secret with keys username and password for accessing
+	// the registry
+	// +optional
+	SecretRef *corev1.LocalObjectReference `json:"secretRef,omitempty"`
+
+	// Interval for polling for new digests. Defaults to 5m
+	// +optional
+	PollInterval *metav1.Duration `json:"pollInterval,omitempty"`
+}
+
+// VarsSourceType defines the kind of source for variables
+type VarsSourceType string
+
+const (
+	// The variable is taken from an output of another stack
+	VarsSourceTypeStackOutput VarsSourceType = "stackOutput"
+)
+
+// VarsFromSource defines a source for a variable of the stack
+type VarsFromSource struct {
+	// Type of the source
+	// +kubebuilder:validation:Enum=stackOutput
+	Type VarsSourceType `json:"type"`
+
+	// Output of another stack. Required when type is stackOutput
+	// +optional
+	StackOutput *StackOutputSource `json:"stackOutput,omitempty"`
+}
+
+// StackOutputSource selects an output from a stack in the same namespace
+type StackOutputSource struct {
+	// Name of the stack producing the output
+	Stack string `json:"stack"`
+
+	// Name of the output
+	Output string `json:"output"`
+
+	// Name of the variable that receives the output's value
+	Var string `json:"var"`
+}
+
+// StackPhase describes the state of the stack
+type StackPhase string
+
+const (
+	// The stack is waiting for the stacks it depends on to be Ready
+	StackPhaseWaiting StackPhase = "Waiting"
+
+	// A Job is applying the stack
+	StackPhaseRunning StackPhase = "Running"
+
+	// The last apply completed successfully
+	StackPhaseReady StackPhase = "Ready"
+
+	// The last apply failed or the stack can't be applied
+	StackPhaseFailed StackPhase = "Failed"
+
+	// The last plan of a plan-only stack completed. The stack is not
+	// applied until plan-only is unset
+	StackPhasePlanned StackPhase = "Planned"
+
+	// A Job is destroying the stack's resources before deleting it
+	StackPhaseDestroying StackPhase = "Destroying"
+)
+
+// StackConditionType is a type of condition of the stack
+type StackConditionType string
+
+const (
+	// The last apply completed successfully and the stack is up to date
+	StackConditionReady StackConditionType = "Ready"
+)
+
+// StackCondition describes an aspect of the state of the stack
+type StackCondition struct {
+	// Type of the condition
+	Type StackConditionType `json:"type"`
+
+	// Status of the condition, one of True, False or Unknown
+	Status corev1.ConditionStatus `json:"status"`
+
+	// Last time the condition changed its status
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+
+	// Reason for the condition's last transition, in CamelCase
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// Human readable details about the last transition
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// Generation of the stack's spec the condition was set for
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+}
+
+// ChangeSummary counts the resources changed by an apply
+type ChangeSummary struct {
+	// Resources added
+	Add int32 `json:"add"`
+
+	// Resources changed in place
+	Change int32 `json:"change"`
+
+	// Resources destroyed
+	Destroy int32 `json:"destroy"`
+}
+
+// ImportResultType is the result of importing a resource
+type ImportResultType string
+
+const (
+	// The resource was imported into the stack's state
+	ImportResultImported ImportResultType = "Imported"
+
+	// The resource was already in the stack's state
+	ImportResultSkipped ImportResultType = "Skipped"
+
+	// The resource could not be imported
+	ImportResultFailed ImportResultType = "Failed"
+)
+
+// ImportResult describes the import of a resource by a run
+type ImportResult struct {
+	// Address of the resource
+	Address string `json:"address"`
+
+	// ID of the resource in the provider
+	ID string `json:"id"`
+
+	// Result of the import
+	Result ImportResultType `json:"result"`
+
+	// Reason of the failure, if any
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// StackRun describes the last run of the Job applying the stack
+type StackRun struct {
+	// Sequence number of the run
+	// +optional
+	Number int64 `json:"number,omitempty"`
+
+	// Time the run started
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// Time the run completed or failed
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Resources changed by the run, reported by the Job on completion
+	// +optional
+	Changes *ChangeSummary `json:"changes,omitempty"`
+
+	// Results of the imports of the run, reported by the Job
+	// +optional
+	Imports []ImportResult `json:"imports,omitempty"`
+
+	// Options of the run, if it was requested as a single run
+	// +optional
+	Request *RunRequest `json:"request,omitempty"`
+}
+
+// RunRequest defines the options of a single run of the stack. The options
+// only apply to the requested run, not to the following ones
+type RunRequest struct {
+	// Identifies the request
+	ID string `json:"id"`
+
+	// Addresses of the modules or resources the run is limited to
+	// +optional
+	Targets []string `json:"targets,omitempty"`
+
+	// Addresses of the resources to replace
+	// +optional
+	Replace []string `json:"replace,omitempty"`
+
+	// Only update the state with the resources' current settings
+	// +optional
+	RefreshOnly bool `json:"refreshOnly,omitempty"`
+
+	// Values of variables overriding the stack's tfvars
+	// +optional
+	Vars map[string]string `json:"vars,omitempty"`
+}
+
+// ConfigRevision records a configuration and tfvars applied to the stack
+type ConfigRevision struct {
+	// Sequence number of the revision
+	Revision int64 `json:"revision"`
+
+	// Name of the ConfigMap with the configuration. Empty for stacks with a
+	// remote source
+	// +optional
+	TfConfig string `json:"tfconfig,omitempty"`
+
+	// Name of the Secret with the tfvars
+	// +optional
+	TfVars string `json:"tfvars,omitempty"`
+
+	// Time the revision was applied
+	AppliedAt metav1.Time `json:"appliedAt"`
 }
 
 // StackStatus defines the observed state of Stack
@@ -38,9 +456,53 @@ type StackStatus struct {
 
 	// base64 encoded tfout
 	TfOutput string `json:"tfout"`
+
+	// Name of the Secret with the values of the sensitive outputs, which are
+	// redacted from tfout
+	// +optional
+	SensitiveOutputs string `json:"sensitiveOutputs,omitempty"`
+
+	// Current phase of the stack
+	// +optional
+	Phase StackPhase `json:"phase,omitempty"`
+
+	// Human readable details about the phase
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// Current state of the stack
+	// +optional
+	Conditions []StackCondition `json:"conditions,omitempty"`
+
+	// Name of the Job running the last apply
+	// +optional
+	Job string `json:"job,omitempty"`
+
+	// Last run of the Job applying the stack
+	// +optional
+	LastRun *StackRun `json:"lastRun,omitempty"`
+
+	// Generation of the stack's spec used by the last apply
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Hash of the variables taken from other stacks by the last apply
+	// +optional
+	VarsFromHash string `json:"varsFromHash,omitempty"`
+
+	// Revision of the remote source used by the last apply. For git sources,
+	// the sha of the commit. For archive and oci sources, the digest
+	// +optional
+	SourceRevision string `json:"sourceRevision,omitempty"`
+
+	// Revisions of the configuration and tfvars applied to the stack, oldest
+	// first. The last one is the current revision
+	// +optional
+	ConfigRevisions []ConfigRevision `json:"configRevisions,omitempty"`
 }
 
 // +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
 
 // Stack is the Schema for the stacks API
 type Stack struct {
@@ -0,0 +1,127 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	tfv1alpha1 "github.com/pablochacin/tf-operator/api/v1alpha1"
+	"github.com/pablochacin/tf-operator/pkg/backend"
+	"github.com/pablochacin/tf-operator/pkg/terraform"
+)
+
+// validateBackend checks the backend configured in the stack, if any, or that
+// the state backend keeps its state otherwise
+func (r *StackReconciler) validateBackend(stack *tfv1alpha1.Stack) error {
+	spec := stack.Spec.Backend
+	if spec == nil {
+		if r.StateBackendURL == "" {
+			return fmt.Errorf("invalid backend: the state backend is not enabled, a backend must be configured")
+		}
+		return nil
+	}
+
+	settings := []string{}
+	for name := range spec.Config {
+		settings = append(settings, name)
+	}
+	for _, src := range spec.ConfigFrom {
+		if src.SecretKeyRef.Name == "" || src.SecretKeyRef.Key == "" {
+			return fmt.Errorf("invalid backend: setting %s must reference a secret and key", src.Name)
+		}
+		settings = append(settings, src.Name)
+	}
+
+	err := terraform.ValidateBackend(spec.Type, settings)
+	if err != nil {
+		return fmt.Errorf("invalid backend: %v", err)
+	}
+	return nil
+}
+
+// validateWorkspace checks the stack's workspace, if any, and that its state
+// is kept in a backend supporting workspaces, which the state backend doesn't
+func (r *StackReconciler) validateWorkspace(stack *tfv1alpha1.Stack) error {
+	if stack.Spec.Workspace == "" {
+		return nil
+	}
+
+	err := terraform.ValidateWorkspace(stack.Spec.Workspace)
+	if err != nil {
+		return fmt.Errorf("invalid workspace: %v", err)
+	}
+	if stack.Spec.Backend == nil && r.StateBackendURL != "" {
+		return fmt.Errorf("invalid workspace: the state backend doesn't support workspaces, a backend must be configured")
+	}
+	return nil
+}
+
+// reconcileBackendConfig stores the backend configured in the stack, with the
+// settings taken from Secrets, for its runs and returns the name of the Secret
+// with it
+func (r *StackReconciler) reconcileBackendConfig(ctx context.Context, stack *tfv1alpha1.Stack) (string, error) {
+	config, err := backend.StackConfig(ctx, r.Client, stack)
+	if err != nil {
+		return "", err
+	}
+
+	return r.reconcileBackendSecret(ctx, stack, backend.ConfigData(stack.Spec.Backend.Type, config))
+}
+
+// reconcileBackendCredentials stores new credentials for a run of the stack
+// to access its state in the state backend, replacing the ones of the
+// previous run, and returns the name of the Secret with them
+func (r *StackReconciler) reconcileBackendCredentials(ctx context.Context, stack *tfv1alpha1.Stack, run int64) (string, error) {
+	data, err := backend.NewCredentials(r.StateBackendURL, stack.Namespace, stack.Name, run)
+	if err != nil {
+		return "", err
+	}
+
+	return r.reconcileBackendSecret(ctx, stack, data)
+}
+
+// reconcileBackendSecret stores the backend of the stack's runs in a Secret
+// owned by the stack, and returns its name
+func (r *StackReconciler) reconcileBackendSecret(ctx context.Context, stack *tfv1alpha1.Stack, data map[string][]byte) (string, error) {
+	secret := &corev1.Secret{}
+	key := types.NamespacedName{Name: backend.CredentialsSecretName(stack.Name), Namespace: stack.Namespace}
+	err := r.Get(ctx, key, secret)
+	if apierr.IsNotFound(err) {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            key.Name,
+				Namespace:       key.Namespace,
+				Labels:          map[string]string{tfv1alpha1.StackLabel: stack.Name},
+				OwnerReferences: []metav1.OwnerReference{stackOwnerReference(stack)},
+			},
+			Data: data,
+		}
+		return key.Name, r.Create(ctx, secret)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	secret.Data = data
+	return key.Name, r.Update(ctx, secret)
+}
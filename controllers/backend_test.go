@@ -0,0 +1,179 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+	"github.com/pablochacin/tf-operator/pkg/backend"
+	batchv1 "k8s.io/api/batch/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Stack state backend", func() {
+	var (
+		r     *StackReconciler
+		stack *tfo.Stack
+	)
+
+	BeforeEach(func() {
+		stack = &tfo.Stack{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "stack",
+				Namespace: namespace,
+			},
+			Spec: tfo.StackSpec{
+				TfConfig: corev1.LocalObjectReference{Name: "stack-tfconf"},
+				TfVars:   corev1.LocalObjectReference{Name: "stack-tfvars"},
+			},
+			Status: tfo.StackStatus{
+				TfState: corev1.LocalObjectReference{Name: "stack-tfstate"},
+			},
+		}
+
+		sch := runtime.NewScheme()
+		Expect(tfo.AddToScheme(sch)).To(Succeed())
+		Expect(batchv1.AddToScheme(sch)).To(Succeed())
+		Expect(coordinationv1.AddToScheme(sch)).To(Succeed())
+		Expect(corev1.AddToScheme(sch)).To(Succeed())
+		Expect(rbacv1.AddToScheme(sch)).To(Succeed())
+		r = &StackReconciler{
+			Client:          fake.NewFakeClientWithScheme(sch, stack.DeepCopy()),
+			Log:             ctrl.Log,
+			Scheme:          sch,
+			StateBackendURL: "http://tf-operator:8082",
+		}
+	})
+
+	// credentials returns the stack's backend credentials
+	credentials := func() *corev1.Secret {
+		secret := &corev1.Secret{}
+		key := client.ObjectKey{Name: backend.CredentialsSecretName(stack.Name), Namespace: namespace}
+		Expect(r.Get(context.TODO(), key, secret)).To(Succeed())
+		return secret
+	}
+
+	It("Should give the Job the credentials of its run instead of the state", func() {
+		Expect(r.startRun(context.TODO(), stack, newJobConfig(stack, "apply", nil))).To(Succeed())
+
+		secret := credentials()
+		Expect(string(secret.Data[backend.OverrideKey])).To(ContainSubstring(`"http://tf-operator:8082/state/test/stack"`))
+		Expect(string(secret.Data["username"])).To(Equal("run-1"))
+
+		job := &batchv1.Job{}
+		Expect(r.Get(context.TODO(), client.ObjectKey{Name: stack.Status.Job, Namespace: namespace}, job)).To(Succeed())
+		spec := job.Spec.Template.Spec
+		Expect(spec.Containers[0].Args).To(ContainElement("--backend-override"))
+		secrets := []string{}
+		for _, vol := range spec.Volumes {
+			if vol.Secret != nil {
+				secrets = append(secrets, vol.Secret.SecretName)
+			}
+		}
+		Expect(secrets).To(ContainElement(secret.Name))
+		Expect(secrets).NotTo(ContainElement("stack-tfstate"))
+	})
+
+	It("Should replace the credentials of the previous run", func() {
+		Expect(r.startRun(context.TODO(), stack, newJobConfig(stack, "apply", nil))).To(Succeed())
+		previous := credentials()
+		Expect(r.releaseRunLock(context.TODO(), stack)).To(Succeed())
+
+		Expect(r.startRun(context.TODO(), stack, newJobConfig(stack, "apply", nil))).To(Succeed())
+		current := credentials()
+		Expect(string(current.Data["username"])).To(Equal("run-2"))
+		Expect(current.Data["password"]).NotTo(Equal(previous.Data["password"]))
+	})
+
+	It("Should reject a workspace, not supported by the state backend", func() {
+		stack.Spec.Workspace = "stage"
+		Expect(r.validateWorkspace(stack)).NotTo(Succeed())
+
+		r.StateBackendURL = ""
+		Expect(r.validateWorkspace(stack)).To(Succeed())
+	})
+
+	It("Should fail a stack without a backend when the state backend is not enabled", func() {
+		Expect(r.validateBackend(stack)).To(Succeed())
+
+		r.StateBackendURL = ""
+		Expect(r.validateBackend(stack)).NotTo(Succeed())
+		Expect(r.startRun(context.TODO(), stack, newJobConfig(stack, "apply", nil))).NotTo(Succeed())
+	})
+
+	Context("with a backend configured in the stack", func() {
+		BeforeEach(func() {
+			stack.Spec.Backend = &tfo.StackBackend{
+				Type:   "s3",
+				Config: map[string]string{"bucket": "states", "key": "stack.tfstate"},
+				ConfigFrom: []tfo.BackendConfigSource{{
+					Name: "secret_key",
+					SecretKeyRef: corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "minio"},
+						Key:                  "secretkey",
+					},
+				}},
+			}
+			Expect(r.Create(context.TODO(), &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "minio", Namespace: namespace},
+				Data:       map[string][]byte{"secretkey": []byte("s3cr3t")},
+			})).To(Succeed())
+		})
+
+		It("Should give the Job the backend with its settings", func() {
+			Expect(r.validateBackend(stack)).To(Succeed())
+			Expect(r.startRun(context.TODO(), stack, newJobConfig(stack, "apply", nil))).To(Succeed())
+
+			secret := credentials()
+			Expect(string(secret.Data[backend.OverrideKey])).To(ContainSubstring(`backend "s3" {}`))
+			Expect(string(secret.Data[backend.ConfigKey])).To(Equal("bucket = \"states\"\nkey = \"stack.tfstate\"\nsecret_key = \"s3cr3t\"\n"))
+			Expect(secret.Data).NotTo(HaveKey("password"))
+
+			job := &batchv1.Job{}
+			Expect(r.Get(context.TODO(), client.ObjectKey{Name: stack.Status.Job, Namespace: namespace}, job)).To(Succeed())
+			Expect(job.Spec.Template.Spec.Containers[0].Args).To(ContainElement("--backend-config"))
+		})
+
+		It("Should run in the stack's workspace", func() {
+			stack.Spec.Workspace = "stage"
+			Expect(r.validateWorkspace(stack)).To(Succeed())
+			Expect(r.startRun(context.TODO(), stack, newJobConfig(stack, "apply", nil))).To(Succeed())
+
+			job := &batchv1.Job{}
+			Expect(r.Get(context.TODO(), client.ObjectKey{Name: stack.Status.Job, Namespace: namespace}, job)).To(Succeed())
+			Expect(job.Spec.Template.Spec.Containers[0].Args).To(ContainElements("--workspace", "stage"))
+
+			stack.Spec.Workspace = "dev/eu"
+			Expect(r.validateWorkspace(stack)).NotTo(Succeed())
+		})
+
+		It("Should fail the run without the Secret with a setting", func() {
+			stack.Spec.Backend.ConfigFrom[0].SecretKeyRef.Name = "other"
+			Expect(r.startRun(context.TODO(), stack, newJobConfig(stack, "apply", nil))).NotTo(Succeed())
+		})
+
+		It("Should reject an invalid backend", func() {
+			stack.Spec.Backend.Type = "local"
+			Expect(r.validateBackend(stack)).NotTo(Succeed())
+
+			stack.Spec.Backend.Type = "s3"
+			stack.Spec.Backend.ConfigFrom[0].SecretKeyRef.Key = ""
+			Expect(r.validateBackend(stack)).NotTo(Succeed())
+		})
+
+		It("Should not require the state backend for encrypting the sensitive outputs", func() {
+			r.StateBackendURL = ""
+			stack.Spec.Encryption = &tfo.StackEncryption{KeySecret: corev1.LocalObjectReference{Name: "keys"}}
+			Expect(r.validateEncryption(stack)).To(Succeed())
+		})
+	})
+})
@@ -116,7 +116,7 @@ var _ = Describe("Controller", func() {
                 stack = createStack(stackName, namespace, tfconfig, tfvars)
                 initObjs = append(initObjs,stack, tfvars, tfconfig)
                 request = ctrl.Request{
-                    types.NamespacedName{
+                    NamespacedName: types.NamespacedName{
                         Name: stack.Name,
                         Namespace: stack.Namespace,
                     },
@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Stack delete", func() {
+	var stack *tfo.Stack
+
+	BeforeEach(func() {
+		stack = &tfo.Stack{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       "stack",
+				Namespace:  namespace,
+				Finalizers: []string{"other", tfo.StackFinalizer},
+			},
+			Spec: tfo.StackSpec{
+				TfConfig: corev1.LocalObjectReference{Name: "stack-tfconf"},
+				TfVars:   corev1.LocalObjectReference{Name: "stack-tfvars"},
+			},
+			Status: tfo.StackStatus{
+				TfState: corev1.LocalObjectReference{Name: "stack-tfstate"},
+			},
+		}
+	})
+
+	It("Should destroy using the stack's configuration and state", func() {
+		jobCfg := newJobConfig(stack, "destroy", &remoteSource{fetch: []string{"git"}, secret: "creds"})
+		Expect(jobCfg.Command).To(Equal("destroy"))
+		Expect(jobCfg.TfConfig).To(Equal("stack-tfconf"))
+		Expect(jobCfg.Tfvars).To(Equal("stack-tfvars"))
+		Expect(jobCfg.Fetch).To(Equal([]string{"git"}))
+		Expect(jobCfg.FetchSecret).To(Equal("creds"))
+	})
+
+	It("Should remove only the stack's finalizer", func() {
+		Expect(containsString(stack.Finalizers, tfo.StackFinalizer)).To(BeTrue())
+		finalizers := removeString(stack.Finalizers, tfo.StackFinalizer)
+		Expect(finalizers).To(Equal([]string{"other"}))
+	})
+})
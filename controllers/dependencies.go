@@ -0,0 +1,286 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	tfv1alpha1 "github.com/pablochacin/tf-operator/api/v1alpha1"
+	"github.com/pablochacin/tf-operator/pkg/encryption"
+	"github.com/pablochacin/tf-operator/pkg/terraform"
+)
+
+// dependencies describes the state of the stacks a stack depends on
+type dependencies struct {
+	// dependency cycle the stack is part of, if any
+	cycle []string
+	// stacks that are not Ready yet
+	pending []string
+	// variables taken from the outputs of other stacks
+	vars map[string]string
+}
+
+// ready indicates if the stack can be applied
+func (d *dependencies) ready() bool {
+	return len(d.cycle) == 0 && len(d.pending) == 0
+}
+
+// hash returns a digest of the variables taken from other stacks, used for
+// detecting changes in their outputs
+func (d *dependencies) hash() string {
+	if len(d.vars) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(d.vars))
+	for name := range d.vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s=%q\n", name, d.vars[name])
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// stackDependencies returns the names of the stacks a stack depends on, either
+// explicitly or by consuming their outputs
+func stackDependencies(stack *tfv1alpha1.Stack) []string {
+	deps := []string{}
+	seen := map[string]bool{}
+	add := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			deps = append(deps, name)
+		}
+	}
+
+	for _, dep := range stack.Spec.DependsOn {
+		add(dep.Name)
+	}
+	for _, src := range stack.Spec.VarsFrom {
+		if src.Type == tfv1alpha1.VarsSourceTypeStackOutput && src.StackOutput != nil {
+			add(src.StackOutput.Stack)
+		}
+	}
+
+	return deps
+}
+
+// findCycle returns the path of a dependency cycle that starts and ends in the
+// given stack, or nil if there is none. Stacks are indexed by name.
+func findCycle(name string, stacks map[string]*tfv1alpha1.Stack) []string {
+	visited := map[string]bool{}
+	var visit func(current string, path []string) []string
+	visit = func(current string, path []string) []string {
+		stack, found := stacks[current]
+		if !found {
+			return nil
+		}
+		for _, dep := range stackDependencies(stack) {
+			if dep == name {
+				return append(path, dep)
+			}
+			if visited[dep] {
+				continue
+			}
+			visited[dep] = true
+			if cycle := visit(dep, append(path, dep)); cycle != nil {
+				return cycle
+			}
+		}
+		return nil
+	}
+
+	return visit(name, []string{name})
+}
+
+// resolveDependencies checks the state of the stacks a stack depends on and
+// collects the variables taken from their outputs
+func (r *StackReconciler) resolveDependencies(ctx context.Context, stack *tfv1alpha1.Stack) (*dependencies, error) {
+	deps := &dependencies{vars: map[string]string{}}
+	if len(stackDependencies(stack)) == 0 {
+		return deps, nil
+	}
+
+	stackList := &tfv1alpha1.StackList{}
+	err := r.List(ctx, stackList, client.InNamespace(stack.Namespace))
+	if err != nil {
+		return nil, err
+	}
+
+	stacks := map[string]*tfv1alpha1.Stack{}
+	for i := range stackList.Items {
+		stacks[stackList.Items[i].Name] = &stackList.Items[i]
+	}
+	// use the stack being reconciled, as the list may be outdated
+	stacks[stack.Name] = stack
+
+	deps.cycle = findCycle(stack.Name, stacks)
+	if deps.cycle != nil {
+		return deps, nil
+	}
+
+	for _, name := range stackDependencies(stack) {
+		dep, found := stacks[name]
+		if !found || dep.Status.Phase != tfv1alpha1.StackPhaseReady {
+			deps.pending = append(deps.pending, name)
+		}
+	}
+	if len(deps.pending) > 0 {
+		return deps, nil
+	}
+
+	for _, src := range stack.Spec.VarsFrom {
+		if src.Type != tfv1alpha1.VarsSourceTypeStackOutput || src.StackOutput == nil {
+			continue
+		}
+		ref := src.StackOutput
+		outputs, err := terraform.DecodeOutputs(stacks[ref.Stack].Status.TfOutput)
+		if err != nil {
+			return nil, fmt.Errorf("stack %s: %v", ref.Stack, err)
+		}
+		output, found := outputs[ref.Output]
+		if !found {
+			return nil, fmt.Errorf("stack %s has no output %s", ref.Stack, ref.Output)
+		}
+		if output.Redacted() {
+			output, err = r.sensitiveOutput(ctx, stacks[ref.Stack], ref.Output, output)
+			if err != nil {
+				return nil, fmt.Errorf("stack %s: %v", ref.Stack, err)
+			}
+		}
+		deps.vars[ref.Var] = output.VarValue()
+	}
+
+	return deps, nil
+}
+
+// sensitiveOutput returns a sensitive output with its value taken from the
+// Secret with the stack's sensitive outputs, decrypted if it is encrypted
+func (r *StackReconciler) sensitiveOutput(ctx context.Context, stack *tfv1alpha1.Stack, name string, output terraform.Output) (terraform.Output, error) {
+	if stack.Status.SensitiveOutputs == "" {
+		return output, fmt.Errorf("value of sensitive output %s not available", name)
+	}
+
+	secret := &corev1.Secret{}
+	key := types.NamespacedName{Name: stack.Status.SensitiveOutputs, Namespace: stack.Namespace}
+	err := r.Get(ctx, key, secret)
+	if err != nil {
+		return output, err
+	}
+
+	enc, err := encryption.ForStack(ctx, r.Client, stack)
+	if err != nil {
+		return output, err
+	}
+	values, err := enc.DecryptValues(ctx, secret.Data)
+	if err != nil {
+		return output, fmt.Errorf("error decrypting sensitive output %s: %v", name, err)
+	}
+
+	outputs := map[string]terraform.Output{name: output}
+	terraform.RestoreSensitive(outputs, values)
+	if outputs[name].Redacted() {
+		return output, fmt.Errorf("value of sensitive output %s not available", name)
+	}
+
+	return outputs[name], nil
+}
+
+// varsFromSecretName returns the name of the Secret with the variables a stack
+// takes from other stacks
+func varsFromSecretName(stack *tfv1alpha1.Stack) string {
+	return stack.Name + "-varsfrom"
+}
+
+// reconcileVarsFrom creates or updates the Secret with the variables taken
+// from other stacks
+func (r *StackReconciler) reconcileVarsFrom(ctx context.Context, stack *tfv1alpha1.Stack, vars map[string]string) error {
+	data := map[string][]byte{}
+	for name, value := range vars {
+		data[name] = []byte(value)
+	}
+
+	secret := &corev1.Secret{}
+	key := types.NamespacedName{Name: varsFromSecretName(stack), Namespace: stack.Namespace}
+	err := r.Get(ctx, key, secret)
+	if apierr.IsNotFound(err) {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            key.Name,
+				Namespace:       key.Namespace,
+				OwnerReferences: []metav1.OwnerReference{stackOwnerReference(stack)},
+			},
+			Data: data,
+		}
+		return r.Create(ctx, secret)
+	}
+	if err != nil {
+		return err
+	}
+
+	secret.Data = data
+	return r.Update(ctx, secret)
+}
+
+// dependentStacks maps a Stack to the requests for reconciling the stacks that
+// depend on it, so they are re-applied when its outputs change
+func (r *StackReconciler) dependentStacks(obj handler.MapObject) []reconcile.Request {
+	stackList := &tfv1alpha1.StackList{}
+	err := r.List(context.Background(), stackList, client.InNamespace(obj.Meta.GetNamespace()))
+	if err != nil {
+		r.Log.Error(err, "unable to list dependent stacks", "stack", obj.Meta.GetName())
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for i := range stackList.Items {
+		for _, dep := range stackDependencies(&stackList.Items[i]) {
+			if dep == obj.Meta.GetName() {
+				requests = append(requests, reconcile.Request{
+					NamespacedName: types.NamespacedName{
+						Name:      stackList.Items[i].Name,
+						Namespace: stackList.Items[i].Namespace,
+					},
+				})
+				break
+			}
+		}
+	}
+
+	return requests
+}
+
+// formatCycle returns a readable description of a dependency cycle
+func formatCycle(cycle []string) string {
+	return strings.Join(cycle, " -> ")
+}
@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// createDependentStack creates a Stack that depends on other stacks, taking
+// one output from each of them
+func createDependentStack(name string, deps ...string) *tfo.Stack {
+	stack := &tfo.Stack{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+	for _, dep := range deps {
+		stack.Spec.VarsFrom = append(stack.Spec.VarsFrom, tfo.VarsFromSource{
+			Type: tfo.VarsSourceTypeStackOutput,
+			StackOutput: &tfo.StackOutputSource{
+				Stack:  dep,
+				Output: "id",
+				Var:    dep + "_id",
+			},
+		})
+	}
+	return stack
+}
+
+// indexStacks returns a map of stacks indexed by name
+func indexStacks(stacks ...*tfo.Stack) map[string]*tfo.Stack {
+	index := map[string]*tfo.Stack{}
+	for _, s := range stacks {
+		index[s.Name] = s
+	}
+	return index
+}
+
+var _ = Describe("Stack dependencies", func() {
+
+	Context("Collect dependencies", func() {
+		It("Should merge dependsOn and stack outputs without duplicates", func() {
+			stack := createDependentStack("app", "network", "db")
+			stack.Spec.DependsOn = []corev1.LocalObjectReference{
+				{Name: "network"},
+				{Name: "dns"},
+			}
+			Expect(stackDependencies(stack)).To(Equal([]string{"network", "dns", "db"}))
+		})
+	})
+
+	Context("Detect cycles", func() {
+		It("Should accept a dependency chain", func() {
+			stacks := indexStacks(
+				createDependentStack("app", "db"),
+				createDependentStack("db", "network"),
+				createDependentStack("network"),
+			)
+			Expect(findCycle("app", stacks)).To(BeNil())
+		})
+
+		It("Should reject a stack depending on itself", func() {
+			stacks := indexStacks(createDependentStack("app", "app"))
+			Expect(findCycle("app", stacks)).To(Equal([]string{"app", "app"}))
+		})
+
+		It("Should reject an indirect cycle", func() {
+			stacks := indexStacks(
+				createDependentStack("app", "db"),
+				createDependentStack("db", "network"),
+				createDependentStack("network", "app"),
+			)
+			Expect(findCycle("app", stacks)).To(Equal([]string{"app", "db", "network", "app"}))
+		})
+
+		It("Should ignore cycles the stack is not part of", func() {
+			stacks := indexStacks(
+				createDependentStack("app", "db"),
+				createDependentStack("db", "network"),
+				createDependentStack("network", "db"),
+			)
+			Expect(findCycle("app", stacks)).To(BeNil())
+		})
+	})
+
+	Context("Hash variables", func() {
+		It("Should not depend on the order of the variables", func() {
+			d1 := &dependencies{vars: map[string]string{"a": "1", "b": "2"}}
+			d2 := &dependencies{vars: map[string]string{"b": "2", "a": "1"}}
+			Expect(d1.hash()).To(Equal(d2.hash()))
+		})
+
+		It("Should change when a value changes", func() {
+			d1 := &dependencies{vars: map[string]string{"a": "1"}}
+			d2 := &dependencies{vars: map[string]string{"a": "2"}}
+			Expect(d1.hash()).NotTo(Equal(d2.hash()))
+		})
+	})
+})
@@ -0,0 +1,39 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	tfv1alpha1 "github.com/pablochacin/tf-operator/api/v1alpha1"
+)
+
+// validateEncryption checks the stack's state can be encrypted, which requires
+// the state backend, as the runs can't decrypt a state mounted from its Secret.
+// A stack configuring its own backend only has its sensitive outputs encrypted.
+func (r *StackReconciler) validateEncryption(stack *tfv1alpha1.Stack) error {
+	if stack.Spec.Encryption == nil {
+		return nil
+	}
+	if stack.Spec.Encryption.KeySecret.Name == "" {
+		return fmt.Errorf("invalid encryption: missing key secret")
+	}
+	if r.StateBackendURL == "" && stack.Spec.Backend == nil {
+		return fmt.Errorf("invalid encryption: requires the state backend")
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+	"github.com/pablochacin/tf-operator/pkg/encryption"
+	"github.com/pablochacin/tf-operator/pkg/terraform"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Stack encryption", func() {
+	var (
+		r     *StackReconciler
+		stack *tfo.Stack
+	)
+
+	BeforeEach(func() {
+		stack = &tfo.Stack{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "stack",
+				Namespace: namespace,
+			},
+			Spec: tfo.StackSpec{
+				Encryption: &tfo.StackEncryption{KeySecret: corev1.LocalObjectReference{Name: "keys"}},
+			},
+			Status: tfo.StackStatus{
+				SensitiveOutputs: "stack-outputs",
+			},
+		}
+
+		sch := runtime.NewScheme()
+		Expect(tfo.AddToScheme(sch)).To(Succeed())
+		Expect(corev1.AddToScheme(sch)).To(Succeed())
+		r = &StackReconciler{
+			Client: fake.NewFakeClientWithScheme(
+				sch,
+				stack.DeepCopy(),
+				&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: "keys", Namespace: namespace},
+					Data: map[string][]byte{
+						encryption.ActiveKey: []byte("k1"),
+						"k1":                 bytes.Repeat([]byte{1}, encryption.KeySize),
+					},
+				},
+				&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: "stack-outputs", Namespace: namespace},
+					Data:       map[string][]byte{"password": []byte(`"secret"`)},
+				},
+			),
+			Log:             ctrl.Log,
+			Scheme:          sch,
+			StateBackendURL: "http://tf-operator:8082",
+		}
+	})
+
+	It("Should require the state backend", func() {
+		Expect(r.validateEncryption(stack)).To(Succeed())
+
+		r.StateBackendURL = ""
+		Expect(r.validateEncryption(stack)).NotTo(Succeed())
+	})
+
+	It("Should decrypt the sensitive outputs of a run", func() {
+		enc, err := encryption.ForStack(context.TODO(), r.Client, stack)
+		Expect(err).NotTo(HaveOccurred())
+		secret := &corev1.Secret{}
+		Expect(r.Get(context.TODO(), client.ObjectKey{Name: "stack-outputs", Namespace: namespace}, secret)).To(Succeed())
+		secret.Data, _, err = enc.EncryptValues(context.TODO(), secret.Data)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Update(context.TODO(), secret)).To(Succeed())
+
+		output, err := r.sensitiveOutput(context.TODO(), stack, "password", terraform.Output{Sensitive: true, Value: json.RawMessage("null")})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output.VarValue()).To(Equal("secret"))
+	})
+})
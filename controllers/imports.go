@@ -0,0 +1,47 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"sort"
+
+	tfv1alpha1 "github.com/pablochacin/tf-operator/api/v1alpha1"
+	"github.com/pablochacin/tf-operator/pkg/terraform"
+)
+
+// validateImports checks the addresses of the resources to import into the
+// stack's state and that they have an ID
+func validateImports(stack *tfv1alpha1.Stack) error {
+	addresses := []string{}
+	for address := range stack.Spec.Imports {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	for _, address := range addresses {
+		err := terraform.ValidateImportAddress(address)
+		if err != nil {
+			return fmt.Errorf("invalid import: %v", err)
+		}
+		if stack.Spec.Imports[address] == "" {
+			return fmt.Errorf("invalid import: missing ID for %s", address)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+)
+
+var _ = Describe("Stack imports", func() {
+	stackWithImports := func(imports map[string]string) *tfo.Stack {
+		return &tfo.Stack{Spec: tfo.StackSpec{Imports: imports}}
+	}
+
+	It("Should accept resources and instances", func() {
+		Expect(validateImports(stackWithImports(map[string]string{
+			"aws_vpc.main":                       "vpc-1",
+			`module.net.aws_subnet.private["a"]`: "subnet-1",
+		}))).To(Succeed())
+	})
+
+	It("Should reject modules and data sources", func() {
+		Expect(validateImports(stackWithImports(map[string]string{"module.net": "net-1"}))).NotTo(Succeed())
+		Expect(validateImports(stackWithImports(map[string]string{"data.aws_ami.ubuntu": "ami-1"}))).NotTo(Succeed())
+	})
+
+	It("Should reject imports without ID", func() {
+		err := validateImports(stackWithImports(map[string]string{"aws_vpc.main": ""}))
+		Expect(err).To(MatchError("invalid import: missing ID for aws_vpc.main"))
+	})
+
+	It("Should pass the imports to the stack's Jobs", func() {
+		imports := map[string]string{"aws_vpc.main": "vpc-1"}
+		Expect(newJobConfig(stackWithImports(imports), "plan", nil).Imports).To(Equal(imports))
+	})
+})
@@ -0,0 +1,138 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strconv"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	tfv1alpha1 "github.com/pablochacin/tf-operator/api/v1alpha1"
+	"github.com/pablochacin/tf-operator/pkg/jobs"
+)
+
+const (
+	// number of runs whose logs are kept archived
+	archivedLogsLimit = 10
+
+	// line added to archived logs that were truncated
+	truncatedLogNotice = "[log truncated]\n"
+)
+
+// archiveLog stores the log of a finished Job in a ConfigMap owned by the
+// stack, so it is available after the Job's pods are deleted, and deletes the
+// logs of older runs. Errors are only logged, as the log is not needed for
+// reconciling the stack.
+func (r *StackReconciler) archiveLog(ctx context.Context, stack *tfv1alpha1.Stack, job *batchv1.Job) {
+	if r.Logs == nil {
+		return
+	}
+	log := r.Log.WithValues("stack", stack.Name, "job", job.Name)
+
+	pods := &corev1.PodList{}
+	err := r.List(ctx, pods, client.InNamespace(job.Namespace), client.MatchingLabels(jobs.PodSelector(job.Name)))
+	if err != nil {
+		log.Error(err, "unable to list the job's pods")
+		return
+	}
+	pod := jobs.LatestPod(pods.Items)
+	if pod == nil {
+		return
+	}
+
+	stream, err := r.Logs.Stream(pod.Namespace, pod.Name, &corev1.PodLogOptions{Container: jobs.RunContainer})
+	if err != nil {
+		log.Error(err, "unable to get the job's log")
+		return
+	}
+	defer stream.Close()
+
+	content, truncated, err := jobs.ReadLogTail(stream)
+	if err != nil {
+		log.Error(err, "unable to read the job's log")
+		return
+	}
+	if truncated {
+		content = truncatedLogNotice + content
+	}
+
+	archive := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      jobs.LogArchiveName(job.Name),
+			Namespace: job.Namespace,
+			Labels: map[string]string{
+				tfv1alpha1.StackLabel: stack.Name,
+				tfv1alpha1.RunLabel:   job.Labels[tfv1alpha1.RunLabel],
+			},
+			OwnerReferences: []metav1.OwnerReference{stackOwnerReference(stack)},
+		},
+		Data: map[string]string{jobs.LogArchiveKey: content},
+	}
+	err = r.Create(ctx, archive)
+	if err != nil && !apierr.IsAlreadyExists(err) {
+		log.Error(err, "unable to archive the job's log")
+		return
+	}
+
+	err = r.pruneArchivedLogs(ctx, stack)
+	if err != nil {
+		log.Error(err, "unable to delete old archived logs")
+	}
+}
+
+// pruneArchivedLogs deletes the archived logs of all but the last runs
+func (r *StackReconciler) pruneArchivedLogs(ctx context.Context, stack *tfv1alpha1.Stack) error {
+	if stack.Status.LastRun == nil {
+		return nil
+	}
+
+	selector := labels.SelectorFromSet(labels.Set{tfv1alpha1.StackLabel: stack.Name})
+	hasRun, err := labels.NewRequirement(tfv1alpha1.RunLabel, selection.Exists, nil)
+	if err != nil {
+		return err
+	}
+	archives := &corev1.ConfigMapList{}
+	err = r.List(
+		ctx,
+		archives,
+		client.InNamespace(stack.Namespace),
+		client.MatchingLabelsSelector{Selector: selector.Add(*hasRun)},
+	)
+	if err != nil {
+		return err
+	}
+
+	for i := range archives.Items {
+		run, err := strconv.ParseInt(archives.Items[i].Labels[tfv1alpha1.RunLabel], 10, 64)
+		if err != nil || run > stack.Status.LastRun.Number-archivedLogsLimit {
+			continue
+		}
+		err = r.Delete(ctx, &archives.Items[i])
+		if err != nil && !apierr.IsNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+	"github.com/pablochacin/tf-operator/pkg/jobs"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// fakePodLogs returns the same log for any pod
+type fakePodLogs struct {
+	log string
+}
+
+func (l *fakePodLogs) Stream(namespace string, pod string, opts *corev1.PodLogOptions) (io.ReadCloser, error) {
+	return ioutil.NopCloser(strings.NewReader(l.log)), nil
+}
+
+// archivedLog returns a ConfigMap archiving the log of a stack's run
+func archivedLog(stack string, run string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      stack + "-apply-" + run + "-log",
+			Namespace: namespace,
+			Labels: map[string]string{
+				tfo.StackLabel: stack,
+				tfo.RunLabel:   run,
+			},
+		},
+	}
+}
+
+var _ = Describe("Log archive", func() {
+	var (
+		r     *StackReconciler
+		stack *tfo.Stack
+		job   *batchv1.Job
+	)
+
+	BeforeEach(func() {
+		sch := runtime.NewScheme()
+		Expect(corev1.AddToScheme(sch)).To(Succeed())
+		Expect(batchv1.AddToScheme(sch)).To(Succeed())
+		Expect(tfo.AddToScheme(sch)).To(Succeed())
+
+		stack = &tfo.Stack{
+			ObjectMeta: metav1.ObjectMeta{Name: "stack", Namespace: namespace},
+			Status: tfo.StackStatus{
+				LastRun: &tfo.StackRun{Number: 12},
+			},
+		}
+		job = &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "stack-apply-12",
+				Namespace: namespace,
+				Labels:    map[string]string{tfo.StackLabel: "stack", tfo.RunLabel: "12"},
+			},
+		}
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "stack-apply-12-abcde",
+				Namespace: namespace,
+				Labels:    jobs.PodSelector(job.Name),
+			},
+		}
+
+		r = &StackReconciler{
+			Client: fake.NewFakeClientWithScheme(
+				sch,
+				pod,
+				archivedLog("stack", "1"),
+				archivedLog("stack", "2"),
+				archivedLog("stack", "3"),
+				archivedLog("other", "1"),
+			),
+			Log:  ctrl.Log,
+			Logs: &fakePodLogs{log: "Apply complete!"},
+		}
+		r.archiveLog(context.TODO(), stack, job)
+	})
+
+	It("Should archive the job's log", func() {
+		archive := &corev1.ConfigMap{}
+		err := r.Get(context.TODO(), client.ObjectKey{Name: "stack-apply-12-log", Namespace: namespace}, archive)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(archive.Data[jobs.LogArchiveKey]).To(Equal("Apply complete!"))
+		Expect(archive.Labels[tfo.RunLabel]).To(Equal("12"))
+	})
+
+	It("Should delete only the stack's oldest logs", func() {
+		archives := &corev1.ConfigMapList{}
+		Expect(r.List(context.TODO(), archives)).To(Succeed())
+		names := []string{}
+		for _, archive := range archives.Items {
+			names = append(names, archive.Name)
+		}
+		Expect(names).To(ConsistOf("other-apply-1-log", "stack-apply-3-log", "stack-apply-12-log"))
+	})
+})
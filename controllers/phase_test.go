@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Stack phase", func() {
+	var (
+		r        *StackReconciler
+		recorder *record.FakeRecorder
+		stack    *tfo.Stack
+	)
+
+	BeforeEach(func() {
+		sch := runtime.NewScheme()
+		Expect(tfo.AddToScheme(sch)).To(Succeed())
+		Expect(batchv1.AddToScheme(sch)).To(Succeed())
+		Expect(coordinationv1.AddToScheme(sch)).To(Succeed())
+
+		stack = &tfo.Stack{
+			ObjectMeta: metav1.ObjectMeta{Name: "stack", Namespace: namespace},
+			Status:     tfo.StackStatus{Phase: tfo.StackPhaseRunning},
+		}
+		recorder = record.NewFakeRecorder(10)
+		r = &StackReconciler{
+			Client:   fake.NewFakeClientWithScheme(sch, stack.DeepCopy()),
+			Log:      ctrl.Log,
+			Scheme:   sch,
+			Recorder: recorder,
+		}
+	})
+
+	It("Should record an event when the phase changes", func() {
+		Expect(r.setPhase(context.TODO(), stack, tfo.StackPhaseFailed, "apply failed")).To(Succeed())
+		Expect(recorder.Events).To(Receive(Equal(corev1.EventTypeWarning + " Failed apply failed")))
+	})
+
+	It("Should not record an event if the phase does not change", func() {
+		Expect(r.setPhase(context.TODO(), stack, tfo.StackPhaseRunning, "running again")).To(Succeed())
+		Expect(recorder.Events).NotTo(Receive())
+	})
+	It("Should set the Planned phase when a plan completes", func() {
+		job := &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "stack-plan-abc", Namespace: namespace},
+			Spec: batchv1.JobSpec{
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{{Command: []string{"tfoctl", "runner", "plan"}}},
+					},
+				},
+			},
+			Status: batchv1.JobStatus{
+				Conditions: []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}},
+			},
+		}
+		Expect(r.Create(context.TODO(), job)).To(Succeed())
+		stack.Status.Job = job.Name
+		stack.Status.LastRun = &tfo.StackRun{Number: 1, Changes: &tfo.ChangeSummary{Change: 1}}
+
+		_, err := r.reconcileJob(context.TODO(), *stack)
+		Expect(err).NotTo(HaveOccurred())
+
+		updated := &tfo.Stack{}
+		Expect(r.Get(context.TODO(), client.ObjectKey{Name: "stack", Namespace: namespace}, updated)).To(Succeed())
+		Expect(updated.Status.Phase).To(Equal(tfo.StackPhasePlanned))
+		Expect(updated.Status.Message).To(Equal("plan completed: 0 to add, 1 to change, 0 to destroy"))
+		Expect(getCondition(&updated.Status, tfo.StackConditionReady).Status).To(Equal(corev1.ConditionFalse))
+	})
+})
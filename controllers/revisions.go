@@ -0,0 +1,333 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/selection"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	tfv1alpha1 "github.com/pablochacin/tf-operator/api/v1alpha1"
+)
+
+const (
+	// number of configuration revisions kept if not specified in the stack
+	defaultRevisionHistoryLimit = 10
+)
+
+// revisionHistoryLimit returns the number of configuration revisions to keep
+// for a stack
+func revisionHistoryLimit(stack *tfv1alpha1.Stack) int {
+	if stack.Spec.RevisionHistoryLimit != nil && *stack.Spec.RevisionHistoryLimit > 0 {
+		return int(*stack.Spec.RevisionHistoryLimit)
+	}
+	return defaultRevisionHistoryLimit
+}
+
+// recordRevision adds a configuration and tfvars to a revision history,
+// keeping at most limit revisions. A revision already in the history, as when
+// rolling back, is moved to the end with a new revision number.
+func recordRevision(history []tfv1alpha1.ConfigRevision, rev tfv1alpha1.ConfigRevision, limit int) []tfv1alpha1.ConfigRevision {
+	sameContent := func(other tfv1alpha1.ConfigRevision) bool {
+		return other.TfConfig == rev.TfConfig && other.TfVars == rev.TfVars
+	}
+
+	if len(history) > 0 && sameContent(history[len(history)-1]) {
+		return history
+	}
+
+	rev.Revision = 1
+	if len(history) > 0 {
+		rev.Revision = history[len(history)-1].Revision + 1
+	}
+
+	updated := []tfv1alpha1.ConfigRevision{}
+	for _, other := range history {
+		if !sameContent(other) {
+			updated = append(updated, other)
+		}
+	}
+	updated = append(updated, rev)
+
+	if len(updated) > limit {
+		updated = updated[len(updated)-limit:]
+	}
+
+	return updated
+}
+
+// reconcileConfigRevisions records the stack's configuration and tfvars in its
+// revision history, makes the stack owner of the ConfigMaps and Secrets of the
+// revisions in the history and deletes the ones of older revisions. Only the
+// objects created as revisions (labeled with their content hash) are considered.
+// Revisions used by other stacks are not deleted, the stack is only removed
+// from their owners.
+func (r *StackReconciler) reconcileConfigRevisions(ctx context.Context, stack *tfv1alpha1.Stack) error {
+	stack.Status.ConfigRevisions = recordRevision(
+		stack.Status.ConfigRevisions,
+		tfv1alpha1.ConfigRevision{
+			TfConfig:  stack.Spec.TfConfig.Name,
+			TfVars:    stack.Spec.TfVars.Name,
+			AppliedAt: metav1.Now(),
+		},
+		revisionHistoryLimit(stack),
+	)
+
+	configMapsInHistory := map[string]bool{}
+	secretsInHistory := map[string]bool{}
+	for _, rev := range stack.Status.ConfigRevisions {
+		configMapsInHistory[rev.TfConfig] = true
+		secretsInHistory[rev.TfVars] = true
+	}
+
+	inUse, err := r.listRevisionsInUse(ctx, stack)
+	if err != nil {
+		return err
+	}
+
+	configMaps := &corev1.ConfigMapList{}
+	err = r.listRevisions(ctx, stack, configMaps, tfv1alpha1.ConfigHashLabel)
+	if err != nil {
+		return err
+	}
+	objs := []runtime.Object{}
+	for i := range configMaps.Items {
+		objs = append(objs, &configMaps.Items[i])
+	}
+	err = r.reconcileRevisionObjects(ctx, stack, objs, configMapsInHistory, inUse)
+	if err != nil {
+		return err
+	}
+
+	secrets := &corev1.SecretList{}
+	err = r.listRevisions(ctx, stack, secrets, tfv1alpha1.TfVarsHashLabel)
+	if err != nil {
+		return err
+	}
+	objs = []runtime.Object{}
+	for i := range secrets.Items {
+		objs = append(objs, &secrets.Items[i])
+	}
+	return r.reconcileRevisionObjects(ctx, stack, objs, secretsInHistory, inUse)
+}
+
+// reconcileRevisionObjects reconciles the objects of a kind created as
+// revisions for the stack. Revisions are created before the stack is updated
+// to use them, so only the ones created before the oldest revision in the
+// history are deleted.
+func (r *StackReconciler) reconcileRevisionObjects(ctx context.Context, stack *tfv1alpha1.Stack, objs []runtime.Object, inHistory map[string]bool, inUse *revisionsInUse) error {
+	var oldestKept *metav1.Time
+	for _, obj := range objs {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return err
+		}
+		if !inHistory[accessor.GetName()] {
+			continue
+		}
+		created := accessor.GetCreationTimestamp()
+		if oldestKept == nil || created.Before(oldestKept) {
+			oldestKept = &created
+		}
+	}
+
+	for _, obj := range objs {
+		err := r.reconcileRevisionObject(ctx, stack, obj, inHistory, inUse, oldestKept)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// listRevisions lists the objects created as revisions for the stack, which
+// have the given hash label
+func (r *StackReconciler) listRevisions(ctx context.Context, stack *tfv1alpha1.Stack, list runtime.Object, hashLabel string) error {
+	// MatchingLabels and HasLabels replace each other's selector, so both
+	// requirements are combined in a single selector
+	selector := labels.SelectorFromSet(labels.Set{tfv1alpha1.StackLabel: stack.Name})
+	hasHash, err := labels.NewRequirement(hashLabel, selection.Exists, nil)
+	if err != nil {
+		return err
+	}
+
+	return r.List(
+		ctx,
+		list,
+		client.InNamespace(stack.Namespace),
+		client.MatchingLabelsSelector{Selector: selector.Add(*hasHash)},
+	)
+}
+
+// revisionsInUse are the names of the ConfigMaps and Secrets used by the
+// other stacks of a namespace
+type revisionsInUse struct {
+	configMaps map[string]bool
+	secrets    map[string]bool
+}
+
+// has indicates if the object of a revision is used by other stacks
+func (u *revisionsInUse) has(obj runtime.Object, name string) bool {
+	if _, isSecret := obj.(*corev1.Secret); isSecret {
+		return u.secrets[name]
+	}
+	return u.configMaps[name]
+}
+
+// listRevisionsInUse returns the ConfigMaps and Secrets the other stacks in the
+// stack's namespace use in their spec or revision history, as stacks sharing
+// a configuration do
+func (r *StackReconciler) listRevisionsInUse(ctx context.Context, stack *tfv1alpha1.Stack) (*revisionsInUse, error) {
+	stacks := &tfv1alpha1.StackList{}
+	err := r.List(ctx, stacks, client.InNamespace(stack.Namespace))
+	if err != nil {
+		return nil, err
+	}
+
+	inUse := &revisionsInUse{configMaps: map[string]bool{}, secrets: map[string]bool{}}
+	for _, other := range stacks.Items {
+		if other.Name == stack.Name {
+			continue
+		}
+		inUse.configMaps[other.Spec.TfConfig.Name] = true
+		inUse.secrets[other.Spec.TfVars.Name] = true
+		for _, rev := range other.Status.ConfigRevisions {
+			inUse.configMaps[rev.TfConfig] = true
+			inUse.secrets[rev.TfVars] = true
+		}
+	}
+
+	return inUse, nil
+}
+
+// reconcileRevisionObject deletes an object of a revision that is no longer
+// in the history and was created before the oldest one kept, or makes the
+// stack its owner. Objects not in the history created later may be about to
+// be used by the stack and are kept. Objects used by other stacks are only
+// released.
+func (r *StackReconciler) reconcileRevisionObject(ctx context.Context, stack *tfv1alpha1.Stack, obj runtime.Object, inHistory map[string]bool, inUse *revisionsInUse, oldestKept *metav1.Time) error {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+
+	if !inHistory[accessor.GetName()] {
+		created := accessor.GetCreationTimestamp()
+		if oldestKept == nil || !created.Before(oldestKept) {
+			return nil
+		}
+		if inUse.has(obj, accessor.GetName()) {
+			return r.releaseRevisionObject(ctx, stack, obj)
+		}
+		err = r.Delete(ctx, obj)
+		if apierr.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if metav1.IsControlledBy(accessor, stack) {
+		return nil
+	}
+
+	// revisions are immutable, only their metadata can be patched
+	original := obj.DeepCopyObject()
+	accessor.SetOwnerReferences(append(accessor.GetOwnerReferences(), stackOwnerReference(stack)))
+	return r.Patch(ctx, obj, client.MergeFrom(original))
+}
+
+// releaseRevisionObject removes the stack from the owners of the object of a
+// revision, so it is not deleted with the stack
+func (r *StackReconciler) releaseRevisionObject(ctx context.Context, stack *tfv1alpha1.Stack, obj runtime.Object) error {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	owners := []metav1.OwnerReference{}
+	for _, owner := range accessor.GetOwnerReferences() {
+		if owner.UID != stack.UID {
+			owners = append(owners, owner)
+		}
+	}
+	if len(owners) == len(accessor.GetOwnerReferences()) {
+		return nil
+	}
+
+	original := obj.DeepCopyObject()
+	accessor.SetOwnerReferences(owners)
+	return r.Patch(ctx, obj, client.MergeFrom(original))
+}
+
+// releaseConfigRevisions deletes the ConfigMaps and Secrets created as
+// revisions for a stack being deleted if cascade is set, unless other stacks
+// use them. Otherwise, removes the stack from their owners, so they are kept
+// after the stack is deleted.
+func (r *StackReconciler) releaseConfigRevisions(ctx context.Context, stack *tfv1alpha1.Stack, cascade bool) error {
+	inUse, err := r.listRevisionsInUse(ctx, stack)
+	if err != nil {
+		return err
+	}
+
+	configMaps := &corev1.ConfigMapList{}
+	err = r.listRevisions(ctx, stack, configMaps, tfv1alpha1.ConfigHashLabel)
+	if err != nil {
+		return err
+	}
+	secrets := &corev1.SecretList{}
+	err = r.listRevisions(ctx, stack, secrets, tfv1alpha1.TfVarsHashLabel)
+	if err != nil {
+		return err
+	}
+
+	objs := []runtime.Object{}
+	for i := range configMaps.Items {
+		objs = append(objs, &configMaps.Items[i])
+	}
+	for i := range secrets.Items {
+		objs = append(objs, &secrets.Items[i])
+	}
+
+	for _, obj := range objs {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return err
+		}
+
+		if cascade && !inUse.has(obj, accessor.GetName()) {
+			err = r.Delete(ctx, obj)
+			if err != nil && !apierr.IsNotFound(err) {
+				return err
+			}
+			continue
+		}
+
+		err = r.releaseRevisionObject(ctx, stack, obj)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// revisionNames returns the names of the config maps in a revision history
+func revisionNames(history []tfo.ConfigRevision) []string {
+	names := []string{}
+	for _, rev := range history {
+		names = append(names, rev.TfConfig)
+	}
+	return names
+}
+
+var _ = Describe("Config revisions", func() {
+	var (
+		now     = metav1.Now()
+		history []tfo.ConfigRevision
+	)
+
+	BeforeEach(func() {
+		history = nil
+		for _, name := range []string{"a", "b", "c"} {
+			history = recordRevision(history, tfo.ConfigRevision{TfConfig: name, AppliedAt: now}, 3)
+		}
+	})
+
+	It("Should number revisions in sequence", func() {
+		Expect(revisionNames(history)).To(Equal([]string{"a", "b", "c"}))
+		Expect(history[2].Revision).To(Equal(int64(3)))
+	})
+
+	It("Should not record the current revision again", func() {
+		history = recordRevision(history, tfo.ConfigRevision{TfConfig: "c", AppliedAt: now}, 3)
+		Expect(history).To(HaveLen(3))
+		Expect(history[2].Revision).To(Equal(int64(3)))
+	})
+
+	It("Should keep only the last revisions", func() {
+		history = recordRevision(history, tfo.ConfigRevision{TfConfig: "d", AppliedAt: now}, 3)
+		Expect(revisionNames(history)).To(Equal([]string{"b", "c", "d"}))
+		Expect(history[2].Revision).To(Equal(int64(4)))
+	})
+
+	It("Should record changes of the tfvars", func() {
+		history = recordRevision(history, tfo.ConfigRevision{TfConfig: "c", TfVars: "v2", AppliedAt: now}, 3)
+		Expect(revisionNames(history)).To(Equal([]string{"b", "c", "c"}))
+		Expect(history[2].TfVars).To(Equal("v2"))
+	})
+
+	It("Should move a rolled back revision to the end", func() {
+		history = recordRevision(history, tfo.ConfigRevision{TfConfig: "a", AppliedAt: now}, 3)
+		Expect(revisionNames(history)).To(Equal([]string{"b", "c", "a"}))
+		Expect(history[2].Revision).To(Equal(int64(4)))
+	})
+})
+
+var _ = Describe("Config revision objects", func() {
+	var (
+		r     *StackReconciler
+		stack *tfo.Stack
+		objs  []runtime.Object
+	)
+
+	// configMap returns a ConfigMap created as a revision of the stack some
+	// minutes ago, owned by the stack
+	configMap := func(name string, minutes int) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         namespace,
+				Labels:            map[string]string{tfo.StackLabel: "stack", tfo.ConfigHashLabel: name},
+				CreationTimestamp: metav1.NewTime(time.Now().Add(-time.Duration(minutes) * time.Minute)),
+				OwnerReferences:   []metav1.OwnerReference{stackOwnerReference(stack)},
+			},
+		}
+	}
+
+	// get returns a ConfigMap, or nil if it doesn't exist
+	get := func(name string) *corev1.ConfigMap {
+		obj := &corev1.ConfigMap{}
+		err := r.Get(context.TODO(), client.ObjectKey{Name: name, Namespace: namespace}, obj)
+		if apierr.IsNotFound(err) {
+			return nil
+		}
+		Expect(err).NotTo(HaveOccurred())
+		return obj
+	}
+
+	BeforeEach(func() {
+		limit := int32(1)
+		stack = &tfo.Stack{
+			ObjectMeta: metav1.ObjectMeta{Name: "stack", Namespace: namespace, UID: "uid"},
+			Spec: tfo.StackSpec{
+				TfConfig:             corev1.LocalObjectReference{Name: "current"},
+				RevisionHistoryLimit: &limit,
+			},
+			Status: tfo.StackStatus{
+				ConfigRevisions: []tfo.ConfigRevision{{TfConfig: "previous", Revision: 1}},
+			},
+		}
+		objs = []runtime.Object{
+			stack.DeepCopy(),
+			configMap("previous", 30),
+			configMap("current", 20),
+			configMap("next", 0),
+		}
+	})
+
+	JustBeforeEach(func() {
+		sch := runtime.NewScheme()
+		Expect(tfo.AddToScheme(sch)).To(Succeed())
+		Expect(corev1.AddToScheme(sch)).To(Succeed())
+		r = &StackReconciler{
+			Client: fake.NewFakeClientWithScheme(sch, objs...),
+			Log:    ctrl.Log,
+			Scheme: sch,
+		}
+	})
+
+	It("Should delete only the revisions older than the history", func() {
+		Expect(r.reconcileConfigRevisions(context.TODO(), stack)).To(Succeed())
+		Expect(revisionNames(stack.Status.ConfigRevisions)).To(Equal([]string{"current"}))
+		Expect(get("previous")).To(BeNil())
+		Expect(get("next")).NotTo(BeNil())
+		Expect(metav1.IsControlledBy(get("current"), stack)).To(BeTrue())
+	})
+
+	It("Should delete the revisions with the stack", func() {
+		Expect(r.releaseConfigRevisions(context.TODO(), stack, true)).To(Succeed())
+		Expect(get("previous")).To(BeNil())
+		Expect(get("current")).To(BeNil())
+	})
+
+	Context("used by other stacks", func() {
+		BeforeEach(func() {
+			objs = append(objs, &tfo.Stack{
+				ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: namespace, UID: "other-uid"},
+				Spec: tfo.StackSpec{
+					TfConfig: corev1.LocalObjectReference{Name: "previous"},
+				},
+			})
+		})
+
+		It("Should only release them from the stack", func() {
+			Expect(r.reconcileConfigRevisions(context.TODO(), stack)).To(Succeed())
+			previous := get("previous")
+			Expect(previous).NotTo(BeNil())
+			Expect(previous.OwnerReferences).To(BeEmpty())
+		})
+
+		It("Should keep them when the stack is deleted", func() {
+			Expect(r.releaseConfigRevisions(context.TODO(), stack, true)).To(Succeed())
+			previous := get("previous")
+			Expect(previous).NotTo(BeNil())
+			Expect(previous.OwnerReferences).To(BeEmpty())
+			Expect(get("current")).To(BeNil())
+		})
+	})
+})
@@ -0,0 +1,147 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	tfv1alpha1 "github.com/pablochacin/tf-operator/api/v1alpha1"
+	"github.com/pablochacin/tf-operator/pkg/jobs"
+)
+
+// reconcileRunnerAccess creates the ServiceAccount the stack's Jobs run as,
+// with a Role that only allows it to record the results of the stack's runs,
+// and returns its name. The Secret for the stack's sensitive outputs is
+// created empty, so the runs only update it.
+func (r *StackReconciler) reconcileRunnerAccess(ctx context.Context, stack *tfv1alpha1.Stack) (string, error) {
+	name := jobs.RunnerName(stack.Name)
+	objectMeta := func(name string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       stack.Namespace,
+			Labels:          map[string]string{tfv1alpha1.StackLabel: stack.Name},
+			OwnerReferences: []metav1.OwnerReference{stackOwnerReference(stack)},
+		}
+	}
+
+	err := r.createIfMissing(ctx, &corev1.Secret{ObjectMeta: objectMeta(jobs.SensitiveOutputsName(stack.Name))})
+	if err != nil {
+		return "", err
+	}
+
+	err = r.createIfMissing(ctx, &corev1.ServiceAccount{ObjectMeta: objectMeta(name)})
+	if err != nil {
+		return "", err
+	}
+
+	err = r.reconcileRunnerRole(ctx, &rbacv1.Role{ObjectMeta: objectMeta(name), Rules: runnerRules(stack)})
+	if err != nil {
+		return "", err
+	}
+
+	err = r.createIfMissing(ctx, &rbacv1.RoleBinding{
+		ObjectMeta: objectMeta(name),
+		Subjects: []rbacv1.Subject{{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      name,
+			Namespace: stack.Namespace,
+		}},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     name,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// runnerRules returns the rules of the Role of the stack's runner, limited to
+// the stack and its Secrets. The runner encrypts the sensitive outputs, so it
+// can read the stack's encryption keys, if any.
+func runnerRules(stack *tfv1alpha1.Stack) []rbacv1.PolicyRule {
+	rules := []rbacv1.PolicyRule{
+		{
+			APIGroups:     []string{tfv1alpha1.GroupVersion.Group},
+			Resources:     []string{"stacks"},
+			ResourceNames: []string{stack.Name},
+			Verbs:         []string{"get"},
+		},
+		{
+			APIGroups:     []string{tfv1alpha1.GroupVersion.Group},
+			Resources:     []string{"stacks/status"},
+			ResourceNames: []string{stack.Name},
+			Verbs:         []string{"get", "update"},
+		},
+		{
+			APIGroups:     []string{""},
+			Resources:     []string{"secrets"},
+			ResourceNames: []string{jobs.SensitiveOutputsName(stack.Name)},
+			Verbs:         []string{"get", "update"},
+		},
+	}
+
+	if stack.Spec.Encryption != nil {
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups:     []string{""},
+			Resources:     []string{"secrets"},
+			ResourceNames: []string{stack.Spec.Encryption.KeySecret.Name},
+			Verbs:         []string{"get"},
+		})
+	}
+
+	return rules
+}
+
+// reconcileRunnerRole creates the Role of the stack's runner, or updates its
+// rules if they changed
+func (r *StackReconciler) reconcileRunnerRole(ctx context.Context, role *rbacv1.Role) error {
+	current := &rbacv1.Role{}
+	err := r.Get(ctx, types.NamespacedName{Name: role.Name, Namespace: role.Namespace}, current)
+	if apierr.IsNotFound(err) {
+		return r.Create(ctx, role)
+	}
+	if err != nil {
+		return err
+	}
+
+	if equality.Semantic.DeepEqual(current.Rules, role.Rules) {
+		return nil
+	}
+	current.Rules = role.Rules
+	return r.Update(ctx, current)
+}
+
+// createIfMissing creates an object of the stack's runner unless it exists
+func (r *StackReconciler) createIfMissing(ctx context.Context, obj runtime.Object) error {
+	err := r.Create(ctx, obj)
+	if apierr.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
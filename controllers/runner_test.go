@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Stack runner access", func() {
+	var (
+		r     *StackReconciler
+		stack *tfo.Stack
+	)
+
+	BeforeEach(func() {
+		stack = &tfo.Stack{
+			ObjectMeta: metav1.ObjectMeta{Name: "stack", Namespace: namespace, UID: "uid"},
+			Spec: tfo.StackSpec{
+				TfConfig: corev1.LocalObjectReference{Name: "stack-tfconf"},
+				TfVars:   corev1.LocalObjectReference{Name: "stack-tfvars"},
+			},
+		}
+
+		sch := runtime.NewScheme()
+		Expect(tfo.AddToScheme(sch)).To(Succeed())
+		Expect(batchv1.AddToScheme(sch)).To(Succeed())
+		Expect(coordinationv1.AddToScheme(sch)).To(Succeed())
+		Expect(corev1.AddToScheme(sch)).To(Succeed())
+		Expect(rbacv1.AddToScheme(sch)).To(Succeed())
+		r = &StackReconciler{
+			Client:          fake.NewFakeClientWithScheme(sch, stack.DeepCopy()),
+			Log:             ctrl.Log,
+			Scheme:          sch,
+			StateBackendURL: "http://tf-operator:8082",
+		}
+	})
+
+	// get gets an object of the stack's runner
+	get := func(name string, obj runtime.Object) {
+		Expect(r.Get(context.TODO(), client.ObjectKey{Name: name, Namespace: namespace}, obj)).To(Succeed())
+	}
+
+	It("Should run the Jobs as the stack's service account", func() {
+		Expect(r.startRun(context.TODO(), stack, newJobConfig(stack, "apply", nil))).To(Succeed())
+
+		job := &batchv1.Job{}
+		get(stack.Status.Job, job)
+		Expect(job.Spec.Template.Spec.ServiceAccountName).To(Equal("stack-runner"))
+
+		account := &corev1.ServiceAccount{}
+		get("stack-runner", account)
+		Expect(metav1.IsControlledBy(account, stack)).To(BeTrue())
+
+		binding := &rbacv1.RoleBinding{}
+		get("stack-runner", binding)
+		Expect(binding.RoleRef.Name).To(Equal("stack-runner"))
+		Expect(binding.Subjects).To(ConsistOf(rbacv1.Subject{Kind: "ServiceAccount", Name: "stack-runner", Namespace: namespace}))
+	})
+
+	It("Should only allow the runner to access the stack and its outputs", func() {
+		_, err := r.reconcileRunnerAccess(context.TODO(), stack)
+		Expect(err).NotTo(HaveOccurred())
+
+		role := &rbacv1.Role{}
+		get("stack-runner", role)
+		Expect(role.Rules).To(HaveLen(3))
+		for _, rule := range role.Rules {
+			Expect(rule.ResourceNames).NotTo(BeEmpty())
+			Expect(rule.Verbs).NotTo(ContainElements("create", "delete", "list"))
+		}
+		Expect(role.Rules[2].ResourceNames).To(Equal([]string{"stack-outputs"}))
+
+		// created empty for the runs to update it
+		get("stack-outputs", &corev1.Secret{})
+	})
+
+	It("Should allow the runner to read the stack's encryption keys", func() {
+		_, err := r.reconcileRunnerAccess(context.TODO(), stack)
+		Expect(err).NotTo(HaveOccurred())
+
+		stack.Spec.Encryption = &tfo.StackEncryption{KeySecret: corev1.LocalObjectReference{Name: "keys"}}
+		_, err = r.reconcileRunnerAccess(context.TODO(), stack)
+		Expect(err).NotTo(HaveOccurred())
+
+		role := &rbacv1.Role{}
+		get("stack-runner", role)
+		Expect(role.Rules).To(ContainElement(rbacv1.PolicyRule{
+			APIGroups:     []string{""},
+			Resources:     []string{"secrets"},
+			ResourceNames: []string{"keys"},
+			Verbs:         []string{"get"},
+		}))
+	})
+
+	It("Should keep the objects of the runner across runs", func() {
+		_, err := r.reconcileRunnerAccess(context.TODO(), stack)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = r.reconcileRunnerAccess(context.TODO(), stack)
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
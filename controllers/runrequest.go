@@ -0,0 +1,102 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	tfv1alpha1 "github.com/pablochacin/tf-operator/api/v1alpha1"
+	"github.com/pablochacin/tf-operator/pkg/terraform"
+)
+
+// runRequest returns the run requested in the stack's annotation, or nil if
+// there is none. Requests whose run already started are ignored, as the
+// annotation may not have been removed when the run started.
+func runRequest(stack *tfv1alpha1.Stack) (*tfv1alpha1.RunRequest, error) {
+	value, found := stack.Annotations[tfv1alpha1.RunRequestAnnotation]
+	if !found {
+		return nil, nil
+	}
+
+	request := &tfv1alpha1.RunRequest{}
+	err := json.Unmarshal([]byte(value), request)
+	if err != nil {
+		return nil, fmt.Errorf("invalid run request: %v", err)
+	}
+	if request.ID == "" {
+		return nil, fmt.Errorf("invalid run request: missing id")
+	}
+	err = runOptions(request).Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid run request %s: %v", request.ID, err)
+	}
+
+	lastRun := stack.Status.LastRun
+	if lastRun != nil && lastRun.Request != nil && lastRun.Request.ID == request.ID {
+		return nil, nil
+	}
+
+	return request, nil
+}
+
+// runOptions returns the terraform options of a run request
+func runOptions(request *tfv1alpha1.RunRequest) terraform.RunOptions {
+	return terraform.RunOptions{
+		Targets:     request.Targets,
+		Replace:     request.Replace,
+		RefreshOnly: request.RefreshOnly,
+		Vars:        request.Vars,
+	}
+}
+
+// runRequestArgs returns the arguments of the Job's command for the options
+// of a run request, with variables in alphabetical order
+func runRequestArgs(request *tfv1alpha1.RunRequest) []string {
+	args := []string{}
+	for _, target := range request.Targets {
+		args = append(args, "--target", target)
+	}
+	for _, address := range request.Replace {
+		args = append(args, "--replace", address)
+	}
+	if request.RefreshOnly {
+		args = append(args, "--refresh-only")
+	}
+
+	names := []string{}
+	for name := range request.Vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, "--var", name+"="+request.Vars[name])
+	}
+
+	return args
+}
+
+// clearRunRequest removes the run request annotation from the stack
+func (r *StackReconciler) clearRunRequest(ctx context.Context, stack *tfv1alpha1.Stack) error {
+	if _, found := stack.Annotations[tfv1alpha1.RunRequestAnnotation]; !found {
+		return nil
+	}
+	delete(stack.Annotations, tfv1alpha1.RunRequestAnnotation)
+	return r.Update(ctx, stack)
+}
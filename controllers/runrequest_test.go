@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+	"github.com/pablochacin/tf-operator/pkg/statelock"
+	batchv1 "k8s.io/api/batch/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Stack run requests", func() {
+	var (
+		r        *StackReconciler
+		recorder *record.FakeRecorder
+		stack    *tfo.Stack
+	)
+
+	BeforeEach(func() {
+		stack = &tfo.Stack{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       "stack",
+				Namespace:  namespace,
+				Generation: 2,
+				Finalizers: []string{tfo.StackFinalizer},
+				Annotations: map[string]string{
+					tfo.RunRequestAnnotation: `{"id": "r1", "targets": ["module.db"], "vars": {"size": "large"}}`,
+				},
+			},
+			Spec: tfo.StackSpec{
+				TfConfig: corev1.LocalObjectReference{Name: "stack-tfconf"},
+				TfVars:   corev1.LocalObjectReference{Name: "stack-tfvars"},
+			},
+			Status: tfo.StackStatus{
+				Phase:              tfo.StackPhaseReady,
+				Job:                "stack-apply-a",
+				LastRun:            &tfo.StackRun{Number: 1},
+				ObservedGeneration: 2,
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		sch := runtime.NewScheme()
+		Expect(tfo.AddToScheme(sch)).To(Succeed())
+		Expect(batchv1.AddToScheme(sch)).To(Succeed())
+		Expect(coordinationv1.AddToScheme(sch)).To(Succeed())
+		Expect(corev1.AddToScheme(sch)).To(Succeed())
+		Expect(rbacv1.AddToScheme(sch)).To(Succeed())
+		recorder = record.NewFakeRecorder(10)
+		r = &StackReconciler{
+			Client:          fake.NewFakeClientWithScheme(sch, stack.DeepCopy()),
+			Log:             ctrl.Log,
+			Scheme:          sch,
+			Recorder:        recorder,
+			StateBackendURL: "http://tf-operator:8082",
+		}
+	})
+
+	// reconciled returns the stack after a reconcile
+	reconciled := func() *tfo.Stack {
+		current := &tfo.Stack{}
+		key := client.ObjectKey{Name: stack.Name, Namespace: namespace}
+		Expect(r.Get(context.TODO(), key, current)).To(Succeed())
+		_, err := r.reconcileUpdate(context.TODO(), *current)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Get(context.TODO(), key, current)).To(Succeed())
+		return current
+	}
+
+	It("Should run the request once with its options", func() {
+		updated := reconciled()
+		Expect(updated.Status.Phase).To(Equal(tfo.StackPhaseRunning))
+		Expect(updated.Status.LastRun.Number).To(Equal(int64(2)))
+		Expect(updated.Status.LastRun.Request.ID).To(Equal("r1"))
+		Expect(updated.Annotations).NotTo(HaveKey(tfo.RunRequestAnnotation))
+
+		job := &batchv1.Job{}
+		Expect(r.Get(context.TODO(), client.ObjectKey{Name: updated.Status.Job, Namespace: namespace}, job)).To(Succeed())
+		Expect(job.Spec.Template.Spec.Containers[0].Args[:10]).To(Equal([]string{
+			"--target", "module.db", "--var", "size=large", "--stack", "stack", "--namespace", namespace, "--run", "2",
+		}))
+	})
+
+	Context("already started", func() {
+		BeforeEach(func() {
+			stack.Status.LastRun.Request = &tfo.RunRequest{ID: "r1"}
+		})
+
+		It("Should remove the request without running it again", func() {
+			updated := reconciled()
+			Expect(updated.Status.Phase).To(Equal(tfo.StackPhaseReady))
+			Expect(updated.Annotations).NotTo(HaveKey(tfo.RunRequestAnnotation))
+		})
+	})
+
+	Context("with invalid options", func() {
+		BeforeEach(func() {
+			stack.Annotations[tfo.RunRequestAnnotation] = `{"id": "r2", "replace": ["module.db"]}`
+		})
+
+		It("Should discard the request recording an event", func() {
+			updated := reconciled()
+			Expect(updated.Status.Phase).To(Equal(tfo.StackPhaseReady))
+			Expect(updated.Annotations).NotTo(HaveKey(tfo.RunRequestAnnotation))
+			Expect(recorder.Events).To(Receive(ContainSubstring("InvalidRunRequest invalid run request r2")))
+		})
+	})
+
+	It("Should hold the state lock until the run ends", func() {
+		updated := reconciled()
+		Expect(updated.Status.Phase).To(Equal(tfo.StackPhaseRunning))
+		locker := statelock.ForStack(r.Client, stack)
+		holder, err := locker.Get(context.TODO())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(holder.ID).To(Equal(statelock.RunLockID(stack.Name, 2)))
+
+		job := &batchv1.Job{}
+		Expect(r.Get(context.TODO(), client.ObjectKey{Name: updated.Status.Job, Namespace: namespace}, job)).To(Succeed())
+		job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}}
+		Expect(r.Status().Update(context.TODO(), job)).To(Succeed())
+
+		updated = reconciled()
+		Expect(updated.Status.Phase).To(Equal(tfo.StackPhaseFailed))
+		holder, err = locker.Get(context.TODO())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(holder).To(BeNil())
+	})
+
+	Context("with the state locked", func() {
+		It("Should wait for the lock keeping the request", func() {
+			_, err := statelock.ForStack(r.Client, stack).Acquire(context.TODO(), statelock.Info{Operation: "push", Who: "user@host"}, statelock.DefaultTTL)
+			Expect(err).NotTo(HaveOccurred())
+
+			current := &tfo.Stack{}
+			key := client.ObjectKey{Name: stack.Name, Namespace: namespace}
+			Expect(r.Get(context.TODO(), key, current)).To(Succeed())
+			result, err := r.reconcileUpdate(context.TODO(), *current)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(stateLockRetryInterval))
+
+			Expect(r.Get(context.TODO(), key, current)).To(Succeed())
+			Expect(current.Status.Phase).To(Equal(tfo.StackPhaseWaiting))
+			Expect(current.Status.Message).To(HavePrefix("waiting for state lock held by user@host (push"))
+			Expect(current.Annotations).To(HaveKey(tfo.RunRequestAnnotation))
+		})
+	})
+})
@@ -0,0 +1,309 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	tfv1alpha1 "github.com/pablochacin/tf-operator/api/v1alpha1"
+	"github.com/pablochacin/tf-operator/pkg/source"
+)
+
+const (
+	// default interval for polling remote sources for new revisions
+	defaultPollInterval = 5 * time.Minute
+
+	// prefix of the message of stacks whose source can't be resolved
+	sourceErrorMessage = "unable to resolve source"
+)
+
+// remoteSource describes the revision of the stack's remote source to apply
+type remoteSource struct {
+	// revision of the source
+	revision string
+	// arguments for fetching the revision in the apply Job
+	fetch []string
+	// Secret with the credentials for fetching the revision
+	secret string
+	// interval for checking for new revisions
+	pollInterval time.Duration
+}
+
+// sourceCache keeps the revisions resolved for the stacks' remote sources
+// until their poll interval expires, so the sources are not polled on every
+// reconcile
+type sourceCache struct {
+	mutex   sync.Mutex
+	entries map[types.NamespacedName]sourceCacheEntry
+}
+
+// sourceCacheEntry is the revision resolved for a stack's source spec
+type sourceCacheEntry struct {
+	spec       *tfv1alpha1.StackSource
+	src        *remoteSource
+	resolvedAt time.Time
+}
+
+// get returns the revision resolved for a stack's source, or nil if the
+// source changed or its poll interval expired
+func (c *sourceCache) get(key types.NamespacedName, spec *tfv1alpha1.StackSource, now time.Time) *remoteSource {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, found := c.entries[key]
+	if !found || !reflect.DeepEqual(entry.spec, spec) {
+		return nil
+	}
+	if entry.src.pollInterval > 0 && now.Sub(entry.resolvedAt) >= entry.src.pollInterval {
+		return nil
+	}
+
+	return entry.src
+}
+
+// set records the revision resolved for a stack's source
+func (c *sourceCache) set(key types.NamespacedName, spec *tfv1alpha1.StackSource, src *remoteSource, now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.entries == nil {
+		c.entries = map[types.NamespacedName]sourceCacheEntry{}
+	}
+	c.entries[key] = sourceCacheEntry{spec: spec.DeepCopy(), src: src, resolvedAt: now}
+}
+
+// forget removes the revision resolved for a stack's source
+func (c *sourceCache) forget(key types.NamespacedName) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.entries, key)
+}
+
+// sourceRetryInterval returns the interval for retrying to resolve a stack's
+// source that failed
+func sourceRetryInterval(src *tfv1alpha1.StackSource) time.Duration {
+	var interval *metav1.Duration
+	switch {
+	case src.Git != nil:
+		interval = src.Git.PollInterval
+	case src.OCI != nil:
+		interval = src.OCI.PollInterval
+	}
+	if interval != nil && interval.Duration > 0 {
+		return interval.Duration
+	}
+	return defaultPollInterval
+}
+
+// sourceFailed indicates if the stack failed because its source couldn't be
+// resolved
+func sourceFailed(stack *tfv1alpha1.Stack) bool {
+	return stack.Status.Phase == tfv1alpha1.StackPhaseFailed &&
+		strings.HasPrefix(stack.Status.Message, sourceErrorMessage)
+}
+
+// validateSource checks the stack specifies exactly one source for the tf config
+func validateSource(stack *tfv1alpha1.Stack) error {
+	hasConfig := stack.Spec.TfConfig.Name != ""
+	hasSource := stack.Spec.Source != nil
+	if hasConfig == hasSource {
+		return fmt.Errorf("either tfconfig or source must be specified")
+	}
+	if !hasSource {
+		return nil
+	}
+
+	sources := 0
+	if stack.Spec.Source.Git != nil {
+		sources++
+	}
+	if stack.Spec.Source.Archive != nil {
+		sources++
+	}
+	if stack.Spec.Source.OCI != nil {
+		sources++
+	}
+	if sources != 1 {
+		return fmt.Errorf("source must specify exactly one of git, archive or oci")
+	}
+
+	git := stack.Spec.Source.Git
+	if git != nil {
+		refs := 0
+		for _, ref := range []string{git.Branch, git.Tag, git.Commit} {
+			if ref != "" {
+				refs++
+			}
+		}
+		if refs > 1 {
+			return fmt.Errorf("git source must specify at most one of branch, tag or commit")
+		}
+	}
+
+	return nil
+}
+
+// resolveSource resolves the revision of the stack's remote source, if any.
+// The revision is resolved again once the source's poll interval expires.
+func (r *StackReconciler) resolveSource(ctx context.Context, stack *tfv1alpha1.Stack) (*remoteSource, error) {
+	key := types.NamespacedName{Name: stack.Name, Namespace: stack.Namespace}
+	if stack.Spec.Source == nil {
+		r.sources.forget(key)
+		return nil, nil
+	}
+
+	now := time.Now()
+	src := r.sources.get(key, stack.Spec.Source, now)
+	if src != nil {
+		return src, nil
+	}
+
+	src, err := r.resolveRemoteSource(ctx, stack)
+	if err != nil {
+		return nil, err
+	}
+	r.sources.set(key, stack.Spec.Source, src, now)
+
+	return src, nil
+}
+
+// resolveRemoteSource resolves the revision of the stack's remote source
+func (r *StackReconciler) resolveRemoteSource(ctx context.Context, stack *tfv1alpha1.Stack) (*remoteSource, error) {
+	switch {
+	case stack.Spec.Source.Git != nil:
+		return r.resolveGitSource(ctx, stack.Namespace, stack.Spec.Source.Git)
+	case stack.Spec.Source.Archive != nil:
+		return resolveArchiveSource(stack.Spec.Source.Archive), nil
+	case stack.Spec.Source.OCI != nil:
+		return r.resolveOCISource(ctx, stack.Namespace, stack.Spec.Source.OCI)
+	}
+
+	return nil, nil
+}
+
+// getCredentials returns the content of the Secret with the credentials for
+// a remote source, or nil if no Secret is referenced
+func (r *StackReconciler) getCredentials(ctx context.Context, namespace string, ref *corev1.LocalObjectReference) (map[string][]byte, error) {
+	if ref == nil {
+		return nil, nil
+	}
+
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: namespace}, secret)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get credentials %s: %v", ref.Name, err)
+	}
+
+	return secret.Data, nil
+}
+
+// resolveGitSource resolves the commit to apply from a git repository
+func (r *StackReconciler) resolveGitSource(ctx context.Context, namespace string, git *tfv1alpha1.GitSource) (*remoteSource, error) {
+	src := &remoteSource{
+		pollInterval: defaultPollInterval,
+	}
+	if git.PollInterval != nil {
+		src.pollInterval = git.PollInterval.Duration
+	}
+
+	data, err := r.getCredentials(ctx, namespace, git.SecretRef)
+	if err != nil {
+		return nil, err
+	}
+	var credentials *source.GitCredentials
+	if data != nil {
+		credentials = source.NewGitCredentials(data)
+		src.secret = git.SecretRef.Name
+	}
+
+	commit, err := source.NewGit(git.URL, credentials).ResolveRef(git.Branch, git.Tag, git.Commit)
+	if err != nil {
+		return nil, err
+	}
+
+	src.revision = commit
+	src.fetch = []string{"git", "--url", git.URL, "--commit", commit}
+	if git.Subdirectory != "" {
+		src.fetch = append(src.fetch, "--subdir", git.Subdirectory)
+	}
+
+	return src, nil
+}
+
+// resolveArchiveSource returns the archive to apply. As archives are pinned
+// to a digest, they are not polled.
+func resolveArchiveSource(archive *tfv1alpha1.ArchiveSource) *remoteSource {
+	digest := "sha256:" + strings.TrimPrefix(strings.ToLower(archive.SHA256), "sha256:")
+	src := &remoteSource{
+		revision: digest,
+		fetch:    []string{"archive", "--url", archive.URL, "--sha256", digest},
+	}
+	if archive.SecretRef != nil {
+		src.secret = archive.SecretRef.Name
+	}
+
+	return src
+}
+
+// resolveOCISource resolves the digest of the artifact to apply from an OCI
+// registry. Artifacts pinned to a digest are not polled.
+func (r *StackReconciler) resolveOCISource(ctx context.Context, namespace string, oci *tfv1alpha1.OCISource) (*remoteSource, error) {
+	src := &remoteSource{
+		revision: oci.Digest,
+	}
+	if oci.SecretRef != nil {
+		src.secret = oci.SecretRef.Name
+	}
+
+	if src.revision == "" {
+		src.pollInterval = defaultPollInterval
+		if oci.PollInterval != nil {
+			src.pollInterval = oci.PollInterval.Duration
+		}
+
+		data, err := r.getCredentials(ctx, namespace, oci.SecretRef)
+		if err != nil {
+			return nil, err
+		}
+		var credentials *source.HTTPCredentials
+		if data != nil {
+			credentials = source.NewHTTPCredentials(data)
+		}
+
+		src.revision, err = source.NewOCI(oci.Insecure, credentials).ResolveDigest(oci.Reference)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	src.fetch = []string{"oci", "--reference", oci.Reference, "--digest", src.revision}
+	if oci.Insecure {
+		src.fetch = append(src.fetch, "--insecure")
+	}
+
+	return src, nil
+}
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// runGit runs a git command in a directory and returns its output
+func runGit(dir string, args ...string) string {
+	args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	Expect(err).NotTo(HaveOccurred(), string(output))
+	return strings.TrimSpace(string(output))
+}
+
+var _ = Describe("Stack source", func() {
+	stackWithGit := func(git *tfo.GitSource) *tfo.Stack {
+		return &tfo.Stack{Spec: tfo.StackSpec{Source: &tfo.StackSource{Git: git}}}
+	}
+
+	It("Should accept a single git reference", func() {
+		Expect(validateSource(stackWithGit(&tfo.GitSource{URL: "https://example.com/infra.git"}))).To(Succeed())
+		Expect(validateSource(stackWithGit(&tfo.GitSource{URL: "https://example.com/infra.git", Tag: "v1.0"}))).To(Succeed())
+	})
+
+	It("Should reject more than one git reference", func() {
+		err := validateSource(stackWithGit(&tfo.GitSource{URL: "https://example.com/infra.git", Branch: "main", Commit: "abc"}))
+		Expect(err).To(MatchError("git source must specify at most one of branch, tag or commit"))
+	})
+
+	Context("resolving a git source", func() {
+		var (
+			r      *StackReconciler
+			stack  *tfo.Stack
+			root   string
+			commit string
+		)
+
+		BeforeEach(func() {
+			var err error
+			root, err = ioutil.TempDir("", "repo")
+			Expect(err).NotTo(HaveOccurred())
+			work := filepath.Join(root, "work")
+			runGit(root, "init", "--quiet", "--bare", "repo.git")
+			runGit(root, "clone", "--quiet", "repo.git", work)
+			Expect(ioutil.WriteFile(filepath.Join(work, "main.tf"), []byte(""), 0666)).To(Succeed())
+			runGit(work, "add", ".")
+			runGit(work, "commit", "--quiet", "-m", "init")
+			runGit(work, "push", "--quiet", "origin", "HEAD")
+			commit = runGit(work, "rev-parse", "HEAD")
+
+			stack = stackWithGit(&tfo.GitSource{
+				URL:          "file://" + filepath.Join(root, "repo.git"),
+				PollInterval: &metav1.Duration{Duration: time.Hour},
+			})
+			stack.ObjectMeta = metav1.ObjectMeta{Name: "stack", Namespace: namespace}
+
+			sch := runtime.NewScheme()
+			Expect(tfo.AddToScheme(sch)).To(Succeed())
+			r = &StackReconciler{
+				Client:          fake.NewFakeClientWithScheme(sch, stack.DeepCopy()),
+				Log:             ctrl.Log,
+				Scheme:          sch,
+				StateBackendURL: "http://tf-operator:8082",
+			}
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(root)
+		})
+
+		It("Should not poll the source again until its poll interval expires", func() {
+			src, err := r.resolveSource(context.TODO(), stack)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(src.revision).To(Equal(commit))
+
+			Expect(os.RemoveAll(root)).To(Succeed())
+			src, err = r.resolveSource(context.TODO(), stack)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(src.revision).To(Equal(commit))
+
+			// a changed source is resolved again
+			stack.Spec.Source.Git.Branch = "master"
+			_, err = r.resolveSource(context.TODO(), stack)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Should poll the source again once its poll interval expires", func() {
+			stack.Spec.Source.Git.PollInterval.Duration = time.Millisecond
+			_, err := r.resolveSource(context.TODO(), stack)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(os.RemoveAll(root)).To(Succeed())
+			time.Sleep(2 * time.Millisecond)
+			_, err = r.resolveSource(context.TODO(), stack)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Should fail the stack if the source can't be resolved", func() {
+			Expect(os.RemoveAll(root)).To(Succeed())
+			key := types.NamespacedName{Name: stack.Name, Namespace: stack.Namespace}
+			result, err := r.Reconcile(ctrl.Request{NamespacedName: key})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(time.Hour))
+
+			updated := &tfo.Stack{}
+			Expect(r.Get(context.TODO(), client.ObjectKey(key), updated)).To(Succeed())
+			Expect(updated.Status.Phase).To(Equal(tfo.StackPhaseFailed))
+			Expect(updated.Status.Message).To(HavePrefix(sourceErrorMessage))
+			Expect(sourceFailed(updated)).To(BeTrue())
+		})
+	})
+})
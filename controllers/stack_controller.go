@@ -18,14 +18,31 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	tfv1alpha1 "github.com/pablochacin/tf-operator/api/v1alpha1"
-    "github.com/pablochacin/tf-operator/pkg/jobs"
+	"github.com/pablochacin/tf-operator/pkg/jobs"
+	"github.com/pablochacin/tf-operator/pkg/statelock"
+)
+
+const (
+	// interval for checking again the state lock of a stack waiting for it
+	stateLockRetryInterval = 30 * time.Second
 )
 
 // StackReconciler reconciles a Stack object
@@ -33,63 +50,576 @@ type StackReconciler struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
+
+	// Access to the logs of the Jobs' pods for archiving them. If not set,
+	// logs are not archived
+	Logs jobs.PodLogs
+
+	// Records events on the stack's phase changes. If not set, events are
+	// not recorded
+	Recorder record.EventRecorder
+
+	// URL of the state backend served by the manager, used by the Jobs for
+	// accessing the stacks' state. If not set, the stacks must configure
+	// their backend
+	StateBackendURL string
+
+	// revisions resolved for the stacks' remote sources
+	sources sourceCache
 }
 
 // +kubebuilder:rbac:groups=tf.tf-operator.io,resources=stacks,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=tf.tf-operator.io,resources=stacks/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;patch;delete
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list
+// +kubebuilder:rbac:groups="",resources=pods/log,verbs=get
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=get;create
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=rolebindings;roles,verbs=get;create;update
 
 func (r *StackReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx := context.Background()
 	log := r.Log.WithValues("stack", req.NamespacedName)
 
-    var stack = tfv1alpha1.Stack{}
-    err := r.Get(ctx, req.NamespacedName, &stack)
-    if err != nil {
-        log.Error(err, "unable to fetch Stack")
-        return ctrl.Result{}, client.IgnoreNotFound(err)
-    }
+	var stack = tfv1alpha1.Stack{}
+	err := r.Get(ctx, req.NamespacedName, &stack)
+	if err != nil {
+		log.Error(err, "unable to fetch Stack")
+		if apierr.IsNotFound(err) {
+			r.sources.forget(req.NamespacedName)
+		}
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
 
-    // is stack been deleted?
+	// is stack been deleted?
 	if !stack.ObjectMeta.DeletionTimestamp.IsZero() {
 		return r.reconcileDelete(ctx, stack)
 	}
 
-    return r.reconcileUpdate(ctx, stack)
-
-	return ctrl.Result{}, nil
+	return r.reconcileUpdate(ctx, stack)
 }
 
 func (r *StackReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&tfv1alpha1.Stack{}).
+		Owns(&batchv1.Job{}).
+		Owns(&coordinationv1.Lease{}).
+		Watches(
+			&source.Kind{Type: &tfv1alpha1.Stack{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.dependentStacks)},
+		).
 		Complete(r)
 }
 
-// reconcileDelete handles Stack delete
-func (r *StackReconciler)reconcileDelete(ctx context.Context, stack tfv1alpha1.Stack) (ctrl.Result, error) {
-    return ctrl.Result{}, nil
+// reconcileDelete handles Stack delete, running a Job for destroying the
+// stack's resources, unless they are orphaned, before removing the finalizer
+func (r *StackReconciler) reconcileDelete(ctx context.Context, stack tfv1alpha1.Stack) (ctrl.Result, error) {
+	if !containsString(stack.Finalizers, tfv1alpha1.StackFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	// the resources of a plan-only stack were never applied by it
+	if stack.Annotations[tfv1alpha1.OrphanResourcesAnnotation] != "true" && !stack.Spec.PlanOnly {
+		// wait for the running apply to complete before destroying
+		if stack.Status.Phase == tfv1alpha1.StackPhaseRunning {
+			return r.reconcileJob(ctx, stack)
+		}
+
+		destroyed, result, err := r.reconcileDestroy(ctx, &stack)
+		if err != nil || !destroyed {
+			return result, err
+		}
+	}
+
+	err := r.releaseConfigRevisions(ctx, &stack, stack.Annotations[tfv1alpha1.CascadeAnnotation] == "true")
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	stack.Finalizers = removeString(stack.Finalizers, tfv1alpha1.StackFinalizer)
+	return ctrl.Result{}, r.Update(ctx, &stack)
+}
+
+// reconcileDestroy starts a Job for destroying the stack's resources, if
+// there is none, and indicates if it has completed. If the Job fails, the
+// stack stays in the Destroying phase with the failure as message, until the
+// Job is deleted for retrying or the resources are orphaned.
+func (r *StackReconciler) reconcileDestroy(ctx context.Context, stack *tfv1alpha1.Stack) (bool, ctrl.Result, error) {
+	if stack.Status.Phase == tfv1alpha1.StackPhaseDestroying {
+		job := &batchv1.Job{}
+		err := r.Get(ctx, types.NamespacedName{Name: stack.Status.Job, Namespace: stack.Namespace}, job)
+		if err == nil {
+			for _, cond := range job.Status.Conditions {
+				if cond.Status != corev1.ConditionTrue {
+					continue
+				}
+				switch cond.Type {
+				case batchv1.JobComplete:
+					return true, ctrl.Result{}, r.releaseRunLock(ctx, stack)
+				case batchv1.JobFailed:
+					r.archiveLog(ctx, stack, job)
+					err = r.releaseRunLock(ctx, stack)
+					if err != nil {
+						return false, ctrl.Result{}, err
+					}
+					msg := fmt.Sprintf("destroy failed: %s", cond.Message)
+					return false, ctrl.Result{}, r.setPhase(ctx, stack, tfv1alpha1.StackPhaseDestroying, msg)
+				}
+			}
+			return false, ctrl.Result{}, nil
+		}
+		if !apierr.IsNotFound(err) {
+			return false, ctrl.Result{}, err
+		}
+
+		// the deleted Job's run has ended
+		err = r.releaseRunLock(ctx, stack)
+		if err != nil {
+			return false, ctrl.Result{}, err
+		}
+	}
+
+	src, err := r.resolveSource(ctx, stack)
+	if err != nil {
+		msg := fmt.Sprintf("%s: %s", sourceErrorMessage, err)
+		result := ctrl.Result{RequeueAfter: sourceRetryInterval(stack.Spec.Source)}
+		return false, result, r.setPhase(ctx, stack, stack.Status.Phase, msg)
+	}
+
+	jobCfg := newJobConfig(stack, "destroy", src)
+	if len(stack.Spec.VarsFrom) > 0 {
+		jobCfg.VarsFrom = varsFromSecretName(stack)
+	}
+
+	// the phase is kept, as Destroying refers to the Job in the status.
+	// Releasing the lock deletes its Lease, which triggers a reconcile
+	err = r.startRun(ctx, stack, jobCfg)
+	if lockedErr, locked := err.(*statelock.LockedError); locked {
+		msg := fmt.Sprintf("waiting for state lock held by %s", &lockedErr.Holder)
+		return false, ctrl.Result{}, r.setPhase(ctx, stack, stack.Status.Phase, msg)
+	}
+	if err != nil {
+		return false, ctrl.Result{}, err
+	}
+
+	return false, ctrl.Result{}, r.setPhase(ctx, stack, tfv1alpha1.StackPhaseDestroying, "")
 }
 
 // reconcileUpdate handles stack creation and updates
-func (r *StackReconciler)reconcileUpdate(ctx context.Context, stack tfv1alpha1.Stack) (ctrl.Result, error) {
-   jobCfg := &jobs.JobConfig{
-        Command:    "apply",
-        Namespace:  stack.Namespace,
-        Stack:      stack.Name,
-        TfConfig:   stack.Spec.TfConfig.Name,
-        Tfvars:     stack.Spec.TfVars.Name,
-        Tfstate:    stack.Status.TfState.Name,
-    }
-
-    job, err := jobs.BuildJob(jobCfg)
-    if err != nil {
-        return ctrl.Result{}, err
-    }
-
-    err = r.Create(ctx, job)
-    if err != nil {
-        return ctrl.Result{}, err
-    }
-
-    return ctrl.Result{}, nil
+func (r *StackReconciler) reconcileUpdate(ctx context.Context, stack tfv1alpha1.Stack) (ctrl.Result, error) {
+	// wait for the running apply to complete before starting a new one
+	if stack.Status.Phase == tfv1alpha1.StackPhaseRunning {
+		return r.reconcileJob(ctx, stack)
+	}
+
+	err := validateSource(&stack)
+	if err == nil {
+		err = validateImports(&stack)
+	}
+	if err == nil {
+		err = r.validateEncryption(&stack)
+	}
+	if err == nil {
+		err = r.validateBackend(&stack)
+	}
+	if err == nil {
+		err = r.validateWorkspace(&stack)
+	}
+	if err != nil {
+		return ctrl.Result{}, r.setPhase(ctx, &stack, tfv1alpha1.StackPhaseFailed, err.Error())
+	}
+
+	deps, err := r.resolveDependencies(ctx, &stack)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if deps.cycle != nil {
+		msg := fmt.Sprintf("dependency cycle: %s", formatCycle(deps.cycle))
+		return ctrl.Result{}, r.setPhase(ctx, &stack, tfv1alpha1.StackPhaseFailed, msg)
+	}
+
+	// stacks it depends on will trigger a reconcile when they change
+	if !deps.ready() {
+		msg := fmt.Sprintf("waiting for stacks: %v", deps.pending)
+		return ctrl.Result{}, r.setPhase(ctx, &stack, tfv1alpha1.StackPhaseWaiting, msg)
+	}
+
+	// the source is resolved again after its poll interval
+	src, err := r.resolveSource(ctx, &stack)
+	if err != nil {
+		msg := fmt.Sprintf("%s: %s", sourceErrorMessage, err)
+		result := ctrl.Result{RequeueAfter: sourceRetryInterval(stack.Spec.Source)}
+		return result, r.setPhase(ctx, &stack, tfv1alpha1.StackPhaseFailed, msg)
+	}
+
+	// remote sources are polled for new revisions
+	result := ctrl.Result{}
+	revision := ""
+	if src != nil {
+		result.RequeueAfter = src.pollInterval
+		revision = src.revision
+	}
+
+	// invalid requests are discarded, as they won't become valid
+	request, err := runRequest(&stack)
+	if err != nil && r.Recorder != nil {
+		r.Recorder.Event(&stack, corev1.EventTypeWarning, "InvalidRunRequest", err.Error())
+	}
+	if request == nil {
+		err = r.clearRunRequest(ctx, &stack)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// nothing changed since last apply. A stack whose source failed to
+	// resolve runs again to recover its phase
+	varsFromHash := deps.hash()
+	if request == nil &&
+		!sourceFailed(&stack) &&
+		stack.Status.Job != "" &&
+		stack.Status.ObservedGeneration == stack.Generation &&
+		stack.Status.VarsFromHash == varsFromHash &&
+		stack.Status.SourceRevision == revision {
+		return result, nil
+	}
+
+	// the stack's resources must be destroyed when it is deleted
+	if !containsString(stack.Finalizers, tfv1alpha1.StackFinalizer) {
+		stack.Finalizers = append(stack.Finalizers, tfv1alpha1.StackFinalizer)
+		err = r.Update(ctx, &stack)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	command := "apply"
+	if stack.Spec.PlanOnly {
+		command = "plan"
+	}
+	jobCfg := newJobConfig(&stack, command, src)
+	if request != nil {
+		jobCfg.Args = runRequestArgs(request)
+	}
+
+	err = r.reconcileConfigRevisions(ctx, &stack)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if len(deps.vars) > 0 {
+		err = r.reconcileVarsFrom(ctx, &stack, deps.vars)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		jobCfg.VarsFrom = varsFromSecretName(&stack)
+	}
+
+	// releasing the lock deletes its Lease, which triggers a reconcile. As
+	// stale locks are not released, the lock is checked again later.
+	err = r.startRun(ctx, &stack, jobCfg)
+	if lockedErr, locked := err.(*statelock.LockedError); locked {
+		msg := fmt.Sprintf("waiting for state lock held by %s", &lockedErr.Holder)
+		return ctrl.Result{RequeueAfter: stateLockRetryInterval}, r.setPhase(ctx, &stack, tfv1alpha1.StackPhaseWaiting, msg)
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// the options of a requested run only apply to it, so the spec is
+	// applied after it if it changed
+	if request != nil {
+		stack.Status.LastRun.Request = request
+		err = r.setPhase(ctx, &stack, tfv1alpha1.StackPhaseRunning, fmt.Sprintf("running request %s", request.ID))
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, r.clearRunRequest(ctx, &stack)
+	}
+
+	stack.Status.ObservedGeneration = stack.Generation
+	stack.Status.VarsFromHash = varsFromHash
+	stack.Status.SourceRevision = revision
+	return ctrl.Result{}, r.setPhase(ctx, &stack, tfv1alpha1.StackPhaseRunning, "")
+}
+
+// newJobConfig returns the configuration of a Job running a command for a
+// stack, taking the tf config from the remote source, if any
+func newJobConfig(stack *tfv1alpha1.Stack, command string, src *remoteSource) *jobs.JobConfig {
+	jobCfg := &jobs.JobConfig{
+		Command:   command,
+		Namespace: stack.Namespace,
+		Stack:     stack.Name,
+		TfConfig:  stack.Spec.TfConfig.Name,
+		Tfvars:    stack.Spec.TfVars.Name,
+		Workspace: stack.Spec.Workspace,
+		Imports:   stack.Spec.Imports,
+	}
+
+	if src != nil {
+		jobCfg.Fetch = src.fetch
+		jobCfg.FetchSecret = src.secret
+	}
+
+	return jobCfg
+}
+
+// startRun creates a Job controlled by the stack for its next run and records
+// the run in the stack's status. The run holds the stack's state lock until it
+// ends, failing with a LockedError if another holder has it.
+func (r *StackReconciler) startRun(ctx context.Context, stack *tfv1alpha1.Stack, jobCfg *jobs.JobConfig) (err error) {
+	jobCfg.Run = 1
+	if stack.Status.LastRun != nil {
+		jobCfg.Run = stack.Status.LastRun.Number + 1
+	}
+
+	// the lock doesn't expire, as it is released when the run ends, or here
+	// if its Job is not created
+	lock, err := statelock.ForStack(r.Client, stack).Acquire(ctx, statelock.RunInfo(stack.Name, jobCfg.Run), 0)
+	if err != nil {
+		return err
+	}
+	created := false
+	defer func() {
+		if err != nil && !created {
+			lock.Release(ctx)
+		}
+	}()
+
+	// the run uses the backend configured in the stack, or the state backend
+	// with new credentials, as the ones of the previous run are no longer
+	// valid. The state written by a run without a backend would be lost.
+	switch {
+	case stack.Spec.Backend != nil:
+		backend, err := r.reconcileBackendConfig(ctx, stack)
+		if err != nil {
+			return err
+		}
+		jobCfg.Backend = backend
+		jobCfg.BackendConfig = true
+	case r.StateBackendURL != "":
+		backend, err := r.reconcileBackendCredentials(ctx, stack, jobCfg.Run)
+		if err != nil {
+			return err
+		}
+		jobCfg.Backend = backend
+	default:
+		return fmt.Errorf("stack %s has no backend for keeping its state", stack.Name)
+	}
+
+	// the run records its results with the stack's own service account
+	serviceAccount, err := r.reconcileRunnerAccess(ctx, stack)
+	if err != nil {
+		return err
+	}
+	jobCfg.ServiceAccount = serviceAccount
+
+	job, err := jobs.BuildJob(jobCfg)
+	if err != nil {
+		return err
+	}
+	job.OwnerReferences = append(job.OwnerReferences, stackOwnerReference(stack))
+
+	err = r.Create(ctx, job)
+	created = err == nil || apierr.IsAlreadyExists(err)
+	if err != nil {
+		return err
+	}
+
+	now := metav1.Now()
+	stack.Status.Job = job.Name
+	stack.Status.LastRun = &tfv1alpha1.StackRun{Number: jobCfg.Run, StartTime: &now}
+	return nil
+}
+
+// reconcileJob updates the stack's phase from the state of the Job running the
+// last apply
+func (r *StackReconciler) reconcileJob(ctx context.Context, stack tfv1alpha1.Stack) (ctrl.Result, error) {
+	job := &batchv1.Job{}
+	err := r.Get(ctx, types.NamespacedName{Name: stack.Status.Job, Namespace: stack.Namespace}, job)
+	if apierr.IsNotFound(err) {
+		err = r.releaseRunLock(ctx, &stack)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		msg := fmt.Sprintf("job %s not found", stack.Status.Job)
+		return ctrl.Result{}, r.setPhase(ctx, &stack, tfv1alpha1.StackPhaseFailed, msg)
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	for _, cond := range job.Status.Conditions {
+		if cond.Status != corev1.ConditionTrue {
+			continue
+		}
+		// the run has ended
+		if cond.Type == batchv1.JobComplete || cond.Type == batchv1.JobFailed {
+			err = r.releaseRunLock(ctx, &stack)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		switch cond.Type {
+		case batchv1.JobComplete:
+			setRunCompletion(&stack, cond.LastTransitionTime)
+			r.archiveLog(ctx, &stack, job)
+			if isPlan(job) {
+				return ctrl.Result{}, r.setPhase(ctx, &stack, tfv1alpha1.StackPhasePlanned, planMessage(&stack))
+			}
+			return ctrl.Result{}, r.setPhase(ctx, &stack, tfv1alpha1.StackPhaseReady, "")
+		case batchv1.JobFailed:
+			setRunCompletion(&stack, cond.LastTransitionTime)
+			r.archiveLog(ctx, &stack, job)
+			return ctrl.Result{}, r.setPhase(ctx, &stack, tfv1alpha1.StackPhaseFailed, cond.Message)
+		}
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// releaseRunLock releases the state lock held by the stack's last run. A lock
+// force unlocked and acquired by another holder is kept.
+func (r *StackReconciler) releaseRunLock(ctx context.Context, stack *tfv1alpha1.Stack) error {
+	if stack.Status.LastRun == nil {
+		return nil
+	}
+	id := statelock.RunLockID(stack.Name, stack.Status.LastRun.Number)
+	err := statelock.ForStack(r.Client, stack).Unlock(ctx, id)
+	if statelock.IsLocked(err) {
+		return nil
+	}
+	return err
+}
+
+// isPlan indicates if a Job runs a plan
+func isPlan(job *batchv1.Job) bool {
+	return jobs.Command(job) == "plan"
+}
+
+// planMessage describes the changes reported by the stack's last run, if any
+func planMessage(stack *tfv1alpha1.Stack) string {
+	if stack.Status.LastRun == nil || stack.Status.LastRun.Changes == nil {
+		return "plan completed"
+	}
+	changes := stack.Status.LastRun.Changes
+	return fmt.Sprintf("plan completed: %d to add, %d to change, %d to destroy", changes.Add, changes.Change, changes.Destroy)
+}
+
+// setPhase updates the stack's phase and its Ready condition, if it has changed
+func (r *StackReconciler) setPhase(ctx context.Context, stack *tfv1alpha1.Stack, phase tfv1alpha1.StackPhase, msg string) error {
+	// the result of a run corresponds to the generation it applied
+	generation := stack.Generation
+	if stack.Status.Phase == tfv1alpha1.StackPhaseRunning {
+		generation = stack.Status.ObservedGeneration
+	}
+
+	ready := getCondition(&stack.Status, tfv1alpha1.StackConditionReady)
+	if stack.Status.Phase == phase && stack.Status.Message == msg &&
+		ready != nil && ready.ObservedGeneration == generation {
+		return nil
+	}
+	if r.Recorder != nil && stack.Status.Phase != phase {
+		eventType := corev1.EventTypeNormal
+		if phase == tfv1alpha1.StackPhaseFailed {
+			eventType = corev1.EventTypeWarning
+		}
+		r.Recorder.Event(stack, eventType, string(phase), msg)
+	}
+	stack.Status.Phase = phase
+	stack.Status.Message = msg
+
+	readyStatus := corev1.ConditionFalse
+	if phase == tfv1alpha1.StackPhaseReady {
+		readyStatus = corev1.ConditionTrue
+	}
+	setCondition(&stack.Status, tfv1alpha1.StackCondition{
+		Type:               tfv1alpha1.StackConditionReady,
+		Status:             readyStatus,
+		Reason:             string(phase),
+		Message:            msg,
+		ObservedGeneration: generation,
+	})
+
+	return r.Status().Update(ctx, stack)
+}
+
+// setRunCompletion records the completion time of the stack's last run
+func setRunCompletion(stack *tfv1alpha1.Stack, completion metav1.Time) {
+	if stack.Status.LastRun == nil {
+		stack.Status.LastRun = &tfv1alpha1.StackRun{}
+	}
+	stack.Status.LastRun.CompletionTime = &completion
+}
+
+// setCondition adds or updates a condition in the stack's status. The
+// transition time is updated only if the condition's status changes.
+func setCondition(status *tfv1alpha1.StackStatus, cond tfv1alpha1.StackCondition) {
+	for i := range status.Conditions {
+		current := &status.Conditions[i]
+		if current.Type != cond.Type {
+			continue
+		}
+		if current.Status != cond.Status {
+			current.Status = cond.Status
+			current.LastTransitionTime = metav1.Now()
+		}
+		current.Reason = cond.Reason
+		current.Message = cond.Message
+		current.ObservedGeneration = cond.ObservedGeneration
+		return
+	}
+
+	cond.LastTransitionTime = metav1.Now()
+	status.Conditions = append(status.Conditions, cond)
+}
+
+// getCondition returns the condition of a type from the stack's status, or nil
+func getCondition(status *tfv1alpha1.StackStatus, condType tfv1alpha1.StackConditionType) *tfv1alpha1.StackCondition {
+	for i := range status.Conditions {
+		if status.Conditions[i].Type == condType {
+			return &status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// containsString indicates if a slice contains a string
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// removeString returns a slice without the occurrences of a string
+func removeString(slice []string, s string) []string {
+	result := []string{}
+	for _, item := range slice {
+		if item != s {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// stackOwnerReference returns a reference for setting the stack as controller
+// of the objects created for it
+func stackOwnerReference(stack *tfv1alpha1.Stack) metav1.OwnerReference {
+	controller := true
+	blockOwnerDeletion := true
+	return metav1.OwnerReference{
+		APIVersion:         tfv1alpha1.GroupVersion.String(),
+		Kind:               "Stack",
+		Name:               stack.Name,
+		UID:                stack.UID,
+		Controller:         &controller,
+		BlockOwnerDeletion: &blockOwnerDeletion,
+	}
 }
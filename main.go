@@ -21,13 +21,17 @@ import (
 	"os"
 
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	tfv1alpha1 "github.com/pablochacin/tf-operator/api/v1alpha1"
 	"github.com/pablochacin/tf-operator/controllers"
+	"github.com/pablochacin/tf-operator/pkg/backend"
+	"github.com/pablochacin/tf-operator/pkg/jobs"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -46,10 +50,16 @@ func init() {
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
+	var stateBackendAddr string
+	var stateBackendURL string
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&stateBackendAddr, "state-backend-addr", ":8082", "The address the state backend binds to.")
+	flag.StringVar(&stateBackendURL, "state-backend-url", "",
+		"The URL the Jobs access the state backend at. "+
+			"If not set, the stacks must configure their backend.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
@@ -66,16 +76,39 @@ func main() {
 		os.Exit(1)
 	}
 
+	kubeClient, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		setupLog.Error(err, "unable to create kubernetes client")
+		os.Exit(1)
+	}
+
 	if err = (&controllers.StackReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("Stack"),
-		Scheme: mgr.GetScheme(),
+		Client:          mgr.GetClient(),
+		Log:             ctrl.Log.WithName("controllers").WithName("Stack"),
+		Scheme:          mgr.GetScheme(),
+		Logs:            jobs.NewPodLogs(kubeClient),
+		Recorder:        mgr.GetEventRecorderFor("stack-controller"),
+		StateBackendURL: stateBackendURL,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Stack")
 		os.Exit(1)
 	}
 	// +kubebuilder:scaffold:builder
 
+	if stateBackendURL != "" {
+		// locks must be read from the API server, not from the manager's cache
+		backendClient, err := client.New(mgr.GetConfig(), client.Options{Scheme: mgr.GetScheme()})
+		if err != nil {
+			setupLog.Error(err, "unable to create state backend client")
+			os.Exit(1)
+		}
+		err = mgr.Add(backend.NewServer(backendClient, stateBackendAddr, ctrl.Log.WithName("backend")))
+		if err != nil {
+			setupLog.Error(err, "unable to add state backend")
+			os.Exit(1)
+		}
+	}
+
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		setupLog.Error(err, "problem running manager")
@@ -0,0 +1,53 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+	"github.com/pablochacin/tf-operator/pkg/terraform"
+)
+
+const (
+	// ConfigKey is the key of the backend config file in the Secret with
+	// the backend of a stack configuring its own
+	ConfigKey = "backend.hcl"
+)
+
+// StackConfig returns the settings of the backend configured in a stack,
+// including the ones taken from Secrets
+func StackConfig(ctx context.Context, c client.Client, stack *tfo.Stack) (map[string]string, error) {
+	config := map[string]string{}
+	for name, value := range stack.Spec.Backend.Config {
+		config[name] = value
+	}
+
+	for _, src := range stack.Spec.Backend.ConfigFrom {
+		ref := src.SecretKeyRef
+		secret := &corev1.Secret{}
+		err := c.Get(ctx, client.ObjectKey{Name: ref.Name, Namespace: stack.Namespace}, secret)
+		if err != nil {
+			return nil, fmt.Errorf("error getting backend setting %s: %v", src.Name, err)
+		}
+		value, found := secret.Data[ref.Key]
+		if !found {
+			return nil, fmt.Errorf("backend setting %s: secret %s has no key %s", src.Name, ref.Name, ref.Key)
+		}
+		config[src.Name] = string(value)
+	}
+
+	return config, nil
+}
+
+// ConfigData returns the data of the Secret with the backend configured in a
+// stack for its runs: an override with the backend's type and a backend
+// config file with its settings, so they are not exposed in the Job's args
+func ConfigData(backendType string, config map[string]string) map[string][]byte {
+	return map[string][]byte{
+		OverrideKey: terraform.BackendOverride(backendType),
+		ConfigKey:   terraform.BackendConfigFile(config),
+	}
+}
@@ -0,0 +1,92 @@
+package backend
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+)
+
+const (
+	// OverrideKey is the key of the backend override in the Secret with the
+	// backend of a stack's runs
+	OverrideKey = "backend_override.tf"
+
+	// keys of the credentials of the stack's current run
+	usernameKey = "username"
+	passwordKey = "password"
+
+	// size in bytes of the generated passwords
+	passwordSize = 32
+)
+
+// CredentialsSecretName returns the name of the Secret with the backend of a
+// stack's runs: the credentials of the current run for accessing its state, or
+// the settings of the backend configured in the stack
+func CredentialsSecretName(stack string) string {
+	return stack + "-backend"
+}
+
+// NewCredentials returns the data of the credentials Secret for a run of a
+// stack: the username and a random password, and a backend override for
+// accessing the state served at url with them
+func NewCredentials(url string, namespace string, stack string, run int64) (map[string][]byte, error) {
+	password := make([]byte, passwordSize)
+	_, err := rand.Read(password)
+	if err != nil {
+		return nil, err
+	}
+
+	username := fmt.Sprintf("run-%d", run)
+	token := hex.EncodeToString(password)
+	return map[string][]byte{
+		usernameKey: []byte(username),
+		passwordKey: []byte(token),
+		OverrideKey: []byte(Override(url, namespace, stack, username, token)),
+	}, nil
+}
+
+// Override returns a terraform configuration overriding the backend with the
+// state of a stack served at url
+func Override(url string, namespace string, stack string, username string, password string) string {
+	address := fmt.Sprintf("%s%s%s/%s", url, statePath, namespace, stack)
+	return fmt.Sprintf(`terraform {
+  backend "http" {
+    address        = %q
+    lock_address   = %q
+    unlock_address = %q
+    username       = %q
+    password       = %q
+  }
+}
+`, address, address, address, username, password)
+}
+
+// authorize checks the request has the credentials of the stack's current run
+func (s *Server) authorize(ctx context.Context, stack *tfo.Stack, r *http.Request) (bool, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false, nil
+	}
+
+	secret := &corev1.Secret{}
+	err := s.client.Get(ctx, client.ObjectKey{Name: CredentialsSecretName(stack.Name), Namespace: stack.Namespace}, secret)
+	if apierr.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	validUser := subtle.ConstantTimeCompare([]byte(username), secret.Data[usernameKey]) == 1
+	validPassword := subtle.ConstantTimeCompare([]byte(password), secret.Data[passwordKey]) == 1
+	return validUser && validPassword && len(secret.Data[passwordKey]) > 0, nil
+}
@@ -0,0 +1,156 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+	"github.com/pablochacin/tf-operator/pkg/statelock"
+)
+
+// lock acquires the state lock for the holder described in the request. The
+// stack's current run, whose credentials were checked, already holds the lock
+// from before its Job is created until it ends, so terraform's lock is granted
+// without acquiring it again. Otherwise, as terraform doesn't renew its locks,
+// the lock is renewed while the run is running, and the lock of a run that
+// ends without releasing it expires. If the state is locked by another holder,
+// it is reported in the response.
+func (s *Server) lock(w http.ResponseWriter, r *http.Request, stack *tfo.Stack) {
+	info, err := readLockInfo(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lock, err := statelock.ForStack(s.client, stack).Acquire(r.Context(), *info, statelock.DefaultTTL)
+	if lockedErr, locked := err.(*statelock.LockedError); locked {
+		if heldByRun(stack, &lockedErr.Holder) {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		writeLockInfo(w, http.StatusLocked, &lockedErr.Holder)
+		return
+	}
+	if err != nil {
+		s.serverError(w, err)
+		return
+	}
+
+	// the request's credentials were checked for the current run
+	if stack.Status.LastRun != nil {
+		go s.renewLock(lock, stack, stack.Status.LastRun.Number)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// renewLock renews a lock held by a run of a stack until the run completes,
+// the lock is lost or released, or the server stops
+func (s *Server) renewLock(lock *statelock.Lock, stack *tfo.Stack, run int64) {
+	ctx := context.Background()
+	locker := statelock.ForStack(s.client, stack)
+	ticker := time.NewTicker(s.renewInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+
+		running, err := s.runRunning(ctx, stack, run)
+		if err == nil && !running {
+			return
+		}
+
+		// a failed renewal is retried, unless the lock is no longer held
+		err = lock.Renew(ctx)
+		if err != nil {
+			holder, getErr := locker.Get(ctx)
+			if getErr == nil && (holder == nil || holder.ID != lock.Info.ID) {
+				return
+			}
+			s.log.Error(err, "unable to renew state lock", "stack", stack.Name, "namespace", stack.Namespace)
+		}
+	}
+}
+
+// runRunning indicates if a run is the current run of a stack and has not
+// completed
+func (s *Server) runRunning(ctx context.Context, stack *tfo.Stack, run int64) (bool, error) {
+	current := &tfo.Stack{}
+	err := s.client.Get(ctx, client.ObjectKey{Name: stack.Name, Namespace: stack.Namespace}, current)
+	if apierr.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	lastRun := current.Status.LastRun
+	return lastRun != nil && lastRun.Number == run && lastRun.CompletionTime == nil, nil
+}
+
+// unlock releases the state lock held by the holder described in the request.
+// The lock held by the stack's current run is kept, as it is released when the
+// run ends.
+func (s *Server) unlock(w http.ResponseWriter, r *http.Request, stack *tfo.Stack) {
+	info, err := readLockInfo(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = statelock.ForStack(s.client, stack).Unlock(r.Context(), info.ID)
+	if lockedErr, locked := err.(*statelock.LockedError); locked {
+		if heldByRun(stack, &lockedErr.Holder) {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		writeLockInfo(w, http.StatusConflict, &lockedErr.Holder)
+		return
+	}
+	if err != nil {
+		s.serverError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// heldByRun indicates if a lock is the one held by the stack's current run
+func heldByRun(stack *tfo.Stack, holder *statelock.Info) bool {
+	lastRun := stack.Status.LastRun
+	return lastRun != nil && holder.ID == statelock.RunLockID(stack.Name, lastRun.Number)
+}
+
+// readLockInfo reads the lock info in a request's body
+func readLockInfo(w http.ResponseWriter, r *http.Request) (*statelock.Info, error) {
+	raw, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		return nil, err
+	}
+
+	info := &statelock.Info{}
+	err = json.Unmarshal(raw, info)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lock info: %v", err)
+	}
+	if info.ID == "" {
+		return nil, fmt.Errorf("invalid lock info: missing ID")
+	}
+	return info, nil
+}
+
+// writeLockInfo responds with the info of a lock's holder
+func writeLockInfo(w http.ResponseWriter, status int, info *statelock.Info) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(info)
+}
@@ -0,0 +1,199 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+	"github.com/pablochacin/tf-operator/pkg/statelock"
+	"github.com/pablochacin/tf-operator/pkg/statestore"
+	"github.com/pablochacin/tf-operator/pkg/terraform"
+)
+
+const (
+	// path prefix of the stacks' states, followed by namespace/name
+	statePath = "/state/"
+
+	// methods used by terraform for locking and unlocking the state
+	methodLock   = "LOCK"
+	methodUnlock = "UNLOCK"
+
+	// maximum size of a state or lock info accepted
+	maxBodySize = 1 << 20
+
+	// operation recorded in the versions of the states stored by runs
+	runOperation = "run"
+
+	// interval for renewing the locks held by runs, before their ttl expires
+	lockRenewInterval = statelock.DefaultTTL / 3
+)
+
+// Server serves terraform's http backend protocol for the stacks' states,
+// storing them in their tfstate Secret, recording their versions, and holding
+// their state locks.
+// Requests are authenticated with the credentials of the stack's current run.
+type Server struct {
+	client client.Client
+	addr   string
+	log    logr.Logger
+
+	// interval for renewing the locks held by runs
+	renewInterval time.Duration
+
+	// closed when the server stops
+	stop <-chan struct{}
+}
+
+// NewServer returns a Server listening at addr. The client must not be
+// cached, as locks rely on reading the latest Lease.
+func NewServer(c client.Client, addr string, log logr.Logger) *Server {
+	return &Server{
+		client:        c,
+		addr:          addr,
+		log:           log,
+		renewInterval: lockRenewInterval,
+	}
+}
+
+// Start serves requests until stop is closed
+func (s *Server) Start(stop <-chan struct{}) error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	s.stop = stop
+
+	srv := &http.Server{Handler: s}
+	go func() {
+		<-stop
+		srv.Shutdown(context.Background())
+	}()
+
+	s.log.Info("serving state backend", "addr", s.addr)
+	err = srv.Serve(listener)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// NeedLeaderElection indicates the server runs in every replica of the
+// manager, as the Service of the state backend selects all of them
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// ServeHTTP handles a request for the state of the stack in the path
+// /state/namespace/name
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, statePath), "/")
+	if !strings.HasPrefix(r.URL.Path, statePath) || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		http.NotFound(w, r)
+		return
+	}
+	namespace, name := parts[0], parts[1]
+
+	ctx := r.Context()
+	stack := &tfo.Stack{}
+	err := s.client.Get(ctx, client.ObjectKey{Name: name, Namespace: namespace}, stack)
+	if apierr.IsNotFound(err) {
+		http.Error(w, fmt.Sprintf("stack %s not found", name), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.serverError(w, err)
+		return
+	}
+
+	authorized, err := s.authorize(ctx, stack, r)
+	if err != nil {
+		s.serverError(w, err)
+		return
+	}
+	if !authorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="tf-operator"`)
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.getState(w, r, stack)
+	case http.MethodPost:
+		s.postState(w, r, stack)
+	case methodLock:
+		s.lock(w, r, stack)
+	case methodUnlock:
+		s.unlock(w, r, stack)
+	default:
+		http.Error(w, fmt.Sprintf("method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// getState returns the stored state, or no content if there is none
+func (s *Server) getState(w http.ResponseWriter, r *http.Request, stack *tfo.Stack) {
+	content, err := statestore.New(s.client, stack).Read(r.Context())
+	if err != nil {
+		s.serverError(w, err)
+		return
+	}
+	if content == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
+// postState stores a state. If the state is locked, the request must give
+// the lock's ID, unless the lock is held by the stack's current run.
+func (s *Server) postState(w http.ResponseWriter, r *http.Request, stack *tfo.Stack) {
+	holder, err := statelock.ForStack(s.client, stack).Get(r.Context())
+	if err != nil {
+		s.serverError(w, err)
+		return
+	}
+	if holder != nil && holder.ID != r.URL.Query().Get("ID") && !heldByRun(stack, holder) {
+		writeLockInfo(w, http.StatusConflict, holder)
+		return
+	}
+
+	content, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err = terraform.ParseStateMeta(content); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the state is stored by the stack's current run, whose credentials
+	// were checked
+	var run int64
+	if stack.Status.LastRun != nil {
+		run = stack.Status.LastRun.Number
+	}
+	_, err = statestore.New(s.client, stack).Write(r.Context(), content, run, runOperation)
+	if err != nil {
+		s.serverError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// serverError logs an unexpected error and reports it
+func (s *Server) serverError(w http.ResponseWriter, err error) {
+	s.log.Error(err, "error serving state")
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
@@ -0,0 +1,288 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+	"github.com/pablochacin/tf-operator/pkg/statelock"
+	"github.com/pablochacin/tf-operator/pkg/statestore"
+)
+
+func TestBackend(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "State Backend Suite")
+}
+
+const (
+	namespace = "test"
+
+	state = `{"version": 4, "serial": 1, "lineage": "a", "resources": []}`
+)
+
+var _ = Describe("State backend server", func() {
+	var (
+		c      client.Client
+		stack  *tfo.Stack
+		server *httptest.Server
+		creds  map[string][]byte
+
+		// interval for renewing the locks held by runs
+		renewInterval time.Duration
+	)
+
+	BeforeEach(func() {
+		renewInterval = lockRenewInterval
+		stack = &tfo.Stack{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "stack",
+				Namespace: namespace,
+			},
+			Status: tfo.StackStatus{
+				LastRun: &tfo.StackRun{Number: 3},
+			},
+		}
+
+		var err error
+		creds, err = NewCredentials("http://unused", namespace, stack.Name, 3)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	JustBeforeEach(func() {
+		sch := runtime.NewScheme()
+		Expect(tfo.AddToScheme(sch)).To(Succeed())
+		Expect(corev1.AddToScheme(sch)).To(Succeed())
+		Expect(coordinationv1.AddToScheme(sch)).To(Succeed())
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: CredentialsSecretName(stack.Name), Namespace: namespace},
+			Data:       creds,
+		}
+		c = fake.NewFakeClientWithScheme(sch, stack.DeepCopy(), secret)
+		backend := NewServer(c, "", ctrl.Log)
+		backend.renewInterval = renewInterval
+		server = httptest.NewServer(backend)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	// request sends a request for the stack's state with its credentials,
+	// returning the response's status and body
+	request := func(method string, query string, body string) (int, string) {
+		req, err := http.NewRequest(method, server.URL+"/state/test/stack"+query, strings.NewReader(body))
+		Expect(err).NotTo(HaveOccurred())
+		req.SetBasicAuth(string(creds[usernameKey]), string(creds[passwordKey]))
+		resp, err := http.DefaultClient.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+		content, err := ioutil.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		return resp.StatusCode, string(content)
+	}
+
+	lockInfo := func(id string) string {
+		info, err := json.Marshal(statelock.Info{ID: id, Operation: "OperationTypeApply", Who: "runner"})
+		Expect(err).NotTo(HaveOccurred())
+		return string(info)
+	}
+
+	It("Should reject requests without the run's credentials", func() {
+		resp, err := http.Get(server.URL + "/state/test/stack")
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+
+		creds[passwordKey] = []byte("invalid")
+		status, _ := request(http.MethodGet, "", "")
+		Expect(status).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("Should return no content if there is no state", func() {
+		status, _ := request(http.MethodGet, "", "")
+		Expect(status).To(Equal(http.StatusNoContent))
+	})
+
+	It("Should store the state holding the lock", func() {
+		status, _ := request(methodLock, "", lockInfo("l1"))
+		Expect(status).To(Equal(http.StatusOK))
+
+		status, _ = request(http.MethodPost, "?ID=l1", state)
+		Expect(status).To(Equal(http.StatusOK))
+
+		status, body := request(http.MethodGet, "", "")
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(Equal(state))
+
+		updated := &tfo.Stack{}
+		Expect(c.Get(context.TODO(), client.ObjectKey{Name: stack.Name, Namespace: namespace}, updated)).To(Succeed())
+		Expect(updated.Status.TfState.Name).To(Equal("stack-tfstate"))
+		versions, err := statestore.New(c, updated).Versions(context.TODO())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(versions).To(HaveLen(1))
+		Expect(versions[0].Run).To(Equal(int64(3)))
+		Expect(versions[0].Operation).To(Equal("run"))
+
+		status, _ = request(methodUnlock, "", lockInfo("l1"))
+		Expect(status).To(Equal(http.StatusOK))
+		err = c.Get(context.TODO(), client.ObjectKey{Name: statelock.LeaseName(stack.Name), Namespace: namespace}, &coordinationv1.Lease{})
+		Expect(apierr.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("Should record one version for the states posted by a run", func() {
+		for _, serial := range []string{"1", "2", "3"} {
+			status, _ := request(http.MethodPost, "", strings.Replace(state, `"serial": 1`, `"serial": `+serial, 1))
+			Expect(status).To(Equal(http.StatusOK))
+		}
+
+		updated := &tfo.Stack{}
+		Expect(c.Get(context.TODO(), client.ObjectKey{Name: stack.Name, Namespace: namespace}, updated)).To(Succeed())
+		versions, err := statestore.New(c, updated).Versions(context.TODO())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(versions).To(HaveLen(1))
+		Expect(versions[0].Run).To(Equal(int64(3)))
+		Expect(versions[0].Serial).To(Equal(int64(3)))
+	})
+
+	It("Should reject an invalid state", func() {
+		status, _ := request(http.MethodPost, "", "not a state")
+		Expect(status).To(Equal(http.StatusBadRequest))
+	})
+
+	Context("locked by another holder", func() {
+		JustBeforeEach(func() {
+			status, _ := request(methodLock, "", lockInfo("l1"))
+			Expect(status).To(Equal(http.StatusOK))
+		})
+
+		It("Should report the holder when locking", func() {
+			status, body := request(methodLock, "", lockInfo("l2"))
+			Expect(status).To(Equal(http.StatusLocked))
+			info := statelock.Info{}
+			Expect(json.Unmarshal([]byte(body), &info)).To(Succeed())
+			Expect(info.ID).To(Equal("l1"))
+			Expect(info.Who).To(Equal("runner"))
+		})
+
+		It("Should reject storing and unlocking", func() {
+			status, _ := request(http.MethodPost, "?ID=l2", state)
+			Expect(status).To(Equal(http.StatusConflict))
+
+			status, _ = request(methodUnlock, "", lockInfo("l2"))
+			Expect(status).To(Equal(http.StatusConflict))
+		})
+	})
+
+	Context("locked by the run", func() {
+		// lease returns the Lease holding the lock
+		lease := func() *coordinationv1.Lease {
+			lease := &coordinationv1.Lease{}
+			Expect(c.Get(context.TODO(), client.ObjectKey{Name: statelock.LeaseName(stack.Name), Namespace: namespace}, lease)).To(Succeed())
+			return lease
+		}
+
+		BeforeEach(func() {
+			renewInterval = 10 * time.Millisecond
+		})
+
+		JustBeforeEach(func() {
+			status, _ := request(methodLock, "", lockInfo("l1"))
+			Expect(status).To(Equal(http.StatusOK))
+		})
+
+		AfterEach(func() {
+			request(methodUnlock, "", lockInfo("l1"))
+		})
+
+		It("Should renew the lock while the run is running", func() {
+			acquired := lease()
+			Expect(*acquired.Spec.LeaseDurationSeconds).To(Equal(int32(statelock.DefaultTTL.Seconds())))
+			Eventually(func() bool {
+				return lease().Spec.RenewTime.After(acquired.Spec.RenewTime.Time)
+			}).Should(BeTrue())
+		})
+
+		It("Should stop renewing the lock once the run completes", func() {
+			current := &tfo.Stack{}
+			Expect(c.Get(context.TODO(), client.ObjectKey{Name: stack.Name, Namespace: namespace}, current)).To(Succeed())
+			now := metav1.Now()
+			current.Status.LastRun.CompletionTime = &now
+			Expect(c.Status().Update(context.TODO(), current)).To(Succeed())
+
+			time.Sleep(5 * renewInterval)
+			renewed := lease().Spec.RenewTime
+			Consistently(func() time.Time {
+				return lease().Spec.RenewTime.Time
+			}, 10*renewInterval).Should(Equal(renewed.Time))
+		})
+	})
+
+	Context("locked by the operator for the run", func() {
+		JustBeforeEach(func() {
+			_, err := statelock.ForStack(c, stack).Acquire(context.TODO(), statelock.RunInfo(stack.Name, 3), 0)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should let terraform lock and store the state keeping the run's lock", func() {
+			status, _ := request(methodLock, "", lockInfo("l1"))
+			Expect(status).To(Equal(http.StatusOK))
+
+			status, _ = request(http.MethodPost, "?ID=l1", state)
+			Expect(status).To(Equal(http.StatusOK))
+
+			status, _ = request(methodUnlock, "", lockInfo("l1"))
+			Expect(status).To(Equal(http.StatusOK))
+
+			holder, err := statelock.ForStack(c, stack).Get(context.TODO())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(holder.ID).To(Equal(statelock.RunLockID(stack.Name, 3)))
+		})
+	})
+
+	Context("locked by a previous run", func() {
+		JustBeforeEach(func() {
+			_, err := statelock.ForStack(c, stack).Acquire(context.TODO(), statelock.RunInfo(stack.Name, 2), 0)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should report the lock", func() {
+			status, body := request(methodLock, "", lockInfo("l1"))
+			Expect(status).To(Equal(http.StatusLocked))
+			Expect(body).To(ContainSubstring(statelock.RunLockID(stack.Name, 2)))
+
+			status, _ = request(http.MethodPost, "?ID=l1", state)
+			Expect(status).To(Equal(http.StatusConflict))
+		})
+	})
+
+	Context("locked by a client", func() {
+		JustBeforeEach(func() {
+			_, err := statelock.ForStack(c, stack).Acquire(context.TODO(), statelock.Info{Operation: "push", Who: "user@host"}, statelock.DefaultTTL)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should report the lock", func() {
+			status, body := request(methodLock, "", lockInfo("l1"))
+			Expect(status).To(Equal(http.StatusLocked))
+			Expect(body).To(ContainSubstring("user@host"))
+		})
+	})
+})
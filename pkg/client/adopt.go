@@ -0,0 +1,187 @@
+package client
+
+import (
+    "context"
+    "fmt"
+    "io/ioutil"
+
+    tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+    "github.com/pablochacin/tf-operator/pkg/statelock"
+    "github.com/pablochacin/tf-operator/pkg/terraform"
+    corev1 "k8s.io/api/core/v1"
+    apierr "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    apirtm "k8s.io/apimachinery/pkg/runtime"
+    "k8s.io/client-go/util/retry"
+    ctlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// AdoptStack creates a plan-only stack from local tf files and the state of
+// the existing resources, so its first run shows the changes the stack would
+// make to them. The stack is created with its state locked, so it is not
+// planned until its state Secret is set.
+func (c *client)AdoptStack(name string, namespace string, tfconf string, tfvars string, tfstate string) (stack *tfo.Stack, err error) {
+    content, err := ioutil.ReadFile(tfstate)
+    if err != nil {
+        errDesc := fmt.Sprintf("error accessing state file %s: %v", tfstate, err)
+        return nil, NewTFOError(errDesc, ErrorReasonFileCanNotBeAccessed)
+    }
+    _, err = terraform.ParseStateMeta(content)
+    if err == nil {
+        _, err = terraform.ParseStateResources(content)
+    }
+    if err != nil {
+        errDesc := fmt.Sprintf("state file %s is not valid: %v", tfstate, err)
+        return nil, NewTFOError(errDesc, ErrorReasonInvalidFileContent)
+    }
+
+    err = c.rc.Get(context.TODO(), ctlclient.ObjectKey{Name: name, Namespace: namespace}, &tfo.Stack{})
+    if err == nil {
+        errDesc := fmt.Sprintf("stack %s already exists in namespace %s", name, namespace)
+        return nil, NewTFOError(errDesc, ErrorReasonAlreadyExists)
+    }
+    if !apierr.IsNotFound(err) {
+        errDesc := fmt.Sprintf("runtime error adopting stack: %s", err)
+        return nil, NewTFOError(errDesc, ErrorReasonRuntimeError)
+    }
+
+    // the lock is acquired before the stack exists, so it has no owner
+    lock, err := statelock.New(c.rc, namespace, name).Acquire(
+        context.TODO(),
+        statelock.Info{Operation: "adopt", Who: lockHolder()},
+        statelock.DefaultTTL,
+    )
+    if err != nil {
+        return nil, lockError(err)
+    }
+    defer func() {
+        unlockErr := lock.Release(context.TODO())
+        if unlockErr != nil && err == nil {
+            errDesc := fmt.Sprintf("error releasing the state lock of stack %s: %s", name, unlockErr)
+            stack, err = nil, NewTFOError(errDesc, ErrorReasonRuntimeError)
+        }
+    }()
+
+    tfconfMap, tfvarsSecret, err := stackRevision(name, namespace, tfconf, tfvars)
+    if err != nil {
+        return nil, err
+    }
+
+    // the objects created by the adoption are deleted if it fails, so it
+    // can be retried. Revisions that already existed may be used by other
+    // stacks and are kept
+    var created []apirtm.Object
+    defer func() {
+        if err == nil {
+            return
+        }
+        for i := len(created) - 1; i >= 0; i-- {
+            _ = c.rc.Delete(context.TODO(), created[i])
+        }
+    }()
+
+    // the state of another stack with the same name must not be replaced
+    stateSecret := &corev1.Secret{
+        ObjectMeta: metav1.ObjectMeta{
+            Name: name + "-tfstate",
+            Namespace: namespace,
+            Labels: map[string]string{tfo.StackLabel: name},
+        },
+        Data: map[string][]byte{terraform.StateKey: content},
+    }
+    err = c.rc.Create(context.TODO(), stateSecret)
+    if apierr.IsAlreadyExists(err) {
+        return nil, NewAlreadyExistsError(stateSecret.Name, "Secret", namespace)
+    }
+    if err != nil {
+        errDesc := fmt.Sprintf("runtime error storing state: %s", err)
+        return nil, NewTFOError(errDesc, ErrorReasonRuntimeError)
+    }
+    created = append(created, stateSecret)
+
+    for _, revision := range []apirtm.Object{tfconfMap, tfvarsSecret} {
+        isNew, err := c.createImmutableIfMissing(revision)
+        if err != nil {
+            errDesc := fmt.Sprintf("runtime error creating stack revision: %s", err)
+            return nil, NewTFOError(errDesc, ErrorReasonRuntimeError)
+        }
+        if isNew {
+            created = append(created, revision)
+        }
+    }
+
+    stack = newStackFor(name, namespace, tfconfMap, tfvarsSecret)
+    stack.Spec.PlanOnly = true
+    err = c.rc.Create(context.TODO(), stack)
+    if err != nil {
+        errDesc := fmt.Sprintf("runtime error creating stack: %s", err)
+        return nil, NewTFOError(errDesc, ErrorReasonRuntimeError)
+    }
+    created = append(created, stack)
+
+    // the controller may have updated the stack since it was created
+    err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+        current := &tfo.Stack{}
+        err := c.rc.Get(context.TODO(), ctlclient.ObjectKey{Name: name, Namespace: namespace}, current)
+        if err != nil {
+            return err
+        }
+        current.Status.TfState.Name = stateSecret.Name
+        err = c.rc.Status().Update(context.TODO(), current)
+        if err == nil {
+            stack = current
+        }
+        return err
+    })
+    if err != nil {
+        errDesc := fmt.Sprintf("runtime error updating stack status: %s", err)
+        return nil, NewTFOError(errDesc, ErrorReasonRuntimeError)
+    }
+
+    return stack, nil
+}
+
+// ConfirmPlan unsets plan-only in a stack whose plan for the current spec
+// completed, so it is applied. Unless forced, the plan must have reported
+// no changes.
+func (c *client)ConfirmPlan(name string, namespace string, force bool) (*tfo.Stack, error) {
+    stack, err := c.GetStack(name, namespace)
+    if err != nil {
+        return nil, err
+    }
+    if !stack.Spec.PlanOnly {
+        errDesc := fmt.Sprintf("stack %s is not plan-only", name)
+        return nil, NewTFOError(errDesc, ErrorReasonInvalidArgument)
+    }
+
+    ready := readyCondition(stack)
+    if stack.Status.Phase != tfo.StackPhasePlanned || ready == nil || ready.ObservedGeneration != stack.Generation {
+        errDesc := fmt.Sprintf("the plan of stack %s for its current spec has not completed", name)
+        return nil, NewTFOError(errDesc, ErrorReasonConflict)
+    }
+
+    if !force {
+        if stack.Status.LastRun == nil || stack.Status.LastRun.Changes == nil {
+            errDesc := fmt.Sprintf("the plan of stack %s didn't report its changes, use force for applying it", name)
+            return nil, NewTFOError(errDesc, ErrorReasonConflict)
+        }
+        changes := stack.Status.LastRun.Changes
+        if changes.Add != 0 || changes.Change != 0 || changes.Destroy != 0 {
+            errDesc := fmt.Sprintf("the plan of stack %s has changes (%s), use force for applying them", name, stack.Status.Message)
+            return nil, NewTFOError(errDesc, ErrorReasonConflict)
+        }
+    }
+
+    stack.Spec.PlanOnly = false
+    err = c.rc.Update(context.TODO(), stack)
+    if apierr.IsConflict(err) {
+        errDesc := fmt.Sprintf("stack %s was modified while confirming its plan, try again", name)
+        return nil, NewTFOError(errDesc, ErrorReasonConflict)
+    }
+    if err != nil {
+        errDesc := fmt.Sprintf("runtime error updating stack: %s", err)
+        return nil, NewTFOError(errDesc, ErrorReasonRuntimeError)
+    }
+
+    return stack, nil
+}
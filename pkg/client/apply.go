@@ -0,0 +1,190 @@
+package client
+
+import (
+    "context"
+    "fmt"
+
+    tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+    corev1 "k8s.io/api/core/v1"
+    apierr "k8s.io/apimachinery/pkg/api/errors"
+    "k8s.io/apimachinery/pkg/api/meta"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    apirtm "k8s.io/apimachinery/pkg/runtime"
+    ctlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+    // Annotations of stack manifests with the local configuration directory
+    // and tfvars file applied with the stack. Relative paths are relative to
+    // the manifest's directory. They are removed when the stack is applied.
+    ConfigDirAnnotation = "tfoctl.tf-operator.io/config"
+    TfVarsFileAnnotation = "tfoctl.tf-operator.io/vars"
+
+    // field manager for applying stacks
+    applyFieldManager = "tfoctl"
+)
+
+// ApplyOperation is the change made to an object when applying a stack
+type ApplyOperation string
+
+const (
+    // The object did not exist
+    ApplyOperationCreated ApplyOperation = "created"
+
+    // The object was modified
+    ApplyOperationConfigured ApplyOperation = "configured"
+
+    // The object already had the applied content
+    ApplyOperationUnchanged ApplyOperation = "unchanged"
+)
+
+// ApplyOptions defines the local files applied with a stack
+type ApplyOptions struct {
+    // Directory with the stack's configuration. If set, it is stored as a
+    // revision and the stack references it
+    TfConfig string
+
+    // File with the stack's tfvars. If set, it is stored as a revision and
+    // the stack references it
+    TfVars string
+}
+
+// AppliedObject reports the change made to an object when applying a stack
+type AppliedObject struct {
+    Kind      string
+    Name      string
+    Operation ApplyOperation
+}
+
+// ApplyResult reports the stack applied and the changes made to it and to
+// its configuration and tfvars
+type ApplyResult struct {
+    Stack   *tfo.Stack
+    Objects []AppliedObject
+}
+
+// stackApplier applies the fields of a stack
+type stackApplier interface {
+    // ApplyStack applies the fields set in the stack, taking the ownership
+    // of them, and updates it with the applied object
+    ApplyStack(stack *tfo.Stack) error
+}
+
+// serverSideApplier implements stackApplier using server-side apply
+type serverSideApplier struct {
+    rc ctlclient.Client
+}
+
+func (a *serverSideApplier) ApplyStack(stack *tfo.Stack) error {
+    return a.rc.Patch(
+        context.TODO(),
+        stack,
+        ctlclient.Apply,
+        ctlclient.FieldOwner(applyFieldManager),
+        ctlclient.ForceOwnership,
+    )
+}
+
+// ApplyStack creates or updates a stack from its manifest. The configuration
+// and tfvars in local files, if given, are stored as revisions and the stack
+// references them. The stack is applied server-side, so only the fields in
+// the manifest are set, and the result reports the objects changed.
+func (c *client)ApplyStack(stack *tfo.Stack, opts ApplyOptions) (*ApplyResult, error) {
+    stack = stack.DeepCopy()
+    result := &ApplyResult{}
+
+    if opts.TfConfig != "" {
+        if stack.Spec.Source != nil {
+            errDesc := fmt.Sprintf("stack %s takes its configuration from a remote source", stack.Name)
+            return nil, NewTFOError(errDesc, ErrorReasonInvalidArgument)
+        }
+        tfconfMap, err := createConfigMap(stack.Name, stack.Namespace, opts.TfConfig, excludeVars(opts.TfConfig, opts.TfVars)...)
+        if err != nil {
+            return nil, err
+        }
+        applied, err := c.applyRevision(tfconfMap, &corev1.ConfigMap{}, "ConfigMap")
+        if err != nil {
+            return nil, err
+        }
+        result.Objects = append(result.Objects, applied)
+        stack.Spec.TfConfig.Name = tfconfMap.Name
+    }
+
+    if opts.TfVars != "" {
+        tfvarsSecret, err := createSecret(stack.Name, stack.Namespace, opts.TfVars)
+        if err != nil {
+            return nil, err
+        }
+        applied, err := c.applyRevision(tfvarsSecret, &corev1.Secret{}, "Secret")
+        if err != nil {
+            return nil, err
+        }
+        result.Objects = append(result.Objects, applied)
+        stack.Spec.TfVars.Name = tfvarsSecret.Name
+    }
+
+    // the local paths are meaningless in the cluster
+    delete(stack.Annotations, ConfigDirAnnotation)
+    delete(stack.Annotations, TfVarsFileAnnotation)
+
+    current := &tfo.Stack{}
+    err := c.rc.Get(context.TODO(), ctlclient.ObjectKey{Name: stack.Name, Namespace: stack.Namespace}, current)
+    if err != nil && !apierr.IsNotFound(err) {
+        errDesc := fmt.Sprintf("runtime error getting stack: %s", err)
+        return nil, NewTFOError(errDesc, ErrorReasonRuntimeError)
+    }
+    operation := ApplyOperationCreated
+    if err == nil {
+        operation = ApplyOperationConfigured
+    }
+
+    stack.TypeMeta = metav1.TypeMeta{APIVersion: tfo.GroupVersion.String(), Kind: "Stack"}
+    stack.ResourceVersion = ""
+    stack.ManagedFields = nil
+    stack.Status = tfo.StackStatus{}
+    err = c.applier.ApplyStack(stack)
+    if apierr.IsInvalid(err) || apierr.IsBadRequest(err) {
+        errDesc := fmt.Sprintf("invalid stack %s: %s", stack.Name, err)
+        return nil, NewTFOError(errDesc, ErrorReasonInvalidArgument)
+    }
+    if err != nil {
+        errDesc := fmt.Sprintf("runtime error applying stack: %s", err)
+        return nil, NewTFOError(errDesc, ErrorReasonRuntimeError)
+    }
+
+    // applying the same fields doesn't modify the stack
+    if operation == ApplyOperationConfigured && stack.ResourceVersion == current.ResourceVersion {
+        operation = ApplyOperationUnchanged
+    }
+    result.Stack = stack
+    result.Objects = append(result.Objects, AppliedObject{Kind: "Stack", Name: stack.Name, Operation: operation})
+
+    return result, nil
+}
+
+// applyRevision creates the ConfigMap or Secret of a revision if it doesn't
+// exist. As revisions are named by their content, an existing one is unchanged.
+func (c *client)applyRevision(obj apirtm.Object, existing apirtm.Object, kind string) (AppliedObject, error) {
+    accessor, err := meta.Accessor(obj)
+    if err != nil {
+        return AppliedObject{}, err
+    }
+    applied := AppliedObject{Kind: kind, Name: accessor.GetName(), Operation: ApplyOperationUnchanged}
+
+    err = c.rc.Get(context.TODO(), ctlclient.ObjectKey{Name: accessor.GetName(), Namespace: accessor.GetNamespace()}, existing)
+    if err == nil {
+        return applied, nil
+    }
+    if !apierr.IsNotFound(err) {
+        errDesc := fmt.Sprintf("runtime error getting %s: %s", kind, err)
+        return applied, NewTFOError(errDesc, ErrorReasonRuntimeError)
+    }
+
+    err = c.createImmutable(obj)
+    if err != nil {
+        errDesc := fmt.Sprintf("runtime error creating %s: %s", kind, err)
+        return applied, NewTFOError(errDesc, ErrorReasonRuntimeError)
+    }
+    applied.Operation = ApplyOperationCreated
+    return applied, nil
+}
@@ -0,0 +1,175 @@
+package client
+
+import (
+    "context"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path"
+
+    tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+    "github.com/pablochacin/tf-operator/pkg/backend"
+    "github.com/pablochacin/tf-operator/pkg/cmdrunner"
+    "github.com/pablochacin/tf-operator/pkg/terraform"
+)
+
+// stateBackend accesses the state of a stack kept in the backend configured
+// in the stack, with the given settings
+type stateBackend interface {
+    // PullState returns the state kept in the backend, or nil if there is none
+    PullState(stack *tfo.Stack, config map[string]string) ([]byte, error)
+
+    // PushState replaces the state kept in the backend. Unless forced, a
+    // state with another lineage or a lower serial is rejected
+    PushState(stack *tfo.Stack, config map[string]string, content []byte, force bool) error
+
+    // ForceUnlock releases the lock of the state in the backend with the
+    // given ID
+    ForceUnlock(stack *tfo.Stack, config map[string]string, id string) error
+}
+
+// terraformBackend implements stateBackend running terraform in a temporary
+// working directory initialized with the stack's backend
+type terraformBackend struct{}
+
+func (b *terraformBackend) PullState(stack *tfo.Stack, config map[string]string) ([]byte, error) {
+    var content []byte
+    err := b.withWorkspace(stack, config, func(w *terraform.TfWorkspace) (err error) {
+        content, err = w.PullState()
+        return err
+    })
+    return content, err
+}
+
+func (b *terraformBackend) PushState(stack *tfo.Stack, config map[string]string, content []byte, force bool) error {
+    return b.withWorkspace(stack, config, func(w *terraform.TfWorkspace) error {
+        return w.PushState(content, force)
+    })
+}
+
+func (b *terraformBackend) ForceUnlock(stack *tfo.Stack, config map[string]string, id string) error {
+    return b.withWorkspace(stack, config, func(w *terraform.TfWorkspace) error {
+        return w.ForceUnlock(id)
+    })
+}
+
+// withWorkspace runs an operation in a working directory initialized with the
+// stack's backend, selecting the stack's terraform workspace if it has one.
+// The settings are passed in a backend config file, so they are not exposed in
+// the command line.
+func (b *terraformBackend) withWorkspace(stack *tfo.Stack, config map[string]string, run func(w *terraform.TfWorkspace) error) error {
+    workDir, err := ioutil.TempDir("", "tfoctl-backend")
+    if err != nil {
+        return err
+    }
+    defer os.RemoveAll(workDir)
+
+    configFile := path.Join(workDir, backend.ConfigKey)
+    err = ioutil.WriteFile(configFile, terraform.BackendConfigFile(config), 0600)
+    if err != nil {
+        return err
+    }
+
+    // the environment may have the credentials of the backend
+    runner := cmdrunner.New()
+    runner.SetInheritEnv(true)
+    w := terraform.NewWithCmdRunner(runner, "", "", "", workDir)
+    err = w.UseBackendOverride(terraform.BackendOverride(stack.Spec.Backend.Type))
+    if err != nil {
+        return err
+    }
+    w.UseBackendConfig(configFile)
+    w.UseWorkspace(stack.Spec.Workspace)
+
+    err = w.Init()
+    if err != nil {
+        return err
+    }
+    return run(w)
+}
+
+// backendConfig returns the settings of the backend configured in a stack
+func (c *client)backendConfig(stack *tfo.Stack) (map[string]string, error) {
+    config, err := backend.StackConfig(context.TODO(), c.rc, stack)
+    if err != nil {
+        return nil, backendError(stack.Name, err)
+    }
+    return config, nil
+}
+
+// pullBackendState returns the state of a stack kept in the backend
+// configured in the stack
+func (c *client)pullBackendState(stack *tfo.Stack) ([]byte, error) {
+    config, err := c.backendConfig(stack)
+    if err != nil {
+        return nil, err
+    }
+
+    content, err := c.backends.PullState(stack, config)
+    if err != nil {
+        return nil, backendError(stack.Name, err)
+    }
+    if content == nil {
+        return nil, NewNotFoundError(stack.Name+" state", "Stack", stack.Namespace)
+    }
+    return content, nil
+}
+
+// updateBackendState changes the state of a stack kept in the backend
+// configured in the stack, pushing the updated state
+func (c *client)updateBackendState(stack *tfo.Stack, force bool, update func(current []byte) ([]byte, error)) (*StateUpdate, error) {
+    config, err := c.backendConfig(stack)
+    if err != nil {
+        return nil, err
+    }
+
+    current, err := c.backends.PullState(stack, config)
+    if err != nil {
+        return nil, backendError(stack.Name, err)
+    }
+
+    updated, err := update(current)
+    if err != nil {
+        return nil, err
+    }
+    meta, err := terraform.ParseStateMeta(updated)
+    if err != nil {
+        return nil, NewTFOError(err.Error(), ErrorReasonInvalidFileContent)
+    }
+
+    err = c.backends.PushState(stack, config, updated, force)
+    if err != nil {
+        return nil, backendError(stack.Name, err)
+    }
+
+    return &StateUpdate{Backend: stack.Spec.Backend.Type, Serial: meta.Serial}, nil
+}
+
+// forceUnlockBackend releases the lock of the state of a stack in the backend
+// configured in the stack
+func (c *client)forceUnlockBackend(stack *tfo.Stack, id string) error {
+    config, err := c.backendConfig(stack)
+    if err != nil {
+        return err
+    }
+
+    err = c.backends.ForceUnlock(stack, config, id)
+    if err != nil {
+        return backendError(stack.Name, err)
+    }
+    return nil
+}
+
+// noStateHistory returns the error for accessing the state history of a stack
+// configuring its own backend, which keeps the history instead
+func noStateHistory(stack *tfo.Stack) error {
+    errDesc := fmt.Sprintf("the state history of stack %s is kept by its %s backend", stack.Name, stack.Spec.Backend.Type)
+    return NewTFOError(errDesc, ErrorReasonInvalidArgument)
+}
+
+// backendError returns the error for a failure accessing the backend
+// configured in a stack
+func backendError(name string, err error) error {
+    errDesc := fmt.Sprintf("runtime error accessing the backend of stack %s: %s", name, err)
+    return NewTFOError(errDesc, ErrorReasonRuntimeError)
+}
@@ -2,16 +2,33 @@ package client
 
 import (
     "context"
+    "crypto/sha256"
+    "errors"
     "fmt"
+    "io"
     "io/ioutil"
     "os"
-    "path"
+    "path/filepath"
+    "strings"
+    "time"
 
 	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+    "github.com/pablochacin/tf-operator/pkg/jobs"
+    "github.com/pablochacin/tf-operator/pkg/statestore"
+    "github.com/pablochacin/tf-operator/pkg/terraform"
+    "github.com/pablochacin/tf-operator/pkg/tfconfig"
+    batchv1 "k8s.io/api/batch/v1"
+    coordinationv1 "k8s.io/api/coordination/v1"
     corev1 "k8s.io/api/core/v1"
     metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
     apierr "k8s.io/apimachinery/pkg/api/errors"
+    "k8s.io/apimachinery/pkg/fields"
+    "k8s.io/apimachinery/pkg/labels"
     apirtm "k8s.io/apimachinery/pkg/runtime"
+    "k8s.io/apimachinery/pkg/selection"
+    "k8s.io/client-go/dynamic"
+    "k8s.io/client-go/kubernetes"
     "k8s.io/client-go/tools/clientcmd"
     ctlclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -21,30 +38,228 @@ type Client interface {
 	// GetStack returns a stack with the name in the given namespace
 	GetStack(stackName string, namespace string) (*tfo.Stack, error)
 
+    // GetOutputs returns the outputs of a stack. The values of sensitive
+    // outputs are only returned if showSensitive is set
+    GetOutputs(stackName string, namespace string, showSensitive bool) (map[string]terraform.Output, error)
+
+    // ListStacks returns the stacks matching the list options
+    ListStacks(opts ListOptions) (*tfo.StackList, error)
+
     // CreateStack creates a stack from local tf files
     CreateStack(name string, namespace string, tfconf string, tfvars string) (*tfo.Stack, error)
+
+    // AdoptStack creates a plan-only stack from local tf files and the state
+    // of the resources it will manage
+    AdoptStack(name string, namespace string, tfconf string, tfvars string, tfstate string) (*tfo.Stack, error)
+
+    // ConfirmPlan unsets plan-only in a stack whose plan has completed
+    ConfirmPlan(name string, namespace string, force bool) (*tfo.Stack, error)
+
+    // DiffStack returns the differences between the configuration and tfvars
+    // of a stack and the ones in local files
+    DiffStack(name string, namespace string, tfconf string, tfvars string) (*StackDiff, error)
+
+    // UpdateStack updates the configuration and tfvars of a stack from local files
+    UpdateStack(name string, namespace string, tfconf string, tfvars string) (*tfo.Stack, error)
+
+    // RollbackStack sets the configuration of a stack to one of the revisions
+    // in its history. Revision 0 means the previous revision
+    RollbackStack(name string, namespace string, revision int64) (*tfo.Stack, error)
+
+    // ApplyStack creates or updates a stack from its manifest, storing the
+    // configuration and tfvars in local files, if given, as revisions
+    ApplyStack(stack *tfo.Stack, opts ApplyOptions) (*ApplyResult, error)
+
+    // RequestRun requests a single run of a stack with options that don't
+    // apply to its following runs
+    RequestRun(name string, namespace string, request tfo.RunRequest) (*tfo.RunRequest, error)
+
+    // RecordImports records the results of the imports of a stack's run in
+    // its status, while the run is the stack's last one
+    RecordImports(name string, namespace string, run int64, results []tfo.ImportResult) error
+
+    // RecordChanges records the changes reported by a stack's run in its
+    // status, while the run is the stack's last one
+    RecordChanges(name string, namespace string, run int64, changes *tfo.ChangeSummary) error
+
+    // RecordOutputs records the outputs of a stack's run in its status, while
+    // the run is the stack's last one. The values of sensitive outputs are
+    // stored in a Secret, encrypted if the stack has encryption keys
+    RecordOutputs(name string, namespace string, run int64, outputs map[string]terraform.Output) error
+
+    // PullState returns the stored terraform state of a stack
+    PullState(name string, namespace string) ([]byte, error)
+
+    // PushState replaces the stored state of a stack, checking its lineage
+    // and serial unless forced
+    PushState(name string, namespace string, content []byte, force bool) (*StateUpdate, error)
+
+    // MoveState moves a module, resource or instance to another address in
+    // the stored state of a stack
+    MoveState(name string, namespace string, src string, dest string) (*StateUpdate, error)
+
+    // RemoveState removes modules, resources or instances from the stored
+    // state of a stack
+    RemoveState(name string, namespace string, addresses []string) (*StateUpdate, error)
+
+    // RollbackState replaces the stored state of a stack with a version in
+    // its state history
+    RollbackState(name string, namespace string, version int64) (*StateUpdate, error)
+
+    // ListStateVersions returns the versions in the state history of a
+    // stack, from the oldest to the newest
+    ListStateVersions(name string, namespace string) ([]statestore.Version, error)
+
+    // GetStateVersion returns a version in the state history of a stack
+    // with its state
+    GetStateVersion(name string, namespace string, version int64) (*statestore.Version, []byte, error)
+
+    // RotateEncryptionKey encrypts the state of a stack, its versions and
+    // its sensitive outputs again with its active encryption key
+    RotateEncryptionKey(name string, namespace string) (*KeyRotation, error)
+
+    // ForceUnlockState releases the state lock of a stack with the given ID,
+    // left by a holder that failed to release it
+    ForceUnlockState(name string, namespace string, id string) error
+
+    // DescribeStack returns the description of a stack: the objects it
+    // references, its runs, its events and the resources in its state
+    DescribeStack(name string, namespace string, opts DescribeOptions) (*StackDescription, error)
+
+    // WaitStack waits until a stack meets a condition and returns it, or
+    // fails if the stack fails or the timeout expires. 0 means no timeout
+    WaitStack(name string, namespace string, condition WaitCondition, timeout time.Duration) (*tfo.Stack, error)
+
+    // StackLogs writes the log of one of the stack's runs
+    StackLogs(name string, namespace string, opts LogOptions, out io.Writer) error
+
+    // DeleteStack deletes a stack, destroying its resources unless they are
+    // orphaned
+    DeleteStack(name string, namespace string, opts DeleteOptions) error
+}
+
+const (
+    // length of the prefix of the content hash used for naming config and
+    // tfvars revisions
+    configHashLength = 10
+)
+
+// ListOptions defines the options for listing stacks
+type ListOptions struct {
+    // Namespace to list the stacks from. If empty, stacks in all namespaces
+    // are listed
+    Namespace string
+
+    // Label selector for filtering the stacks, such as "env=prod,tier!=db"
+    LabelSelector string
+
+    // Field selector for filtering the stacks, such as "metadata.name=net"
+    FieldSelector string
+
+    // Maximum number of stacks to return. If there are more stacks, the list
+    // has a continue token for requesting the next page. 0 means no limit
+    Limit int64
+
+    // Continue token returned by a previous list for requesting the next page
+    Continue string
+}
+
+// DeleteOptions defines the options for deleting a stack
+type DeleteOptions struct {
+    // Keep the stack's resources instead of destroying them
+    Orphan bool
+
+    // Delete the ConfigMaps and Secrets with the revisions of the stack's
+    // configuration and tfvars
+    Cascade bool
 }
 
 // tfoClient Client implementation
 type client struct {
-    rc ctlclient.Client
+    rc       ctlclient.Client
+    logs     jobs.PodLogs
+    watcher  stackWatcher
+    applier  stackApplier
+    backends stateBackend
 }
 
 // GetStack returns an existing stack or an error
 func (c *client)GetStack(stackName string, namespace string) (*tfo.Stack, error) {
-	return nil, nil
+    stack := &tfo.Stack{}
+    err := c.rc.Get(
+        context.TODO(),
+        ctlclient.ObjectKey{Name: stackName, Namespace: namespace},
+        stack,
+    )
+    if apierr.IsNotFound(err) {
+        return nil, NewNotFoundError(stackName, "Stack", namespace)
+    }
+    if err != nil {
+        errDesc := fmt.Sprintf("runtime error getting stack: %s", err)
+        return nil, NewTFOError(errDesc, ErrorReasonRuntimeError)
+    }
+
+    return stack, nil
+}
+
+// ListStacks returns the stacks matching the list options
+func (c *client)ListStacks(opts ListOptions) (*tfo.StackList, error) {
+    listOpts := &ctlclient.ListOptions{
+        Namespace: opts.Namespace,
+        Limit:     opts.Limit,
+        Continue:  opts.Continue,
+    }
+
+    if opts.LabelSelector != "" {
+        selector, err := labels.Parse(opts.LabelSelector)
+        if err != nil {
+            errDesc := fmt.Sprintf("invalid label selector %q: %v", opts.LabelSelector, err)
+            return nil, NewTFOError(errDesc, ErrorReasonInvalidArgument)
+        }
+        listOpts.LabelSelector = selector
+    }
+
+    if opts.FieldSelector != "" {
+        selector, err := fields.ParseSelector(opts.FieldSelector)
+        if err != nil {
+            errDesc := fmt.Sprintf("invalid field selector %q: %v", opts.FieldSelector, err)
+            return nil, NewTFOError(errDesc, ErrorReasonInvalidArgument)
+        }
+        listOpts.FieldSelector = selector
+    }
+
+    stacks := &tfo.StackList{}
+    err := c.rc.List(context.TODO(), stacks, listOpts)
+    if err != nil {
+        errDesc := fmt.Sprintf("runtime error listing stacks: %s", err)
+        return nil, NewTFOError(errDesc, ErrorReasonRuntimeError)
+    }
+
+    return stacks, nil
 }
 
 // NewClientFromKubeconfig creates a Client from a kubeconfig
 func NewFromKubeconfig(kubeconfig string) (Client, error) {
 	sch := apirtm.NewScheme()
 	corev1.AddToScheme(sch)
+	batchv1.AddToScheme(sch)
+	coordinationv1.AddToScheme(sch)
 	tfo.AddToScheme(sch)
 	cfg, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
 	if err != nil {
 		return nil, err
 	}
 
+	kc, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	dc, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	kubeClient, err := ctlclient.New(
 		cfg,
 		ctlclient.Options{Scheme: sch},
@@ -53,12 +268,18 @@ func NewFromKubeconfig(kubeconfig string) (Client, error) {
 		return nil, err
 	}
 
-	return &client{rc: kubeClient}, nil
+	return &client{
+		rc:       kubeClient,
+		logs:     jobs.NewPodLogs(kc),
+		watcher:  &dynamicStackWatcher{dc: dc},
+		applier:  &serverSideApplier{rc: kubeClient},
+		backends: &terraformBackend{},
+	}, nil
 }
 
 // NewClientFromRuntimeClient create a Client from a runtime client
 func NewFromRuntimeClient(rc ctlclient.Client) (Client, error) {
-    return &client{rc: rc}, nil
+    return &client{rc: rc, applier: &serverSideApplier{rc: rc}, backends: &terraformBackend{}}, nil
 }
 
 // CreateStack creates a stack from local tf files
@@ -81,59 +302,359 @@ func (c *client)CreateStack(name string, namespace string, tfconf string, tfvars
         return nil, NewTFOError(errDesc, ErrorReasonRuntimeError)
     }
 
-    // create configmap for config
-    tfconfMap, err := createConfigMap(name+"-tfconf",namespace, tfconf)
+    tfconfMap, tfvarsSecret, err := stackRevision(name, namespace, tfconf, tfvars)
     if err != nil {
         return  nil, err
     }
-    err = c.rc.Create(context.TODO(), tfconfMap)
+
+    // create configmap for config
+    err = c.createImmutable(tfconfMap)
     if err != nil {
         return  nil, err
     }
 
     // create secret for tfvars
-    tfvarsSecret, err := createSecret(name+"-tfvars", namespace, tfvars)
+    err = c.createImmutable(tfvarsSecret)
     if err != nil {
         return  nil, err
     }
-    err = c.rc.Create(context.TODO(), tfvarsSecret)
+
+    //create Stack
+    stack := newStackFor(name, namespace, tfconfMap, tfvarsSecret)
+
+    err = c.rc.Create(context.TODO(), stack)
     if err != nil {
         return  nil, err
     }
 
+    return stack, nil
 
-    //create Stack
-    stack := &tfo.Stack{
-        ObjectMeta: metav1.ObjectMeta{
-            Name: name,
-            Namespace: namespace,
-        },
-        Spec: tfo.StackSpec{
-            TfConfig: corev1.LocalObjectReference{Name: tfconfMap.Name},
-            TfVars:   corev1.LocalObjectReference{Name: tfvarsSecret.Name},
-        },
+}
+
+// DiffStack returns the differences between the configuration and tfvars of
+// a stack and the ones in local files
+func (c *client)DiffStack(name string, namespace string, tfconf string, tfvars string) (*StackDiff, error) {
+    stack, err := c.GetStack(name, namespace)
+    if err != nil {
+        return nil, err
+    }
+    if stack.Spec.Source != nil {
+        errDesc := fmt.Sprintf("stack %s takes its configuration from a remote source", name)
+        return nil, NewTFOError(errDesc, ErrorReasonInvalidArgument)
     }
 
-    err = c.rc.Create(context.TODO(), stack)
+    tfconfMap, tfvarsSecret, err := stackRevision(name, namespace, tfconf, tfvars)
     if err != nil {
-        return  nil, err
+        return nil, err
+    }
+
+    currentConfMap := &corev1.ConfigMap{}
+    err = c.rc.Get(
+        context.TODO(),
+        ctlclient.ObjectKey{Name: stack.Spec.TfConfig.Name, Namespace: namespace},
+        currentConfMap,
+    )
+    if err != nil && !apierr.IsNotFound(err) {
+        errDesc := fmt.Sprintf("runtime error getting config map: %s", err)
+        return nil, NewTFOError(errDesc, ErrorReasonRuntimeError)
+    }
+
+    currentSecret := &corev1.Secret{}
+    err = c.rc.Get(
+        context.TODO(),
+        ctlclient.ObjectKey{Name: stack.Spec.TfVars.Name, Namespace: namespace},
+        currentSecret,
+    )
+    if err != nil && !apierr.IsNotFound(err) {
+        errDesc := fmt.Sprintf("runtime error getting secret: %s", err)
+        return nil, NewTFOError(errDesc, ErrorReasonRuntimeError)
+    }
+
+    files, err := diffConfigMaps(currentConfMap, tfconfMap)
+    if err != nil {
+        errDesc := fmt.Sprintf("error reading configuration of stack %s: %v", name, err)
+        return nil, NewTFOError(errDesc, ErrorReasonInvalidFileContent)
+    }
+
+    vars, err := diffSecrets(currentSecret, tfvarsSecret)
+    if err != nil {
+        errDesc := fmt.Sprintf("error reading tfvars: %v", err)
+        return nil, NewTFOError(errDesc, ErrorReasonInvalidFileContent)
+    }
+
+    return &StackDiff{Files: files, Vars: vars}, nil
+}
+
+// UpdateStack updates the configuration and tfvars of a stack from local
+// files. The new configuration and tfvars are stored as new revisions and the
+// stack is updated to reference both at once.
+func (c *client)UpdateStack(name string, namespace string, tfconf string, tfvars string) (*tfo.Stack, error) {
+    stack, err := c.GetStack(name, namespace)
+    if err != nil {
+        return nil, err
+    }
+    if stack.Spec.Source != nil {
+        errDesc := fmt.Sprintf("stack %s takes its configuration from a remote source", name)
+        return nil, NewTFOError(errDesc, ErrorReasonInvalidArgument)
+    }
+
+    tfconfMap, tfvarsSecret, err := stackRevision(name, namespace, tfconf, tfvars)
+    if err != nil {
+        return nil, err
+    }
+
+    if stack.Spec.TfConfig.Name == tfconfMap.Name && stack.Spec.TfVars.Name == tfvarsSecret.Name {
+        return stack, nil
+    }
+
+    err = c.createImmutable(tfconfMap)
+    if err != nil {
+        errDesc := fmt.Sprintf("runtime error creating config map: %s", err)
+        return nil, NewTFOError(errDesc, ErrorReasonRuntimeError)
+    }
+    err = c.createImmutable(tfvarsSecret)
+    if err != nil {
+        errDesc := fmt.Sprintf("runtime error creating secret: %s", err)
+        return nil, NewTFOError(errDesc, ErrorReasonRuntimeError)
+    }
+
+    // the update fails if the stack was modified since it was read
+    stack.Spec.TfConfig.Name = tfconfMap.Name
+    stack.Spec.TfVars.Name = tfvarsSecret.Name
+    err = c.rc.Update(context.TODO(), stack)
+    if apierr.IsConflict(err) {
+        errDesc := fmt.Sprintf("stack %s was modified during the update, try again", name)
+        return nil, NewTFOError(errDesc, ErrorReasonConflict)
+    }
+    if err != nil {
+        errDesc := fmt.Sprintf("runtime error updating stack: %s", err)
+        return nil, NewTFOError(errDesc, ErrorReasonRuntimeError)
+    }
+
+    return stack, nil
+}
+
+// RollbackStack sets the configuration of a stack to one of the revisions
+// in its history. Revision 0 means the previous revision
+func (c *client)RollbackStack(name string, namespace string, revision int64) (*tfo.Stack, error) {
+    stack, err := c.GetStack(name, namespace)
+    if err != nil {
+        return nil, err
+    }
+
+    history := stack.Status.ConfigRevisions
+    target := -1
+    for i := range history {
+        if history[i].Revision == revision {
+            target = i
+        }
+    }
+    if revision == 0 && len(history) > 1 {
+        target = len(history)-2
+    }
+    if target < 0 {
+        errDesc := fmt.Sprintf("revision %d not found in the history of stack %s", revision, name)
+        return nil, NewTFOError(errDesc, ErrorReasonNotFound)
+    }
+
+    // the revision's ConfigMap and Secret must be still available
+    rev := history[target]
+    if rev.TfConfig != "" {
+        err = c.checkExists(rev.TfConfig, namespace, &corev1.ConfigMap{}, "ConfigMap")
+        if err != nil {
+            return nil, err
+        }
+        stack.Spec.TfConfig.Name = rev.TfConfig
+    }
+    if rev.TfVars != "" {
+        err = c.checkExists(rev.TfVars, namespace, &corev1.Secret{}, "Secret")
+        if err != nil {
+            return nil, err
+        }
+        stack.Spec.TfVars.Name = rev.TfVars
+    }
+
+    err = c.rc.Update(context.TODO(), stack)
+    if apierr.IsConflict(err) {
+        errDesc := fmt.Sprintf("stack %s was modified during the rollback, try again", name)
+        return nil, NewTFOError(errDesc, ErrorReasonConflict)
+    }
+    if err != nil {
+        errDesc := fmt.Sprintf("runtime error updating stack: %s", err)
+        return nil, NewTFOError(errDesc, ErrorReasonRuntimeError)
     }
 
     return stack, nil
+}
+
+// DeleteStack deletes a stack. The options are set as annotations in the
+// stack before deleting it, for the controller to handle them while
+// finalizing the stack.
+func (c *client)DeleteStack(name string, namespace string, opts DeleteOptions) error {
+    stack, err := c.GetStack(name, namespace)
+    if err != nil {
+        return err
+    }
 
+    annotations := map[string]bool{
+        tfo.OrphanResourcesAnnotation: opts.Orphan,
+        tfo.CascadeAnnotation:         opts.Cascade,
+    }
+    changed := false
+    for annotation, set := range annotations {
+        if !set || stack.Annotations[annotation] == "true" {
+            continue
+        }
+        if stack.Annotations == nil {
+            stack.Annotations = map[string]string{}
+        }
+        stack.Annotations[annotation] = "true"
+        changed = true
+    }
+
+    if changed {
+        err = c.rc.Update(context.TODO(), stack)
+        if apierr.IsConflict(err) {
+            errDesc := fmt.Sprintf("stack %s was modified during the delete, try again", name)
+            return NewTFOError(errDesc, ErrorReasonConflict)
+        }
+        if err != nil {
+            errDesc := fmt.Sprintf("runtime error updating stack: %s", err)
+            return NewTFOError(errDesc, ErrorReasonRuntimeError)
+        }
+    }
+
+    err = c.rc.Delete(context.TODO(), stack)
+    if apierr.IsNotFound(err) {
+        return NewNotFoundError(name, "Stack", namespace)
+    }
+    if err != nil {
+        errDesc := fmt.Sprintf("runtime error deleting stack: %s", err)
+        return NewTFOError(errDesc, ErrorReasonRuntimeError)
+    }
+
+    // stacks never applied have no finalizer, so the controller will not
+    // release their revisions
+    if opts.Cascade && !hasFinalizer(stack, tfo.StackFinalizer) {
+        return c.deleteRevisions(name, namespace)
+    }
+
+    return nil
 }
 
-// createConfigMap create a ConfigMap from a the files in a directory
-func createConfigMap(name string, namespace string, dirPath string) (*corev1.ConfigMap, error) {
+// deleteRevisions deletes the ConfigMaps and Secrets with the revisions of a
+// stack's configuration and tfvars
+func (c *client)deleteRevisions(name string, namespace string) error {
+    revisions := []struct{
+        obj       apirtm.Object
+        hashLabel string
+        class     string
+    }{
+        {&corev1.ConfigMap{}, tfo.ConfigHashLabel, "config maps"},
+        {&corev1.Secret{}, tfo.TfVarsHashLabel, "secrets"},
+    }
 
-    configMap := &corev1.ConfigMap{
-        ObjectMeta: metav1.ObjectMeta{
-            Name: name,
-            Namespace: namespace,
-        },
-        Data: map[string]string{},
+    for _, rev := range revisions {
+        // the stack and hash labels must be in the same selector, as
+        // MatchingLabels and HasLabels replace each other's selector
+        selector := labels.SelectorFromSet(labels.Set{tfo.StackLabel: name})
+        hasHash, err := labels.NewRequirement(rev.hashLabel, selection.Exists, nil)
+        if err != nil {
+            return err
+        }
+
+        err = c.rc.DeleteAllOf(
+            context.TODO(),
+            rev.obj,
+            ctlclient.InNamespace(namespace),
+            ctlclient.MatchingLabelsSelector{Selector: selector.Add(*hasHash)},
+        )
+        if err != nil {
+            errDesc := fmt.Sprintf("runtime error deleting %s: %s", rev.class, err)
+            return NewTFOError(errDesc, ErrorReasonRuntimeError)
+        }
+    }
+
+    return nil
+}
+
+// hasFinalizer indicates if a stack has a finalizer
+func hasFinalizer(stack *tfo.Stack, finalizer string) bool {
+    for _, f := range stack.Finalizers {
+        if f == finalizer {
+            return true
+        }
+    }
+    return false
+}
+
+// checkExists checks an object of the given class exists
+func (c *client)checkExists(name string, namespace string, obj apirtm.Object, class string) error {
+    err := c.rc.Get(
+        context.TODO(),
+        ctlclient.ObjectKey{Name: name, Namespace: namespace},
+        obj,
+    )
+    if apierr.IsNotFound(err) {
+        return NewNotFoundError(name, class, namespace)
+    }
+    if err != nil {
+        errDesc := fmt.Sprintf("runtime error getting %s: %s", class, err)
+        return NewTFOError(errDesc, ErrorReasonRuntimeError)
+    }
+    return nil
+}
+
+// createImmutable creates an immutable ConfigMap or Secret for a revision of a
+// stack's configuration or tfvars. As revisions are named by their content, an
+// existing object with the same name already has the same content. The object
+// is created as unstructured with the immutable field set, as the field is not
+// supported by the ConfigMap and Secret types of the kubernetes api version in
+// use, so it is never created mutable.
+func (c *client)createImmutable(obj apirtm.Object) error {
+    _, err := c.createImmutableIfMissing(obj)
+    return err
+}
+
+// createImmutableIfMissing creates an immutable revision object unless it
+// exists, and indicates if it was created
+func (c *client)createImmutableIfMissing(obj apirtm.Object) (bool, error) {
+    var kind string
+    switch obj.(type) {
+    case *corev1.ConfigMap:
+        kind = "ConfigMap"
+    case *corev1.Secret:
+        kind = "Secret"
+    default:
+        return false, fmt.Errorf("unexpected revision object %T", obj)
+    }
+
+    fields, err := apirtm.DefaultUnstructuredConverter.ToUnstructured(obj)
+    if err != nil {
+        return false, err
+    }
+    u := &unstructured.Unstructured{Object: fields}
+    u.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind(kind))
+    u.Object["immutable"] = true
+
+    err = c.rc.Create(context.TODO(), u)
+    if apierr.IsAlreadyExists(err) {
+        return false, nil
+    }
+    if err != nil {
+        return false, err
     }
 
+    return true, apirtm.DefaultUnstructuredConverter.FromUnstructured(u.Object, obj)
+}
+
+// createConfigMap create a ConfigMap for a revision of a stack's configuration
+// from the files in a directory and its subdirectories, except the ones
+// excluded by a .terraformignore file or the exclude list (as paths relative to
+// the directory). The ConfigMap is named after the stack and the hash of its
+// content.
+func createConfigMap(stack string, namespace string, dirPath string, exclude ...string) (*corev1.ConfigMap, error) {
+
     fileList, err := ioutil.ReadDir(dirPath)
     if err != nil {
         desc := fmt.Sprintf("error accessing directory %s: %v", dirPath, err)
@@ -145,29 +666,70 @@ func createConfigMap(name string, namespace string, dirPath string) (*corev1.Con
         return nil, NewTFOError(desc, ErrorReasonFileCanNotBeAccessed)
     }
 
-    for _, file := range fileList {
-        filePath := path.Join(dirPath, file.Name())
-        if file.Size() == 0 {
-            desc := fmt.Sprintf("file %s is empty", filePath)
-            return nil, NewTFOError(desc, ErrorReasonInvalidFileContent)
-        }
-        data, err := ioutil.ReadFile(filePath)
-        if err != nil {
-            desc := fmt.Sprintf("error reading file %s: %v", filePath, err)
-            return nil, NewTFOError(desc, ErrorReasonFileCanNotBeAccessed)
-        }
-        configMap.Data[file.Name()] = string(data)
+    config, err := tfconfig.Pack(dirPath, exclude...)
+    if errors.Is(err, tfconfig.ErrEmpty) {
+        desc := fmt.Sprintf("directory %s has no configuration files", dirPath)
+        return nil, NewTFOError(desc, ErrorReasonInvalidFileContent)
+    }
+    if errors.Is(err, tfconfig.ErrTooLarge) {
+        desc := fmt.Sprintf("configuration in %s can't be stored in a ConfigMap: %v", dirPath, err)
+        return nil, NewTFOError(desc, ErrorReasonConfigTooLarge)
+    }
+    if err != nil {
+        desc := fmt.Sprintf("error reading directory %s: %v", dirPath, err)
+        return nil, NewTFOError(desc, ErrorReasonFileCanNotBeAccessed)
+    }
 
+    hash := config.Hash()[:configHashLength]
+    configMap := &corev1.ConfigMap{
+        ObjectMeta: metav1.ObjectMeta{
+            Name: fmt.Sprintf("%s-tfconf-%s", stack, hash),
+            Namespace: namespace,
+            Labels: map[string]string{
+                tfo.StackLabel: stack,
+                tfo.ConfigHashLabel: hash,
+            },
+        },
+        Data:       config.Data,
+        BinaryData: config.BinaryData,
     }
 
     return configMap, nil
 }
 
-// createSecret create a Secret from a file
-func createSecret(name string, namespace string, filePath string) (*corev1.Secret, error) {
+// excludeVars returns the files to exclude from the configuration: the tfvars,
+// if they are in the config directory, as they are stored in a secret
+func excludeVars(tfconf string, tfvars string) []string {
+    exclude := []string{}
+    if relPath, ok := relativePath(tfconf, tfvars); ok {
+        exclude = append(exclude, relPath)
+    }
+    return exclude
+}
+
+// relativePath returns the path of a file relative to a directory, if the
+// file is inside it
+func relativePath(dirPath string, filePath string) (string, bool) {
+    absDir, err := filepath.Abs(dirPath)
+    if err != nil {
+        return "", false
+    }
+    absFile, err := filepath.Abs(filePath)
+    if err != nil {
+        return "", false
+    }
+    relPath, err := filepath.Rel(absDir, absFile)
+    if err != nil || strings.HasPrefix(relPath, "..") {
+        return "", false
+    }
+    return relPath, true
+}
+
+// createSecret create a Secret for a revision of a stack's tfvars from a file.
+// The Secret is named after the stack and the hash of its content.
+func createSecret(stack string, namespace string, filePath string) (*corev1.Secret, error) {
     secret := &corev1.Secret{
         ObjectMeta: metav1.ObjectMeta{
-            Name: name,
             Namespace: namespace,
         },
         Data: map[string][]byte{},
@@ -191,5 +753,12 @@ func createSecret(name string, namespace string, filePath string) (*corev1.Secre
     }
     secret.Data[fileInfo.Name()] = data
 
+    hash := fmt.Sprintf("%x", sha256.Sum256(append([]byte(fileInfo.Name()+"\x00"), data...)))[:configHashLength]
+    secret.Name = fmt.Sprintf("%s-tfvars-%s", stack, hash)
+    secret.Labels = map[string]string{
+        tfo.StackLabel: stack,
+        tfo.TfVarsHashLabel: hash,
+    }
+
     return secret, nil
 }
@@ -1,10 +1,27 @@
 package client
 
 import (
+    "bytes"
     "context"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/sha256"
+    "crypto/x509"
+    "encoding/binary"
+    "encoding/json"
+    "encoding/pem"
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "io"
+    "strings"
+    "time"
     "io/ioutil"
     "os"
     "path/filepath"
+    "reflect"
 	"testing"
 
 
@@ -12,9 +29,19 @@ import (
 	. "github.com/onsi/gomega"
 
 	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+    "github.com/pablochacin/tf-operator/pkg/encryption"
+    "github.com/pablochacin/tf-operator/pkg/jobs"
+    "github.com/pablochacin/tf-operator/pkg/statelock"
+    "github.com/pablochacin/tf-operator/pkg/terraform"
+    "github.com/pablochacin/tf-operator/pkg/tfconfig"
+    batchv1 "k8s.io/api/batch/v1"
+    coordinationv1 "k8s.io/api/coordination/v1"
     corev1 "k8s.io/api/core/v1"
+    apierr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
     rmt "k8s.io/apimachinery/pkg/runtime"
+    "k8s.io/apimachinery/pkg/watch"
     ctl "sigs.k8s.io/controller-runtime/pkg/client"
     fake "sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
@@ -53,6 +80,8 @@ func TestCreate(t *testing.T) {
 func newFakeClient(objs...rmt.Object) ctl.Client {
     sch := rmt.NewScheme()
     corev1.AddToScheme(sch)
+    batchv1.AddToScheme(sch)
+    coordinationv1.AddToScheme(sch)
     tfo.AddToScheme(sch)
     rc := fake.NewFakeClientWithScheme(sch, objs...)
     return rc
@@ -86,6 +115,17 @@ func createTfWorkDir(files map[string]string) (string, error) {
     return tfDir, nil
 }
 
+// newStack returns a Stack with the given labels
+func newStack(name string, namespace string, labels map[string]string) *tfo.Stack {
+    return &tfo.Stack{
+        ObjectMeta: metav1.ObjectMeta{
+            Name: name,
+            Namespace: namespace,
+            Labels: labels,
+        },
+    }
+}
+
 var _ = Describe("Client", func() {
 	var (
         stack       *tfo.Stack
@@ -154,30 +194,51 @@ var _ = Describe("Client", func() {
                     &stck,
                 )
                 Expect(getErr).NotTo(HaveOccurred())
-                Expect(stck.Spec.TfConfig.Name).To(Equal(stackName+"-tfconf"))
-                Expect(stck.Spec.TfVars.Name).To(Equal(stackName+"-tfvars"))
+                Expect(stck.Spec.TfConfig.Name).To(HavePrefix(stackName+"-tfconf-"))
+                Expect(stck.Spec.TfVars.Name).To(HavePrefix(stackName+"-tfvars-"))
             })
 
             It("Should create Config Map", func(){
                 cfgMap := corev1.ConfigMap{}
                 getErr := rc.Get(
                     context.TODO(),
-                    ctl.ObjectKey{Name: stackName+"-tfconf", Namespace: namespace},
+                    ctl.ObjectKey{Name: stack.Spec.TfConfig.Name, Namespace: namespace},
                     &cfgMap,
                 )
                 Expect(getErr).NotTo(HaveOccurred())
                 Expect(cfgMap.Data["main.tf"]).To(Equal(main_tf))
+                Expect(cfgMap.Labels).To(HaveKeyWithValue(tfo.StackLabel, stackName))
+                Expect(cfgMap.Labels).To(HaveKey(tfo.ConfigHashLabel))
             })
 
             It("Should create Secret", func(){
                 secret := corev1.Secret{}
                 getErr := rc.Get(
                     context.TODO(),
-                    ctl.ObjectKey{Name: stackName+"-tfvars", Namespace: namespace},
+                    ctl.ObjectKey{Name: stack.Spec.TfVars.Name, Namespace: namespace},
                     &secret,
                 )
                 Expect(getErr).NotTo(HaveOccurred())
                 Expect(secret.Data["terraform.tfvars"]).To(Equal([]byte(terraform_tfvars)))
+                Expect(secret.Labels).To(HaveKeyWithValue(tfo.StackLabel, stackName))
+                Expect(secret.Labels).To(HaveKey(tfo.TfVarsHashLabel))
+            })
+
+            It("Should create the revisions immutable", func(){
+                for kind, name := range map[string]string{
+                    "ConfigMap": stack.Spec.TfConfig.Name,
+                    "Secret": stack.Spec.TfVars.Name,
+                } {
+                    obj := &unstructured.Unstructured{}
+                    obj.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind(kind))
+                    getErr := rc.Get(
+                        context.TODO(),
+                        ctl.ObjectKey{Name: name, Namespace: namespace},
+                        obj,
+                    )
+                    Expect(getErr).NotTo(HaveOccurred())
+                    Expect(obj.Object["immutable"]).To(BeTrue())
+                }
             })
         })
 
@@ -246,12 +307,11 @@ var _ = Describe("Client", func() {
                 })
            })
 
-           Context("tfconfig file is empty", func(){
+           Context("tfconfig has only ignored files", func(){
                 BeforeEach(func(){
-                    // create tfconf but not tfvars
                     tfFiles = map[string]string{
                         "terraform.tfvars": terraform_tfvars,
-                        "tfconfig/main.tf": "",
+                        "tfconfig/terraform.tfstate": "{}",
                     }
                 })
 
@@ -262,5 +322,1808 @@ var _ = Describe("Client", func() {
                 })
            })
         })
+
+        Context("tfconfig has nested modules", func() {
+            BeforeEach(func(){
+               tfFiles = map[string]string{
+                   "terraform.tfvars": terraform_tfvars,
+                   "tfconfig/main.tf": main_tf,
+                   "tfconfig/empty.tf": "",
+                   "tfconfig/modules/greeter/main.tf": main_tf,
+               }
+            })
+
+            It("Should create a Config Map with a bundle", func(){
+                Expect(err).NotTo(HaveOccurred())
+                cfgMap := corev1.ConfigMap{}
+                getErr := rc.Get(
+                    context.TODO(),
+                    ctl.ObjectKey{Name: stack.Spec.TfConfig.Name, Namespace: namespace},
+                    &cfgMap,
+                )
+                Expect(getErr).NotTo(HaveOccurred())
+                Expect(cfgMap.BinaryData).To(HaveKey(tfconfig.BundleKey))
+            })
+        })
+
+        Context("tfvars are in the config directory", func() {
+            BeforeEach(func(){
+               tfFiles = map[string]string{
+                   "tfconfig/terraform.tfvars": terraform_tfvars,
+                   "tfconfig/main.tf": main_tf,
+               }
+            })
+
+            JustBeforeEach(func() {
+                // recreate the stack using the tfvars in the config directory
+                rc = newFakeClient()
+                c, _ := NewFromRuntimeClient(rc)
+                tfconf := filepath.Join(tfDir, "tfconfig")
+                stack, err = c.CreateStack(stackName, namespace, tfconf, filepath.Join(tfconf, "terraform.tfvars"))
+            })
+
+            It("Should not store the tfvars in the Config Map", func(){
+                Expect(err).NotTo(HaveOccurred())
+                cfgMap := corev1.ConfigMap{}
+                getErr := rc.Get(
+                    context.TODO(),
+                    ctl.ObjectKey{Name: stack.Spec.TfConfig.Name, Namespace: namespace},
+                    &cfgMap,
+                )
+                Expect(getErr).NotTo(HaveOccurred())
+                Expect(cfgMap.Data).To(HaveKey("main.tf"))
+                Expect(cfgMap.Data).NotTo(HaveKey("terraform.tfvars"))
+            })
+        })
     })
-})
+
+    Context("Update Stack", func(){
+        var (
+            c       Client
+            tfconf  string
+            tfvars  string
+            created *tfo.Stack
+            diff    *StackDiff
+        )
+
+        BeforeEach(func() {
+            rc = newFakeClient()
+            c, _ = NewFromRuntimeClient(rc)
+            tfDir, err = createTfWorkDir(map[string]string{
+                "terraform.tfvars": terraform_tfvars,
+                "tfconfig/main.tf": main_tf,
+                "tfconfig/outputs.tf": "",
+            })
+            Expect(err).NotTo(HaveOccurred())
+            tfvars = filepath.Join(tfDir, "terraform.tfvars")
+            tfconf = filepath.Join(tfDir, "tfconfig")
+            created, err = c.CreateStack(stackName, namespace, tfconf, tfvars)
+            Expect(err).NotTo(HaveOccurred())
+        })
+
+        AfterEach(func(){
+            os.RemoveAll(tfDir)
+        })
+
+        Context("without changes", func() {
+            It("Should report no differences", func() {
+                diff, err = c.DiffStack(stackName, namespace, tfconf, tfvars)
+                Expect(err).NotTo(HaveOccurred())
+                Expect(diff.Empty()).To(BeTrue())
+            })
+
+            It("Should keep the references", func() {
+                stack, err = c.UpdateStack(stackName, namespace, tfconf, tfvars)
+                Expect(err).NotTo(HaveOccurred())
+                Expect(stack.Spec).To(Equal(created.Spec))
+            })
+        })
+
+        Context("with changes", func() {
+            BeforeEach(func() {
+                Expect(ioutil.WriteFile(filepath.Join(tfconf, "main.tf"), []byte(main_tf+"\n# updated\n"), 0666)).To(Succeed())
+                Expect(ioutil.WriteFile(filepath.Join(tfconf, "variables.tf"), []byte(""), 0666)).To(Succeed())
+                Expect(os.Remove(filepath.Join(tfconf, "outputs.tf"))).To(Succeed())
+                Expect(ioutil.WriteFile(tfvars, []byte("greetee = \"Moon\"\nloud = true\n"), 0666)).To(Succeed())
+            })
+
+            It("Should report the changed files and variables", func() {
+                diff, err = c.DiffStack(stackName, namespace, tfconf, tfvars)
+                Expect(err).NotTo(HaveOccurred())
+                Expect(diff.Files).To(Equal(Changes{
+                    Added:    []string{"variables.tf"},
+                    Modified: []string{"main.tf"},
+                    Removed:  []string{"outputs.tf"},
+                }))
+                Expect(diff.Vars).To(Equal(Changes{
+                    Added:    []string{"loud"},
+                    Modified: []string{"greetee"},
+                }))
+            })
+
+            It("Should reference new revisions of the config and tfvars", func() {
+                stack, err = c.UpdateStack(stackName, namespace, tfconf, tfvars)
+                Expect(err).NotTo(HaveOccurred())
+                Expect(stack.Spec.TfConfig.Name).NotTo(Equal(created.Spec.TfConfig.Name))
+                Expect(stack.Spec.TfVars.Name).NotTo(Equal(created.Spec.TfVars.Name))
+
+                // previous revisions are kept for rolling back
+                Expect(rc.Get(
+                    context.TODO(),
+                    ctl.ObjectKey{Name: created.Spec.TfConfig.Name, Namespace: namespace},
+                    &corev1.ConfigMap{},
+                )).To(Succeed())
+            })
+        })
+
+        Context("stack doesn't exist", func() {
+            It("Should Return an error", func() {
+                _, err = c.UpdateStack("other", namespace, tfconf, tfvars)
+                Expect(Is(err,ErrorReasonNotFound)).To(BeTrue())
+            })
+        })
+    })
+
+    Context("Rollback Stack", func(){
+        var (
+            revision int64
+        )
+
+        BeforeEach(func() {
+            initObjs = []rmt.Object{
+                &tfo.Stack{
+                    ObjectMeta: metav1.ObjectMeta{
+                        Name: stackName,
+                        Namespace: namespace,
+                    },
+                    Spec: tfo.StackSpec{
+                        TfConfig: corev1.LocalObjectReference{Name: stackName+"-tfconf-b"},
+                    },
+                    Status: tfo.StackStatus{
+                        ConfigRevisions: []tfo.ConfigRevision{
+                            {Revision: 1, TfConfig: stackName+"-tfconf-a"},
+                            {Revision: 2, TfConfig: stackName+"-tfconf-b"},
+                        },
+                    },
+                },
+                &corev1.ConfigMap{
+                    ObjectMeta: metav1.ObjectMeta{
+                        Name: stackName+"-tfconf-a",
+                        Namespace: namespace,
+                    },
+                },
+            }
+        })
+
+        JustBeforeEach(func() {
+            rc = newFakeClient(initObjs...)
+            c, _ := NewFromRuntimeClient(rc)
+            stack, err = c.RollbackStack(stackName, namespace, revision)
+        })
+
+        AfterEach(func(){
+            initObjs = []rmt.Object{}
+        })
+
+        Context("to the previous revision", func() {
+            BeforeEach(func() {
+                revision = 0
+            })
+
+            It("Should reference the previous config map", func() {
+                Expect(err).NotTo(HaveOccurred())
+                stck := tfo.Stack{}
+                getErr := rc.Get(
+                    context.TODO(),
+                    ctl.ObjectKey{Name: stackName, Namespace: namespace},
+                    &stck,
+                )
+                Expect(getErr).NotTo(HaveOccurred())
+                Expect(stck.Spec.TfConfig.Name).To(Equal(stackName+"-tfconf-a"))
+            })
+        })
+
+        Context("to a given revision", func() {
+            BeforeEach(func() {
+                revision = 1
+            })
+
+            It("Should reference the revision's config map", func() {
+                Expect(err).NotTo(HaveOccurred())
+                Expect(stack.Spec.TfConfig.Name).To(Equal(stackName+"-tfconf-a"))
+            })
+        })
+
+        Context("to a revision not in the history", func() {
+            BeforeEach(func() {
+                revision = 5
+            })
+
+            It("Should Return an error", func() {
+                Expect(err).To(HaveOccurred())
+                Expect(Is(err,ErrorReasonNotFound)).To(BeTrue())
+                Expect(stack).To(BeNil())
+            })
+        })
+
+        Context("to a revision whose config map was deleted", func() {
+            BeforeEach(func() {
+                revision = 1
+                initObjs = initObjs[:1]
+            })
+
+            It("Should Return an error", func() {
+                Expect(err).To(HaveOccurred())
+                Expect(Is(err,ErrorReasonNotFound)).To(BeTrue())
+            })
+        })
+
+        Context("stack doesn't exist", func() {
+            BeforeEach(func() {
+                initObjs = []rmt.Object{}
+            })
+
+            It("Should Return an error", func() {
+                Expect(err).To(HaveOccurred())
+                Expect(Is(err,ErrorReasonNotFound)).To(BeTrue())
+            })
+        })
+    })
+
+    Context("Get Stack", func(){
+        JustBeforeEach(func() {
+            rc = newFakeClient(initObjs...)
+            c, _ := NewFromRuntimeClient(rc)
+            stack, err = c.GetStack(stackName, namespace)
+        })
+
+        AfterEach(func(){
+            initObjs = []rmt.Object{}
+        })
+
+        Context("stack exists", func() {
+            BeforeEach(func() {
+                initObjs = []rmt.Object{newStack(stackName, namespace, nil)}
+            })
+
+            It("Should return the stack", func() {
+                Expect(err).NotTo(HaveOccurred())
+                Expect(stack.Name).To(Equal(stackName))
+                Expect(stack.Namespace).To(Equal(namespace))
+            })
+        })
+
+        Context("stack doesn't exist", func() {
+            It("Should Return an error", func() {
+                Expect(err).To(HaveOccurred())
+                Expect(Is(err,ErrorReasonNotFound)).To(BeTrue())
+                Expect(stack).To(BeNil())
+            })
+        })
+    })
+
+    Context("List Stacks", func(){
+        var (
+            opts   ListOptions
+            stacks *tfo.StackList
+        )
+
+        BeforeEach(func() {
+            opts = ListOptions{}
+            initObjs = []rmt.Object{
+                newStack("network", namespace, map[string]string{"env": "prod"}),
+                newStack("database", namespace, map[string]string{"env": "dev"}),
+                newStack("network", "other", map[string]string{"env": "prod"}),
+            }
+        })
+
+        JustBeforeEach(func() {
+            rc = newFakeClient(initObjs...)
+            c, _ := NewFromRuntimeClient(rc)
+            stacks, err = c.ListStacks(opts)
+        })
+
+        AfterEach(func(){
+            initObjs = []rmt.Object{}
+        })
+
+        Context("in a namespace", func() {
+            BeforeEach(func() {
+                opts.Namespace = namespace
+            })
+
+            It("Should return only the namespace's stacks", func() {
+                Expect(err).NotTo(HaveOccurred())
+                Expect(stacks.Items).To(HaveLen(2))
+                for _, s := range stacks.Items {
+                    Expect(s.Namespace).To(Equal(namespace))
+                }
+            })
+        })
+
+        Context("in all namespaces", func() {
+            It("Should return all stacks", func() {
+                Expect(err).NotTo(HaveOccurred())
+                Expect(stacks.Items).To(HaveLen(3))
+            })
+        })
+
+        Context("with a label selector", func() {
+            BeforeEach(func() {
+                opts.LabelSelector = "env=prod"
+            })
+
+            It("Should return the matching stacks", func() {
+                Expect(err).NotTo(HaveOccurred())
+                Expect(stacks.Items).To(HaveLen(2))
+                for _, s := range stacks.Items {
+                    Expect(s.Name).To(Equal("network"))
+                }
+            })
+        })
+
+        Context("with an invalid label selector", func() {
+            BeforeEach(func() {
+                opts.LabelSelector = "env in (prod"
+            })
+
+            It("Should Return an error", func() {
+                Expect(err).To(HaveOccurred())
+                Expect(Is(err,ErrorReasonInvalidArgument)).To(BeTrue())
+            })
+        })
+
+        Context("with an invalid field selector", func() {
+            BeforeEach(func() {
+                opts.FieldSelector = "metadata.name"
+            })
+
+            It("Should Return an error", func() {
+                Expect(err).To(HaveOccurred())
+                Expect(Is(err,ErrorReasonInvalidArgument)).To(BeTrue())
+            })
+        })
+    })
+
+    Context("Delete Stack", func(){
+        var (
+            opts       DeleteOptions
+            finalizers []string
+        )
+
+        // revisions returns a ConfigMap and a Secret labeled as revisions of a stack
+        revisions := func(stack string) []rmt.Object {
+            return []rmt.Object{
+                &corev1.ConfigMap{
+                    ObjectMeta: metav1.ObjectMeta{
+                        Name: stack+"-tfconf-a",
+                        Namespace: namespace,
+                        Labels: map[string]string{
+                            tfo.StackLabel: stack,
+                            tfo.ConfigHashLabel: "a",
+                        },
+                    },
+                },
+                &corev1.Secret{
+                    ObjectMeta: metav1.ObjectMeta{
+                        Name: stack+"-tfvars-a",
+                        Namespace: namespace,
+                        Labels: map[string]string{
+                            tfo.StackLabel: stack,
+                            tfo.TfVarsHashLabel: "a",
+                        },
+                    },
+                },
+            }
+        }
+
+        BeforeEach(func() {
+            opts = DeleteOptions{}
+            finalizers = nil
+        })
+
+        JustBeforeEach(func() {
+            stck := newStack(stackName, namespace, nil)
+            stck.Finalizers = finalizers
+            objs := append([]rmt.Object{stck}, revisions(stackName)...)
+            rc = newFakeClient(append(objs, revisions("other")...)...)
+            c, _ := NewFromRuntimeClient(rc)
+            err = c.DeleteStack(stackName, namespace, opts)
+        })
+
+        It("Should delete the stack and keep its revisions", func() {
+            Expect(err).NotTo(HaveOccurred())
+            getErr := rc.Get(
+                context.TODO(),
+                ctl.ObjectKey{Name: stackName, Namespace: namespace},
+                &tfo.Stack{},
+            )
+            Expect(apierr.IsNotFound(getErr)).To(BeTrue())
+
+            configMaps := &corev1.ConfigMapList{}
+            Expect(rc.List(context.TODO(), configMaps)).To(Succeed())
+            Expect(configMaps.Items).To(HaveLen(2))
+        })
+
+        Context("with cascade", func() {
+            BeforeEach(func() {
+                opts.Cascade = true
+            })
+
+            It("Should delete only the stack's revisions", func() {
+                Expect(err).NotTo(HaveOccurred())
+                configMaps := &corev1.ConfigMapList{}
+                Expect(rc.List(context.TODO(), configMaps)).To(Succeed())
+                Expect(configMaps.Items).To(HaveLen(1))
+                Expect(configMaps.Items[0].Name).To(Equal("other-tfconf-a"))
+
+                secrets := &corev1.SecretList{}
+                Expect(rc.List(context.TODO(), secrets)).To(Succeed())
+                Expect(secrets.Items).To(HaveLen(1))
+                Expect(secrets.Items[0].Name).To(Equal("other-tfvars-a"))
+            })
+        })
+
+        Context("with cascade and the stack's finalizer", func() {
+            BeforeEach(func() {
+                opts.Cascade = true
+                finalizers = []string{tfo.StackFinalizer}
+            })
+
+            It("Should leave the revisions to the controller", func() {
+                Expect(err).NotTo(HaveOccurred())
+                configMaps := &corev1.ConfigMapList{}
+                Expect(rc.List(context.TODO(), configMaps)).To(Succeed())
+                Expect(configMaps.Items).To(HaveLen(2))
+            })
+        })
+    })
+
+    Context("Delete a non existing Stack", func(){
+        It("Should Return a not found error", func() {
+            c, _ := NewFromRuntimeClient(newFakeClient())
+            err := c.DeleteStack(stackName, namespace, DeleteOptions{Orphan: true})
+            Expect(err).To(HaveOccurred())
+            Expect(Is(err,ErrorReasonNotFound)).To(BeTrue())
+        })
+    })
+
+    Context("Get Outputs", func(){
+        var (
+            outputs       map[string]terraform.Output
+            showSensitive bool
+            secretName    string
+        )
+
+        BeforeEach(func() {
+            showSensitive = false
+            secretName = stackName+"-outputs"
+            tfout := `{
+                "vpc_id": {"sensitive": false, "type": "string", "value": "vpc-1234"},
+                "password": {"sensitive": true, "type": "string", "value": null}
+            }`
+            stck := newStack(stackName, namespace, nil)
+            stck.Status.TfOutput = base64.StdEncoding.EncodeToString([]byte(tfout))
+            stck.Status.SensitiveOutputs = secretName
+            initObjs = []rmt.Object{stck}
+        })
+
+        JustBeforeEach(func() {
+            initObjs = append(initObjs, &corev1.Secret{
+                ObjectMeta: metav1.ObjectMeta{
+                    Name: secretName,
+                    Namespace: namespace,
+                },
+                Data: map[string][]byte{"password": []byte(`"secret"`)},
+            })
+            rc = newFakeClient(initObjs...)
+        })
+
+        AfterEach(func(){
+            initObjs = []rmt.Object{}
+        })
+
+        Context("without showing sensitive outputs", func() {
+            JustBeforeEach(func() {
+                c, _ := NewFromRuntimeClient(rc)
+                outputs, err = c.GetOutputs(stackName, namespace, showSensitive)
+            })
+
+            It("Should redact the sensitive outputs", func() {
+                Expect(err).NotTo(HaveOccurred())
+                Expect(outputs["vpc_id"].VarValue()).To(Equal("vpc-1234"))
+                Expect(outputs["password"].Redacted()).To(BeTrue())
+            })
+        })
+
+        Context("showing sensitive outputs", func() {
+            BeforeEach(func() {
+                showSensitive = true
+            })
+
+            It("Should take the sensitive values from the secret", func() {
+                c, _ := NewFromRuntimeClient(rc)
+                outputs, err = c.GetOutputs(stackName, namespace, showSensitive)
+                Expect(err).NotTo(HaveOccurred())
+                Expect(outputs["password"].VarValue()).To(Equal("secret"))
+            })
+
+            It("Should Return an error if not allowed to read the secret", func() {
+                c, _ := NewFromRuntimeClient(&forbiddenSecrets{rc})
+                outputs, err = c.GetOutputs(stackName, namespace, showSensitive)
+                Expect(err).To(HaveOccurred())
+                Expect(Is(err,ErrorReasonForbidden)).To(BeTrue())
+            })
+        })
+    })
+
+    Context("Stack Logs", func(){
+        var (
+            opts   LogOptions
+            output *strings.Builder
+        )
+
+        BeforeEach(func() {
+            opts = LogOptions{}
+            output = new(strings.Builder)
+
+            stck := newStack(stackName, namespace, nil)
+            stck.Status.Job = stackName+"-apply-b"
+            stck.Status.LastRun = &tfo.StackRun{Number: 2}
+            runLabels := func(run string) map[string]string {
+                return map[string]string{tfo.StackLabel: stackName, tfo.RunLabel: run}
+            }
+            rc = newFakeClient(
+                stck,
+                &batchv1.Job{
+                    ObjectMeta: metav1.ObjectMeta{
+                        Name: stackName+"-apply-b",
+                        Namespace: namespace,
+                        Labels: runLabels("2"),
+                    },
+                },
+                &corev1.Pod{
+                    ObjectMeta: metav1.ObjectMeta{
+                        Name: stackName+"-apply-b-xyz",
+                        Namespace: namespace,
+                        Labels: jobs.PodSelector(stackName+"-apply-b"),
+                    },
+                    Status: corev1.PodStatus{Phase: corev1.PodRunning},
+                },
+                &corev1.ConfigMap{
+                    ObjectMeta: metav1.ObjectMeta{
+                        Name: stackName+"-apply-a-log",
+                        Namespace: namespace,
+                        Labels: runLabels("1"),
+                    },
+                    Data: map[string]string{jobs.LogArchiveKey: "archived log"},
+                },
+            )
+        })
+
+        JustBeforeEach(func() {
+            c := &client{rc: rc, logs: &fakePodLogs{pod: stackName+"-apply-b-xyz", log: "current log"}}
+            err = c.StackLogs(stackName, namespace, opts, output)
+        })
+
+        It("Should stream the log of the last run", func() {
+            Expect(err).NotTo(HaveOccurred())
+            Expect(output.String()).To(Equal("current log"))
+        })
+
+        Context("of the previous run", func() {
+            BeforeEach(func() {
+                opts.Previous = true
+            })
+
+            It("Should return the archived log", func() {
+                Expect(err).NotTo(HaveOccurred())
+                Expect(output.String()).To(Equal("archived log"))
+            })
+        })
+
+        Context("of a run after the last one", func() {
+            BeforeEach(func() {
+                opts.Run = 3
+            })
+
+            It("Should Return a not found error", func() {
+                Expect(err).To(HaveOccurred())
+                Expect(Is(err,ErrorReasonNotFound)).To(BeTrue())
+            })
+        })
+    })
+
+    Context("Wait Stack", func(){
+        var (
+            condition WaitCondition
+            timeout   time.Duration
+            events    []watch.Event
+            waitStack *tfo.Stack
+        )
+
+        // stackWithReady returns the stack with its Ready condition
+        stackWithReady := func(status corev1.ConditionStatus, reason tfo.StackPhase, generation int64) *tfo.Stack {
+            stck := newStack(stackName, namespace, nil)
+            stck.Generation = 2
+            stck.Status.Conditions = []tfo.StackCondition{{
+                Type: tfo.StackConditionReady,
+                Status: status,
+                Reason: string(reason),
+                Message: "apply failed",
+                ObservedGeneration: generation,
+            }}
+            return stck
+        }
+
+        BeforeEach(func() {
+            condition = WaitConditionReady
+            timeout = 0
+            events = nil
+            // the last apply was for a previous generation
+            rc = newFakeClient(stackWithReady(corev1.ConditionTrue, tfo.StackPhaseReady, 1))
+        })
+
+        JustBeforeEach(func() {
+            watcher := watch.NewFakeWithChanSize(len(events), false)
+            for _, event := range events {
+                watcher.Action(event.Type, event.Object)
+            }
+            c := &client{rc: rc, watcher: &fakeStackWatcher{watcher}}
+            waitStack, err = c.WaitStack(stackName, namespace, condition, timeout)
+        })
+
+        Context("until the current generation is ready", func() {
+            BeforeEach(func() {
+                events = []watch.Event{
+                    {Type: watch.Modified, Object: stackWithReady(corev1.ConditionFalse, tfo.StackPhaseRunning, 2)},
+                    {Type: watch.Modified, Object: stackWithReady(corev1.ConditionTrue, tfo.StackPhaseReady, 2)},
+                }
+            })
+
+            It("Should return the ready stack", func() {
+                Expect(err).NotTo(HaveOccurred())
+                Expect(readyCondition(waitStack).ObservedGeneration).To(Equal(int64(2)))
+            })
+        })
+
+        Context("for a stack that fails", func() {
+            BeforeEach(func() {
+                events = []watch.Event{
+                    {Type: watch.Modified, Object: stackWithReady(corev1.ConditionFalse, tfo.StackPhaseFailed, 2)},
+                }
+            })
+
+            It("Should Return the failure", func() {
+                Expect(err).To(HaveOccurred())
+                Expect(Is(err,ErrorReasonStackFailed)).To(BeTrue())
+                Expect(err.Error()).To(Equal("apply failed"))
+            })
+        })
+
+        Context("for a plan-only stack", func() {
+            BeforeEach(func() {
+                events = []watch.Event{
+                    {Type: watch.Modified, Object: stackWithReady(corev1.ConditionFalse, tfo.StackPhasePlanned, 2)},
+                }
+            })
+
+            It("Should Return the plan", func() {
+                Expect(Is(err,ErrorReasonStackFailed)).To(BeTrue())
+                Expect(err.Error()).To(HavePrefix("stack is plan-only"))
+            })
+        })
+
+        Context("for the stack to be deleted", func() {
+            BeforeEach(func() {
+                condition = WaitConditionDelete
+                events = []watch.Event{
+                    {Type: watch.Deleted, Object: newStack(stackName, namespace, nil)},
+                }
+            })
+
+            It("Should not fail", func() {
+                Expect(err).NotTo(HaveOccurred())
+            })
+        })
+
+        Context("with a timeout", func() {
+            BeforeEach(func() {
+                timeout = 10*time.Millisecond
+            })
+
+            It("Should Return a timeout error", func() {
+                Expect(err).To(HaveOccurred())
+                Expect(Is(err,ErrorReasonTimeout)).To(BeTrue())
+            })
+        })
+        Context("with a client created from a runtime client", func() {
+            BeforeEach(func() {
+                timeout = 10*time.Millisecond
+            })
+
+            It("Should Return an error instead of watching", func() {
+                c, _ := NewFromRuntimeClient(rc)
+                _, err := c.WaitStack(stackName, namespace, condition, timeout)
+                Expect(Is(err,ErrorReasonRuntimeError)).To(BeTrue())
+            })
+        })
+    })
+
+    Context("Describe Stack", func(){
+        var desc *StackDescription
+
+        BeforeEach(func() {
+            stck := newStack(stackName, namespace, nil)
+            stck.Spec.TfConfig.Name = stackName+"-tfconf"
+            stck.Spec.TfVars.Name = stackName+"-tfvars"
+            stck.Status.TfState.Name = stackName+"-tfstate"
+
+            job := func(name string, run string, cond batchv1.JobConditionType) *batchv1.Job {
+                return &batchv1.Job{
+                    ObjectMeta: metav1.ObjectMeta{
+                        Name: name,
+                        Namespace: namespace,
+                        Labels: map[string]string{tfo.StackLabel: stackName, tfo.RunLabel: run},
+                    },
+                    Spec: batchv1.JobSpec{
+                        Template: corev1.PodTemplateSpec{
+                            Spec: corev1.PodSpec{
+                                Containers: []corev1.Container{{Command: []string{"tfoctl", "runner", "apply"}}},
+                            },
+                        },
+                    },
+                    Status: batchv1.JobStatus{
+                        Conditions: []batchv1.JobCondition{
+                            {Type: cond, Status: corev1.ConditionTrue, Message: "BackoffLimitExceeded"},
+                        },
+                    },
+                }
+            }
+            event := func(name string, kind string, object string, minutes int) *corev1.Event {
+                return &corev1.Event{
+                    ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+                    InvolvedObject: corev1.ObjectReference{Kind: kind, Name: object},
+                    LastTimestamp: metav1.NewTime(time.Now().Add(time.Duration(minutes)*time.Minute)),
+                    Reason: name,
+                }
+            }
+
+            rc = newFakeClient(
+                stck,
+                &corev1.ConfigMap{
+                    ObjectMeta: metav1.ObjectMeta{Name: stackName+"-tfconf", Namespace: namespace},
+                    Data: map[string]string{"main.tf": "", "vars.tf": ""},
+                },
+                &corev1.Secret{
+                    ObjectMeta: metav1.ObjectMeta{Name: stackName+"-tfstate", Namespace: namespace},
+                    Data: map[string][]byte{
+                        terraform.StateKey: []byte(`{"version": 4, "resources": [
+                            {"mode": "managed", "type": "aws_vpc", "name": "main", "instances": [{"attributes": {"id": "vpc-1"}}]}
+                        ]}`),
+                    },
+                },
+                job(stackName+"-apply-a", "1", batchv1.JobFailed),
+                job(stackName+"-apply-b", "2", batchv1.JobComplete),
+                event("Started", "Job", stackName+"-apply-b", -2),
+                event("Ready", "Stack", stackName, -1),
+                event("Other", "Job", "other-apply-a", 0),
+            )
+            c, _ := NewFromRuntimeClient(rc)
+            desc, err = c.DescribeStack(stackName, namespace, DescribeOptions{})
+        })
+
+        It("Should describe the referenced objects", func() {
+            Expect(err).NotTo(HaveOccurred())
+            Expect(desc.TfConfig.Found).To(BeTrue())
+            Expect(desc.TfConfig.Items).To(Equal([]string{"main.tf", "vars.tf"}))
+            Expect(desc.TfVars.Found).To(BeFalse())
+        })
+
+        It("Should describe the runs from the last one", func() {
+            Expect(desc.Runs).To(HaveLen(2))
+            Expect(desc.Runs[0].Number).To(Equal(int64(2)))
+            Expect(desc.Runs[0].Command).To(Equal("apply"))
+            Expect(desc.Runs[0].Result).To(Equal(RunResultSucceeded))
+            Expect(desc.Runs[1].Result).To(Equal(RunResultFailed))
+            Expect(desc.Runs[1].Message).To(Equal("BackoffLimitExceeded"))
+        })
+
+        It("Should list the events of the stack and its jobs", func() {
+            reasons := []string{}
+            for _, event := range desc.Events {
+                reasons = append(reasons, event.Reason)
+            }
+            Expect(reasons).To(Equal([]string{"Started", "Ready"}))
+        })
+
+        It("Should list the resources in the state", func() {
+            Expect(desc.Resources).To(HaveLen(1))
+            Expect(desc.Resources[0].Address).To(Equal("aws_vpc.main"))
+            Expect(desc.Warnings).To(BeEmpty())
+        })
+    })
+
+    Context("Render Stack", func(){
+        var (
+            manifests *StackManifests
+            opts      RenderOptions
+            key       *rsa.PrivateKey
+        )
+
+        BeforeEach(func() {
+            opts = RenderOptions{}
+            tfDir, err = createTfWorkDir(map[string]string{
+                "tfconfig/main.tf": main_tf,
+                "terraform.tfvars": terraform_tfvars,
+            })
+            Expect(err).NotTo(HaveOccurred())
+        })
+
+        JustBeforeEach(func() {
+            manifests, err = RenderStack(
+                stackName,
+                namespace,
+                filepath.Join(tfDir, "tfconfig"),
+                filepath.Join(tfDir, "terraform.tfvars"),
+                opts,
+            )
+        })
+
+        AfterEach(func(){
+            os.RemoveAll(tfDir)
+        })
+
+        It("Should render the objects CreateStack creates", func() {
+            Expect(err).NotTo(HaveOccurred())
+            Expect(manifests.Stack.Spec.TfConfig.Name).To(Equal(manifests.TfConfig.Name))
+            Expect(manifests.Stack.Spec.TfVars.Name).To(Equal(manifests.TfVars.Name))
+
+            rc = newFakeClient()
+            c, _ := NewFromRuntimeClient(rc)
+            stack, err = c.CreateStack(stackName, namespace, filepath.Join(tfDir, "tfconfig"), filepath.Join(tfDir, "terraform.tfvars"))
+            Expect(err).NotTo(HaveOccurred())
+            Expect(stack.Spec).To(Equal(manifests.Stack.Spec))
+        })
+
+        It("Should render immutable objects in order", func() {
+            objs, err := manifests.Objects()
+            Expect(err).NotTo(HaveOccurred())
+            kinds := []interface{}{}
+            for _, obj := range objs {
+                kinds = append(kinds, obj["kind"])
+                Expect(obj["metadata"]).NotTo(HaveKey("creationTimestamp"))
+                Expect(obj).NotTo(HaveKey("status"))
+            }
+            Expect(kinds).To(Equal([]interface{}{"ConfigMap", "Secret", "Stack"}))
+            Expect(objs[0]["immutable"]).To(BeTrue())
+            Expect(objs[1]["immutable"]).To(BeTrue())
+            Expect(objs[2]["apiVersion"]).To(Equal(tfo.GroupVersion.String()))
+        })
+
+        Context("with a sealing key", func() {
+            BeforeEach(func() {
+                key, err = rsa.GenerateKey(rand.Reader, 2048)
+                Expect(err).NotTo(HaveOccurred())
+                der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+                Expect(err).NotTo(HaveOccurred())
+                opts.SealingKey = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+            })
+
+            It("Should seal the tfvars for the secret's name and namespace", func() {
+                Expect(err).NotTo(HaveOccurred())
+                Expect(manifests.TfVars).To(BeNil())
+                sealed := manifests.SealedTfVars
+                Expect(sealed.Kind).To(Equal("SealedSecret"))
+                Expect(sealed.Spec.Template.Labels).To(HaveKey(tfo.TfVarsHashLabel))
+
+                encrypted, err := base64.StdEncoding.DecodeString(sealed.Spec.EncryptedData["terraform.tfvars"])
+                Expect(err).NotTo(HaveOccurred())
+                value, err := hybridDecrypt(key, encrypted, []byte(namespace+"/"+sealed.Name))
+                Expect(err).NotTo(HaveOccurred())
+                Expect(string(value)).To(Equal(terraform_tfvars))
+
+                _, err = hybridDecrypt(key, encrypted, []byte(namespace+"/other"))
+                Expect(err).To(HaveOccurred())
+            })
+        })
+
+        Context("with an invalid sealing key", func() {
+            BeforeEach(func() {
+                opts.SealingKey = []byte("not a key")
+            })
+
+            It("Should fail", func() {
+                Expect(Is(err, ErrorReasonInvalidArgument)).To(BeTrue())
+            })
+        })
+    })
+
+    Context("Apply Stack", func(){
+        var (
+            c        *client
+            manifest *tfo.Stack
+            opts     ApplyOptions
+            result   *ApplyResult
+        )
+
+        // operations returns the operation applied to each kind of object
+        operations := func(result *ApplyResult) map[string]ApplyOperation {
+            ops := map[string]ApplyOperation{}
+            for _, obj := range result.Objects {
+                ops[obj.Kind] = obj.Operation
+            }
+            return ops
+        }
+
+        BeforeEach(func() {
+            tfDir, err = createTfWorkDir(map[string]string{
+                "tfconfig/main.tf": main_tf,
+                "terraform.tfvars": terraform_tfvars,
+            })
+            Expect(err).NotTo(HaveOccurred())
+            opts = ApplyOptions{
+                TfConfig: filepath.Join(tfDir, "tfconfig"),
+                TfVars:   filepath.Join(tfDir, "terraform.tfvars"),
+            }
+            manifest = newStack(stackName, namespace, map[string]string{"env": "prod"})
+            manifest.Annotations = map[string]string{ConfigDirAnnotation: "tfconfig"}
+
+            rc = newFakeClient()
+            c = &client{rc: rc, applier: &fakeStackApplier{rc}}
+            result, err = c.ApplyStack(manifest, opts)
+        })
+
+        AfterEach(func(){
+            os.RemoveAll(tfDir)
+        })
+
+        It("Should create the stack referencing its configuration and tfvars", func() {
+            Expect(err).NotTo(HaveOccurred())
+            Expect(operations(result)).To(Equal(map[string]ApplyOperation{
+                "ConfigMap": ApplyOperationCreated,
+                "Secret":    ApplyOperationCreated,
+                "Stack":     ApplyOperationCreated,
+            }))
+
+            stack, err := c.GetStack(stackName, namespace)
+            Expect(err).NotTo(HaveOccurred())
+            Expect(stack.Spec.TfConfig.Name).To(HavePrefix(stackName+"-tfconf-"))
+            Expect(stack.Spec.TfVars.Name).To(HavePrefix(stackName+"-tfvars-"))
+            Expect(stack.Annotations).NotTo(HaveKey(ConfigDirAnnotation))
+            Expect(manifest.Spec.TfConfig.Name).To(BeEmpty())
+        })
+
+        It("Should report an unchanged stack", func() {
+            result, err = c.ApplyStack(manifest, opts)
+            Expect(err).NotTo(HaveOccurred())
+            Expect(operations(result)).To(Equal(map[string]ApplyOperation{
+                "ConfigMap": ApplyOperationUnchanged,
+                "Secret":    ApplyOperationUnchanged,
+                "Stack":     ApplyOperationUnchanged,
+            }))
+        })
+
+        It("Should store a new revision of the tfvars", func() {
+            Expect(ioutil.WriteFile(opts.TfVars, []byte(`greetee = "Moon"`), 0666)).To(Succeed())
+            result, err = c.ApplyStack(manifest, opts)
+            Expect(err).NotTo(HaveOccurred())
+            Expect(operations(result)).To(Equal(map[string]ApplyOperation{
+                "ConfigMap": ApplyOperationUnchanged,
+                "Secret":    ApplyOperationCreated,
+                "Stack":     ApplyOperationConfigured,
+            }))
+        })
+
+        It("Should reject a configuration for a stack with a remote source", func() {
+            manifest.Spec.Source = &tfo.StackSource{Git: &tfo.GitSource{URL: "https://example.com/repo.git"}}
+            _, err = c.ApplyStack(manifest, opts)
+            Expect(Is(err, ErrorReasonInvalidArgument)).To(BeTrue())
+        })
+    })
+    Context("Stack State", func(){
+        var (
+            c      *client
+            update *StateUpdate
+        )
+
+        state := func(lineage string, serial int) []byte {
+            return []byte(fmt.Sprintf(`{"version": 4, "serial": %d, "lineage": %q, "resources": [
+                {"mode": "managed", "type": "aws_vpc", "name": "main", "instances": [{"attributes": {"id": "vpc-1"}}]}
+            ]}`, serial, lineage))
+        }
+
+        stateLockHolder := func() *statelock.Info {
+            holder, err := statelock.New(rc, namespace, stackName).Get(context.TODO())
+            Expect(err).NotTo(HaveOccurred())
+            return holder
+        }
+
+        getSecret := func(name string) *corev1.Secret {
+            secret := &corev1.Secret{}
+            Expect(rc.Get(context.TODO(), ctl.ObjectKey{Name: name, Namespace: namespace}, secret)).To(Succeed())
+            return secret
+        }
+
+        Context("without a stored state", func() {
+            BeforeEach(func() {
+                rc = newFakeClient(newStack(stackName, namespace, nil))
+                c = &client{rc: rc}
+            })
+
+            It("Should report the state as not found", func() {
+                _, err = c.PullState(stackName, namespace)
+                Expect(Is(err, ErrorReasonNotFound)).To(BeTrue())
+            })
+
+            It("Should push the state creating its Secret", func() {
+                update, err = c.PushState(stackName, namespace, state("a", 1), false)
+                Expect(err).NotTo(HaveOccurred())
+                Expect(update).To(Equal(&StateUpdate{Secret: stackName+"-tfstate", Version: 1, Serial: 1}))
+
+                stack, err := c.GetStack(stackName, namespace)
+                Expect(err).NotTo(HaveOccurred())
+                Expect(stack.Status.TfState.Name).To(Equal(stackName+"-tfstate"))
+                Expect(stateLockHolder()).To(BeNil())
+                Expect(getSecret(stackName+"-tfstate").Labels).To(HaveKeyWithValue(tfo.StackLabel, stackName))
+
+                content, err := c.PullState(stackName, namespace)
+                Expect(err).NotTo(HaveOccurred())
+                Expect(content).To(Equal(state("a", 1)))
+            })
+
+            It("Should reject an invalid state", func() {
+                _, err = c.PushState(stackName, namespace, []byte("not a state"), true)
+                Expect(Is(err, ErrorReasonInvalidFileContent)).To(BeTrue())
+            })
+        })
+
+        Context("with a stored state", func() {
+            BeforeEach(func() {
+                stck := newStack(stackName, namespace, nil)
+                stck.Status.TfState.Name = stackName+"-tfstate"
+                rc = newFakeClient(
+                    stck,
+                    &corev1.Secret{
+                        ObjectMeta: metav1.ObjectMeta{Name: stackName+"-tfstate", Namespace: namespace},
+                        Data: map[string][]byte{terraform.StateKey: state("a", 3)},
+                    },
+                )
+                c = &client{rc: rc}
+            })
+
+            It("Should push a newer state recording its version", func() {
+                update, err = c.PushState(stackName, namespace, state("a", 4), false)
+                Expect(err).NotTo(HaveOccurred())
+                Expect(update.Version).To(Equal(int64(2)))
+                Expect(getSecret(stackName+"-tfstate").Data[terraform.StateKey]).To(Equal(state("a", 4)))
+
+                versions, err := c.ListStateVersions(stackName, namespace)
+                Expect(err).NotTo(HaveOccurred())
+                Expect(versions).To(HaveLen(2))
+                Expect(versions[0].Serial).To(Equal(int64(3)))
+                Expect(versions[1].Serial).To(Equal(int64(4)))
+                Expect(versions[1].Operation).To(Equal("push"))
+
+                version, content, err := c.GetStateVersion(stackName, namespace, 1)
+                Expect(err).NotTo(HaveOccurred())
+                Expect(version.Number).To(Equal(int64(1)))
+                Expect(content).To(Equal(state("a", 3)))
+
+                _, _, err = c.GetStateVersion(stackName, namespace, 5)
+                Expect(Is(err, ErrorReasonNotFound)).To(BeTrue())
+            })
+
+            It("Should roll back to a version increasing the serial", func() {
+                _, err = c.RemoveState(stackName, namespace, []string{"aws_vpc.main"})
+                Expect(err).NotTo(HaveOccurred())
+
+                update, err = c.RollbackState(stackName, namespace, 1)
+                Expect(err).NotTo(HaveOccurred())
+                Expect(update.Serial).To(Equal(int64(5)))
+                Expect(update.Version).To(Equal(int64(3)))
+                Expect(stateLockHolder()).To(BeNil())
+
+                resources, err := terraform.ParseStateResources(getSecret(stackName+"-tfstate").Data[terraform.StateKey])
+                Expect(err).NotTo(HaveOccurred())
+                Expect(resources).To(HaveLen(1))
+
+                versions, err := c.ListStateVersions(stackName, namespace)
+                Expect(err).NotTo(HaveOccurred())
+                Expect(versions[2].Operation).To(Equal("rollback to version 1"))
+
+                _, err = c.RollbackState(stackName, namespace, 7)
+                Expect(Is(err, ErrorReasonNotFound)).To(BeTrue())
+            })
+
+            It("Should reject a state with another lineage unless forced", func() {
+                _, err = c.PushState(stackName, namespace, state("b", 4), false)
+                Expect(Is(err, ErrorReasonConflict)).To(BeTrue())
+                Expect(getSecret(stackName+"-tfstate").Data[terraform.StateKey]).To(Equal(state("a", 3)))
+
+                _, err = c.PushState(stackName, namespace, state("b", 4), true)
+                Expect(err).NotTo(HaveOccurred())
+            })
+
+            It("Should reject an older state", func() {
+                _, err = c.PushState(stackName, namespace, state("a", 2), false)
+                Expect(Is(err, ErrorReasonConflict)).To(BeTrue())
+            })
+
+            It("Should move a resource increasing the serial", func() {
+                update, err = c.MoveState(stackName, namespace, "aws_vpc.main", "aws_vpc.this")
+                Expect(err).NotTo(HaveOccurred())
+                Expect(update.Serial).To(Equal(int64(4)))
+
+                resources, err := terraform.ParseStateResources(getSecret(stackName+"-tfstate").Data[terraform.StateKey])
+                Expect(err).NotTo(HaveOccurred())
+                Expect(resources[0].Address).To(Equal("aws_vpc.this"))
+            })
+
+            It("Should remove a resource", func() {
+                _, err = c.RemoveState(stackName, namespace, []string{"aws_vpc.main"})
+                Expect(err).NotTo(HaveOccurred())
+
+                resources, err := terraform.ParseStateResources(getSecret(stackName+"-tfstate").Data[terraform.StateKey])
+                Expect(err).NotTo(HaveOccurred())
+                Expect(resources).To(BeEmpty())
+            })
+
+            It("Should reject an address not in the state", func() {
+                _, err = c.RemoveState(stackName, namespace, []string{"aws_vpc.other"})
+                Expect(Is(err, ErrorReasonInvalidArgument)).To(BeTrue())
+                Expect(stateLockHolder()).To(BeNil())
+            })
+
+            It("Should fail if the state is locked until force unlocked", func() {
+                lock, err := statelock.New(rc, namespace, stackName).Acquire(
+                    context.TODO(),
+                    statelock.Info{Operation: "apply", Who: "someone"},
+                    statelock.DefaultTTL,
+                )
+                Expect(err).NotTo(HaveOccurred())
+
+                _, err = c.RemoveState(stackName, namespace, []string{"aws_vpc.main"})
+                Expect(Is(err, ErrorReasonConflict)).To(BeTrue())
+                Expect(err.Error()).To(ContainSubstring("locked by someone (apply, lock ID "+lock.Info.ID))
+
+                err = c.ForceUnlockState(stackName, namespace, "other")
+                Expect(Is(err, ErrorReasonConflict)).To(BeTrue())
+                Expect(c.ForceUnlockState(stackName, namespace, lock.Info.ID)).To(Succeed())
+
+                _, err = c.RemoveState(stackName, namespace, []string{"aws_vpc.main"})
+                Expect(err).NotTo(HaveOccurred())
+            })
+
+            It("Should fail if the stack has a run in progress", func() {
+                stack, err := c.GetStack(stackName, namespace)
+                Expect(err).NotTo(HaveOccurred())
+                stack.Status.Phase = tfo.StackPhaseRunning
+                Expect(rc.Status().Update(context.TODO(), stack)).To(Succeed())
+
+                _, err = c.MoveState(stackName, namespace, "aws_vpc.main", "aws_vpc.this")
+                Expect(Is(err, ErrorReasonConflict)).To(BeTrue())
+            })
+
+            It("Should not rotate the encryption key of a stack without keys", func() {
+                _, err = c.RotateEncryptionKey(stackName, namespace)
+                Expect(Is(err, ErrorReasonInvalidArgument)).To(BeTrue())
+            })
+        })
+
+        Context("with encryption keys", func() {
+            var keys *corev1.Secret
+
+            BeforeEach(func() {
+                keys = &corev1.Secret{
+                    ObjectMeta: metav1.ObjectMeta{Name: "keys", Namespace: namespace},
+                    Data: map[string][]byte{
+                        encryption.ActiveKey: []byte("k1"),
+                        "k1": bytes.Repeat([]byte{1}, encryption.KeySize),
+                    },
+                }
+                stck := newStack(stackName, namespace, nil)
+                stck.Spec.Encryption = &tfo.StackEncryption{KeySecret: corev1.LocalObjectReference{Name: "keys"}}
+                stck.Status.TfState.Name = stackName+"-tfstate"
+                stck.Status.TfOutput = base64.StdEncoding.EncodeToString([]byte(`{"password": {"sensitive": true, "type": "string", "value": null}}`))
+                stck.Status.SensitiveOutputs = stackName+"-outputs"
+                rc = newFakeClient(
+                    stck,
+                    keys,
+                    &corev1.Secret{
+                        ObjectMeta: metav1.ObjectMeta{Name: stackName+"-tfstate", Namespace: namespace},
+                        Data: map[string][]byte{terraform.StateKey: state("a", 3)},
+                    },
+                    &corev1.Secret{
+                        ObjectMeta: metav1.ObjectMeta{Name: stackName+"-outputs", Namespace: namespace},
+                        Data: map[string][]byte{"password": []byte(`"secret"`)},
+                    },
+                )
+                c = &client{rc: rc}
+            })
+
+            It("Should store the state encrypted", func() {
+                _, err = c.PushState(stackName, namespace, state("a", 4), false)
+                Expect(err).NotTo(HaveOccurred())
+                Expect(encryption.IsEncrypted(getSecret(stackName+"-tfstate").Data[terraform.StateKey])).To(BeTrue())
+
+                content, err := c.PullState(stackName, namespace)
+                Expect(err).NotTo(HaveOccurred())
+                Expect(content).To(Equal(state("a", 4)))
+            })
+
+            It("Should encrypt the state and outputs again after rotating the key", func() {
+                keys.Data["k2"] = bytes.Repeat([]byte{2}, encryption.KeySize)
+                keys.Data[encryption.ActiveKey] = []byte("k2")
+                Expect(rc.Update(context.TODO(), keys)).To(Succeed())
+
+                rotation, err := c.RotateEncryptionKey(stackName, namespace)
+                Expect(err).NotTo(HaveOccurred())
+                Expect(rotation.States).To(Equal([]string{stackName+"-tfstate"}))
+                Expect(rotation.SensitiveOutputs).To(Equal(stackName+"-outputs"))
+                Expect(stateLockHolder()).To(BeNil())
+                Expect(encryption.IsEncrypted(getSecret(stackName+"-tfstate").Data[terraform.StateKey])).To(BeTrue())
+                Expect(encryption.IsEncrypted(getSecret(stackName+"-outputs").Data["password"])).To(BeTrue())
+
+                outputs, err := c.GetOutputs(stackName, namespace, true)
+                Expect(err).NotTo(HaveOccurred())
+                Expect(outputs["password"].VarValue()).To(Equal("secret"))
+                content, err := c.PullState(stackName, namespace)
+                Expect(err).NotTo(HaveOccurred())
+                Expect(content).To(Equal(state("a", 3)))
+            })
+        })
+
+        Context("with a backend configured in the stack", func() {
+            var backend *fakeStateBackend
+
+            BeforeEach(func() {
+                stck := newStack(stackName, namespace, nil)
+                stck.Spec.Backend = &tfo.StackBackend{
+                    Type: "pg",
+                    Config: map[string]string{"schema_name": stackName},
+                    ConfigFrom: []tfo.BackendConfigSource{{
+                        Name: "conn_str",
+                        SecretKeyRef: corev1.SecretKeySelector{
+                            LocalObjectReference: corev1.LocalObjectReference{Name: "postgres"},
+                            Key: "url",
+                        },
+                    }},
+                }
+                rc = newFakeClient(
+                    stck,
+                    &corev1.Secret{
+                        ObjectMeta: metav1.ObjectMeta{Name: "postgres", Namespace: namespace},
+                        Data: map[string][]byte{"url": []byte("postgres://db/states")},
+                    },
+                )
+                backend = &fakeStateBackend{content: state("a", 3)}
+                c = &client{rc: rc, backends: backend}
+            })
+
+            It("Should pull the state from the backend with its settings", func() {
+                content, err := c.PullState(stackName, namespace)
+                Expect(err).NotTo(HaveOccurred())
+                Expect(content).To(Equal(state("a", 3)))
+                Expect(backend.config).To(Equal(map[string]string{"schema_name": stackName, "conn_str": "postgres://db/states"}))
+
+                backend.content = nil
+                _, err = c.PullState(stackName, namespace)
+                Expect(Is(err, ErrorReasonNotFound)).To(BeTrue())
+            })
+
+            It("Should push the state to the backend", func() {
+                update, err = c.PushState(stackName, namespace, state("a", 4), false)
+                Expect(err).NotTo(HaveOccurred())
+                Expect(update).To(Equal(&StateUpdate{Backend: "pg", Serial: 4}))
+                Expect(backend.content).To(Equal(state("a", 4)))
+                Expect(stateLockHolder()).To(BeNil())
+
+                _, err = c.PushState(stackName, namespace, state("b", 5), false)
+                Expect(Is(err, ErrorReasonConflict)).To(BeTrue())
+
+                _, err = c.PushState(stackName, namespace, state("b", 1), true)
+                Expect(err).NotTo(HaveOccurred())
+                Expect(backend.content).To(Equal(state("b", 1)))
+            })
+
+            It("Should move and remove resources in the backend", func() {
+                _, err = c.MoveState(stackName, namespace, "aws_vpc.main", "aws_vpc.this")
+                Expect(err).NotTo(HaveOccurred())
+                resources, err := terraform.ParseStateResources(backend.content)
+                Expect(err).NotTo(HaveOccurred())
+                Expect(resources[0].Address).To(Equal("aws_vpc.this"))
+
+                update, err = c.RemoveState(stackName, namespace, []string{"aws_vpc.this"})
+                Expect(err).NotTo(HaveOccurred())
+                Expect(update.Serial).To(Equal(int64(5)))
+            })
+
+            It("Should fail without the Secret with a setting", func() {
+                Expect(rc.Delete(context.TODO(), getSecret("postgres"))).To(Succeed())
+                _, err = c.PullState(stackName, namespace)
+                Expect(Is(err, ErrorReasonRuntimeError)).To(BeTrue())
+            })
+
+            It("Should force unlock the state in the backend", func() {
+                Expect(c.ForceUnlockState(stackName, namespace, "1a2b")).To(Succeed())
+                Expect(backend.unlocked).To(Equal("1a2b"))
+            })
+
+            It("Should not keep a state history", func() {
+                _, err = c.ListStateVersions(stackName, namespace)
+                Expect(Is(err, ErrorReasonInvalidArgument)).To(BeTrue())
+                _, _, err = c.GetStateVersion(stackName, namespace, 1)
+                Expect(Is(err, ErrorReasonInvalidArgument)).To(BeTrue())
+                _, err = c.RollbackState(stackName, namespace, 1)
+                Expect(Is(err, ErrorReasonInvalidArgument)).To(BeTrue())
+            })
+        })
+    })
+    Context("Adopt Stack", func(){
+        var (
+            c       *client
+            tfstate string
+        )
+
+        BeforeEach(func() {
+            tfDir, err = createTfWorkDir(map[string]string{
+                "main.tf": main_tf,
+                "terraform.tfvars": terraform_tfvars,
+                "terraform.tfstate": `{"version": 4, "serial": 7, "lineage": "a", "resources": []}`,
+            })
+            Expect(err).NotTo(HaveOccurred())
+            tfstate = filepath.Join(tfDir, "terraform.tfstate")
+            rc = newFakeClient()
+            c = &client{rc: rc}
+        })
+
+        AfterEach(func(){
+            os.RemoveAll(tfDir)
+        })
+
+        It("Should create a plan-only stack with the state", func() {
+            stack, err = c.AdoptStack(stackName, namespace, tfDir, filepath.Join(tfDir, "terraform.tfvars"), tfstate)
+            Expect(err).NotTo(HaveOccurred())
+            Expect(stack.Spec.PlanOnly).To(BeTrue())
+            Expect(stack.Status.TfState.Name).To(Equal(stackName+"-tfstate"))
+            holder, err := statelock.New(rc, namespace, stackName).Get(context.TODO())
+            Expect(err).NotTo(HaveOccurred())
+            Expect(holder).To(BeNil())
+
+            content, err := c.PullState(stackName, namespace)
+            Expect(err).NotTo(HaveOccurred())
+            Expect(string(content)).To(ContainSubstring(`"serial": 7`))
+
+            configMap := &corev1.ConfigMap{}
+            Expect(rc.Get(context.TODO(), ctl.ObjectKey{Name: stack.Spec.TfConfig.Name, Namespace: namespace}, configMap)).To(Succeed())
+            Expect(configMap.Data).NotTo(HaveKey("terraform.tfstate"))
+        })
+
+        It("Should reject an invalid state", func() {
+            Expect(ioutil.WriteFile(tfstate, []byte(`{"version": 3}`), 0666)).To(Succeed())
+            _, err = c.AdoptStack(stackName, namespace, tfDir, filepath.Join(tfDir, "terraform.tfvars"), tfstate)
+            Expect(Is(err, ErrorReasonInvalidFileContent)).To(BeTrue())
+        })
+
+        It("Should not replace the state of an existing stack", func() {
+            rc = newFakeClient(newStack(stackName, namespace, nil))
+            c = &client{rc: rc}
+            _, err = c.AdoptStack(stackName, namespace, tfDir, filepath.Join(tfDir, "terraform.tfvars"), tfstate)
+            Expect(Is(err, ErrorReasonAlreadyExists)).To(BeTrue())
+        })
+
+        It("Should retry the status update on conflicts", func() {
+            c = &client{rc: &failingStatus{Client: rc, err: apierr.NewConflict(tfo.GroupVersion.WithResource("stacks").GroupResource(), stackName, errors.New("modified")), failures: 1}}
+            stack, err = c.AdoptStack(stackName, namespace, tfDir, filepath.Join(tfDir, "terraform.tfvars"), tfstate)
+            Expect(err).NotTo(HaveOccurred())
+            Expect(stack.Status.TfState.Name).To(Equal(stackName+"-tfstate"))
+        })
+
+        It("Should delete the created objects if it fails", func() {
+            c = &client{rc: &failingStatus{Client: rc, err: errors.New("unavailable"), failures: -1}}
+            _, err = c.AdoptStack(stackName, namespace, tfDir, filepath.Join(tfDir, "terraform.tfvars"), tfstate)
+            Expect(Is(err, ErrorReasonRuntimeError)).To(BeTrue())
+
+            Expect(apierr.IsNotFound(rc.Get(context.TODO(), ctl.ObjectKey{Name: stackName, Namespace: namespace}, &tfo.Stack{}))).To(BeTrue())
+            Expect(apierr.IsNotFound(rc.Get(context.TODO(), ctl.ObjectKey{Name: stackName+"-tfstate", Namespace: namespace}, &corev1.Secret{}))).To(BeTrue())
+            configMaps := &corev1.ConfigMapList{}
+            Expect(rc.List(context.TODO(), configMaps, ctl.InNamespace(namespace))).To(Succeed())
+            Expect(configMaps.Items).To(BeEmpty())
+            secrets := &corev1.SecretList{}
+            Expect(rc.List(context.TODO(), secrets, ctl.InNamespace(namespace))).To(Succeed())
+            Expect(secrets.Items).To(BeEmpty())
+
+            // the adoption can be retried
+            c = &client{rc: rc}
+            _, err = c.AdoptStack(stackName, namespace, tfDir, filepath.Join(tfDir, "terraform.tfvars"), tfstate)
+            Expect(err).NotTo(HaveOccurred())
+        })
+
+        Context("confirming the plan", func() {
+            // planned sets the stack as planned for its current spec
+            planned := func(changes *tfo.ChangeSummary) {
+                stck, err := c.GetStack(stackName, namespace)
+                Expect(err).NotTo(HaveOccurred())
+                stck.Status.Phase = tfo.StackPhasePlanned
+                stck.Status.LastRun = &tfo.StackRun{Number: 1, Changes: changes}
+                stck.Status.Conditions = []tfo.StackCondition{{
+                    Type: tfo.StackConditionReady,
+                    Status: corev1.ConditionFalse,
+                    Reason: string(tfo.StackPhasePlanned),
+                    ObservedGeneration: stck.Generation,
+                }}
+                Expect(rc.Status().Update(context.TODO(), stck)).To(Succeed())
+            }
+
+            BeforeEach(func() {
+                _, err = c.AdoptStack(stackName, namespace, tfDir, filepath.Join(tfDir, "terraform.tfvars"), tfstate)
+                Expect(err).NotTo(HaveOccurred())
+            })
+
+            It("Should apply a plan without changes", func() {
+                planned(&tfo.ChangeSummary{})
+                stack, err = c.ConfirmPlan(stackName, namespace, false)
+                Expect(err).NotTo(HaveOccurred())
+                Expect(stack.Spec.PlanOnly).To(BeFalse())
+            })
+
+            It("Should reject a plan with changes unless forced", func() {
+                planned(&tfo.ChangeSummary{Destroy: 1})
+                _, err = c.ConfirmPlan(stackName, namespace, false)
+                Expect(Is(err, ErrorReasonConflict)).To(BeTrue())
+
+                stack, err = c.ConfirmPlan(stackName, namespace, true)
+                Expect(err).NotTo(HaveOccurred())
+                Expect(stack.Spec.PlanOnly).To(BeFalse())
+            })
+
+            It("Should reject a plan not completed", func() {
+                _, err = c.ConfirmPlan(stackName, namespace, true)
+                Expect(Is(err, ErrorReasonConflict)).To(BeTrue())
+            })
+
+            It("Should apply a plan whose run recorded no changes", func() {
+                planned(nil)
+                _, err = c.ConfirmPlan(stackName, namespace, false)
+                Expect(Is(err, ErrorReasonConflict)).To(BeTrue())
+
+                Expect(c.RecordChanges(stackName, namespace, 1, &tfo.ChangeSummary{})).To(Succeed())
+                stack, err = c.ConfirmPlan(stackName, namespace, false)
+                Expect(err).NotTo(HaveOccurred())
+                Expect(stack.Spec.PlanOnly).To(BeFalse())
+            })
+        })
+    })
+    Context("Request Run", func(){
+        var (
+            c       *client
+            request *tfo.RunRequest
+        )
+
+        BeforeEach(func() {
+            rc = newFakeClient(newStack(stackName, namespace, nil))
+            c = &client{rc: rc}
+        })
+
+        It("Should annotate the stack with the request", func() {
+            request, err = c.RequestRun(stackName, namespace, tfo.RunRequest{Targets: []string{"module.db"}})
+            Expect(err).NotTo(HaveOccurred())
+            Expect(request.ID).NotTo(BeEmpty())
+
+            stack, err := c.GetStack(stackName, namespace)
+            Expect(err).NotTo(HaveOccurred())
+            annotated := tfo.RunRequest{}
+            Expect(json.Unmarshal([]byte(stack.Annotations[tfo.RunRequestAnnotation]), &annotated)).To(Succeed())
+            Expect(annotated).To(Equal(*request))
+        })
+
+        It("Should reject a request while another one is pending", func() {
+            _, err = c.RequestRun(stackName, namespace, tfo.RunRequest{ID: "r1", RefreshOnly: true})
+            Expect(err).NotTo(HaveOccurred())
+            _, err = c.RequestRun(stackName, namespace, tfo.RunRequest{ID: "r2", RefreshOnly: true})
+            Expect(Is(err, ErrorReasonConflict)).To(BeTrue())
+        })
+
+        It("Should reject invalid options", func() {
+            _, err = c.RequestRun(stackName, namespace, tfo.RunRequest{Replace: []string{"aws_instance.web"}, RefreshOnly: true})
+            Expect(Is(err, ErrorReasonInvalidArgument)).To(BeTrue())
+        })
+    })
+    Context("Record Imports", func(){
+        var (
+            c       *client
+            results []tfo.ImportResult
+        )
+
+        BeforeEach(func() {
+            stack := newStack(stackName, namespace, nil)
+            stack.Status.LastRun = &tfo.StackRun{Number: 2}
+            rc = newFakeClient(stack)
+            c = &client{rc: rc}
+            results = []tfo.ImportResult{
+                {Address: "aws_vpc.main", ID: "vpc-1", Result: tfo.ImportResultImported},
+                {Address: "aws_subnet.a", ID: "subnet-1", Result: tfo.ImportResultFailed, Message: "not found"},
+            }
+        })
+
+        It("Should record the results in the last run", func() {
+            Expect(c.RecordImports(stackName, namespace, 2, results)).To(Succeed())
+            stack, err := c.GetStack(stackName, namespace)
+            Expect(err).NotTo(HaveOccurred())
+            Expect(stack.Status.LastRun.Imports).To(Equal(results))
+        })
+
+        It("Should discard the results of a previous run", func() {
+            err = c.RecordImports(stackName, namespace, 1, results)
+            Expect(Is(err, ErrorReasonConflict)).To(BeTrue())
+            stack, err := c.GetStack(stackName, namespace)
+            Expect(err).NotTo(HaveOccurred())
+            Expect(stack.Status.LastRun.Imports).To(BeEmpty())
+        })
+    })
+    Context("Record Changes", func(){
+        var c *client
+
+        BeforeEach(func() {
+            stack := newStack(stackName, namespace, nil)
+            stack.Status.LastRun = &tfo.StackRun{Number: 2}
+            rc = newFakeClient(stack)
+            c = &client{rc: rc}
+        })
+
+        It("Should record the changes in the last run", func() {
+            changes := &tfo.ChangeSummary{Add: 1, Destroy: 2}
+            Expect(c.RecordChanges(stackName, namespace, 2, changes)).To(Succeed())
+            stack, err := c.GetStack(stackName, namespace)
+            Expect(err).NotTo(HaveOccurred())
+            Expect(stack.Status.LastRun.Changes).To(Equal(changes))
+        })
+
+        It("Should discard the changes of a previous run", func() {
+            err = c.RecordChanges(stackName, namespace, 1, &tfo.ChangeSummary{Add: 1})
+            Expect(Is(err, ErrorReasonConflict)).To(BeTrue())
+        })
+    })
+    Context("Record Outputs", func(){
+        var (
+            c       *client
+            stack   *tfo.Stack
+            outputs map[string]terraform.Output
+        )
+
+        BeforeEach(func() {
+            stack = newStack(stackName, namespace, nil)
+            stack.Status.LastRun = &tfo.StackRun{Number: 2}
+            outputs = map[string]terraform.Output{
+                "vpc_id": {Type: json.RawMessage(`"string"`), Value: json.RawMessage(`"vpc-1234"`)},
+                "password": {Sensitive: true, Type: json.RawMessage(`"string"`), Value: json.RawMessage(`"secret"`)},
+            }
+        })
+
+        JustBeforeEach(func() {
+            rc = newFakeClient(
+                stack,
+                &corev1.Secret{
+                    ObjectMeta: metav1.ObjectMeta{Name: "keys", Namespace: namespace},
+                    Data: map[string][]byte{
+                        encryption.ActiveKey: []byte("k1"),
+                        "k1": bytes.Repeat([]byte{1}, encryption.KeySize),
+                    },
+                },
+                // created by the controller
+                &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: stackName+"-outputs", Namespace: namespace}},
+            )
+            c = &client{rc: rc}
+        })
+
+        getOutputsSecret := func() *corev1.Secret {
+            secret := &corev1.Secret{}
+            Expect(rc.Get(context.TODO(), ctl.ObjectKey{Name: stackName+"-outputs", Namespace: namespace}, secret)).To(Succeed())
+            return secret
+        }
+
+        It("Should record the outputs redacting the sensitive values", func() {
+            Expect(c.RecordOutputs(stackName, namespace, 2, outputs)).To(Succeed())
+
+            recorded, err := c.GetStack(stackName, namespace)
+            Expect(err).NotTo(HaveOccurred())
+            Expect(recorded.Status.SensitiveOutputs).To(Equal(stackName+"-outputs"))
+            decoded, err := terraform.DecodeOutputs(recorded.Status.TfOutput)
+            Expect(err).NotTo(HaveOccurred())
+            Expect(decoded["vpc_id"].VarValue()).To(Equal("vpc-1234"))
+            Expect(decoded["password"].Redacted()).To(BeTrue())
+            Expect(getOutputsSecret().Data["password"]).To(Equal([]byte(`"secret"`)))
+
+            shown, err := c.GetOutputs(stackName, namespace, true)
+            Expect(err).NotTo(HaveOccurred())
+            Expect(shown["password"].VarValue()).To(Equal("secret"))
+        })
+
+        Context("with encryption keys", func() {
+            BeforeEach(func() {
+                stack.Spec.Encryption = &tfo.StackEncryption{KeySecret: corev1.LocalObjectReference{Name: "keys"}}
+            })
+
+            It("Should store the sensitive values encrypted", func() {
+                Expect(c.RecordOutputs(stackName, namespace, 2, outputs)).To(Succeed())
+                Expect(encryption.IsEncrypted(getOutputsSecret().Data["password"])).To(BeTrue())
+
+                shown, err := c.GetOutputs(stackName, namespace, true)
+                Expect(err).NotTo(HaveOccurred())
+                Expect(shown["password"].VarValue()).To(Equal("secret"))
+            })
+        })
+
+        It("Should remove the sensitive values once there are none", func() {
+            Expect(c.RecordOutputs(stackName, namespace, 2, outputs)).To(Succeed())
+            delete(outputs, "password")
+            Expect(c.RecordOutputs(stackName, namespace, 2, outputs)).To(Succeed())
+
+            recorded, err := c.GetStack(stackName, namespace)
+            Expect(err).NotTo(HaveOccurred())
+            Expect(recorded.Status.SensitiveOutputs).To(BeEmpty())
+            Expect(getOutputsSecret().Data).To(BeEmpty())
+        })
+
+        It("Should discard the outputs of a previous run", func() {
+            err = c.RecordOutputs(stackName, namespace, 1, outputs)
+            Expect(Is(err, ErrorReasonConflict)).To(BeTrue())
+            Expect(getOutputsSecret().Data).To(BeEmpty())
+        })
+
+        It("Should fail if the controller didn't create the Secret", func() {
+            Expect(rc.Delete(context.TODO(), getOutputsSecret())).To(Succeed())
+            err = c.RecordOutputs(stackName, namespace, 2, outputs)
+            Expect(Is(err, ErrorReasonNotFound)).To(BeTrue())
+        })
+    })
+})
+
+// hybridDecrypt decrypts a value sealed by hybridEncrypt
+func hybridDecrypt(key *rsa.PrivateKey, ciphertext []byte, label []byte) ([]byte, error) {
+    keyLength := int(binary.BigEndian.Uint16(ciphertext))
+    sessionKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, key, ciphertext[2:2+keyLength], label)
+    if err != nil {
+        return nil, err
+    }
+    block, err := aes.NewCipher(sessionKey)
+    if err != nil {
+        return nil, err
+    }
+    aead, err := cipher.NewGCM(block)
+    if err != nil {
+        return nil, err
+    }
+    return aead.Open(nil, make([]byte, aead.NonceSize()), ciphertext[2+keyLength:], nil)
+}
+
+// forbiddenSecrets is a runtime client that is not allowed to get secrets
+type forbiddenSecrets struct {
+    ctl.Client
+}
+
+func (c *forbiddenSecrets) Get(ctx context.Context, key ctl.ObjectKey, obj rmt.Object) error {
+    if _, isSecret := obj.(*corev1.Secret); isSecret {
+        return apierr.NewForbidden(corev1.Resource("secrets"), key.Name, errors.New("forbidden"))
+    }
+    return c.Client.Get(ctx, key, obj)
+}
+
+// failingStatus is a runtime client whose status updates fail with an error.
+// A negative number of failures means they always fail
+type failingStatus struct {
+    ctl.Client
+    err      error
+    failures int
+}
+
+func (c *failingStatus) Status() ctl.StatusWriter {
+    return &failingStatusWriter{StatusWriter: c.Client.Status(), client: c}
+}
+
+type failingStatusWriter struct {
+    ctl.StatusWriter
+    client *failingStatus
+}
+
+func (w *failingStatusWriter) Update(ctx context.Context, obj rmt.Object, opts ...ctl.UpdateOption) error {
+    if w.client.failures != 0 {
+        w.client.failures--
+        return w.client.err
+    }
+    return w.StatusWriter.Update(ctx, obj, opts...)
+}
+
+// fakePodLogs returns a log for the pod of a given name
+type fakePodLogs struct {
+    pod string
+    log string
+}
+
+func (l *fakePodLogs) Stream(namespace string, pod string, opts *corev1.PodLogOptions) (io.ReadCloser, error) {
+    if pod != l.pod {
+        return nil, apierr.NewNotFound(corev1.Resource("pods"), pod)
+    }
+    return ioutil.NopCloser(strings.NewReader(l.log)), nil
+}
+
+// fakeStackWatcher returns a fake watch
+type fakeStackWatcher struct {
+    watcher *watch.FakeWatcher
+}
+
+func (w *fakeStackWatcher) WatchStack(name string, namespace string, resourceVersion string) (watch.Interface, error) {
+    return w.watcher, nil
+}
+
+// fakeStateBackend keeps a state as a backend, rejecting a state with
+// another lineage or a lower serial unless forced, as terraform does
+type fakeStateBackend struct {
+    content  []byte
+    config   map[string]string
+    unlocked string
+}
+
+func (b *fakeStateBackend) PullState(stack *tfo.Stack, config map[string]string) ([]byte, error) {
+    b.config = config
+    return b.content, nil
+}
+
+func (b *fakeStateBackend) PushState(stack *tfo.Stack, config map[string]string, content []byte, force bool) error {
+    if b.content != nil && !force {
+        current, _ := terraform.ParseStateMeta(b.content)
+        pushed, _ := terraform.ParseStateMeta(content)
+        if pushed.Lineage != current.Lineage || pushed.Serial < current.Serial {
+            return errors.New("cannot overwrite existing state")
+        }
+    }
+    b.content = content
+    return nil
+}
+
+func (b *fakeStateBackend) ForceUnlock(stack *tfo.Stack, config map[string]string, id string) error {
+    b.unlocked = id
+    return nil
+}
+
+// fakeStackApplier emulates server-side apply with the fake client: the
+// stack's spec is replaced and its labels and annotations merged, updating
+// the stack only if they change
+type fakeStackApplier struct {
+    rc ctl.Client
+}
+
+func (a *fakeStackApplier) ApplyStack(stack *tfo.Stack) error {
+    current := &tfo.Stack{}
+    err := a.rc.Get(context.TODO(), ctl.ObjectKey{Name: stack.Name, Namespace: stack.Namespace}, current)
+    if apierr.IsNotFound(err) {
+        return a.rc.Create(context.TODO(), stack)
+    }
+    if err != nil {
+        return err
+    }
+
+    applied := current.DeepCopy()
+    applied.Spec = stack.Spec
+    for name, value := range stack.Labels {
+        if applied.Labels == nil {
+            applied.Labels = map[string]string{}
+        }
+        applied.Labels[name] = value
+    }
+    for name, value := range stack.Annotations {
+        if applied.Annotations == nil {
+            applied.Annotations = map[string]string{}
+        }
+        applied.Annotations[name] = value
+    }
+    if !reflect.DeepEqual(applied, current) {
+        err = a.rc.Update(context.TODO(), applied)
+        if err != nil {
+            return err
+        }
+    }
+    applied.DeepCopyInto(stack)
+    return nil
+}
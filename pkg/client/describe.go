@@ -0,0 +1,314 @@
+package client
+
+import (
+    "context"
+    "fmt"
+    "sort"
+    "strconv"
+    "time"
+
+    tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+    "github.com/pablochacin/tf-operator/pkg/jobs"
+    "github.com/pablochacin/tf-operator/pkg/terraform"
+    batchv1 "k8s.io/api/batch/v1"
+    corev1 "k8s.io/api/core/v1"
+    apierr "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/fields"
+    ctlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// RunResult is the result of a stack's run
+type RunResult string
+
+const (
+    // The run's Job is still running
+    RunResultRunning RunResult = "Running"
+
+    // The run's Job completed
+    RunResultSucceeded RunResult = "Succeeded"
+
+    // The run's Job failed
+    RunResultFailed RunResult = "Failed"
+)
+
+// DescribeOptions defines the options for describing a stack
+type DescribeOptions struct {
+    // Maximum number of runs to describe, from the last one. 0 means all
+    Runs int
+}
+
+// StackDescription gathers the information about a stack and the objects
+// related to it
+type StackDescription struct {
+    Stack *tfo.Stack
+
+    // ConfigMap with the stack's configuration. Nil for remote sources
+    TfConfig *ReferencedObject
+
+    // Secret with the stack's tfvars
+    TfVars *ReferencedObject
+
+    // Runs of the stack that still have a Job, from the last one
+    Runs []Run
+
+    // Events of the stack and its Jobs, oldest first
+    Events []corev1.Event
+
+    // Resources in the stack's terraform state
+    Resources []terraform.StateResource
+
+    // Information that could not be obtained, such as the resources if the
+    // state can not be read
+    Warnings []string
+}
+
+// ReferencedObject describes a ConfigMap or Secret referenced by the stack
+type ReferencedObject struct {
+    Kind string
+    Name string
+
+    // Indicates if the object exists
+    Found bool
+
+    // Files in a ConfigMap with a configuration or keys of a Secret
+    Items []string
+}
+
+// Run describes the execution of a Job applying or destroying the stack
+type Run struct {
+    // Sequence number of the run. 0 for Jobs created before runs were numbered
+    Number int64
+
+    // Command executed, such as apply or destroy
+    Command string
+
+    Job            string
+    StartTime      *metav1.Time
+    CompletionTime *metav1.Time
+    Result         RunResult
+
+    // Reason of a failure
+    Message string
+}
+
+// DescribeStack returns the description of a stack. Failures getting the
+// information related to the stack are reported as warnings.
+func (c *client)DescribeStack(name string, namespace string, opts DescribeOptions) (*StackDescription, error) {
+    stack, err := c.GetStack(name, namespace)
+    if err != nil {
+        return nil, err
+    }
+
+    desc := &StackDescription{Stack: stack}
+
+    if stack.Spec.Source == nil {
+        desc.TfConfig, err = c.referencedConfigMap(stack.Spec.TfConfig.Name, namespace)
+        if err != nil {
+            desc.Warnings = append(desc.Warnings, fmt.Sprintf("config map not available: %s", err))
+        }
+    }
+
+    if stack.Spec.TfVars.Name != "" {
+        desc.TfVars, err = c.referencedSecret(stack.Spec.TfVars.Name, namespace)
+        if err != nil {
+            desc.Warnings = append(desc.Warnings, fmt.Sprintf("tfvars not available: %s", err))
+        }
+    }
+
+    desc.Runs, err = c.stackRuns(stack, opts.Runs)
+    if err != nil {
+        desc.Warnings = append(desc.Warnings, fmt.Sprintf("runs not available: %s", err))
+    }
+
+    desc.Events, err = c.stackEvents(stack, desc.Runs)
+    if err != nil {
+        desc.Warnings = append(desc.Warnings, fmt.Sprintf("events not available: %s", err))
+    }
+
+    if stack.Status.TfState.Name != "" {
+        desc.Resources, err = c.stateResources(stack.Status.TfState.Name, namespace)
+        if err != nil {
+            desc.Warnings = append(desc.Warnings, fmt.Sprintf("state not available: %s", err))
+        }
+    }
+
+    return desc, nil
+}
+
+// referencedConfigMap describes a ConfigMap with a configuration
+func (c *client)referencedConfigMap(name string, namespace string) (*ReferencedObject, error) {
+    ref := &ReferencedObject{Kind: "ConfigMap", Name: name}
+    configMap := &corev1.ConfigMap{}
+    err := c.rc.Get(context.TODO(), ctlclient.ObjectKey{Name: name, Namespace: namespace}, configMap)
+    if apierr.IsNotFound(err) {
+        return ref, nil
+    }
+    if err != nil {
+        return ref, err
+    }
+
+    ref.Found = true
+    files, err := configFiles(configMap)
+    if err != nil {
+        return ref, err
+    }
+    ref.Items = sortedKeys(files)
+    return ref, nil
+}
+
+// referencedSecret describes a Secret, without its content
+func (c *client)referencedSecret(name string, namespace string) (*ReferencedObject, error) {
+    ref := &ReferencedObject{Kind: "Secret", Name: name}
+    secret := &corev1.Secret{}
+    err := c.rc.Get(context.TODO(), ctlclient.ObjectKey{Name: name, Namespace: namespace}, secret)
+    if apierr.IsNotFound(err) {
+        return ref, nil
+    }
+    if err != nil {
+        return ref, err
+    }
+
+    ref.Found = true
+    for key := range secret.Data {
+        ref.Items = append(ref.Items, key)
+    }
+    sort.Strings(ref.Items)
+    return ref, nil
+}
+
+// stackRuns returns the runs of a stack from the Jobs that still exist, from
+// the last one, up to limit runs if not 0
+func (c *client)stackRuns(stack *tfo.Stack, limit int) ([]Run, error) {
+    jobList := &batchv1.JobList{}
+    err := c.rc.List(
+        context.TODO(),
+        jobList,
+        ctlclient.InNamespace(stack.Namespace),
+        ctlclient.MatchingLabels{tfo.StackLabel: stack.Name},
+    )
+    if err != nil {
+        return nil, err
+    }
+
+    runs := []Run{}
+    for i := range jobList.Items {
+        runs = append(runs, jobRun(&jobList.Items[i]))
+    }
+
+    sort.SliceStable(runs, func(i, j int) bool {
+        if runs[i].Number != runs[j].Number {
+            return runs[i].Number > runs[j].Number
+        }
+        return runs[i].Job > runs[j].Job
+    })
+    if limit > 0 && len(runs) > limit {
+        runs = runs[:limit]
+    }
+
+    return runs, nil
+}
+
+// jobRun describes the run executed by a Job
+func jobRun(job *batchv1.Job) Run {
+    run := Run{
+        Job:            job.Name,
+        StartTime:      job.Status.StartTime,
+        CompletionTime: job.Status.CompletionTime,
+        Result:         RunResultRunning,
+    }
+    run.Number, _ = strconv.ParseInt(job.Labels[tfo.RunLabel], 10, 64)
+    run.Command = jobs.Command(job)
+
+    for _, cond := range job.Status.Conditions {
+        if cond.Status != corev1.ConditionTrue {
+            continue
+        }
+        switch cond.Type {
+        case batchv1.JobComplete:
+            run.Result = RunResultSucceeded
+        case batchv1.JobFailed:
+            run.Result = RunResultFailed
+            run.Message = cond.Message
+            if run.CompletionTime == nil {
+                failed := cond.LastTransitionTime
+                run.CompletionTime = &failed
+            }
+        }
+    }
+
+    return run
+}
+
+// stackEvents returns the events of a stack and the Jobs of its runs, oldest
+// first
+func (c *client)stackEvents(stack *tfo.Stack, runs []Run) ([]corev1.Event, error) {
+    involved := map[string]string{stack.Name: "Stack"}
+    for _, run := range runs {
+        involved[run.Job] = "Job"
+    }
+
+    events := []corev1.Event{}
+    for name, kind := range involved {
+        eventList := &corev1.EventList{}
+        err := c.rc.List(
+            context.TODO(),
+            eventList,
+            ctlclient.InNamespace(stack.Namespace),
+            ctlclient.MatchingFieldsSelector{Selector: fields.OneTermEqualSelector("involvedObject.name", name)},
+        )
+        if err != nil {
+            return nil, err
+        }
+
+        // the field selector is not supported by all clients
+        for _, event := range eventList.Items {
+            if event.InvolvedObject.Name == name && event.InvolvedObject.Kind == kind {
+                events = append(events, event)
+            }
+        }
+    }
+
+    sort.SliceStable(events, func(i, j int) bool {
+        return eventTime(&events[i]).Before(eventTime(&events[j]))
+    })
+
+    return events, nil
+}
+
+// eventTime returns the last time an event happened
+func eventTime(event *corev1.Event) time.Time {
+    if !event.LastTimestamp.IsZero() {
+        return event.LastTimestamp.Time
+    }
+    if !event.EventTime.IsZero() {
+        return event.EventTime.Time
+    }
+    return event.FirstTimestamp.Time
+}
+
+// stateResources returns the resources in the state kept in a Secret
+func (c *client)stateResources(name string, namespace string) ([]terraform.StateResource, error) {
+    secret := &corev1.Secret{}
+    err := c.rc.Get(context.TODO(), ctlclient.ObjectKey{Name: name, Namespace: namespace}, secret)
+    if err != nil {
+        return nil, err
+    }
+
+    content, found := secret.Data[terraform.StateKey]
+    if !found {
+        return nil, fmt.Errorf("secret %s has no %s", name, terraform.StateKey)
+    }
+
+    return terraform.ParseStateResources(content)
+}
+
+// sortedKeys returns the keys of a map in alphabetical order
+func sortedKeys(m map[string]string) []string {
+    keys := []string{}
+    for key := range m {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    return keys
+}
@@ -0,0 +1,123 @@
+package client
+
+import (
+    "sort"
+
+    "github.com/pablochacin/tf-operator/pkg/terraform"
+    "github.com/pablochacin/tf-operator/pkg/tfconfig"
+    corev1 "k8s.io/api/core/v1"
+)
+
+// Changes lists the names of the items added, modified and removed
+type Changes struct {
+    Added    []string
+    Modified []string
+    Removed  []string
+}
+
+// Empty indicates if there are no changes
+func (c Changes) Empty() bool {
+    return len(c.Added) == 0 && len(c.Modified) == 0 && len(c.Removed) == 0
+}
+
+// StackDiff describes the differences between the configuration and tfvars of
+// a stack and the ones in local files
+type StackDiff struct {
+    // Files of the configuration, by their path relative to the configuration
+    // directory
+    Files Changes
+
+    // Variables in the tfvars. Only their names are reported, as their values
+    // may be sensitive
+    Vars Changes
+}
+
+// Empty indicates if there are no differences
+func (d *StackDiff) Empty() bool {
+    return d.Files.Empty() && d.Vars.Empty()
+}
+
+// diffConfigMaps returns the changes in the files of the configurations
+// stored in two ConfigMaps
+func diffConfigMaps(current *corev1.ConfigMap, updated *corev1.ConfigMap) (Changes, error) {
+    currentFiles, err := configFiles(current)
+    if err != nil {
+        return Changes{}, err
+    }
+    updatedFiles, err := configFiles(updated)
+    if err != nil {
+        return Changes{}, err
+    }
+
+    return diffMaps(currentFiles, updatedFiles), nil
+}
+
+// configFiles returns the content of the files of the configuration stored in
+// a ConfigMap
+func configFiles(configMap *corev1.ConfigMap) (map[string]string, error) {
+    config := &tfconfig.Config{Data: configMap.Data, BinaryData: configMap.BinaryData}
+    files, err := config.Files()
+    if err != nil {
+        return nil, err
+    }
+
+    contents := map[string]string{}
+    for path, content := range files {
+        contents[path] = string(content)
+    }
+    return contents, nil
+}
+
+// diffSecrets returns the changes in the variables of the tfvars stored in
+// two Secrets
+func diffSecrets(current *corev1.Secret, updated *corev1.Secret) (Changes, error) {
+    currentVars, err := secretVars(current)
+    if err != nil {
+        return Changes{}, err
+    }
+    updatedVars, err := secretVars(updated)
+    if err != nil {
+        return Changes{}, err
+    }
+
+    return diffMaps(currentVars, updatedVars), nil
+}
+
+// secretVars returns the variables in the tfvars files stored in a Secret
+func secretVars(secret *corev1.Secret) (map[string]string, error) {
+    vars := map[string]string{}
+    for fileName, content := range secret.Data {
+        fileVars, err := terraform.ParseVars(fileName, content)
+        if err != nil {
+            return nil, err
+        }
+        for name, value := range fileVars {
+            vars[name] = value
+        }
+    }
+    return vars, nil
+}
+
+// diffMaps returns the keys added, modified and removed from a map, sorted
+func diffMaps(current map[string]string, updated map[string]string) Changes {
+    changes := Changes{}
+    for key, value := range updated {
+        currentValue, found := current[key]
+        switch {
+        case !found:
+            changes.Added = append(changes.Added, key)
+        case currentValue != value:
+            changes.Modified = append(changes.Modified, key)
+        }
+    }
+    for key := range current {
+        if _, found := updated[key]; !found {
+            changes.Removed = append(changes.Removed, key)
+        }
+    }
+
+    sort.Strings(changes.Added)
+    sort.Strings(changes.Modified)
+    sort.Strings(changes.Removed)
+    return changes
+}
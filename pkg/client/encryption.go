@@ -0,0 +1,87 @@
+package client
+
+import (
+    "context"
+    "fmt"
+
+    tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+    "github.com/pablochacin/tf-operator/pkg/encryption"
+    "github.com/pablochacin/tf-operator/pkg/statestore"
+    corev1 "k8s.io/api/core/v1"
+    apierr "k8s.io/apimachinery/pkg/api/errors"
+    ctlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// KeyRotation reports the Secrets of a stack encrypted again with its
+// active encryption key
+type KeyRotation struct {
+    // Secrets with the state and its versions
+    States []string
+
+    // Secret with the sensitive outputs. Empty if the stack has none
+    SensitiveOutputs string
+}
+
+// RotateEncryptionKey encrypts the state of a stack, its versions and its
+// sensitive outputs again with the active key in the stack's encryption keys,
+// holding the state lock. Once rotated, the previous keys can be removed.
+func (c *client)RotateEncryptionKey(name string, namespace string) (result *KeyRotation, err error) {
+    err = c.withStateLock(name, namespace, "rotate-key", func(stack *tfo.Stack) error {
+        if stack.Spec.Encryption == nil {
+            errDesc := fmt.Sprintf("stack %s has no encryption keys", name)
+            return NewTFOError(errDesc, ErrorReasonInvalidArgument)
+        }
+
+        states, err := statestore.New(c.rc, stack).Reencrypt(context.TODO())
+        if err != nil {
+            return stateStoreError(name, err)
+        }
+        result = &KeyRotation{States: states}
+
+        if stack.Status.SensitiveOutputs == "" {
+            return nil
+        }
+        err = c.reencryptSensitiveOutputs(stack)
+        if err != nil {
+            return err
+        }
+        result.SensitiveOutputs = stack.Status.SensitiveOutputs
+        return nil
+    })
+    return result, err
+}
+
+// reencryptSensitiveOutputs encrypts the values of the stack's sensitive
+// outputs again with the active encryption key
+func (c *client)reencryptSensitiveOutputs(stack *tfo.Stack) error {
+    secret := &corev1.Secret{}
+    err := c.rc.Get(
+        context.TODO(),
+        ctlclient.ObjectKey{Name: stack.Status.SensitiveOutputs, Namespace: stack.Namespace},
+        secret,
+    )
+    if apierr.IsNotFound(err) {
+        return nil
+    }
+    if err != nil {
+        errDesc := fmt.Sprintf("runtime error getting sensitive outputs: %s", err)
+        return NewTFOError(errDesc, ErrorReasonRuntimeError)
+    }
+
+    enc, err := encryption.ForStack(context.TODO(), c.rc, stack)
+    if err != nil {
+        return NewTFOError(err.Error(), ErrorReasonRuntimeError)
+    }
+    secret.Data, err = enc.ReencryptValues(context.TODO(), secret.Data)
+    if err != nil {
+        errDesc := fmt.Sprintf("error encrypting sensitive outputs of stack %s: %s", stack.Name, err)
+        return NewTFOError(errDesc, ErrorReasonRuntimeError)
+    }
+
+    err = c.rc.Update(context.TODO(), secret)
+    if err != nil {
+        errDesc := fmt.Sprintf("runtime error storing sensitive outputs: %s", err)
+        return NewTFOError(errDesc, ErrorReasonRuntimeError)
+    }
+    return nil
+}
@@ -20,6 +20,24 @@ const (
     // The File has an invalid content (it is empty, invalid content type)
     ErrorReasonInvalidFileContent ErrorReason = "File content is not valid"
 
+    // The configuration doesn't fit in a ConfigMap
+    ErrorReasonConfigTooLarge ErrorReason = "Configuration too large"
+
+    // The object was modified concurrently by another operation
+    ErrorReasonConflict ErrorReason = "Conflict"
+
+    // An argument of the operation is not valid (for example, a selector)
+    ErrorReasonInvalidArgument ErrorReason = "Invalid argument"
+
+    // The user is not allowed to access an object
+    ErrorReasonForbidden ErrorReason = "Forbidden"
+
+    // The operation did not complete in the given time
+    ErrorReasonTimeout ErrorReason = "Timeout"
+
+    // The stack failed to reach the expected state
+    ErrorReasonStackFailed ErrorReason = "Stack failed"
+
     // Error accessing the kubernetes runtime
     ErrorReasonRuntimeError ErrorReason = "Runtime error"
 
@@ -0,0 +1,195 @@
+package client
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "strconv"
+    "time"
+
+    tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+    "github.com/pablochacin/tf-operator/pkg/jobs"
+    batchv1 "k8s.io/api/batch/v1"
+    corev1 "k8s.io/api/core/v1"
+    apierr "k8s.io/apimachinery/pkg/api/errors"
+    ctlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+    // interval between checks of a pending pod before following its log
+    podPollInterval = time.Second
+)
+
+// LogOptions defines the options for getting the log of a stack's run
+type LogOptions struct {
+    // Follow the log of a running Job
+    Follow bool
+
+    // Number of the run. If 0, the last run
+    Run int64
+
+    // Select the run before the last one
+    Previous bool
+}
+
+// StackLogs writes the log of one of the stack's runs. The log is read from
+// the pod of the run's Job if it still exists, or from the log archived when
+// the Job finished.
+func (c *client)StackLogs(name string, namespace string, opts LogOptions, out io.Writer) error {
+    stack, err := c.GetStack(name, namespace)
+    if err != nil {
+        return err
+    }
+
+    run, err := selectRun(stack, opts)
+    if err != nil {
+        return err
+    }
+
+    pod, err := c.runPod(stack, run)
+    if err != nil {
+        return err
+    }
+    var podErr error
+    if pod != nil && c.logs != nil {
+        streamed, err := c.streamPodLog(pod, opts.Follow, out)
+        if streamed {
+            return err
+        }
+        podErr = err
+    }
+
+    err = c.archivedLog(stack, run, out)
+    if Is(err, ErrorReasonNotFound) && podErr != nil {
+        return podErr
+    }
+    return err
+}
+
+// selectRun returns the number of the run selected by the log options
+func selectRun(stack *tfo.Stack, opts LogOptions) (int64, error) {
+    if stack.Status.LastRun == nil {
+        errDesc := fmt.Sprintf("stack %s has not run yet", stack.Name)
+        return 0, NewTFOError(errDesc, ErrorReasonNotFound)
+    }
+    last := stack.Status.LastRun.Number
+
+    run := last
+    switch {
+    case opts.Run > 0:
+        run = opts.Run
+    case opts.Previous:
+        run = last-1
+    }
+    if run < 1 || run > last {
+        errDesc := fmt.Sprintf("stack %s has no run %d, last run is %d", stack.Name, run, last)
+        return 0, NewTFOError(errDesc, ErrorReasonNotFound)
+    }
+
+    return run, nil
+}
+
+// runPod returns the pod running the last attempt of the Job of a run, or nil
+// if the Job or its pods no longer exist
+func (c *client)runPod(stack *tfo.Stack, run int64) (*corev1.Pod, error) {
+    jobList := &batchv1.JobList{}
+    err := c.rc.List(
+        context.TODO(),
+        jobList,
+        ctlclient.InNamespace(stack.Namespace),
+        ctlclient.MatchingLabels{
+            tfo.StackLabel: stack.Name,
+            tfo.RunLabel:   strconv.FormatInt(run, 10),
+        },
+    )
+    if err != nil {
+        errDesc := fmt.Sprintf("runtime error listing jobs: %s", err)
+        return nil, NewTFOError(errDesc, ErrorReasonRuntimeError)
+    }
+
+    job := ""
+    if len(jobList.Items) > 0 {
+        job = jobList.Items[0].Name
+    } else if run == stack.Status.LastRun.Number {
+        // Jobs created before runs were numbered are not labeled
+        job = stack.Status.Job
+    }
+    if job == "" {
+        return nil, nil
+    }
+
+    pods := &corev1.PodList{}
+    err = c.rc.List(
+        context.TODO(),
+        pods,
+        ctlclient.InNamespace(stack.Namespace),
+        ctlclient.MatchingLabels(jobs.PodSelector(job)),
+    )
+    if err != nil {
+        errDesc := fmt.Sprintf("runtime error listing pods: %s", err)
+        return nil, NewTFOError(errDesc, ErrorReasonRuntimeError)
+    }
+
+    return jobs.LatestPod(pods.Items), nil
+}
+
+// streamPodLog writes the log of a pod, following it if requested. If
+// following, waits for the pod to start. Indicates if the log was available,
+// returning the reason if it was not.
+func (c *client)streamPodLog(pod *corev1.Pod, follow bool, out io.Writer) (bool, error) {
+    for follow && pod.Status.Phase == corev1.PodPending {
+        time.Sleep(podPollInterval)
+        err := c.rc.Get(context.TODO(), ctlclient.ObjectKey{Name: pod.Name, Namespace: pod.Namespace}, pod)
+        if apierr.IsNotFound(err) {
+            return false, nil
+        }
+        if err != nil {
+            errDesc := fmt.Sprintf("runtime error getting pod: %s", err)
+            return false, NewTFOError(errDesc, ErrorReasonRuntimeError)
+        }
+    }
+
+    stream, err := c.logs.Stream(
+        pod.Namespace,
+        pod.Name,
+        &corev1.PodLogOptions{Container: jobs.RunContainer, Follow: follow},
+    )
+    if err != nil {
+        errDesc := fmt.Sprintf("error getting log of pod %s: %s", pod.Name, err)
+        return false, NewTFOError(errDesc, ErrorReasonRuntimeError)
+    }
+    defer stream.Close()
+
+    _, err = io.Copy(out, stream)
+    if err != nil {
+        errDesc := fmt.Sprintf("error reading log of pod %s: %s", pod.Name, err)
+        return true, NewTFOError(errDesc, ErrorReasonRuntimeError)
+    }
+
+    return true, nil
+}
+
+// archivedLog writes the log of a run archived when its Job finished
+func (c *client)archivedLog(stack *tfo.Stack, run int64, out io.Writer) error {
+    archives := &corev1.ConfigMapList{}
+    err := c.rc.List(
+        context.TODO(),
+        archives,
+        ctlclient.InNamespace(stack.Namespace),
+        ctlclient.MatchingLabels{
+            tfo.StackLabel: stack.Name,
+            tfo.RunLabel:   strconv.FormatInt(run, 10),
+        },
+    )
+    if err != nil {
+        errDesc := fmt.Sprintf("runtime error listing archived logs: %s", err)
+        return NewTFOError(errDesc, ErrorReasonRuntimeError)
+    }
+    if len(archives.Items) == 0 {
+        errDesc := fmt.Sprintf("log of run %d of stack %s not found", run, stack.Name)
+        return NewTFOError(errDesc, ErrorReasonNotFound)
+    }
+
+    _, err = io.WriteString(out, archives.Items[0].Data[jobs.LogArchiveKey])
+    return err
+}
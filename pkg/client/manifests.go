@@ -0,0 +1,144 @@
+package client
+
+import (
+    "encoding/json"
+
+    tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+    corev1 "k8s.io/api/core/v1"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RenderOptions defines the options for rendering the manifests of a stack
+type RenderOptions struct {
+    // PEM encoded certificate or public key of a sealed secrets controller.
+    // If set, the tfvars are rendered as a SealedSecret
+    SealingKey []byte
+}
+
+// StackManifests are the objects that define a stack created from local
+// files, for applying them with other tools
+type StackManifests struct {
+    Stack *tfo.Stack
+
+    // ConfigMap with the stack's configuration
+    TfConfig *corev1.ConfigMap
+
+    // Secret with the stack's tfvars. Nil if they are sealed
+    TfVars *corev1.Secret
+
+    // Sealed Secret with the stack's tfvars, if they are sealed
+    SealedTfVars *SealedSecret
+}
+
+// RenderStack returns the manifests of a stack created from local tf files,
+// as CreateStack would create them, without accessing the cluster
+func RenderStack(name string, namespace string, tfconf string, tfvars string, opts RenderOptions) (*StackManifests, error) {
+    tfconfMap, tfvarsSecret, err := stackRevision(name, namespace, tfconf, tfvars)
+    if err != nil {
+        return nil, err
+    }
+
+    manifests := &StackManifests{
+        Stack:    newStackFor(name, namespace, tfconfMap, tfvarsSecret),
+        TfConfig: tfconfMap,
+        TfVars:   tfvarsSecret,
+    }
+    manifests.Stack.TypeMeta = metav1.TypeMeta{APIVersion: tfo.GroupVersion.String(), Kind: "Stack"}
+    tfconfMap.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"}
+    tfvarsSecret.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"}
+
+    if opts.SealingKey != nil {
+        key, err := ParseSealingKey(opts.SealingKey)
+        if err != nil {
+            return nil, err
+        }
+        manifests.SealedTfVars, err = SealSecret(tfvarsSecret, key)
+        if err != nil {
+            return nil, err
+        }
+        manifests.TfVars = nil
+    }
+
+    return manifests, nil
+}
+
+// Objects returns the manifests as generic objects, ready to be serialized,
+// in the order they must be applied: the ConfigMap, the Secret (or Sealed
+// Secret) and the Stack. The ConfigMap and Secret are immutable, as the
+// ones created by CreateStack, and fields without value are omitted.
+func (m *StackManifests) Objects() ([]map[string]interface{}, error) {
+    objs := []interface{}{m.TfConfig}
+    if m.SealedTfVars != nil {
+        objs = append(objs, m.SealedTfVars)
+    } else {
+        objs = append(objs, m.TfVars)
+    }
+    objs = append(objs, m.Stack)
+
+    generic := []map[string]interface{}{}
+    for _, obj := range objs {
+        data, err := json.Marshal(obj)
+        if err != nil {
+            return nil, err
+        }
+        fields := map[string]interface{}{}
+        err = json.Unmarshal(data, &fields)
+        if err != nil {
+            return nil, err
+        }
+
+        delete(fields, "status")
+        removeCreationTimestamp(fields)
+        if spec, ok := fields["spec"].(map[string]interface{}); ok {
+            if template, ok := spec["template"].(map[string]interface{}); ok {
+                removeCreationTimestamp(template)
+            }
+        }
+        switch fields["kind"] {
+        case "ConfigMap", "Secret":
+            fields["immutable"] = true
+        }
+
+        generic = append(generic, fields)
+    }
+
+    return generic, nil
+}
+
+// removeCreationTimestamp removes the empty creation timestamp from an
+// object's metadata
+func removeCreationTimestamp(fields map[string]interface{}) {
+    if metadata, ok := fields["metadata"].(map[string]interface{}); ok {
+        if metadata["creationTimestamp"] == nil {
+            delete(metadata, "creationTimestamp")
+        }
+    }
+}
+
+// stackRevision returns the ConfigMap and Secret for a revision of a stack's
+// configuration and tfvars from local files
+func stackRevision(name string, namespace string, tfconf string, tfvars string) (*corev1.ConfigMap, *corev1.Secret, error) {
+    tfconfMap, err := createConfigMap(name, namespace, tfconf, excludeVars(tfconf, tfvars)...)
+    if err != nil {
+        return nil, nil, err
+    }
+    tfvarsSecret, err := createSecret(name, namespace, tfvars)
+    if err != nil {
+        return nil, nil, err
+    }
+    return tfconfMap, tfvarsSecret, nil
+}
+
+// newStackFor returns a stack referencing a configuration and tfvars
+func newStackFor(name string, namespace string, tfconfMap *corev1.ConfigMap, tfvarsSecret *corev1.Secret) *tfo.Stack {
+    return &tfo.Stack{
+        ObjectMeta: metav1.ObjectMeta{
+            Name: name,
+            Namespace: namespace,
+        },
+        Spec: tfo.StackSpec{
+            TfConfig: corev1.LocalObjectReference{Name: tfconfMap.Name},
+            TfVars:   corev1.LocalObjectReference{Name: tfvarsSecret.Name},
+        },
+    }
+}
@@ -0,0 +1,142 @@
+package client
+
+import (
+    "context"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+
+    tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+    "github.com/pablochacin/tf-operator/pkg/encryption"
+    "github.com/pablochacin/tf-operator/pkg/jobs"
+    "github.com/pablochacin/tf-operator/pkg/terraform"
+    corev1 "k8s.io/api/core/v1"
+    apierr "k8s.io/apimachinery/pkg/api/errors"
+    ctlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// GetOutputs returns the outputs of a stack. The values of sensitive outputs
+// are taken from the Secret with the stack's sensitive outputs, if
+// showSensitive is set, which requires permission to read it and the stack's
+// encryption keys, if they are encrypted. Otherwise, they are redacted.
+func (c *client)GetOutputs(stackName string, namespace string, showSensitive bool) (map[string]terraform.Output, error) {
+    stack, err := c.GetStack(stackName, namespace)
+    if err != nil {
+        return nil, err
+    }
+
+    outputs, err := terraform.DecodeOutputs(stack.Status.TfOutput)
+    if err != nil {
+        errDesc := fmt.Sprintf("stack %s has invalid outputs: %s", stackName, err)
+        return nil, NewTFOError(errDesc, ErrorReasonRuntimeError)
+    }
+
+    if !showSensitive {
+        terraform.SplitSensitive(outputs)
+        return outputs, nil
+    }
+
+    // stacks without the Secret keep the sensitive values in their outputs
+    if stack.Status.SensitiveOutputs == "" {
+        return outputs, nil
+    }
+
+    secret := &corev1.Secret{}
+    err = c.rc.Get(
+        context.TODO(),
+        ctlclient.ObjectKey{Name: stack.Status.SensitiveOutputs, Namespace: namespace},
+        secret,
+    )
+    if apierr.IsForbidden(err) {
+        errDesc := fmt.Sprintf("not allowed to read the sensitive outputs of stack %s: %s", stackName, err)
+        return nil, NewTFOError(errDesc, ErrorReasonForbidden)
+    }
+    if apierr.IsNotFound(err) {
+        return nil, NewNotFoundError(stack.Status.SensitiveOutputs, "Secret", namespace)
+    }
+    if err != nil {
+        errDesc := fmt.Sprintf("runtime error getting sensitive outputs: %s", err)
+        return nil, NewTFOError(errDesc, ErrorReasonRuntimeError)
+    }
+
+    enc, err := encryption.ForStack(context.TODO(), c.rc, stack)
+    if err != nil {
+        return nil, NewTFOError(err.Error(), ErrorReasonRuntimeError)
+    }
+    values, err := enc.DecryptValues(context.TODO(), secret.Data)
+    if err != nil {
+        errDesc := fmt.Sprintf("error decrypting sensitive outputs of stack %s: %s", stackName, err)
+        return nil, NewTFOError(errDesc, ErrorReasonRuntimeError)
+    }
+
+    terraform.RestoreSensitive(outputs, values)
+    return outputs, nil
+}
+
+// RecordOutputs records the outputs of a stack's run in its status, while the
+// run is the stack's last one. The values of sensitive outputs are redacted in
+// the status and stored in the Secret the controller creates for them,
+// encrypted before storing them if the stack has encryption keys.
+func (c *client)RecordOutputs(name string, namespace string, run int64, outputs map[string]terraform.Output) error {
+    stack, err := c.GetStack(name, namespace)
+    if err != nil {
+        return err
+    }
+    err = checkLastRun(stack, run)
+    if err != nil {
+        return err
+    }
+
+    values := terraform.SplitSensitive(outputs)
+    err = c.storeSensitiveOutputs(stack, values)
+    if err != nil {
+        return err
+    }
+    sensitive := ""
+    if len(values) > 0 {
+        sensitive = jobs.SensitiveOutputsName(name)
+    }
+
+    tfout, err := json.Marshal(outputs)
+    if err != nil {
+        return NewTFOError(err.Error(), ErrorReasonInvalidArgument)
+    }
+    return c.updateRunStatus(name, namespace, run, func(stack *tfo.Stack) {
+        stack.Status.TfOutput = base64.StdEncoding.EncodeToString(tfout)
+        stack.Status.SensitiveOutputs = sensitive
+    })
+}
+
+// storeSensitiveOutputs updates the Secret with the values of the stack's
+// sensitive outputs, encrypted with the stack's encryption keys. The Secret is
+// emptied once the stack has none.
+func (c *client)storeSensitiveOutputs(stack *tfo.Stack, values map[string][]byte) error {
+    data := map[string][]byte{}
+    if len(values) > 0 {
+        enc, err := encryption.ForStack(context.TODO(), c.rc, stack)
+        if err != nil {
+            return NewTFOError(err.Error(), ErrorReasonRuntimeError)
+        }
+        data, _, err = enc.EncryptValues(context.TODO(), values)
+        if err != nil {
+            errDesc := fmt.Sprintf("error encrypting sensitive outputs of stack %s: %s", stack.Name, err)
+            return NewTFOError(errDesc, ErrorReasonRuntimeError)
+        }
+    }
+
+    name := jobs.SensitiveOutputsName(stack.Name)
+    secret := &corev1.Secret{}
+    err := c.rc.Get(context.TODO(), ctlclient.ObjectKey{Name: name, Namespace: stack.Namespace}, secret)
+    if apierr.IsNotFound(err) {
+        return NewNotFoundError(name, "Secret", stack.Namespace)
+    }
+    if err == nil {
+        secret.Data = data
+        err = c.rc.Update(context.TODO(), secret)
+    }
+    if err != nil {
+        errDesc := fmt.Sprintf("runtime error storing sensitive outputs: %s", err)
+        return NewTFOError(errDesc, ErrorReasonRuntimeError)
+    }
+    return nil
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "strconv"
+    "time"
+
+    tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+    "github.com/pablochacin/tf-operator/pkg/terraform"
+    apierr "k8s.io/apimachinery/pkg/api/errors"
+    "k8s.io/client-go/util/retry"
+)
+
+// RequestRun requests a single run of a stack with the options of the
+// request, which are not applied to the following runs. If the request has no
+// ID, one is generated. Only one request can be pending at a time.
+func (c *client)RequestRun(name string, namespace string, request tfo.RunRequest) (*tfo.RunRequest, error) {
+    opts := terraform.RunOptions{
+        Targets: request.Targets,
+        Replace: request.Replace,
+        RefreshOnly: request.RefreshOnly,
+        Vars: request.Vars,
+    }
+    err := opts.Validate()
+    if err != nil {
+        return nil, NewTFOError(err.Error(), ErrorReasonInvalidArgument)
+    }
+    if request.ID == "" {
+        request.ID = strconv.FormatInt(time.Now().UnixNano(), 36)
+    }
+
+    stack, err := c.GetStack(name, namespace)
+    if err != nil {
+        return nil, err
+    }
+    if pending, found := stack.Annotations[tfo.RunRequestAnnotation]; found {
+        errDesc := fmt.Sprintf("stack %s has a pending run request: %s", name, pending)
+        return nil, NewTFOError(errDesc, ErrorReasonConflict)
+    }
+
+    value, err := json.Marshal(request)
+    if err != nil {
+        return nil, NewTFOError(err.Error(), ErrorReasonInvalidArgument)
+    }
+    if stack.Annotations == nil {
+        stack.Annotations = map[string]string{}
+    }
+    stack.Annotations[tfo.RunRequestAnnotation] = string(value)
+
+    err = c.rc.Update(context.TODO(), stack)
+    if apierr.IsConflict(err) {
+        errDesc := fmt.Sprintf("stack %s was modified while requesting the run, try again", name)
+        return nil, NewTFOError(errDesc, ErrorReasonConflict)
+    }
+    if err != nil {
+        errDesc := fmt.Sprintf("runtime error requesting run: %s", err)
+        return nil, NewTFOError(errDesc, ErrorReasonRuntimeError)
+    }
+
+    return &request, nil
+}
+
+// RecordImports records the results of the imports of a stack's run in its
+// status, while the run is the stack's last one
+func (c *client)RecordImports(name string, namespace string, run int64, results []tfo.ImportResult) error {
+    return c.updateRunStatus(name, namespace, run, func(stack *tfo.Stack) {
+        stack.Status.LastRun.Imports = results
+    })
+}
+
+// RecordChanges records the changes reported by a stack's run in its status,
+// while the run is the stack's last one
+func (c *client)RecordChanges(name string, namespace string, run int64, changes *tfo.ChangeSummary) error {
+    return c.updateRunStatus(name, namespace, run, func(stack *tfo.Stack) {
+        stack.Status.LastRun.Changes = changes
+    })
+}
+
+// updateRunStatus updates the status of a stack reported by one of its runs,
+// retrying if the controller modifies the stack meanwhile. Once the run is no
+// longer the stack's last one, its reports are discarded.
+func (c *client)updateRunStatus(name string, namespace string, run int64, update func(stack *tfo.Stack)) error {
+    return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+        stack, err := c.GetStack(name, namespace)
+        if err != nil {
+            return err
+        }
+        err = checkLastRun(stack, run)
+        if err != nil {
+            return err
+        }
+
+        update(stack)
+        err = c.rc.Status().Update(context.TODO(), stack)
+        if err == nil || apierr.IsConflict(err) {
+            return err
+        }
+        errDesc := fmt.Sprintf("runtime error updating stack status: %s", err)
+        return NewTFOError(errDesc, ErrorReasonRuntimeError)
+    })
+}
+
+// checkLastRun checks a run is the last run of a stack
+func checkLastRun(stack *tfo.Stack, run int64) error {
+    if stack.Status.LastRun == nil || stack.Status.LastRun.Number != run {
+        errDesc := fmt.Sprintf("run %d is not the last run of stack %s", run, stack.Name)
+        return NewTFOError(errDesc, ErrorReasonConflict)
+    }
+    return nil
+}
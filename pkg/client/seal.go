@@ -0,0 +1,161 @@
+package client
+
+import (
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/sha256"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/binary"
+    "encoding/pem"
+    "fmt"
+    "io"
+
+    corev1 "k8s.io/api/core/v1"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+    // api version and kind of the sealed secrets controller's objects
+    sealedSecretAPIVersion = "bitnami.com/v1alpha1"
+    sealedSecretKind       = "SealedSecret"
+
+    // length of the symmetric key encrypting each value
+    sealingSessionKeyLength = 32
+)
+
+// SealedSecret is a Secret encrypted with the public key of a sealed secrets
+// controller, which can be stored in a repository and is decrypted into a
+// Secret only by the controller in the cluster
+type SealedSecret struct {
+    metav1.TypeMeta   `json:",inline"`
+    metav1.ObjectMeta `json:"metadata"`
+
+    Spec SealedSecretSpec `json:"spec"`
+}
+
+// SealedSecretSpec defines the encrypted data and the Secret created from it
+type SealedSecretSpec struct {
+    // Template for the Secret's metadata and type
+    Template SecretTemplate `json:"template"`
+
+    // Values of the Secret's keys, encrypted and base64 encoded
+    EncryptedData map[string]string `json:"encryptedData"`
+}
+
+// SecretTemplate defines the metadata of the Secret created from a SealedSecret
+type SecretTemplate struct {
+    metav1.ObjectMeta `json:"metadata"`
+
+    Type corev1.SecretType `json:"type,omitempty"`
+}
+
+// ParseSealingKey parses the public key of a sealed secrets controller, given
+// as a PEM encoded certificate (as obtained with kubeseal --fetch-cert) or
+// RSA public key
+func ParseSealingKey(data []byte) (*rsa.PublicKey, error) {
+    block, _ := pem.Decode(data)
+    if block == nil {
+        return nil, NewTFOError("sealing key is not PEM encoded", ErrorReasonInvalidArgument)
+    }
+
+    var key interface{}
+    var err error
+    switch block.Type {
+    case "CERTIFICATE":
+        var cert *x509.Certificate
+        cert, err = x509.ParseCertificate(block.Bytes)
+        if err == nil {
+            key = cert.PublicKey
+        }
+    case "PUBLIC KEY":
+        key, err = x509.ParsePKIXPublicKey(block.Bytes)
+    case "RSA PUBLIC KEY":
+        key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+    default:
+        err = fmt.Errorf("unsupported PEM block %s", block.Type)
+    }
+    if err != nil {
+        errDesc := fmt.Sprintf("invalid sealing key: %v", err)
+        return nil, NewTFOError(errDesc, ErrorReasonInvalidArgument)
+    }
+
+    rsaKey, ok := key.(*rsa.PublicKey)
+    if !ok {
+        return nil, NewTFOError("sealing key is not a RSA key", ErrorReasonInvalidArgument)
+    }
+    return rsaKey, nil
+}
+
+// SealSecret encrypts the data of a Secret with the public key of a sealed
+// secrets controller. The values are encrypted for the Secret's name and
+// namespace (the controller's strict scope), so they can't be decrypted
+// into another Secret.
+func SealSecret(secret *corev1.Secret, key *rsa.PublicKey) (*SealedSecret, error) {
+    sealed := &SealedSecret{
+        TypeMeta: metav1.TypeMeta{APIVersion: sealedSecretAPIVersion, Kind: sealedSecretKind},
+        ObjectMeta: metav1.ObjectMeta{
+            Name:      secret.Name,
+            Namespace: secret.Namespace,
+            Labels:    secret.Labels,
+        },
+        Spec: SealedSecretSpec{
+            Template: SecretTemplate{
+                ObjectMeta: metav1.ObjectMeta{
+                    Name:      secret.Name,
+                    Namespace: secret.Namespace,
+                    Labels:    secret.Labels,
+                },
+                Type: secret.Type,
+            },
+            EncryptedData: map[string]string{},
+        },
+    }
+
+    label := []byte(secret.Namespace + "/" + secret.Name)
+    for name, value := range secret.Data {
+        encrypted, err := hybridEncrypt(rand.Reader, key, value, label)
+        if err != nil {
+            errDesc := fmt.Sprintf("error sealing %s: %v", name, err)
+            return nil, NewTFOError(errDesc, ErrorReasonInvalidArgument)
+        }
+        sealed.Spec.EncryptedData[name] = base64.StdEncoding.EncodeToString(encrypted)
+    }
+
+    return sealed, nil
+}
+
+// hybridEncrypt encrypts a value as the sealed secrets controller expects:
+// a random session key encrypted with RSA-OAEP using the label, prefixed with
+// its length as two bytes, followed by the value encrypted with AES-GCM using
+// the session key. As each session key is used once, the nonce is zero.
+func hybridEncrypt(rnd io.Reader, key *rsa.PublicKey, plaintext []byte, label []byte) ([]byte, error) {
+    sessionKey := make([]byte, sealingSessionKeyLength)
+    _, err := io.ReadFull(rnd, sessionKey)
+    if err != nil {
+        return nil, err
+    }
+
+    block, err := aes.NewCipher(sessionKey)
+    if err != nil {
+        return nil, err
+    }
+    aead, err := cipher.NewGCM(block)
+    if err != nil {
+        return nil, err
+    }
+
+    encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rnd, key, sessionKey, label)
+    if err != nil {
+        return nil, err
+    }
+
+    ciphertext := make([]byte, 2)
+    binary.BigEndian.PutUint16(ciphertext, uint16(len(encryptedKey)))
+    ciphertext = append(ciphertext, encryptedKey...)
+
+    nonce := make([]byte, aead.NonceSize())
+    return aead.Seal(ciphertext, nonce, plaintext, nil), nil
+}
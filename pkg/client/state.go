@@ -0,0 +1,350 @@
+package client
+
+import (
+    "bytes"
+    "context"
+    "fmt"
+    "os"
+    "os/user"
+    "strings"
+
+    tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+    "github.com/pablochacin/tf-operator/pkg/statelock"
+    "github.com/pablochacin/tf-operator/pkg/statestore"
+    "github.com/pablochacin/tf-operator/pkg/terraform"
+    apierr "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// StateUpdate reports a change of a stack's state
+type StateUpdate struct {
+    // Secret with the stored state. Empty if the state is kept in the
+    // backend configured in the stack
+    Secret string
+
+    // Type of the backend configured in the stack keeping the state
+    Backend string
+
+    // Number of the version recording the stored state in the stack's
+    // state history. 0 if the history is kept by the stack's backend
+    Version int64
+
+    // Serial of the stored state
+    Serial int64
+}
+
+// PullState returns the stored state of a stack, decrypted if it is
+// encrypted, or the one kept in the backend configured in the stack
+func (c *client)PullState(name string, namespace string) ([]byte, error) {
+    stack, err := c.GetStack(name, namespace)
+    if err != nil {
+        return nil, err
+    }
+    if stack.Spec.Backend != nil {
+        return c.pullBackendState(stack)
+    }
+    if stack.Status.TfState.Name == "" {
+        return nil, NewNotFoundError(name+" state", "Stack", namespace)
+    }
+
+    content, err := statestore.New(c.rc, stack).Read(context.TODO())
+    if err != nil {
+        return nil, stateStoreError(name, err)
+    }
+    if content == nil {
+        return nil, NewNotFoundError(stack.Status.TfState.Name, "Secret", namespace)
+    }
+    return content, nil
+}
+
+// PushState replaces the stored state of a stack. Unless forced, the state
+// must have the same lineage as the stored one and a higher serial, or the
+// same serial and content.
+func (c *client)PushState(name string, namespace string, content []byte, force bool) (*StateUpdate, error) {
+    pushed, err := terraform.ParseStateMeta(content)
+    if err != nil {
+        return nil, NewTFOError(err.Error(), ErrorReasonInvalidFileContent)
+    }
+
+    return c.updateState(name, namespace, "push", force, func(_ *statestore.Store, current []byte) ([]byte, error) {
+        if current == nil || force {
+            return content, nil
+        }
+
+        stored, err := terraform.ParseStateMeta(current)
+        if err != nil {
+            errDesc := fmt.Sprintf("stored state is invalid, use force for replacing it: %v", err)
+            return nil, NewTFOError(errDesc, ErrorReasonInvalidFileContent)
+        }
+        switch {
+        case pushed.Lineage != stored.Lineage:
+            errDesc := fmt.Sprintf("state lineage %q doesn't match the stored state's lineage %q", pushed.Lineage, stored.Lineage)
+            return nil, NewTFOError(errDesc, ErrorReasonConflict)
+        case pushed.Serial < stored.Serial:
+            errDesc := fmt.Sprintf("state serial %d is older than the stored state's serial %d", pushed.Serial, stored.Serial)
+            return nil, NewTFOError(errDesc, ErrorReasonConflict)
+        case pushed.Serial == stored.Serial && !bytes.Equal(content, current):
+            errDesc := fmt.Sprintf("state differs from the stored state with the same serial %d", stored.Serial)
+            return nil, NewTFOError(errDesc, ErrorReasonConflict)
+        }
+        return content, nil
+    })
+}
+
+// MoveState moves a module, resource or instance to another address in the
+// stored state of a stack
+func (c *client)MoveState(name string, namespace string, src string, dest string) (*StateUpdate, error) {
+    operation := fmt.Sprintf("mv %s %s", src, dest)
+    return c.updateState(name, namespace, operation, false, func(_ *statestore.Store, current []byte) ([]byte, error) {
+        if current == nil {
+            return nil, NewNotFoundError(name+" state", "Stack", namespace)
+        }
+        updated, err := terraform.MoveStateResource(current, src, dest)
+        if err != nil {
+            return nil, NewTFOError(err.Error(), ErrorReasonInvalidArgument)
+        }
+        return updated, nil
+    })
+}
+
+// RemoveState removes modules, resources or instances from the stored state
+// of a stack, so they are no longer managed by it
+func (c *client)RemoveState(name string, namespace string, addresses []string) (*StateUpdate, error) {
+    operation := "rm " + strings.Join(addresses, " ")
+    return c.updateState(name, namespace, operation, false, func(_ *statestore.Store, current []byte) ([]byte, error) {
+        if current == nil {
+            return nil, NewNotFoundError(name+" state", "Stack", namespace)
+        }
+        updated, err := terraform.RemoveStateResources(current, addresses)
+        if err != nil {
+            return nil, NewTFOError(err.Error(), ErrorReasonInvalidArgument)
+        }
+        return updated, nil
+    })
+}
+
+// RollbackState replaces the stored state of a stack with a version in its
+// state history. The restored state gets a serial higher than the stored one,
+// so runs don't reject it as stale.
+func (c *client)RollbackState(name string, namespace string, version int64) (*StateUpdate, error) {
+    stack, err := c.GetStack(name, namespace)
+    if err != nil {
+        return nil, err
+    }
+    if stack.Spec.Backend != nil {
+        return nil, noStateHistory(stack)
+    }
+
+    operation := fmt.Sprintf("rollback to version %d", version)
+    return c.updateState(name, namespace, operation, false, func(store *statestore.Store, current []byte) ([]byte, error) {
+        _, content, err := c.stateVersion(store, name, namespace, version)
+        if err != nil {
+            return nil, err
+        }
+
+        serial := int64(1)
+        if current != nil {
+            meta, err := terraform.ParseStateMeta(current)
+            if err == nil {
+                serial = meta.Serial + 1
+            }
+        }
+        restored, err := terraform.SetStateSerial(content, serial)
+        if err != nil {
+            return nil, NewTFOError(err.Error(), ErrorReasonInvalidFileContent)
+        }
+        return restored, nil
+    })
+}
+
+// ListStateVersions returns the versions in the state history of a stack,
+// from the oldest to the newest
+func (c *client)ListStateVersions(name string, namespace string) ([]statestore.Version, error) {
+    stack, err := c.GetStack(name, namespace)
+    if err != nil {
+        return nil, err
+    }
+    if stack.Spec.Backend != nil {
+        return nil, noStateHistory(stack)
+    }
+
+    versions, err := statestore.New(c.rc, stack).Versions(context.TODO())
+    if err != nil {
+        return nil, stateStoreError(name, err)
+    }
+    return versions, nil
+}
+
+// GetStateVersion returns a version in the state history of a stack with its
+// state
+func (c *client)GetStateVersion(name string, namespace string, version int64) (*statestore.Version, []byte, error) {
+    stack, err := c.GetStack(name, namespace)
+    if err != nil {
+        return nil, nil, err
+    }
+    if stack.Spec.Backend != nil {
+        return nil, nil, noStateHistory(stack)
+    }
+
+    return c.stateVersion(statestore.New(c.rc, stack), name, namespace, version)
+}
+
+// stateVersion returns a version in a stack's state history with its state,
+// failing if it is not in the history
+func (c *client)stateVersion(store *statestore.Store, name string, namespace string, version int64) (*statestore.Version, []byte, error) {
+    v, content, err := store.Version(context.TODO(), version)
+    if err != nil {
+        return nil, nil, stateStoreError(name, err)
+    }
+    if v == nil {
+        return nil, nil, NewNotFoundError(fmt.Sprintf("%s state version %d", name, version), "Stack", namespace)
+    }
+    return v, content, nil
+}
+
+// updateState changes the stored state of a stack holding its state lock.
+// The update receives the current state, nil if there is none. The updated
+// state is recorded as a new version in the stack's state history. If the
+// stack has no state Secret, it is created.
+// If the stack configures its own backend, the updated state is pushed to it,
+// with no store, and terraform rejects it as stale unless forced.
+func (c *client)updateState(name string, namespace string, operation string, force bool, update func(store *statestore.Store, current []byte) ([]byte, error)) (result *StateUpdate, err error) {
+    err = c.withStateLock(name, namespace, operation, func(stack *tfo.Stack) error {
+        if stack.Spec.Backend != nil {
+            result, err = c.updateBackendState(stack, force, func(current []byte) ([]byte, error) {
+                return update(nil, current)
+            })
+            return err
+        }
+
+        store := statestore.New(c.rc, stack)
+        current, err := store.Read(context.TODO())
+        if err != nil {
+            return stateStoreError(name, err)
+        }
+
+        updated, err := update(store, current)
+        if err != nil {
+            return err
+        }
+        meta, err := terraform.ParseStateMeta(updated)
+        if err != nil {
+            return NewTFOError(err.Error(), ErrorReasonInvalidFileContent)
+        }
+
+        version, err := store.Write(context.TODO(), updated, 0, operation)
+        if err != nil {
+            return stateStoreError(name, err)
+        }
+
+        result = &StateUpdate{
+            Secret:  statestore.SecretName(stack),
+            Version: version.Number,
+            Serial:  meta.Serial,
+        }
+        return nil
+    })
+    return result, err
+}
+
+// withStateLock runs an operation on the state of a stack holding its state
+// lock, which is renewed while the operation runs
+func (c *client)withStateLock(name string, namespace string, operation string, run func(stack *tfo.Stack) error) (err error) {
+    stack, lock, err := c.lockState(name, namespace, operation)
+    if err != nil {
+        return err
+    }
+    stopRenewal := lock.KeepAlive(context.TODO())
+    defer func() {
+        stopRenewal()
+        unlockErr := lock.Release(context.TODO())
+        if unlockErr != nil && err == nil {
+            errDesc := fmt.Sprintf("error releasing the state lock of stack %s: %s", name, unlockErr)
+            err = NewTFOError(errDesc, ErrorReasonRuntimeError)
+        }
+    }()
+
+    return run(stack)
+}
+
+// stateStoreError returns the error for a failure accessing the state of a
+// stack or its history
+func stateStoreError(name string, err error) error {
+    if apierr.IsForbidden(err) {
+        errDesc := fmt.Sprintf("not allowed to access the state of stack %s", name)
+        return NewTFOError(errDesc, ErrorReasonForbidden)
+    }
+    errDesc := fmt.Sprintf("runtime error accessing the state of stack %s: %s", name, err)
+    return NewTFOError(errDesc, ErrorReasonRuntimeError)
+}
+
+// lockState acquires the state lock of a stack, failing if it is already
+// locked or the stack has a run in progress. The lock must be renewed while
+// it is held.
+func (c *client)lockState(name string, namespace string, operation string) (*tfo.Stack, *statelock.Lock, error) {
+    stack, err := c.GetStack(name, namespace)
+    if err != nil {
+        return nil, nil, err
+    }
+    if stack.Status.Phase == tfo.StackPhaseRunning || stack.Status.Phase == tfo.StackPhaseDestroying {
+        errDesc := fmt.Sprintf("stack %s has a run in progress", name)
+        return nil, nil, NewTFOError(errDesc, ErrorReasonConflict)
+    }
+
+    info := statelock.Info{Operation: operation, Who: lockHolder()}
+    lock, err := statelock.ForStack(c.rc, stack).Acquire(context.TODO(), info, statelock.DefaultTTL)
+    if err != nil {
+        return nil, nil, lockError(err)
+    }
+
+    return stack, lock, nil
+}
+
+// ForceUnlockState releases the state lock of a stack with the given ID,
+// left by a holder that failed to release it. For a stack configuring its own
+// backend, an ID other than the one of the state lock releases the lock in
+// the backend.
+func (c *client)ForceUnlockState(name string, namespace string, id string) error {
+    stack, err := c.GetStack(name, namespace)
+    if err != nil {
+        return err
+    }
+    locker := statelock.ForStack(c.rc, stack)
+
+    // runs of a stack configuring its own backend lock the state in it
+    if stack.Spec.Backend != nil {
+        info, err := locker.Get(context.TODO())
+        if err != nil {
+            return lockError(err)
+        }
+        if info == nil || info.ID != id {
+            return c.forceUnlockBackend(stack, id)
+        }
+    }
+
+    err = locker.Unlock(context.TODO(), id)
+    if err != nil {
+        return lockError(err)
+    }
+    return nil
+}
+
+// lockError returns the error for a failure accessing a state lock
+func lockError(err error) error {
+    if statelock.IsLocked(err) {
+        return NewTFOError(err.Error(), ErrorReasonConflict)
+    }
+    errDesc := fmt.Sprintf("runtime error accessing state lock: %s", err)
+    return NewTFOError(errDesc, ErrorReasonRuntimeError)
+}
+
+// lockHolder identifies the user and host holding a lock
+func lockHolder() string {
+    holder := "unknown"
+    if u, err := user.Current(); err == nil {
+        holder = u.Username
+    }
+    if host, err := os.Hostname(); err == nil {
+        holder += "@" + host
+    }
+    return holder
+}
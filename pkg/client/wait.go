@@ -0,0 +1,201 @@
+package client
+
+import (
+    "fmt"
+    "time"
+
+    tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+    corev1 "k8s.io/api/core/v1"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/fields"
+    apirtm "k8s.io/apimachinery/pkg/runtime"
+    "k8s.io/apimachinery/pkg/watch"
+    "k8s.io/client-go/dynamic"
+)
+
+// WaitCondition is a condition a stack can be waited for
+type WaitCondition string
+
+const (
+    // The stack's Ready condition is true for its current spec
+    WaitConditionReady WaitCondition = "Ready"
+
+    // The stack is ready and has outputs
+    WaitConditionOutputs WaitCondition = "outputs"
+
+    // The stack is deleted
+    WaitConditionDelete WaitCondition = "delete"
+)
+
+// stackWatcher watches the changes of a stack
+type stackWatcher interface {
+    // WatchStack watches a stack from a resource version. The events have
+    // the stack as object
+    WatchStack(name string, namespace string, resourceVersion string) (watch.Interface, error)
+}
+
+// dynamicStackWatcher implements stackWatcher using the dynamic client
+type dynamicStackWatcher struct {
+    dc dynamic.Interface
+}
+
+func (w *dynamicStackWatcher) WatchStack(name string, namespace string, resourceVersion string) (watch.Interface, error) {
+    stacks := w.dc.Resource(tfo.GroupVersion.WithResource("stacks")).Namespace(namespace)
+    watcher, err := stacks.Watch(metav1.ListOptions{
+        FieldSelector:   fields.OneTermEqualSelector("metadata.name", name).String(),
+        ResourceVersion: resourceVersion,
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    // convert the unstructured objects to stacks
+    return watch.Filter(watcher, func(in watch.Event) (watch.Event, bool) {
+        obj, ok := in.Object.(*unstructured.Unstructured)
+        if !ok {
+            return in, true
+        }
+        stack := &tfo.Stack{}
+        err := apirtm.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), stack)
+        if err != nil {
+            return in, false
+        }
+        in.Object = stack
+        return in, true
+    }), nil
+}
+
+// WaitStack waits until a stack meets a condition and returns it, or fails if
+// the stack fails or the timeout expires. 0 means no timeout. The stack is
+// watched from its current state. If the watch is closed, it is started again.
+func (c *client)WaitStack(name string, namespace string, condition WaitCondition, timeout time.Duration) (*tfo.Stack, error) {
+    // clients created from a runtime client have no rest config to watch
+    // the stacks with
+    if c.watcher == nil {
+        return nil, NewTFOError("waiting for stacks is not supported by this client", ErrorReasonRuntimeError)
+    }
+
+    var deadline <-chan time.Time
+    if timeout > 0 {
+        timer := time.NewTimer(timeout)
+        defer timer.Stop()
+        deadline = timer.C
+    }
+
+    for {
+        stack, err := c.GetStack(name, namespace)
+        if Is(err, ErrorReasonNotFound) && condition == WaitConditionDelete {
+            return nil, nil
+        }
+        if err != nil {
+            return nil, err
+        }
+
+        done, err := conditionMet(stack, condition)
+        if done || err != nil {
+            return stack, err
+        }
+
+        watcher, err := c.watcher.WatchStack(name, namespace, stack.ResourceVersion)
+        if err != nil {
+            errDesc := fmt.Sprintf("runtime error watching stack: %s", err)
+            return nil, NewTFOError(errDesc, ErrorReasonRuntimeError)
+        }
+
+        stack, done, err = watchUntil(watcher, condition, deadline)
+        watcher.Stop()
+        if done || err != nil {
+            return stack, err
+        }
+    }
+}
+
+// watchUntil processes the events of a watch until the condition is met, the
+// stack fails, the deadline expires or the watch is closed
+func watchUntil(watcher watch.Interface, condition WaitCondition, deadline <-chan time.Time) (*tfo.Stack, bool, error) {
+    for {
+        select {
+        case <-deadline:
+            errDesc := fmt.Sprintf("timed out waiting for condition %s", condition)
+            return nil, false, NewTFOError(errDesc, ErrorReasonTimeout)
+        case event, open := <-watcher.ResultChan():
+            if !open {
+                return nil, false, nil
+            }
+
+            switch event.Type {
+            case watch.Deleted:
+                if condition == WaitConditionDelete {
+                    return nil, true, nil
+                }
+                return nil, false, NewTFOError("stack was deleted", ErrorReasonNotFound)
+            case watch.Error:
+                // the watch may have expired, it is started again
+                return nil, false, nil
+            case watch.Added, watch.Modified:
+                stack, isStack := event.Object.(*tfo.Stack)
+                if !isStack {
+                    continue
+                }
+                done, err := conditionMet(stack, condition)
+                if done || err != nil {
+                    return stack, done, err
+                }
+            }
+        }
+    }
+}
+
+// conditionMet indicates if the stack meets a condition, or returns an error
+// if it failed to meet it
+func conditionMet(stack *tfo.Stack, condition WaitCondition) (bool, error) {
+    if condition == WaitConditionDelete {
+        // a failed destroy leaves the stack destroying with the failure
+        if stack.Status.Phase == tfo.StackPhaseDestroying && stack.Status.Message != "" {
+            return false, NewTFOError(stack.Status.Message, ErrorReasonStackFailed)
+        }
+        return false, nil
+    }
+
+    ready := readyCondition(stack)
+    if ready == nil {
+        return false, nil
+    }
+
+    // the condition must correspond to the current spec. Conditions set
+    // before the generation was recorded are considered current
+    if ready.ObservedGeneration != 0 && ready.ObservedGeneration != stack.Generation {
+        return false, nil
+    }
+
+    if ready.Reason == string(tfo.StackPhaseFailed) {
+        return false, NewTFOError(ready.Message, ErrorReasonStackFailed)
+    }
+
+    // plan-only stacks are not applied until their plan is confirmed
+    if ready.Reason == string(tfo.StackPhasePlanned) {
+        errDesc := fmt.Sprintf("stack is plan-only: %s", ready.Message)
+        return false, NewTFOError(errDesc, ErrorReasonStackFailed)
+    }
+
+    if ready.Status != corev1.ConditionTrue {
+        return false, nil
+    }
+
+    if condition == WaitConditionOutputs {
+        return stack.Status.TfOutput != "", nil
+    }
+
+    return true, nil
+}
+
+// readyCondition returns the stack's Ready condition, or nil
+func readyCondition(stack *tfo.Stack) *tfo.StackCondition {
+    for i := range stack.Status.Conditions {
+        if stack.Status.Conditions[i].Type == tfo.StackConditionReady {
+            return &stack.Status.Conditions[i]
+        }
+    }
+    return nil
+}
@@ -0,0 +1,246 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+)
+
+const (
+	// KeySize is the size in bytes of the data keys and key encryption keys,
+	// for AES-256
+	KeySize = 32
+
+	// prefix of the encrypted data, followed by its envelope as json
+	envelopePrefix = "tfo:enc:v1:"
+)
+
+// KMS wraps the data keys encrypting each piece of data with a key
+// encryption key it holds
+type KMS interface {
+	// Wrap encrypts a data key with the active key encryption key,
+	// returning the ID of the key
+	Wrap(ctx context.Context, dataKey []byte) (keyID string, wrapped []byte, err error)
+
+	// Unwrap decrypts a data key wrapped with the key encryption key with
+	// the given ID
+	Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// envelope is the encrypted data with its data key, wrapped by the KMS
+type envelope struct {
+	KeyID      string `json:"keyID"`
+	WrappedKey []byte `json:"wrappedKey"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Encrypter encrypts data with AES-256-GCM using a new data key each time,
+// which is stored with the data wrapped by a KMS. A nil Encrypter doesn't
+// encrypt data.
+type Encrypter struct {
+	kms KMS
+}
+
+// New returns an Encrypter wrapping its data keys with a KMS
+func New(kms KMS) *Encrypter {
+	return &Encrypter{kms: kms}
+}
+
+// ForStack returns the Encrypter of a stack's state and sensitive outputs,
+// with the key encryption keys in the Secret referenced by the stack, or nil
+// if they are not encrypted
+func ForStack(ctx context.Context, c client.Client, stack *tfo.Stack) (*Encrypter, error) {
+	if stack.Spec.Encryption == nil {
+		return nil, nil
+	}
+
+	secret := &corev1.Secret{}
+	key := client.ObjectKey{Name: stack.Spec.Encryption.KeySecret.Name, Namespace: stack.Namespace}
+	err := c.Get(ctx, key, secret)
+	if err != nil {
+		return nil, fmt.Errorf("error getting encryption keys of stack %s: %v", stack.Name, err)
+	}
+
+	kms, err := NewLocalKMS(secret.Data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption keys in secret %s: %v", secret.Name, err)
+	}
+	return New(kms), nil
+}
+
+// IsEncrypted indicates if data was encrypted by an Encrypter
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(envelopePrefix))
+}
+
+// Encrypt encrypts data, returning it with its wrapped data key
+func (e *Encrypter) Encrypt(ctx context.Context, plaintext []byte) ([]byte, error) {
+	if e == nil {
+		return plaintext, nil
+	}
+
+	dataKey := make([]byte, KeySize)
+	_, err := rand.Read(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, ciphertext, err := seal(dataKey, plaintext, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	keyID, wrapped, err := e.kms.Wrap(ctx, dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("error wrapping data key: %v", err)
+	}
+
+	raw, err := json.Marshal(envelope{
+		KeyID:      keyID,
+		WrappedKey: wrapped,
+		Nonce:      nonce,
+		Ciphertext: ciphertext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(envelopePrefix), raw...), nil
+}
+
+// Decrypt decrypts data encrypted by an Encrypter. Data not encrypted, as
+// stored before enabling the encryption, is returned as is.
+func (e *Encrypter) Decrypt(ctx context.Context, data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return data, nil
+	}
+	if e == nil {
+		return nil, fmt.Errorf("data is encrypted and no encryption keys are configured")
+	}
+
+	env := envelope{}
+	err := json.Unmarshal(data[len(envelopePrefix):], &env)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encrypted data: %v", err)
+	}
+
+	dataKey, err := e.kms.Unwrap(ctx, env.KeyID, env.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("error unwrapping data key: %v", err)
+	}
+
+	return open(dataKey, env.Nonce, env.Ciphertext, nil)
+}
+
+// Reencrypt decrypts data and encrypts it again with a new data key wrapped
+// by the active key encryption key, as when rotating the keys. Data not
+// encrypted is encrypted.
+func (e *Encrypter) Reencrypt(ctx context.Context, data []byte) ([]byte, error) {
+	plaintext, err := e.Decrypt(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	return e.Encrypt(ctx, plaintext)
+}
+
+// DecryptValues decrypts the values of a Secret's data, keeping the ones not
+// encrypted
+func (e *Encrypter) DecryptValues(ctx context.Context, values map[string][]byte) (map[string][]byte, error) {
+	decrypted := map[string][]byte{}
+	for name, value := range values {
+		plaintext, err := e.Decrypt(ctx, value)
+		if err != nil {
+			return nil, fmt.Errorf("value %s: %v", name, err)
+		}
+		decrypted[name] = plaintext
+	}
+	return decrypted, nil
+}
+
+// EncryptValues encrypts the values of a Secret's data not encrypted yet,
+// indicating if any was
+func (e *Encrypter) EncryptValues(ctx context.Context, values map[string][]byte) (map[string][]byte, bool, error) {
+	encrypted := map[string][]byte{}
+	changed := false
+	for name, value := range values {
+		if IsEncrypted(value) || e == nil {
+			encrypted[name] = value
+			continue
+		}
+		ciphertext, err := e.Encrypt(ctx, value)
+		if err != nil {
+			return nil, false, fmt.Errorf("value %s: %v", name, err)
+		}
+		encrypted[name] = ciphertext
+		changed = true
+	}
+	return encrypted, changed, nil
+}
+
+// ReencryptValues encrypts the values of a Secret's data again with new data
+// keys wrapped by the active key encryption key
+func (e *Encrypter) ReencryptValues(ctx context.Context, values map[string][]byte) (map[string][]byte, error) {
+	reencrypted := map[string][]byte{}
+	for name, value := range values {
+		ciphertext, err := e.Reencrypt(ctx, value)
+		if err != nil {
+			return nil, fmt.Errorf("value %s: %v", name, err)
+		}
+		reencrypted[name] = ciphertext
+	}
+	return reencrypted, nil
+}
+
+// seal encrypts data with AES-GCM, returning the random nonce used
+func seal(key []byte, plaintext []byte, additionalData []byte) ([]byte, []byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return nonce, gcm.Seal(nil, nonce, plaintext, additionalData), nil
+}
+
+// open decrypts and authenticates data encrypted with AES-GCM
+func open(key []byte, nonce []byte, ciphertext []byte, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce size %d", len(nonce))
+	}
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting data: %v", err)
+	}
+	return plaintext, nil
+}
+
+// newGCM returns the AES-GCM cipher for a 256 bits key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("invalid key size %d, must be %d", len(key), KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,175 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+)
+
+func TestEncryption(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Encryption Suite")
+}
+
+// key returns a key encryption key filled with a byte
+func key(b byte) []byte {
+	return bytes.Repeat([]byte{b}, KeySize)
+}
+
+// newEncrypter returns an Encrypter with the given keys and active key
+func newEncrypter(keys map[string][]byte, active string) *Encrypter {
+	data := map[string][]byte{ActiveKey: []byte(active)}
+	for id, k := range keys {
+		data[id] = k
+	}
+	kms, err := NewLocalKMS(data)
+	Expect(err).NotTo(HaveOccurred())
+	return New(kms)
+}
+
+var _ = Describe("Envelope encryption", func() {
+	var (
+		ctx   = context.TODO()
+		state = []byte(`{"version": 4, "serial": 1, "lineage": "a", "resources": []}`)
+	)
+
+	It("Should encrypt with a new data key each time", func() {
+		enc := newEncrypter(map[string][]byte{"k1": key(1)}, "k1")
+
+		encrypted, err := enc.Encrypt(ctx, state)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(IsEncrypted(encrypted)).To(BeTrue())
+		Expect(encrypted).NotTo(ContainSubstring("lineage"))
+
+		other, err := enc.Encrypt(ctx, state)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(other).NotTo(Equal(encrypted))
+
+		decrypted, err := enc.Decrypt(ctx, encrypted)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(decrypted).To(Equal(state))
+	})
+
+	It("Should keep data not encrypted", func() {
+		enc := newEncrypter(map[string][]byte{"k1": key(1)}, "k1")
+		decrypted, err := enc.Decrypt(ctx, state)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(decrypted).To(Equal(state))
+
+		var none *Encrypter
+		content, err := none.Encrypt(ctx, state)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(content).To(Equal(state))
+
+		encrypted, err := enc.Encrypt(ctx, state)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = none.Decrypt(ctx, encrypted)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should fail decrypting tampered data or with other keys", func() {
+		encrypted, err := newEncrypter(map[string][]byte{"k1": key(1)}, "k1").Encrypt(ctx, state)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = newEncrypter(map[string][]byte{"k2": key(2)}, "k2").Decrypt(ctx, encrypted)
+		Expect(err).To(HaveOccurred())
+		_, err = newEncrypter(map[string][]byte{"k1": key(2)}, "k1").Decrypt(ctx, encrypted)
+		Expect(err).To(HaveOccurred())
+
+		env := envelope{}
+		Expect(json.Unmarshal(encrypted[len(envelopePrefix):], &env)).To(Succeed())
+		env.Ciphertext[0] ^= 1
+		raw, err := json.Marshal(env)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = newEncrypter(map[string][]byte{"k1": key(1)}, "k1").Decrypt(ctx, append([]byte(envelopePrefix), raw...))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should re-encrypt with the active key after rotating it", func() {
+		encrypted, err := newEncrypter(map[string][]byte{"k1": key(1)}, "k1").Encrypt(ctx, state)
+		Expect(err).NotTo(HaveOccurred())
+
+		rotated := newEncrypter(map[string][]byte{"k1": key(1), "k2": key(2)}, "k2")
+		reencrypted, err := rotated.Reencrypt(ctx, encrypted)
+		Expect(err).NotTo(HaveOccurred())
+
+		// once re-encrypted, the previous key is no longer needed
+		decrypted, err := newEncrypter(map[string][]byte{"k2": key(2)}, "k2").Decrypt(ctx, reencrypted)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(decrypted).To(Equal(state))
+	})
+
+	It("Should decrypt the values of a Secret", func() {
+		enc := newEncrypter(map[string][]byte{"k1": key(1)}, "k1")
+		password, err := enc.Encrypt(ctx, []byte(`"secret"`))
+		Expect(err).NotTo(HaveOccurred())
+
+		values, err := enc.DecryptValues(ctx, map[string][]byte{"password": password, "plain": []byte(`"value"`)})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(values).To(Equal(map[string][]byte{"password": []byte(`"secret"`), "plain": []byte(`"value"`)}))
+	})
+
+	It("Should reject invalid keys", func() {
+		_, err := NewLocalKMS(map[string][]byte{"k1": key(1)})
+		Expect(err).To(HaveOccurred())
+		_, err = NewLocalKMS(map[string][]byte{ActiveKey: []byte("k2"), "k1": key(1)})
+		Expect(err).To(HaveOccurred())
+		_, err = NewLocalKMS(map[string][]byte{ActiveKey: []byte("k1"), "k1": []byte("short")})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should load the keys from files", func() {
+		dir, err := ioutil.TempDir("", "kms")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		Expect(ioutil.WriteFile(filepath.Join(dir, "k1"), key(1), 0600)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, ActiveKey), []byte("k1\n"), 0600)).To(Succeed())
+		Expect(os.Mkdir(filepath.Join(dir, "..data"), 0700)).To(Succeed())
+
+		kms, err := LoadLocalKMS(dir)
+		Expect(err).NotTo(HaveOccurred())
+		encrypted, err := New(kms).Encrypt(ctx, state)
+		Expect(err).NotTo(HaveOccurred())
+
+		decrypted, err := newEncrypter(map[string][]byte{"k1": key(1)}, "k1").Decrypt(ctx, encrypted)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(decrypted).To(Equal(state))
+	})
+
+	It("Should use the keys referenced by a stack", func() {
+		sch := runtime.NewScheme()
+		Expect(corev1.AddToScheme(sch)).To(Succeed())
+		c := fake.NewFakeClientWithScheme(sch, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "keys", Namespace: "test"},
+			Data:       map[string][]byte{ActiveKey: []byte("k1"), "k1": key(1)},
+		})
+
+		stack := &tfo.Stack{ObjectMeta: metav1.ObjectMeta{Name: "stack", Namespace: "test"}}
+		enc, err := ForStack(ctx, c, stack)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(enc).To(BeNil())
+
+		stack.Spec.Encryption = &tfo.StackEncryption{KeySecret: corev1.LocalObjectReference{Name: "keys"}}
+		enc, err = ForStack(ctx, c, stack)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(enc).NotTo(BeNil())
+
+		stack.Spec.Encryption.KeySecret.Name = "other"
+		_, err = ForStack(ctx, c, stack)
+		Expect(err).To(HaveOccurred())
+	})
+})
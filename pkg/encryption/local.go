@@ -0,0 +1,111 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// ActiveKey is the key of the key encryption keys with the ID of the key
+	// wrapping new data keys
+	ActiveKey = "active"
+)
+
+// LocalKMS wraps data keys with key encryption keys it holds in memory,
+// taken from the data of a Secret or from files. The keys are indexed by
+// their ID, and the one with the ID given in the ActiveKey entry wraps new
+// data keys. The others unwrap the data keys wrapped before rotating the
+// active key.
+type LocalKMS struct {
+	keys   map[string][]byte
+	active string
+}
+
+// NewLocalKMS returns a LocalKMS with the given keys and the ActiveKey entry
+// with the ID of the active one
+func NewLocalKMS(keys map[string][]byte) (*LocalKMS, error) {
+	active := strings.TrimSpace(string(keys[ActiveKey]))
+	if active == "" {
+		return nil, fmt.Errorf("missing %s key ID", ActiveKey)
+	}
+
+	kms := &LocalKMS{keys: map[string][]byte{}, active: active}
+	for id, key := range keys {
+		if id == ActiveKey {
+			continue
+		}
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("key %s must have %d bytes", id, KeySize)
+		}
+		kms.keys[id] = key
+	}
+	if _, found := kms.keys[active]; !found {
+		return nil, fmt.Errorf("active key %s not found", active)
+	}
+
+	return kms, nil
+}
+
+// LoadLocalKMS returns a LocalKMS with the keys in the files of a directory,
+// as a Secret with the keys mounted in a volume. Each file holds a key, with
+// its ID as name, and the ActiveKey file holds the ID of the active one.
+func LoadLocalKMS(dir string) (*LocalKMS, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string][]byte{}
+	for _, file := range files {
+		// mounted Secrets have their files linked from hidden directories
+		// with the current content
+		if strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		keys[file.Name()] = content
+	}
+
+	return NewLocalKMS(keys)
+}
+
+// Wrap encrypts a data key with the active key, using its ID as additional
+// data so it is only unwrapped with the same key ID
+func (k *LocalKMS) Wrap(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	nonce, wrapped, err := seal(k.keys[k.active], dataKey, []byte(k.active))
+	if err != nil {
+		return "", nil, err
+	}
+	return k.active, append(nonce, wrapped...), nil
+}
+
+// Unwrap decrypts a data key wrapped with the key with the given ID
+func (k *LocalKMS) Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	key, found := k.keys[keyID]
+	if !found {
+		return nil, fmt.Errorf("key %s not found", keyID)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid wrapped key")
+	}
+	return open(key, wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():], []byte(keyID))
+}
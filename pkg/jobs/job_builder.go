@@ -2,6 +2,9 @@ package jobs
 
 import (
 	"math/rand"
+	"path"
+	"sort"
+	"strconv"
     "strings"
 	"time"
 
@@ -20,6 +23,12 @@ const (
 	// command to execute in the job
 	jobCommand = "tfoctl"
 
+	// RunContainer is the name of the container running the command
+	RunContainer = "run-" + jobCommand
+
+	// subcommand running terraform for the stack in the Job
+	runnerCommand = "runner"
+
 	// name of the tf config volume in the job spec
 	tfconfigVolName = "tfconf"
 
@@ -32,11 +41,35 @@ const (
 	// path for mounting the tfvars
 	tfvarsPath = "/var/lib/tfoperator"
 
-	// name of the tfvol in the job spec
-	tfstateVolName = "tfstate"
+	// subcommand for fetching the tf config from a remote source
+	fetchCommand = "fetch"
+
+	// name of the volume with the packed tf config
+	tfconfigSrcVolName = "tfconf-src"
+
+	// path for mounting the packed tf config
+	tfconfigSrcPath = "/var/lib/tfoperator/tfconfig-src"
+
+	// name of the volume with the credentials for the remote source
+	fetchCredentialsVolName = "fetch-credentials"
+
+	// path for mounting the credentials for the remote source
+	fetchCredentialsPath = "/var/lib/tfoperator/fetch-credentials"
+
+	// prefix for environment variables terraform reads as input variables
+	tfVarEnvPrefix = "TF_VAR_"
+
+	// name of the volume with the backend override
+	backendVolName = "backend"
+
+	// path for mounting the backend override
+	backendPath = "/var/lib/tfoperator/backend"
 
-	// path for mounting the tfstate
-	tfstatePath = "/var/lib/tfoperator"
+	// key of the backend override in its Secret
+	backendOverrideKey = "backend_override.tf"
+
+	// key of the backend config file in the backend Secret
+	backendConfigKey = "backend.hcl"
 )
 
 var (
@@ -70,13 +103,24 @@ var (
 )
 
 type JobConfig struct {
-	Command   string   // command to execute
-	Args      []string // options to the command
-	Namespace string   // Stack's namespace
-	Stack     string   // Stack name
-	TfConfig  string   // TfConfig ConfigMap name
-	Tfvars    string   // tfvars Secret name
-	Tfstate   string   // tfstate Secret name
+	Command       string   // command to execute
+	Args          []string // options to the command
+	Namespace     string   // Stack's namespace
+	Stack         string   // Stack name
+	TfConfig      string   // TfConfig ConfigMap name
+	Tfvars        string   // tfvars Secret name
+	VarsFrom      string   // Secret name with variables taken from other stacks
+	Backend       string   // Secret name with the override of the backend keeping the state
+	BackendConfig bool     // the Backend Secret has a backend config file with the settings of the backend for terraform init
+	Run           int64    // number of the stack's run executed by the Job
+	Workspace     string   // terraform workspace selected before running the command, if any
+
+	Imports map[string]string // resources imported before running the command, by address, with their IDs in the provider
+
+	ServiceAccount string // service account the Job's pods run as, for recording the results of the run
+
+	Fetch       []string // arguments to tfoctl fetch for getting the tf config from a remote source, instead of the TfConfig ConfigMap
+	FetchSecret string   // Secret name with the credentials for the remote source
 }
 
 // buildJob returns a Job for running a command
@@ -90,64 +134,139 @@ func BuildJob(cfg *JobConfig) (*batchv1.Job, error) {
 	job.Namespace = cfg.Namespace
 
 	jobPodSpec := &job.Spec.Template.Spec
+	jobPodSpec.ServiceAccountName = cfg.ServiceAccount
 	jobCont0 := &jobPodSpec.Containers[0]
 
-	jobCont0.Name = "run-" + jobCommand
-	jobCont0.Command = []string{jobCommand, cfg.Command}
+	jobCont0.Name = RunContainer
+	jobCont0.Command = []string{jobCommand, runnerCommand, cfg.Command}
 	jobCont0.Args = append(cfg.Args, "--stack", cfg.Stack, "--namespace", cfg.Namespace)
 
 	labels := map[string]string{
 		"stack.tf-operator.io": cfg.Stack,
 	}
+	if cfg.Run > 0 {
+		labels["run.tf-operator.io/number"] = strconv.FormatInt(cfg.Run, 10)
+		jobCont0.Args = append(jobCont0.Args, "--run", strconv.FormatInt(cfg.Run, 10))
+	}
+	// the pods are labeled too, for finding the ones of a stack's run
+	job.Spec.Template.ObjectMeta.Labels = map[string]string{}
 	for k, v := range labels {
 		job.ObjectMeta.Labels[k] = v
+		job.Spec.Template.ObjectMeta.Labels[k] = v
 	}
 
 	err := volumeFromSecret(jobPodSpec, tfvarsVolName, tfvarsPath, cfg.Tfvars)
 	if err != nil {
 	}
 
-	err = volumeFromConfigMap(jobPodSpec, tfconfigVolName, tfconfigPath, cfg.TfConfig)
-	if err != nil {
+	if len(cfg.Fetch) > 0 {
+		fetchTfConfig(jobPodSpec, cfg.Fetch, cfg.FetchSecret)
+	} else {
+		unpackTfConfig(jobPodSpec, cfg.TfConfig)
+	}
+
+	if cfg.Backend != "" {
+		err = volumeFromSecret(jobPodSpec, backendVolName, backendPath, cfg.Backend)
+		if err != nil {
+		}
+		jobCont0.Args = append(jobCont0.Args, "--backend-override", path.Join(backendPath, backendOverrideKey))
+		if cfg.BackendConfig {
+			jobCont0.Args = append(jobCont0.Args, "--backend-config", path.Join(backendPath, backendConfigKey))
+		}
+	}
+
+	if cfg.VarsFrom != "" {
+		envFromSecret(jobPodSpec, tfVarEnvPrefix, cfg.VarsFrom)
+	}
+
+	if cfg.Workspace != "" {
+		jobCont0.Args = append(jobCont0.Args, "--workspace", cfg.Workspace)
 	}
 
-    if cfg.Tfstate != "" {
-        err = volumeFromSecret(jobPodSpec, tfstateVolName, tfstatePath, cfg.Tfstate)
-	    if err != nil {
-	    }
-    }
+	// in the order of their addresses, so the Job's spec is stable
+	addresses := []string{}
+	for address := range cfg.Imports {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	for _, address := range addresses {
+		jobCont0.Args = append(jobCont0.Args, "--import", address+"="+cfg.Imports[address])
+	}
 
 	return job, nil
 }
 
-// volumeFromSecret mounts a volume from a secret in container 0 of a Job
-func volumeFromSecret(podSpec *corev1.PodSpec, volName string, volPath string, secret string) error {
-	secretVolume := corev1.Volume{
-		Name: volName,
+// Command returns the command run by a Job, such as apply or destroy
+func Command(job *batchv1.Job) string {
+	containers := job.Spec.Template.Spec.Containers
+	if len(containers) == 0 || len(containers[0].Command) == 0 {
+		return ""
+	}
+	command := containers[0].Command
+	return command[len(command)-1]
+}
+
+// fetchTfConfig adds an init container to a Job that fetches the tf config
+// from a remote source into a volume mounted in container 0
+func fetchTfConfig(podSpec *corev1.PodSpec, fetchArgs []string, credentials string) {
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: tfconfigVolName,
 		VolumeSource: corev1.VolumeSource{
-			Secret: &corev1.SecretVolumeSource{
-				SecretName: secret,
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	})
+
+	// terraform runs in the tf config directory, where it is initialized
+	jobCont0 := &podSpec.Containers[0]
+	jobCont0.VolumeMounts = append(jobCont0.VolumeMounts, corev1.VolumeMount{
+		Name:      tfconfigVolName,
+		MountPath: tfconfigPath,
+	})
+
+	args := append([]string{}, fetchArgs...)
+	args = append(args, "--dest", tfconfigPath)
+	fetchCont := corev1.Container{
+		Name:            "fetch-tfconfig",
+		Image:           jobImage,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         []string{jobCommand, fetchCommand},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      tfconfigVolName,
+				MountPath: tfconfigPath,
 			},
 		},
 	}
-	podSpec.Volumes = append(podSpec.Volumes, secretVolume)
 
-	secretVolumeMount := corev1.VolumeMount{
-		Name:      volName,
-		MountPath: volPath,
-		ReadOnly:  true,
+	if credentials != "" {
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: fetchCredentialsVolName,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: credentials,
+				},
+			},
+		})
+		fetchCont.VolumeMounts = append(fetchCont.VolumeMounts, corev1.VolumeMount{
+			Name:      fetchCredentialsVolName,
+			MountPath: fetchCredentialsPath,
+			ReadOnly:  true,
+		})
+		args = append(args, "--credentials", fetchCredentialsPath)
 	}
 
-	jobCont0 := &podSpec.Containers[0]
-	jobCont0.VolumeMounts = append(jobCont0.VolumeMounts, secretVolumeMount)
-
-	return nil
+	fetchCont.Args = args
+	podSpec.InitContainers = append(podSpec.InitContainers, fetchCont)
 }
 
-// volumeFromConfigmap mounts a volume from a configmap in container 0 of a Job
-func volumeFromConfigMap(podSpec *corev1.PodSpec, volName string, volPath string, cfgMap string) error {
-	configMapVolume := corev1.Volume{
-		Name: volName,
+// unpackTfConfig adds an init container to a Job that unpacks the tf config
+// from a ConfigMap into a volume mounted in container 0, as the config may
+// have been bundled when the ConfigMap was created
+func unpackTfConfig(podSpec *corev1.PodSpec, cfgMap string) {
+	fetchTfConfig(podSpec, []string{"configmap", "--src", tfconfigSrcPath}, "")
+
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: tfconfigSrcVolName,
 		VolumeSource: corev1.VolumeSource{
 			ConfigMap: &corev1.ConfigMapVolumeSource{
 				LocalObjectReference: corev1.LocalObjectReference{
@@ -155,17 +274,52 @@ func volumeFromConfigMap(podSpec *corev1.PodSpec, volName string, volPath string
 				},
 			},
 		},
+	})
+
+	fetchCont := &podSpec.InitContainers[len(podSpec.InitContainers)-1]
+	fetchCont.VolumeMounts = append(fetchCont.VolumeMounts, corev1.VolumeMount{
+		Name:      tfconfigSrcVolName,
+		MountPath: tfconfigSrcPath,
+		ReadOnly:  true,
+	})
+}
+
+// envFromSecret exposes the keys of a secret as environment variables in
+// container 0 of a Job
+func envFromSecret(podSpec *corev1.PodSpec, prefix string, secret string) {
+	envSource := corev1.EnvFromSource{
+		Prefix: prefix,
+		SecretRef: &corev1.SecretEnvSource{
+			LocalObjectReference: corev1.LocalObjectReference{
+				Name: secret,
+			},
+		},
 	}
-	podSpec.Volumes = append(podSpec.Volumes, configMapVolume)
 
-	configMapVolumeMount := corev1.VolumeMount{
+	jobCont0 := &podSpec.Containers[0]
+	jobCont0.EnvFrom = append(jobCont0.EnvFrom, envSource)
+}
+
+// volumeFromSecret mounts a volume from a secret in container 0 of a Job
+func volumeFromSecret(podSpec *corev1.PodSpec, volName string, volPath string, secret string) error {
+	secretVolume := corev1.Volume{
+		Name: volName,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: secret,
+			},
+		},
+	}
+	podSpec.Volumes = append(podSpec.Volumes, secretVolume)
+
+	secretVolumeMount := corev1.VolumeMount{
 		Name:      volName,
 		MountPath: volPath,
 		ReadOnly:  true,
 	}
 
 	jobCont0 := &podSpec.Containers[0]
-	jobCont0.VolumeMounts = append(jobCont0.VolumeMounts, configMapVolumeMount)
+	jobCont0.VolumeMounts = append(jobCont0.VolumeMounts, secretVolumeMount)
 
 	return nil
 }
@@ -39,7 +39,9 @@ var _ = Describe("Apply Job Builder", func() {
 				Stack:     "TestStack",
 				TfConfig:  "TestConfig",
 				Tfvars:    "TestVars",
-				Tfstate:   "TestState",
+				VarsFrom:  "TestVarsFrom",
+				Backend:   "TestBackend",
+				Run:       3,
 			}
 			applyJob  *batchv1.Job
 			spec      corev1.PodSpec
@@ -62,7 +64,8 @@ var _ = Describe("Apply Job Builder", func() {
 		})
 
 		It("Should have the apply command set", func() {
-			Expect(container.Command).Should(ContainElements(cfg.Command))
+			Expect(container.Command).Should(Equal([]string{"tfoctl", "runner", cfg.Command}))
+			Expect(Command(applyJob)).To(Equal(cfg.Command))
 		})
 
 		It("Should have the arguments set", func() {
@@ -71,8 +74,182 @@ var _ = Describe("Apply Job Builder", func() {
 
 		It("Should have volume mounts with secrets and configmap", func() {
 			// check secreats and ConfigMaps are mounted in container
-			sourceNames := []string{cfg.TfConfig, cfg.Tfvars, cfg.Tfstate}
+			sourceNames := []string{cfg.TfConfig, cfg.Tfvars, cfg.Backend}
 			Expect(getVolumeSources(spec.Volumes)).To(ContainElements(sourceNames))
 		})
+
+		It("Should unpack the configmap in an init container", func() {
+			Expect(spec.InitContainers).To(HaveLen(1))
+			Expect(spec.InitContainers[0].Command).To(Equal([]string{"tfoctl", "fetch"}))
+			Expect(spec.InitContainers[0].Args).To(ContainElement("configmap"))
+		})
+
+		It("Should label the Job and its pods with the stack and run", func() {
+			labels := map[string]string{
+				"stack.tf-operator.io":      cfg.Stack,
+				"run.tf-operator.io/number": "3",
+			}
+			Expect(applyJob.Labels).To(Equal(labels))
+			Expect(applyJob.Spec.Template.Labels).To(Equal(labels))
+		})
+
+		It("Should expose variables from other stacks as terraform variables", func() {
+			Expect(container.EnvFrom).To(HaveLen(1))
+			Expect(container.EnvFrom[0].Prefix).To(Equal("TF_VAR_"))
+			Expect(container.EnvFrom[0].SecretRef.Name).To(Equal(cfg.VarsFrom))
+		})
+	})
+})
+
+var _ = Describe("Job Builder with remote source", func() {
+	var (
+		cfg = &JobConfig{
+			Command:     "apply",
+			Namespace:   "TestNS",
+			Stack:       "TestStack",
+			Tfvars:      "TestVars",
+			Fetch:       []string{"git", "--url", "file:///repo.git"},
+			FetchSecret: "TestCredentials",
+		}
+		spec corev1.PodSpec
+	)
+
+	BeforeEach(func() {
+		job, err := BuildJob(cfg)
+		Expect(err).ShouldNot(HaveOccurred())
+		spec = job.Spec.Template.Spec
+	})
+
+	It("Should fetch the config in an init container", func() {
+		Expect(spec.InitContainers).To(HaveLen(1))
+		fetch := spec.InitContainers[0]
+		Expect(fetch.Command).To(Equal([]string{"tfoctl", "fetch"}))
+		Expect(fetch.Args).To(ContainElements(cfg.Fetch))
+		Expect(fetch.Args).To(ContainElements("--dest", "--credentials"))
+	})
+
+	It("Should share the fetched config with the main container", func() {
+		var tfconf *corev1.Volume
+		for i := range spec.Volumes {
+			if spec.Volumes[i].Name == tfconfigVolName {
+				tfconf = &spec.Volumes[i]
+			}
+		}
+		Expect(tfconf).NotTo(BeNil())
+		Expect(tfconf.EmptyDir).NotTo(BeNil())
+		mounts := []string{}
+		for _, m := range spec.Containers[0].VolumeMounts {
+			mounts = append(mounts, m.Name)
+		}
+		Expect(mounts).To(ContainElement(tfconfigVolName))
+	})
+
+	It("Should mount the credentials", func() {
+		Expect(getVolumeSources(spec.Volumes)).To(ContainElement(cfg.FetchSecret))
+	})
+})
+
+var _ = Describe("Job Builder with state backend", func() {
+	var (
+		cfg = &JobConfig{
+			Command:   "apply",
+			Namespace: "TestNS",
+			Stack:     "TestStack",
+			TfConfig:  "TestConfig",
+			Tfvars:    "TestVars",
+			Backend:   "TestBackend",
+		}
+		spec corev1.PodSpec
+	)
+
+	BeforeEach(func() {
+		job, err := BuildJob(cfg)
+		Expect(err).ShouldNot(HaveOccurred())
+		spec = job.Spec.Template.Spec
+	})
+
+	It("Should mount the backend override", func() {
+		Expect(getVolumeSources(spec.Volumes)).To(ContainElement(cfg.Backend))
+	})
+
+	It("Should pass the backend override to the command", func() {
+		Expect(spec.Containers[0].Args).To(ContainElements("--backend-override", "/var/lib/tfoperator/backend/backend_override.tf"))
+	})
+})
+
+var _ = Describe("Job with a backend configured in the stack", func() {
+	var spec corev1.PodSpec
+
+	BeforeEach(func() {
+		job, err := BuildJob(&JobConfig{
+			Command:       "apply",
+			Namespace:     "TestNamespace",
+			Stack:         "TestStack",
+			TfConfig:      "TestConfig",
+			Tfvars:        "TestVars",
+			Backend:       "TestBackend",
+			BackendConfig: true,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		spec = job.Spec.Template.Spec
+	})
+
+	It("Should pass the backend config file to the command", func() {
+		Expect(getVolumeSources(spec.Volumes)).To(ContainElement("TestBackend"))
+		Expect(spec.Containers[0].Args).To(ContainElements("--backend-config", "/var/lib/tfoperator/backend/backend.hcl"))
+	})
+})
+
+var _ = Describe("Job with a terraform workspace", func() {
+	It("Should pass the workspace to the command", func() {
+		job, err := BuildJob(&JobConfig{
+			Command:   "apply",
+			Namespace: "TestNamespace",
+			Stack:     "TestStack",
+			TfConfig:  "TestConfig",
+			Tfvars:    "TestVars",
+			Workspace: "stage",
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(job.Spec.Template.Spec.Containers[0].Args).To(ContainElements("--workspace", "stage"))
+	})
+})
+
+var _ = Describe("Job importing resources", func() {
+	It("Should pass the imports to the command", func() {
+		job, err := BuildJob(&JobConfig{
+			Command:   "apply",
+			Namespace: "TestNamespace",
+			Stack:     "TestStack",
+			TfConfig:  "TestConfig",
+			Tfvars:    "TestVars",
+			Run:       3,
+			Imports: map[string]string{
+				"aws_s3_bucket.logs": "logs-bucket",
+				"aws_instance.web":   "i-0123",
+			},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		args := job.Spec.Template.Spec.Containers[0].Args
+		Expect(args).To(ContainElements("--run", "3"))
+		Expect(args[len(args)-4:]).To(Equal([]string{
+			"--import", "aws_instance.web=i-0123",
+			"--import", "aws_s3_bucket.logs=logs-bucket",
+		}))
+	})
+})
+
+var _ = Describe("Job with a service account", func() {
+	It("Should run the pods as the service account", func() {
+		job, err := BuildJob(&JobConfig{
+			Command:        "apply",
+			Namespace:      "TestNamespace",
+			Stack:          "TestStack",
+			TfConfig:       "TestConfig",
+			Tfvars:         "TestVars",
+			ServiceAccount: RunnerName("TestStack"),
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(job.Spec.Template.Spec.ServiceAccountName).To(Equal("TestStack-runner"))
 	})
 })
@@ -0,0 +1,76 @@
+package jobs
+
+import (
+	"io"
+	"io/ioutil"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// LogArchiveKey is the key of the log in the ConfigMap archiving it
+	LogArchiveKey = "log"
+
+	// MaxArchivedLogSize is the maximum size of an archived log. Longer logs
+	// are truncated, keeping their end
+	MaxArchivedLogSize = 512 * 1024
+
+	// label kubernetes sets in the pods of a Job
+	jobNameLabel = "job-name"
+)
+
+// PodLogs gives access to the logs of pods
+type PodLogs interface {
+	// Stream opens the log of a pod's container
+	Stream(namespace string, pod string, opts *corev1.PodLogOptions) (io.ReadCloser, error)
+}
+
+// kubePodLogs implements PodLogs using the kubernetes api
+type kubePodLogs struct {
+	kc kubernetes.Interface
+}
+
+// NewPodLogs returns a PodLogs accessing the logs with a kubernetes clientset
+func NewPodLogs(kc kubernetes.Interface) PodLogs {
+	return &kubePodLogs{kc: kc}
+}
+
+func (l *kubePodLogs) Stream(namespace string, pod string, opts *corev1.PodLogOptions) (io.ReadCloser, error) {
+	return l.kc.CoreV1().Pods(namespace).GetLogs(pod, opts).Stream()
+}
+
+// LogArchiveName returns the name of the ConfigMap archiving the log of a Job
+func LogArchiveName(job string) string {
+	return job + "-log"
+}
+
+// PodSelector returns the labels of the pods of a Job
+func PodSelector(job string) map[string]string {
+	return map[string]string{jobNameLabel: job}
+}
+
+// LatestPod returns the most recently created pod, which runs the last
+// attempt of a Job, or nil if there are no pods
+func LatestPod(pods []corev1.Pod) *corev1.Pod {
+	var latest *corev1.Pod
+	for i := range pods {
+		if latest == nil || latest.CreationTimestamp.Before(&pods[i].CreationTimestamp) {
+			latest = &pods[i]
+		}
+	}
+	return latest
+}
+
+// ReadLogTail reads a log keeping at most its last MaxArchivedLogSize bytes.
+// Indicates if the log was truncated.
+func ReadLogTail(r io.Reader) (string, bool, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", false, err
+	}
+	if len(data) <= MaxArchivedLogSize {
+		return string(data), false, nil
+	}
+	return string(data[len(data)-MaxArchivedLogSize:]), true, nil
+}
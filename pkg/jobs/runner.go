@@ -0,0 +1,13 @@
+package jobs
+
+// RunnerName returns the name of the ServiceAccount a stack's Jobs run as,
+// and of the Role and RoleBinding giving it access to the stack
+func RunnerName(stack string) string {
+	return stack + "-runner"
+}
+
+// SensitiveOutputsName returns the name of the Secret where a stack's runs
+// store the values of its sensitive outputs
+func SensitiveOutputsName(stack string) string {
+	return stack + "-outputs"
+}
@@ -0,0 +1,114 @@
+package source
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/pablochacin/tf-operator/pkg/tfconfig"
+)
+
+const (
+	// prefix of sha256 digests
+	sha256Prefix = "sha256:"
+)
+
+// HTTPCredentials defines the credentials for basic authentication, taken
+// from a Secret using the same keys as the git credentials
+type HTTPCredentials struct {
+	Username string
+	Password string
+}
+
+// NewHTTPCredentials returns the credentials from the content of a Secret
+func NewHTTPCredentials(data map[string][]byte) *HTTPCredentials {
+	return &HTTPCredentials{
+		Username: string(data[GitUsernameKey]),
+		Password: string(data[GitPasswordKey]),
+	}
+}
+
+// Archive gives access to a tar.gz archive served over http
+type Archive struct {
+	client      *http.Client
+	url         string
+	credentials *HTTPCredentials
+}
+
+// NewArchive builds an Archive for an url
+func NewArchive(url string, credentials *HTTPCredentials) *Archive {
+	return &Archive{
+		client:      http.DefaultClient,
+		url:         url,
+		credentials: credentials,
+	}
+}
+
+// Fetch downloads the archive, verifies it has the expected sha256 (hex
+// encoded, optionally prefixed with sha256:) and extracts it into a
+// destination directory
+func (a *Archive) Fetch(sum string, dest string) error {
+	expected := strings.TrimPrefix(strings.ToLower(sum), sha256Prefix)
+	if expected == "" {
+		return fmt.Errorf("sha256 of archive %s must be specified", a.url)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, a.url, nil)
+	if err != nil {
+		return fmt.Errorf("invalid archive url %s: %v", a.url, err)
+	}
+	if a.credentials != nil && a.credentials.Username != "" {
+		req.SetBasicAuth(a.credentials.Username, a.credentials.Password)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error downloading archive %s: %v", a.url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error downloading archive %s: %s", a.url, resp.Status)
+	}
+
+	archive, err := downloadVerified(resp.Body, expected)
+	if err != nil {
+		return fmt.Errorf("archive %s: %v", a.url, err)
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	return tfconfig.ExtractTarGz(archive, dest)
+}
+
+// downloadVerified stores the content of a reader in a temporary file and
+// verifies its sha256 matches the expected hex encoded digest. Returns the
+// file positioned at its beginning.
+func downloadVerified(r io.Reader, expected string) (*os.File, error) {
+	tmp, err := ioutil.TempFile("", "download")
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), r)
+	if err == nil {
+		actual := fmt.Sprintf("%x", h.Sum(nil))
+		if actual != expected {
+			err = fmt.Errorf("digest mismatch: expected sha256:%s got sha256:%s", expected, actual)
+		}
+	}
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	return tmp, nil
+}
@@ -0,0 +1,126 @@
+package source
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// createTarGz returns a tar.gz archive with the given files
+func createTarGz(files map[string]string) []byte {
+	buf := new(bytes.Buffer)
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		header := &tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		}
+		Expect(tw.WriteHeader(header)).To(Succeed())
+		_, err := tw.Write([]byte(content))
+		Expect(err).NotTo(HaveOccurred())
+	}
+	Expect(tw.Close()).To(Succeed())
+	Expect(gz.Close()).To(Succeed())
+	return buf.Bytes()
+}
+
+// sha256Hex returns the hex encoded sha256 of a content
+func sha256Hex(content []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(content))
+}
+
+var _ = Describe("Archive Source", func() {
+	var (
+		server  *httptest.Server
+		archive []byte
+		dest    string
+		sum     string
+		err     error
+	)
+
+	BeforeEach(func() {
+		archive = createTarGz(map[string]string{
+			"main.tf":          main_tf,
+			"modules/net/a.tf": module_tf,
+		})
+		sum = sha256Hex(archive)
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, pass, ok := r.BasicAuth()
+			if r.URL.Path == "/private.tar.gz" && (!ok || user != "user" || pass != "pass") {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write(archive)
+		}))
+		dest, _ = ioutil.TempDir("", "tfconfig")
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(dest)
+	})
+
+	Context("Fetch archive with valid sha256", func() {
+		BeforeEach(func() {
+			err = NewArchive(server.URL+"/bundle.tar.gz", nil).Fetch("sha256:"+sum, dest)
+		})
+
+		It("Should extract the files", func() {
+			Expect(err).NotTo(HaveOccurred())
+			content, _ := ioutil.ReadFile(filepath.Join(dest, "main.tf"))
+			Expect(string(content)).To(Equal(main_tf))
+			Expect(filepath.Join(dest, "modules", "net", "a.tf")).To(BeAnExistingFile())
+		})
+	})
+
+	Context("Fetch archive with invalid sha256", func() {
+		BeforeEach(func() {
+			err = NewArchive(server.URL+"/bundle.tar.gz", nil).Fetch(sha256Hex([]byte("other")), dest)
+		})
+
+		It("Should fail without extracting files", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("digest mismatch"))
+			Expect(filepath.Join(dest, "main.tf")).NotTo(BeAnExistingFile())
+		})
+	})
+
+	Context("Fetch archive with credentials", func() {
+		It("Should fail without credentials", func() {
+			err = NewArchive(server.URL+"/private.tar.gz", nil).Fetch(sum, dest)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Should authenticate", func() {
+			credentials := &HTTPCredentials{Username: "user", Password: "pass"}
+			err = NewArchive(server.URL+"/private.tar.gz", credentials).Fetch(sum, dest)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("Fetch archive with entries outside destination", func() {
+		BeforeEach(func() {
+			archive = createTarGz(map[string]string{"../../escape.tf": main_tf})
+			sum = sha256Hex(archive)
+			err = NewArchive(server.URL+"/bundle.tar.gz", nil).Fetch(sum, dest)
+		})
+
+		It("Should keep the files in the destination", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(filepath.Join(dest, "escape.tf")).To(BeAnExistingFile())
+		})
+	})
+})
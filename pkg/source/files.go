@@ -0,0 +1,56 @@
+package source
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// copyDir copies recursively the content of a directory into a destination
+// directory, skipping the entries with the given names
+func copyDir(src string, dest string, skip []string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		for _, name := range skip {
+			if info.Name() == name {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		destPath := filepath.Join(dest, relPath)
+
+		if info.IsDir() {
+			return os.MkdirAll(destPath, os.ModePerm)
+		}
+
+		return copyFile(path, destPath, info.Mode())
+	})
+}
+
+// copyFile copies a file to a destination path with the given permissions
+func copyFile(src string, dest string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	return err
+}
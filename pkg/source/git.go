@@ -0,0 +1,232 @@
+package source
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pablochacin/tf-operator/pkg/cmdrunner"
+)
+
+const (
+	// keys of the credentials in a Secret, following the kubernetes.io/basic-auth
+	// and kubernetes.io/ssh-auth Secret types
+	GitUsernameKey      = "username"
+	GitPasswordKey      = "password"
+	GitSSHPrivateKeyKey = "ssh-privatekey"
+	GitKnownHostsKey    = "known_hosts"
+)
+
+var (
+	commitRegexp = regexp.MustCompile("^[0-9a-f]{40}$")
+)
+
+// GitCredentials defines the credentials for accessing a git repository
+// using https (username and password) or ssh (private key)
+type GitCredentials struct {
+	Username      string
+	Password      string
+	SSHPrivateKey []byte
+	KnownHosts    []byte
+}
+
+// NewGitCredentials returns the credentials from the content of a Secret
+func NewGitCredentials(data map[string][]byte) *GitCredentials {
+	return &GitCredentials{
+		Username:      string(data[GitUsernameKey]),
+		Password:      string(data[GitPasswordKey]),
+		SSHPrivateKey: data[GitSSHPrivateKeyKey],
+		KnownHosts:    data[GitKnownHostsKey],
+	}
+}
+
+// Git gives access to a remote git repository using the git command
+type Git struct {
+	runner      cmdrunner.Runner
+	url         string
+	credentials *GitCredentials
+}
+
+// NewGitWithCmdRunner builds a Git with a given command runner
+func NewGitWithCmdRunner(runner cmdrunner.Runner, url string, credentials *GitCredentials) *Git {
+	runner.SetInheritEnv(true)
+	return &Git{
+		runner:      runner,
+		url:         url,
+		credentials: credentials,
+	}
+}
+
+// NewGit builds a Git with a default command runner
+func NewGit(url string, credentials *GitCredentials) *Git {
+	return NewGitWithCmdRunner(cmdrunner.New(), url, credentials)
+}
+
+// ResolveRef returns the commit a reference points to in the remote repository.
+// At most one of branch, tag or commit can be given. If none is given, the
+// repository's HEAD is resolved.
+func (g *Git) ResolveRef(branch string, tag string, commit string) (string, error) {
+	if countRefs(branch, tag, commit) > 1 {
+		return "", fmt.Errorf("only one of branch, tag or commit can be specified")
+	}
+	if commit != "" {
+		if !commitRegexp.MatchString(commit) {
+			return "", fmt.Errorf("invalid commit %s: a full sha is expected", commit)
+		}
+		return commit, nil
+	}
+
+	refs := []string{"HEAD"}
+	switch {
+	case branch != "":
+		refs = []string{"refs/heads/" + branch}
+	case tag != "":
+		// annotated tags must be resolved to the commit they point to
+		refs = []string{"refs/tags/" + tag + "^{}", "refs/tags/" + tag}
+	}
+
+	output, err := g.git("", "ls-remote", g.url)
+	if err != nil {
+		return "", err
+	}
+
+	remoteRefs := map[string]string{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			remoteRefs[fields[1]] = fields[0]
+		}
+	}
+
+	for _, ref := range refs {
+		if sha, found := remoteRefs[ref]; found {
+			return sha, nil
+		}
+	}
+
+	return "", fmt.Errorf("reference %s not found in repository %s", refs[len(refs)-1], g.url)
+}
+
+// countRefs returns the number of references given
+func countRefs(refs ...string) int {
+	count := 0
+	for _, ref := range refs {
+		if ref != "" {
+			count++
+		}
+	}
+	return count
+}
+
+// Fetch copies the content of a subdirectory of the repository at a given
+// commit into a destination directory
+func (g *Git) Fetch(commit string, subdir string, dest string) error {
+	cloneDir, err := ioutil.TempDir("", "git")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(cloneDir)
+
+	_, err = g.git("", "clone", "--quiet", "--no-checkout", g.url, cloneDir)
+	if err != nil {
+		return err
+	}
+
+	_, err = g.git(cloneDir, "checkout", "--quiet", "--detach", commit)
+	if err != nil {
+		return err
+	}
+
+	srcDir := filepath.Join(cloneDir, filepath.Clean("/"+subdir))
+	info, err := os.Stat(srcDir)
+	if err != nil || !info.IsDir() {
+		return fmt.Errorf("subdirectory %s not found at commit %s", subdir, commit)
+	}
+
+	return copyDir(srcDir, dest, []string{".git"})
+}
+
+// git runs a git command in a working directory, using the credentials
+// if given
+func (g *Git) git(workDir string, args ...string) (string, error) {
+	credArgs, cleanup, err := g.credentialArgs()
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+
+	g.runner.SetWorkDir(workDir)
+	result, err := g.runner.Run("git", append(credArgs, args...)...)
+	if err != nil {
+		return "", err
+	}
+	if result.ExitCode != 0 {
+		return "", fmt.Errorf("git %s failed: %s", args[0], strings.TrimSpace(result.Output))
+	}
+
+	return result.Output, nil
+}
+
+// credentialArgs returns the git configuration options for using the
+// credentials. Credentials are stored in temporary files to prevent them
+// to be exposed as command arguments. The returned function removes them.
+func (g *Git) credentialArgs() ([]string, func(), error) {
+	noop := func() {}
+	if g.credentials == nil {
+		return []string{}, noop, nil
+	}
+
+	// the host key of the repository is always verified, as an unverified
+	// host could impersonate it and serve another configuration
+	if len(g.credentials.SSHPrivateKey) > 0 && len(g.credentials.KnownHosts) == 0 {
+		return nil, noop, fmt.Errorf("ssh credentials for repository %s have no %s for verifying its host key", g.url, GitKnownHostsKey)
+	}
+
+	credDir, err := ioutil.TempDir("", "git-credentials")
+	if err != nil {
+		return nil, noop, err
+	}
+	cleanup := func() { os.RemoveAll(credDir) }
+
+	args := []string{}
+	if len(g.credentials.SSHPrivateKey) > 0 {
+		keyFile := filepath.Join(credDir, GitSSHPrivateKeyKey)
+		err = ioutil.WriteFile(keyFile, g.credentials.SSHPrivateKey, 0600)
+		if err != nil {
+			cleanup()
+			return nil, noop, err
+		}
+		hostsFile := filepath.Join(credDir, GitKnownHostsKey)
+		err = ioutil.WriteFile(hostsFile, g.credentials.KnownHosts, 0600)
+		if err != nil {
+			cleanup()
+			return nil, noop, err
+		}
+		sshCmd := "ssh -i " + keyFile + " -o IdentitiesOnly=yes" +
+			" -o UserKnownHostsFile=" + hostsFile + " -o StrictHostKeyChecking=yes"
+		args = append(args, "-c", "core.sshCommand="+sshCmd)
+	}
+
+	if g.credentials.Username != "" {
+		repoURL, err := url.Parse(g.url)
+		if err != nil {
+			cleanup()
+			return nil, noop, fmt.Errorf("invalid repository url %s: %v", g.url, err)
+		}
+		repoURL.User = url.UserPassword(g.credentials.Username, g.credentials.Password)
+		repoURL.Path = ""
+		storeFile := filepath.Join(credDir, "store")
+		err = ioutil.WriteFile(storeFile, []byte(repoURL.String()+"\n"), 0600)
+		if err != nil {
+			cleanup()
+			return nil, noop, err
+		}
+		args = append(args, "-c", "credential.helper=store --file="+storeFile)
+	}
+
+	return args, cleanup, nil
+}
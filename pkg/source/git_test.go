@@ -0,0 +1,218 @@
+package source
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	main_tf = `
+output "greetings" {
+    value = "Hello"
+}
+`
+	module_tf = `
+variable "name" {}
+`
+)
+
+func TestSource(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Source Suite")
+}
+
+// runGit runs a git command in a directory and returns its output
+func runGit(dir string, args ...string) string {
+	args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	Expect(err).NotTo(HaveOccurred(), string(output))
+	return strings.TrimSpace(string(output))
+}
+
+// createBareRepo creates a bare repository with a commit adding the given files
+// in the master branch and returns its path
+func createBareRepo(files map[string]string) string {
+	root, err := ioutil.TempDir("", "repo")
+	Expect(err).NotTo(HaveOccurred())
+
+	bare := filepath.Join(root, "repo.git")
+	work := filepath.Join(root, "work")
+	runGit(root, "init", "--quiet", "--bare", bare)
+	runGit(root, "clone", "--quiet", bare, work)
+	runGit(work, "checkout", "--quiet", "-b", "master")
+	commitFiles(work, files)
+
+	return bare
+}
+
+// commitFiles writes files in a working copy, commits and pushes them
+func commitFiles(work string, files map[string]string) {
+	for path, content := range files {
+		absPath := filepath.Join(work, path)
+		Expect(os.MkdirAll(filepath.Dir(absPath), os.ModePerm)).To(Succeed())
+		Expect(ioutil.WriteFile(absPath, []byte(content), 0666)).To(Succeed())
+	}
+	runGit(work, "add", ".")
+	runGit(work, "commit", "--quiet", "-m", "update")
+	runGit(work, "push", "--quiet", "origin", "HEAD")
+}
+
+var _ = Describe("Git Source", func() {
+	var (
+		bare    string
+		work    string
+		repoURL string
+		git     *Git
+		commit  string
+		err     error
+	)
+
+	BeforeEach(func() {
+		bare = createBareRepo(map[string]string{
+			"stacks/hello/main.tf":        main_tf,
+			"stacks/hello/modules/a/a.tf": module_tf,
+		})
+		work = filepath.Join(filepath.Dir(bare), "work")
+		repoURL = "file://" + bare
+		git = NewGit(repoURL, nil)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(filepath.Dir(bare))
+	})
+
+	Context("Resolve references", func() {
+		It("Should resolve HEAD by default", func() {
+			commit, err = git.ResolveRef("", "", "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(commit).To(Equal(runGit(work, "rev-parse", "HEAD")))
+		})
+
+		It("Should resolve a branch", func() {
+			commit, err = git.ResolveRef("master", "", "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(commit).To(Equal(runGit(work, "rev-parse", "HEAD")))
+		})
+
+		It("Should resolve an annotated tag to its commit", func() {
+			runGit(work, "tag", "-a", "v1.0", "-m", "release")
+			runGit(work, "push", "--quiet", "origin", "v1.0")
+			commit, err = git.ResolveRef("", "v1.0", "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(commit).To(Equal(runGit(work, "rev-parse", "HEAD")))
+		})
+
+		It("Should follow new commits in a branch", func() {
+			first, _ := git.ResolveRef("master", "", "")
+			commitFiles(work, map[string]string{"stacks/hello/other.tf": module_tf})
+			commit, err = git.ResolveRef("master", "", "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(commit).NotTo(Equal(first))
+		})
+
+		It("Should fail for an unknown branch", func() {
+			_, err = git.ResolveRef("unknown", "", "")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Should reject more than one reference", func() {
+			_, err = git.ResolveRef("master", "v1.0", "")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Should reject an abbreviated commit", func() {
+			_, err = git.ResolveRef("", "", "abc123")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Fetch files", func() {
+		var (
+			dest string
+		)
+
+		BeforeEach(func() {
+			dest, err = ioutil.TempDir("", "tfconfig")
+			Expect(err).NotTo(HaveOccurred())
+			commit, err = git.ResolveRef("master", "", "")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dest)
+		})
+
+		It("Should copy the subdirectory including nested modules", func() {
+			err = git.Fetch(commit, "stacks/hello", dest)
+			Expect(err).NotTo(HaveOccurred())
+			content, err := ioutil.ReadFile(filepath.Join(dest, "main.tf"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal(main_tf))
+			Expect(filepath.Join(dest, "modules", "a", "a.tf")).To(BeAnExistingFile())
+		})
+
+		It("Should fetch the requested commit", func() {
+			commitFiles(work, map[string]string{"stacks/hello/main.tf": module_tf})
+			err = git.Fetch(commit, "stacks/hello", dest)
+			Expect(err).NotTo(HaveOccurred())
+			content, _ := ioutil.ReadFile(filepath.Join(dest, "main.tf"))
+			Expect(string(content)).To(Equal(main_tf))
+		})
+
+		It("Should not copy git metadata", func() {
+			err = git.Fetch(commit, "", dest)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(filepath.Join(dest, ".git")).NotTo(BeADirectory())
+			Expect(filepath.Join(dest, "stacks", "hello", "main.tf")).To(BeAnExistingFile())
+		})
+
+		It("Should fail for a missing subdirectory", func() {
+			err = git.Fetch(commit, "stacks/missing", dest)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Use credentials", func() {
+		It("Should not expose the password in the command arguments", func() {
+			git = NewGit("https://example.com/repo.git", NewGitCredentials(map[string][]byte{
+				GitUsernameKey: []byte("user"),
+				GitPasswordKey: []byte("s3cret"),
+			}))
+			args, cleanup, err := git.credentialArgs()
+			defer cleanup()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(strings.Join(args, " ")).NotTo(ContainSubstring("s3cret"))
+			Expect(strings.Join(args, " ")).To(ContainSubstring("credential.helper=store"))
+		})
+
+		It("Should use the ssh private key", func() {
+			git = NewGit("ssh://git@example.com/repo.git", NewGitCredentials(map[string][]byte{
+				GitSSHPrivateKeyKey: []byte("key"),
+				GitKnownHostsKey:    []byte("example.com ssh-ed25519 AAAA"),
+			}))
+			args, cleanup, err := git.credentialArgs()
+			defer cleanup()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(strings.Join(args, " ")).To(ContainSubstring("core.sshCommand=ssh -i"))
+			Expect(strings.Join(args, " ")).To(ContainSubstring("StrictHostKeyChecking=yes"))
+		})
+
+		It("Should require the known hosts for ssh", func() {
+			git = NewGit("ssh://git@example.com/repo.git", NewGitCredentials(map[string][]byte{
+				GitSSHPrivateKeyKey: []byte("key"),
+			}))
+			_, cleanup, err := git.credentialArgs()
+			defer cleanup()
+			Expect(err).To(MatchError(ContainSubstring("no known_hosts")))
+		})
+	})
+})
@@ -0,0 +1,307 @@
+package source
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pablochacin/tf-operator/pkg/tfconfig"
+)
+
+const (
+	// registry used for references without a registry
+	defaultRegistry = "registry-1.docker.io"
+
+	// media types accepted for the artifact's manifest
+	ociManifestMediaType    = "application/vnd.oci.image.manifest.v1+json"
+	dockerManifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+
+	// suffix of the media type of the layers with the configuration
+	tarGzipMediaTypeSuffix = "tar+gzip"
+)
+
+var (
+	authParamRegexp = regexp.MustCompile(`(\w+)="([^"]*)"`)
+)
+
+// ociReference is a parsed reference to an artifact in a registry
+type ociReference struct {
+	registry   string
+	repository string
+	tag        string
+	digest     string
+}
+
+// parseOCIReference parses a reference in the form registry/repository:tag
+// or registry/repository@digest
+func parseOCIReference(reference string) (*ociReference, error) {
+	ref := &ociReference{}
+	name := reference
+	if i := strings.Index(name, "@"); i >= 0 {
+		ref.digest = name[i+1:]
+		name = name[:i]
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		ref.tag = name[i+1:]
+		name = name[:i]
+	}
+
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		ref.registry = parts[0]
+		ref.repository = parts[1]
+	} else {
+		ref.registry = defaultRegistry
+		ref.repository = name
+		if len(parts) == 1 {
+			ref.repository = "library/" + name
+		}
+	}
+
+	if ref.repository == "" {
+		return nil, fmt.Errorf("invalid reference %s", reference)
+	}
+	if ref.tag == "" && ref.digest == "" {
+		ref.tag = "latest"
+	}
+
+	return ref, nil
+}
+
+// ociManifest is the subset of an image manifest used for fetching an artifact
+type ociManifest struct {
+	Layers []ociDescriptor `json:"layers"`
+}
+
+// ociDescriptor describes a content in the registry
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// OCI gives access to artifacts with configurations stored in an OCI registry
+type OCI struct {
+	client      *http.Client
+	scheme      string
+	credentials *HTTPCredentials
+	token       string
+}
+
+// NewOCI builds an OCI registry client. Insecure registries are accessed
+// using http.
+func NewOCI(insecure bool, credentials *HTTPCredentials) *OCI {
+	scheme := "https"
+	if insecure {
+		scheme = "http"
+	}
+	return &OCI{
+		client:      http.DefaultClient,
+		scheme:      scheme,
+		credentials: credentials,
+	}
+}
+
+// ResolveDigest returns the digest of the manifest a reference points to
+func (o *OCI) ResolveDigest(reference string) (string, error) {
+	ref, err := parseOCIReference(reference)
+	if err != nil {
+		return "", err
+	}
+
+	_, digest, err := o.getManifest(ref, ref.digest)
+	return digest, err
+}
+
+// Fetch downloads the artifact a reference points to and extracts the
+// configuration into a destination directory. If a digest is given, the
+// artifact's manifest must match it.
+func (o *OCI) Fetch(reference string, digest string, dest string) error {
+	ref, err := parseOCIReference(reference)
+	if err != nil {
+		return err
+	}
+	if digest == "" {
+		digest = ref.digest
+	}
+
+	manifest, _, err := o.getManifest(ref, digest)
+	if err != nil {
+		return err
+	}
+
+	layer, err := configLayer(manifest)
+	if err != nil {
+		return fmt.Errorf("artifact %s: %v", reference, err)
+	}
+
+	resp, err := o.get(ref, "blobs/"+layer.Digest, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	blob, err := downloadVerified(resp.Body, strings.TrimPrefix(layer.Digest, sha256Prefix))
+	if err != nil {
+		return fmt.Errorf("layer %s: %v", layer.Digest, err)
+	}
+	defer os.Remove(blob.Name())
+	defer blob.Close()
+
+	return tfconfig.ExtractTarGz(blob, dest)
+}
+
+// getManifest returns the manifest of a reference and its digest. If a
+// digest is given, it is used for getting the manifest, which must match it.
+func (o *OCI) getManifest(ref *ociReference, digest string) (*ociManifest, string, error) {
+	manifestRef := ref.tag
+	if digest != "" {
+		if !strings.HasPrefix(digest, sha256Prefix) {
+			return nil, "", fmt.Errorf("unsupported digest %s: only sha256 is supported", digest)
+		}
+		manifestRef = digest
+	}
+
+	resp, err := o.get(ref, "manifests/"+manifestRef, ociManifestMediaType+", "+dockerManifestMediaType)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	actual := fmt.Sprintf("%s%x", sha256Prefix, sha256.Sum256(body))
+	if digest != "" && actual != digest {
+		return nil, "", fmt.Errorf("digest mismatch: expected %s got %s", digest, actual)
+	}
+
+	manifest := &ociManifest{}
+	err = json.Unmarshal(body, manifest)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid manifest for %s/%s: %v", ref.registry, ref.repository, err)
+	}
+
+	return manifest, actual, nil
+}
+
+// configLayer returns the layer with the configuration from a manifest:
+// the first layer with a tar+gzip media type, or the only layer
+func configLayer(manifest *ociManifest) (*ociDescriptor, error) {
+	for i := range manifest.Layers {
+		if strings.HasSuffix(manifest.Layers[i].MediaType, tarGzipMediaTypeSuffix) {
+			return &manifest.Layers[i], nil
+		}
+	}
+	if len(manifest.Layers) == 1 {
+		return &manifest.Layers[0], nil
+	}
+
+	return nil, fmt.Errorf("no tar+gzip layer found")
+}
+
+// get requests a resource of a repository from the registry, authenticating
+// if requested by the registry
+func (o *OCI) get(ref *ociReference, resource string, accept string) (*http.Response, error) {
+	resourceURL := fmt.Sprintf("%s://%s/v2/%s/%s", o.scheme, ref.registry, ref.repository, resource)
+	do := func() (*http.Response, error) {
+		req, err := http.NewRequest(http.MethodGet, resourceURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if o.token != "" {
+			req.Header.Set("Authorization", "Bearer "+o.token)
+		} else if o.credentials != nil && o.credentials.Username != "" {
+			req.SetBasicAuth(o.credentials.Username, o.credentials.Password)
+		}
+		return o.client.Do(req)
+	}
+
+	resp, err := do()
+	if err != nil {
+		return nil, fmt.Errorf("error accessing %s: %v", resourceURL, err)
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	if resp.StatusCode == http.StatusUnauthorized && strings.HasPrefix(challenge, "Bearer ") && o.token == "" {
+		resp.Body.Close()
+		o.token, err = o.requestToken(challenge)
+		if err != nil {
+			return nil, err
+		}
+		resp, err = do()
+		if err != nil {
+			return nil, fmt.Errorf("error accessing %s: %v", resourceURL, err)
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("error accessing %s: %s", resourceURL, resp.Status)
+	}
+
+	return resp, nil
+}
+
+// requestToken requests a bearer token from the authorization service
+// given in a registry's challenge
+func (o *OCI) requestToken(challenge string) (string, error) {
+	params := map[string]string{}
+	for _, match := range authParamRegexp.FindAllStringSubmatch(challenge, -1) {
+		params[match[1]] = match[2]
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("invalid authentication realm in %s", challenge)
+	}
+	query := realm.Query()
+	for _, param := range []string{"service", "scope"} {
+		if params[param] != "" {
+			query.Set(param, params[param])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if o.credentials != nil && o.credentials.Username != "" {
+		req.SetBasicAuth(o.credentials.Username, o.credentials.Password)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error requesting token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error requesting token: %s", resp.Status)
+	}
+
+	token := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return "", fmt.Errorf("invalid token response: %v", err)
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+
+	return token.AccessToken, nil
+}
@@ -0,0 +1,160 @@
+package source
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeRegistry serves a single artifact with a tar.gz layer, optionally
+// requiring a bearer token
+type fakeRegistry struct {
+	manifest    []byte
+	layer       []byte
+	requireAuth bool
+	server      *httptest.Server
+}
+
+func newFakeRegistry(layer []byte, requireAuth bool) *fakeRegistry {
+	reg := &fakeRegistry{layer: layer, requireAuth: requireAuth}
+	reg.manifest, _ = json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     ociManifestMediaType,
+		"layers": []ociDescriptor{
+			{
+				MediaType: "application/vnd.tf-operator.config.v1.tar+gzip",
+				Digest:    "sha256:" + sha256Hex(layer),
+				Size:      int64(len(layer)),
+			},
+		},
+	})
+	reg.server = httptest.NewServer(http.HandlerFunc(reg.serve))
+	return reg
+}
+
+func (reg *fakeRegistry) serve(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/token" {
+		user, pass, _ := r.BasicAuth()
+		if user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"token": "secret-token"}`))
+		return
+	}
+
+	if reg.requireAuth && r.Header.Get("Authorization") != "Bearer secret-token" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+reg.server.URL+`/token",service="registry",scope="repository:modules/network:pull"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	manifestDigest := "sha256:" + sha256Hex(reg.manifest)
+	switch r.URL.Path {
+	case "/v2/modules/network/manifests/1.0", "/v2/modules/network/manifests/" + manifestDigest:
+		w.Header().Set("Content-Type", ociManifestMediaType)
+		w.Write(reg.manifest)
+	case "/v2/modules/network/blobs/sha256:" + sha256Hex(reg.layer):
+		w.Write(reg.layer)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// reference returns the reference to the artifact in the registry
+func (reg *fakeRegistry) reference(tag string) string {
+	return strings.TrimPrefix(reg.server.URL, "http://") + "/modules/network" + tag
+}
+
+var _ = Describe("OCI Source", func() {
+	var (
+		registry *fakeRegistry
+		dest     string
+		digest   string
+		err      error
+	)
+
+	BeforeEach(func() {
+		registry = newFakeRegistry(createTarGz(map[string]string{"main.tf": main_tf}), false)
+		dest, _ = ioutil.TempDir("", "tfconfig")
+	})
+
+	AfterEach(func() {
+		registry.server.Close()
+		os.RemoveAll(dest)
+	})
+
+	Context("Parse references", func() {
+		It("Should parse registry, repository and tag", func() {
+			ref, err := parseOCIReference("localhost:5000/modules/network:1.0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*ref).To(Equal(ociReference{registry: "localhost:5000", repository: "modules/network", tag: "1.0"}))
+		})
+
+		It("Should parse digests", func() {
+			ref, err := parseOCIReference("example.com/network@sha256:abc")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ref.digest).To(Equal("sha256:abc"))
+			Expect(ref.tag).To(BeEmpty())
+		})
+
+		It("Should default to docker hub and latest", func() {
+			ref, err := parseOCIReference("network")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*ref).To(Equal(ociReference{registry: defaultRegistry, repository: "library/network", tag: "latest"}))
+		})
+	})
+
+	Context("Resolve and fetch artifact", func() {
+		BeforeEach(func() {
+			digest, err = NewOCI(true, nil).ResolveDigest(registry.reference(":1.0"))
+		})
+
+		It("Should resolve the manifest digest", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(digest).To(Equal("sha256:" + sha256Hex(registry.manifest)))
+		})
+
+		It("Should fetch the pinned digest", func() {
+			err = NewOCI(true, nil).Fetch(registry.reference(":1.0"), digest, dest)
+			Expect(err).NotTo(HaveOccurred())
+			content, _ := ioutil.ReadFile(filepath.Join(dest, "main.tf"))
+			Expect(string(content)).To(Equal(main_tf))
+		})
+
+		It("Should fetch a reference by digest", func() {
+			err = NewOCI(true, nil).Fetch(registry.reference("@"+digest), "", dest)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should reject a different digest", func() {
+			err = NewOCI(true, nil).Fetch(registry.reference(":1.0"), "sha256:"+sha256Hex([]byte("other")), dest)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Access registry with token authentication", func() {
+		BeforeEach(func() {
+			registry.requireAuth = true
+		})
+
+		It("Should fail without credentials", func() {
+			_, err = NewOCI(true, nil).ResolveDigest(registry.reference(":1.0"))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Should request a token with the credentials", func() {
+			credentials := &HTTPCredentials{Username: "user", Password: "pass"}
+			err = NewOCI(true, credentials).Fetch(registry.reference(":1.0"), "", dest)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(filepath.Join(dest, "main.tf")).To(BeAnExistingFile())
+		})
+	})
+})
@@ -0,0 +1,321 @@
+package statelock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+)
+
+const (
+	// InfoAnnotation is the annotation of the lock's Lease with its holder's
+	// info
+	InfoAnnotation = "tf.tf-operator.io/lock-info"
+
+	// DefaultTTL is the time to live of the locks held by clients, which
+	// renew them while they are held
+	DefaultTTL = 30 * time.Second
+)
+
+// Info describes the holder of a state lock. It has the fields of the lock
+// info of terraform, so the locks it holds are described as well.
+type Info struct {
+	ID        string
+	Operation string
+	Info      string
+	Who       string
+	Version   string
+	Created   time.Time
+	Path      string
+}
+
+// String describes the lock's holder
+func (i *Info) String() string {
+	return fmt.Sprintf("%s (%s, lock ID %s) since %s", i.Who, i.Operation, i.ID, i.Created.UTC().Format(time.RFC3339))
+}
+
+// LockedError reports the state is locked by another holder
+type LockedError struct {
+	Stack  string
+	Holder Info
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("state of stack %s is locked by %s", e.Stack, e.Holder.String())
+}
+
+// IsLocked indicates if an error reports the state is locked by another
+// holder
+func IsLocked(err error) bool {
+	_, ok := err.(*LockedError)
+	return ok
+}
+
+// LeaseName returns the name of the Lease holding the lock of a stack's state
+func LeaseName(stack string) string {
+	return stack + "-tfstate-lock"
+}
+
+// RunLockID returns the ID of the lock held for a run of a stack, from before
+// its Job is created until it ends
+func RunLockID(stack string, run int64) string {
+	return fmt.Sprintf("%s-run-%d", stack, run)
+}
+
+// RunInfo returns the info of the holder of the lock of a run of a stack
+func RunInfo(stack string, run int64) Info {
+	return Info{
+		ID:        RunLockID(stack, run),
+		Operation: "run",
+		Info:      fmt.Sprintf("run %d", run),
+		Who:       "tf-operator",
+	}
+}
+
+// Locker gives access to the lock of a stack's state, held in a Lease
+type Locker struct {
+	client    client.Client
+	namespace string
+	stack     string
+	owner     *metav1.OwnerReference
+}
+
+// New returns a Locker for the state of a stack which may not exist yet
+func New(c client.Client, namespace string, stack string) *Locker {
+	return &Locker{
+		client:    c,
+		namespace: namespace,
+		stack:     stack,
+	}
+}
+
+// ForStack returns a Locker for the state of a stack, whose Lease is owned
+// by it
+func ForStack(c client.Client, stack *tfo.Stack) *Locker {
+	locker := New(c, stack.Namespace, stack.Name)
+	locker.owner = metav1.NewControllerRef(stack, tfo.GroupVersion.WithKind("Stack"))
+	return locker
+}
+
+// Lock is a state lock held
+type Lock struct {
+	locker *Locker
+	ttl    time.Duration
+	Info   Info
+}
+
+// Acquire locks the state for a holder, which must renew the lock before its
+// ttl expires. A ttl of 0 means the lock doesn't expire. An ID and creation
+// time are set in the holder's info if it doesn't have them. If the lock is
+// already held by a holder with the same ID, it is returned. Locks not renewed
+// in time are stale and are taken over.
+func (l *Locker) Acquire(ctx context.Context, info Info, ttl time.Duration) (*Lock, error) {
+	if info.ID == "" {
+		id, err := newID()
+		if err != nil {
+			return nil, err
+		}
+		info.ID = id
+	}
+	if info.Created.IsZero() {
+		info.Created = time.Now().UTC()
+	}
+	raw, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+
+	lease := &coordinationv1.Lease{}
+	err = l.client.Get(ctx, l.key(), lease)
+	if err != nil && !apierr.IsNotFound(err) {
+		return nil, err
+	}
+	exists := err == nil
+
+	if exists {
+		holder := leaseInfo(lease)
+		if holder.ID == info.ID {
+			return &Lock{locker: l, ttl: ttl, Info: *holder}, nil
+		}
+		if !isStale(lease) {
+			return nil, &LockedError{Stack: l.stack, Holder: *holder}
+		}
+	}
+
+	now := metav1.NowMicro()
+	lease.Name = LeaseName(l.stack)
+	lease.Namespace = l.namespace
+	lease.Labels = map[string]string{tfo.StackLabel: l.stack}
+	lease.Annotations = map[string]string{InfoAnnotation: string(raw)}
+	if l.owner != nil {
+		lease.OwnerReferences = []metav1.OwnerReference{*l.owner}
+	}
+	lease.Spec = coordinationv1.LeaseSpec{
+		HolderIdentity: &info.ID,
+		AcquireTime:    &now,
+		RenewTime:      &now,
+	}
+	if ttl > 0 {
+		seconds := int32(ttl.Seconds())
+		lease.Spec.LeaseDurationSeconds = &seconds
+	}
+
+	// another holder may have acquired the lock since it was read
+	if exists {
+		err = l.client.Update(ctx, lease)
+	} else {
+		err = l.client.Create(ctx, lease)
+	}
+	if apierr.IsConflict(err) || apierr.IsAlreadyExists(err) {
+		holder, getErr := l.Get(ctx)
+		if getErr == nil && holder != nil {
+			return nil, &LockedError{Stack: l.stack, Holder: *holder}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Lock{locker: l, ttl: ttl, Info: info}, nil
+}
+
+// Get returns the holder of the lock, or nil if the state is not locked or
+// its lock is stale
+func (l *Locker) Get(ctx context.Context) (*Info, error) {
+	lease := &coordinationv1.Lease{}
+	err := l.client.Get(ctx, l.key(), lease)
+	if apierr.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if isStale(lease) {
+		return nil, nil
+	}
+
+	return leaseInfo(lease), nil
+}
+
+// Unlock releases the lock with the given ID, whoever holds it, as when its
+// holder failed to release it. It fails if the lock has another ID, reporting
+// its holder. Unlocking a state that isn't locked succeeds.
+func (l *Locker) Unlock(ctx context.Context, id string) error {
+	lease := &coordinationv1.Lease{}
+	err := l.client.Get(ctx, l.key(), lease)
+	if apierr.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	holder := leaseInfo(lease)
+	if holder.ID != id {
+		return &LockedError{Stack: l.stack, Holder: *holder}
+	}
+
+	// the lock must not have been acquired again since it was read
+	err = l.client.Delete(ctx, lease, client.Preconditions{
+		UID:             &lease.UID,
+		ResourceVersion: &lease.ResourceVersion,
+	})
+	if apierr.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// Renew extends the lock for another ttl. It fails if the lock was lost, as
+// when it was taken over after becoming stale or was force unlocked.
+func (l *Lock) Renew(ctx context.Context) error {
+	lease := &coordinationv1.Lease{}
+	err := l.locker.client.Get(ctx, l.locker.key(), lease)
+	if apierr.IsNotFound(err) {
+		return fmt.Errorf("state lock %s of stack %s was released", l.Info.ID, l.locker.stack)
+	}
+	if err != nil {
+		return err
+	}
+	if holder := leaseInfo(lease); holder.ID != l.Info.ID {
+		return &LockedError{Stack: l.locker.stack, Holder: *holder}
+	}
+
+	now := metav1.NowMicro()
+	lease.Spec.RenewTime = &now
+	return l.locker.client.Update(ctx, lease)
+}
+
+// KeepAlive renews the lock in the background before its ttl expires, until
+// the returned function is called
+func (l *Lock) KeepAlive(ctx context.Context) (stop func()) {
+	if l.ttl <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				// a failed renewal is retried, as the lock is only lost
+				// when the ttl expires
+				l.Renew(ctx)
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// Release releases the lock
+func (l *Lock) Release(ctx context.Context) error {
+	return l.locker.Unlock(ctx, l.Info.ID)
+}
+
+// key returns the key of the lock's Lease
+func (l *Locker) key() client.ObjectKey {
+	return client.ObjectKey{Name: LeaseName(l.stack), Namespace: l.namespace}
+}
+
+// leaseInfo returns the info of a Lease's holder
+func leaseInfo(lease *coordinationv1.Lease) *Info {
+	info := &Info{}
+	json.Unmarshal([]byte(lease.Annotations[InfoAnnotation]), info)
+	if lease.Spec.HolderIdentity != nil {
+		info.ID = *lease.Spec.HolderIdentity
+	}
+	return info
+}
+
+// isStale indicates if a Lease expired without being renewed
+func isStale(lease *coordinationv1.Lease) bool {
+	if lease.Spec.LeaseDurationSeconds == nil || lease.Spec.RenewTime == nil {
+		return false
+	}
+	expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	return time.Now().After(expiry)
+}
+
+// newID returns a random lock ID
+func newID() (string, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
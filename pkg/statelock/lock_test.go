@@ -0,0 +1,126 @@
+package statelock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+)
+
+func TestStateLock(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "State Lock Suite")
+}
+
+const namespace = "test"
+
+var _ = Describe("State lock", func() {
+	var (
+		c      client.Client
+		stack  *tfo.Stack
+		locker *Locker
+		ctx    = context.TODO()
+	)
+
+	BeforeEach(func() {
+		stack = &tfo.Stack{
+			ObjectMeta: metav1.ObjectMeta{Name: "stack", Namespace: namespace, UID: "uid"},
+		}
+		sch := runtime.NewScheme()
+		Expect(tfo.AddToScheme(sch)).To(Succeed())
+		Expect(coordinationv1.AddToScheme(sch)).To(Succeed())
+		c = fake.NewFakeClientWithScheme(sch, stack.DeepCopy())
+		locker = ForStack(c, stack)
+	})
+
+	getLease := func() (*coordinationv1.Lease, error) {
+		lease := &coordinationv1.Lease{}
+		err := c.Get(ctx, client.ObjectKey{Name: LeaseName(stack.Name), Namespace: namespace}, lease)
+		return lease, err
+	}
+
+	It("Should hold the lock in a Lease owned by the stack", func() {
+		lock, err := locker.Acquire(ctx, Info{Operation: "push", Who: "user@host"}, DefaultTTL)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lock.Info.ID).NotTo(BeEmpty())
+
+		lease, err := getLease()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*lease.Spec.HolderIdentity).To(Equal(lock.Info.ID))
+		Expect(*lease.Spec.LeaseDurationSeconds).To(Equal(int32(30)))
+		Expect(lease.OwnerReferences[0].UID).To(Equal(stack.UID))
+
+		holder, err := locker.Get(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(holder.Who).To(Equal("user@host"))
+
+		Expect(lock.Release(ctx)).To(Succeed())
+		_, err = getLease()
+		Expect(apierr.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("Should reject another holder reporting the current one", func() {
+		lock, err := locker.Acquire(ctx, Info{Operation: "push", Who: "user@host"}, DefaultTTL)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = locker.Acquire(ctx, Info{Operation: "apply"}, 0)
+		Expect(IsLocked(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("locked by user@host (push, lock ID " + lock.Info.ID))
+	})
+
+	It("Should return the lock to the holder with the same ID", func() {
+		_, err := locker.Acquire(ctx, Info{ID: "l1", Operation: "apply"}, 0)
+		Expect(err).NotTo(HaveOccurred())
+
+		lock, err := locker.Acquire(ctx, Info{ID: "l1", Operation: "apply"}, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lock.Info.ID).To(Equal("l1"))
+	})
+
+	It("Should take over a stale lock", func() {
+		_, err := locker.Acquire(ctx, Info{ID: "l1"}, DefaultTTL)
+		Expect(err).NotTo(HaveOccurred())
+		lease, err := getLease()
+		Expect(err).NotTo(HaveOccurred())
+		expired := metav1.NewMicroTime(time.Now().Add(-time.Minute))
+		lease.Spec.RenewTime = &expired
+		Expect(c.Update(ctx, lease)).To(Succeed())
+
+		holder, err := locker.Get(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(holder).To(BeNil())
+
+		lock, err := locker.Acquire(ctx, Info{ID: "l2"}, DefaultTTL)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lock.Info.ID).To(Equal("l2"))
+	})
+
+	It("Should renew the lock while held", func() {
+		lock, err := locker.Acquire(ctx, Info{ID: "l1"}, DefaultTTL)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lock.Renew(ctx)).To(Succeed())
+
+		Expect(locker.Unlock(ctx, "l1")).To(Succeed())
+		Expect(lock.Renew(ctx)).NotTo(Succeed())
+	})
+
+	It("Should force unlock only the lock with the given ID", func() {
+		_, err := locker.Acquire(ctx, Info{ID: "l1"}, 0)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(IsLocked(locker.Unlock(ctx, "l2"))).To(BeTrue())
+		Expect(locker.Unlock(ctx, "l1")).To(Succeed())
+		Expect(locker.Unlock(ctx, "l1")).To(Succeed())
+	})
+})
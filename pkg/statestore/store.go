@@ -0,0 +1,461 @@
+package statestore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+	"github.com/pablochacin/tf-operator/pkg/encryption"
+	"github.com/pablochacin/tf-operator/pkg/terraform"
+)
+
+const (
+	// DefaultHistoryLimit is the number of state versions kept if not
+	// specified in the stack
+	DefaultHistoryLimit = 10
+
+	// annotations of the Secrets holding the state versions
+	serialAnnotation    = "tfstate.tf-operator.io/serial"
+	lineageAnnotation   = "tfstate.tf-operator.io/lineage"
+	runAnnotation       = "tfstate.tf-operator.io/run"
+	operationAnnotation = "tfstate.tf-operator.io/operation"
+	createdAnnotation   = "tfstate.tf-operator.io/created"
+
+	// operation of the version recording a state stored before the history
+	// was kept
+	initialOperation = "initial"
+)
+
+// Version is a version of a stack's state kept in its history
+type Version struct {
+	// Sequence number of the version in the stack's history
+	Number int64
+
+	// Serial and lineage of the state
+	Serial  int64
+	Lineage string
+
+	// Number of the run that stored the state, 0 if it was not stored by a run
+	Run int64
+
+	// Operation that stored the state
+	Operation string
+
+	// Time the state was stored
+	Created time.Time
+
+	// Secret holding the version
+	Secret string
+}
+
+// Store keeps the state of a stack in its tfstate Secret, recording the
+// states stored in a history of versions held in Secrets owned by the stack.
+// If the stack has encryption keys, the states are encrypted at rest.
+type Store struct {
+	client client.Client
+	stack  *tfo.Stack
+}
+
+// New returns the Store of a stack's state
+func New(c client.Client, stack *tfo.Stack) *Store {
+	return &Store{
+		client: c,
+		stack:  stack,
+	}
+}
+
+// SecretName returns the name of the Secret with a stack's state
+func SecretName(stack *tfo.Stack) string {
+	if stack.Status.TfState.Name != "" {
+		return stack.Status.TfState.Name
+	}
+	return stack.Name + "-tfstate"
+}
+
+// HistoryLimit returns the number of state versions to keep for a stack
+func HistoryLimit(stack *tfo.Stack) int {
+	if stack.Spec.StateHistoryLimit != nil && *stack.Spec.StateHistoryLimit > 0 {
+		return int(*stack.Spec.StateHistoryLimit)
+	}
+	return DefaultHistoryLimit
+}
+
+// Read returns the stored state, or nil if there is none
+func (s *Store) Read(ctx context.Context) ([]byte, error) {
+	secret := &corev1.Secret{}
+	err := s.client.Get(ctx, client.ObjectKey{Name: SecretName(s.stack), Namespace: s.stack.Namespace}, secret)
+	if apierr.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(secret.Data[terraform.StateKey]) == 0 {
+		return nil, nil
+	}
+	return s.decrypt(ctx, secret.Data[terraform.StateKey])
+}
+
+// Write stores a state, recording it as a new version stored by an operation
+// of a run, 0 if not stored by a run. As terraform stores the state several
+// times during a run, a run has at most one version, updated with the last
+// state it stores. The oldest versions beyond the stack's history limit are
+// deleted. If the stack has no tfstate Secret, it is created and referenced in
+// the stack's status. A state stored before the history was kept is recorded
+// first, so it can be rolled back to.
+func (s *Store) Write(ctx context.Context, content []byte, run int64, operation string) (*Version, error) {
+	_, err := terraform.ParseStateMeta(content)
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := s.Versions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		current, err := s.Read(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if current != nil {
+			initial, err := s.record(ctx, 1, current, 0, initialOperation)
+			if err != nil {
+				return nil, err
+			}
+			versions = append(versions, *initial)
+		}
+	}
+
+	err = s.writeSecret(ctx, content)
+	if err != nil {
+		return nil, err
+	}
+
+	number := int64(1)
+	if len(versions) > 0 {
+		last := versions[len(versions)-1]
+		if run > 0 && last.Run == run && last.Operation == operation {
+			return s.updateVersion(ctx, last.Number, content, run, operation)
+		}
+		number = last.Number + 1
+	}
+	version, err := s.record(ctx, number, content, run, operation)
+	if err != nil {
+		return nil, err
+	}
+	versions = append(versions, *version)
+
+	err = s.prune(ctx, versions)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.stack.Status.TfState.Name == "" {
+		s.stack.Status.TfState.Name = SecretName(s.stack)
+		err = s.client.Status().Update(ctx, s.stack)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return version, nil
+}
+
+// Versions returns the versions in the stack's history, from the oldest to
+// the newest
+func (s *Store) Versions(ctx context.Context) ([]Version, error) {
+	// MatchingLabels and HasLabels replace each other's selector, so both
+	// requirements are combined in a single selector
+	selector := labels.SelectorFromSet(labels.Set{tfo.StackLabel: s.stack.Name})
+	hasVersion, err := labels.NewRequirement(tfo.StateVersionLabel, selection.Exists, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := &corev1.SecretList{}
+	err = s.client.List(
+		ctx,
+		secrets,
+		client.InNamespace(s.stack.Namespace),
+		client.MatchingLabelsSelector{Selector: selector.Add(*hasVersion)},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []Version{}
+	for i := range secrets.Items {
+		version, err := secretVersion(&secrets.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, *version)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Number < versions[j].Number
+	})
+
+	return versions, nil
+}
+
+// Version returns a version in the stack's history with its state, or nil
+// if it is not in the history
+func (s *Store) Version(ctx context.Context, number int64) (*Version, []byte, error) {
+	secret := &corev1.Secret{}
+	err := s.client.Get(ctx, client.ObjectKey{Name: s.versionSecretName(number), Namespace: s.stack.Namespace}, secret)
+	if apierr.IsNotFound(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	version, err := secretVersion(secret)
+	if err != nil {
+		return nil, nil, err
+	}
+	content, err := s.decrypt(ctx, secret.Data[terraform.StateKey])
+	if err != nil {
+		return nil, nil, err
+	}
+	return version, content, nil
+}
+
+// Reencrypt encrypts the stored state and its versions again with new data
+// keys wrapped by the stack's active key encryption key, as after rotating
+// it, and returns the Secrets re-encrypted. States not encrypted, as stored
+// before enabling the encryption, are encrypted.
+func (s *Store) Reencrypt(ctx context.Context) ([]string, error) {
+	enc, err := encryption.ForStack(ctx, s.client, s.stack)
+	if err != nil {
+		return nil, err
+	}
+	if enc == nil {
+		return nil, fmt.Errorf("stack %s has no encryption keys", s.stack.Name)
+	}
+
+	versions, err := s.Versions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{SecretName(s.stack)}
+	for _, version := range versions {
+		names = append(names, version.Secret)
+	}
+
+	reencrypted := []string{}
+	for _, name := range names {
+		secret := &corev1.Secret{}
+		err = s.client.Get(ctx, client.ObjectKey{Name: name, Namespace: s.stack.Namespace}, secret)
+		if apierr.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(secret.Data[terraform.StateKey]) == 0 {
+			continue
+		}
+
+		content, err := enc.Reencrypt(ctx, secret.Data[terraform.StateKey])
+		if err != nil {
+			return nil, fmt.Errorf("error re-encrypting state in secret %s: %v", name, err)
+		}
+		secret.Data[terraform.StateKey] = content
+		err = s.client.Update(ctx, secret)
+		if err != nil {
+			return nil, err
+		}
+		reencrypted = append(reencrypted, name)
+	}
+
+	return reencrypted, nil
+}
+
+// writeSecret stores a state in the stack's tfstate Secret, creating it if
+// it doesn't exist
+func (s *Store) writeSecret(ctx context.Context, content []byte) error {
+	content, err := s.encrypt(ctx, content)
+	if err != nil {
+		return err
+	}
+
+	secret := &corev1.Secret{}
+	err = s.client.Get(ctx, client.ObjectKey{Name: SecretName(s.stack), Namespace: s.stack.Namespace}, secret)
+	if err != nil && !apierr.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+
+	secret.Name = SecretName(s.stack)
+	secret.Namespace = s.stack.Namespace
+	if secret.Labels == nil {
+		secret.Labels = map[string]string{}
+	}
+	secret.Labels[tfo.StackLabel] = s.stack.Name
+	secret.Data = map[string][]byte{terraform.StateKey: content}
+
+	if exists {
+		return s.client.Update(ctx, secret)
+	}
+	return s.client.Create(ctx, secret)
+}
+
+// record records a state as a version in the history
+func (s *Store) record(ctx context.Context, number int64, content []byte, run int64, operation string) (*Version, error) {
+	// a state stored before the history was kept may be invalid, as when
+	// it is being replaced by a forced push, and is recorded anyway
+	meta, _ := terraform.ParseStateMeta(content)
+	version := &Version{
+		Number:    number,
+		Serial:    meta.Serial,
+		Lineage:   meta.Lineage,
+		Run:       run,
+		Operation: operation,
+		Created:   time.Now().UTC(),
+		Secret:    s.versionSecretName(number),
+	}
+	return version, s.createVersion(ctx, version, content)
+}
+
+// updateVersion replaces the state held by a version with the last state
+// stored by its run
+func (s *Store) updateVersion(ctx context.Context, number int64, content []byte, run int64, operation string) (*Version, error) {
+	secret := &corev1.Secret{}
+	err := s.client.Get(ctx, client.ObjectKey{Name: s.versionSecretName(number), Namespace: s.stack.Namespace}, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, _ := terraform.ParseStateMeta(content)
+	version := &Version{
+		Number:    number,
+		Serial:    meta.Serial,
+		Lineage:   meta.Lineage,
+		Run:       run,
+		Operation: operation,
+		Created:   time.Now().UTC(),
+		Secret:    secret.Name,
+	}
+	encrypted, err := s.encrypt(ctx, content)
+	if err != nil {
+		return nil, err
+	}
+	secret.Annotations = versionAnnotations(version)
+	secret.Data = map[string][]byte{terraform.StateKey: encrypted}
+	return version, s.client.Update(ctx, secret)
+}
+
+// createVersion creates the Secret holding a version, owned by the stack
+func (s *Store) createVersion(ctx context.Context, version *Version, content []byte) error {
+	content, err := s.encrypt(ctx, content)
+	if err != nil {
+		return err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      version.Secret,
+			Namespace: s.stack.Namespace,
+			Labels: map[string]string{
+				tfo.StackLabel:        s.stack.Name,
+				tfo.StateVersionLabel: strconv.FormatInt(version.Number, 10),
+			},
+			Annotations: versionAnnotations(version),
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(s.stack, tfo.GroupVersion.WithKind("Stack")),
+			},
+		},
+		Data: map[string][]byte{terraform.StateKey: content},
+	}
+	return s.client.Create(ctx, secret)
+}
+
+// versionAnnotations returns the annotations describing a version in the
+// Secret holding it
+func versionAnnotations(version *Version) map[string]string {
+	return map[string]string{
+		serialAnnotation:    strconv.FormatInt(version.Serial, 10),
+		lineageAnnotation:   version.Lineage,
+		runAnnotation:       strconv.FormatInt(version.Run, 10),
+		operationAnnotation: version.Operation,
+		createdAnnotation:   version.Created.Format(time.RFC3339),
+	}
+}
+
+// prune deletes the oldest versions beyond the stack's history limit
+func (s *Store) prune(ctx context.Context, versions []Version) error {
+	limit := HistoryLimit(s.stack)
+	if len(versions) <= limit {
+		return nil
+	}
+
+	for _, version := range versions[:len(versions)-limit] {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: version.Secret, Namespace: s.stack.Namespace},
+		}
+		err := s.client.Delete(ctx, secret)
+		if err != nil && !apierr.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// encrypt encrypts a state if the stack's states are encrypted
+func (s *Store) encrypt(ctx context.Context, content []byte) ([]byte, error) {
+	enc, err := encryption.ForStack(ctx, s.client, s.stack)
+	if err != nil {
+		return nil, err
+	}
+	return enc.Encrypt(ctx, content)
+}
+
+// decrypt decrypts a state, if it is encrypted
+func (s *Store) decrypt(ctx context.Context, content []byte) ([]byte, error) {
+	if !encryption.IsEncrypted(content) {
+		return content, nil
+	}
+	enc, err := encryption.ForStack(ctx, s.client, s.stack)
+	if err != nil {
+		return nil, err
+	}
+	return enc.Decrypt(ctx, content)
+}
+
+// versionSecretName returns the name of the Secret holding a version
+func (s *Store) versionSecretName(number int64) string {
+	return fmt.Sprintf("%s-v%d", SecretName(s.stack), number)
+}
+
+// secretVersion returns the version held by a Secret
+func secretVersion(secret *corev1.Secret) (*Version, error) {
+	number, err := strconv.ParseInt(secret.Labels[tfo.StateVersionLabel], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state version in secret %s: %v", secret.Name, err)
+	}
+
+	// the annotations are informative, so invalid values are ignored
+	version := &Version{
+		Number:    number,
+		Lineage:   secret.Annotations[lineageAnnotation],
+		Operation: secret.Annotations[operationAnnotation],
+		Secret:    secret.Name,
+	}
+	version.Serial, _ = strconv.ParseInt(secret.Annotations[serialAnnotation], 10, 64)
+	version.Run, _ = strconv.ParseInt(secret.Annotations[runAnnotation], 10, 64)
+	version.Created, _ = time.Parse(time.RFC3339, secret.Annotations[createdAnnotation])
+
+	return version, nil
+}
@@ -0,0 +1,238 @@
+package statestore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+	"github.com/pablochacin/tf-operator/pkg/encryption"
+	"github.com/pablochacin/tf-operator/pkg/terraform"
+)
+
+func TestStateStore(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "State Store Suite")
+}
+
+const namespace = "test"
+
+// state returns a state with the given serial
+func state(serial int64) []byte {
+	return []byte(fmt.Sprintf(`{"version": 4, "serial": %d, "lineage": "a", "resources": []}`, serial))
+}
+
+var _ = Describe("State store", func() {
+	var (
+		c       client.Client
+		stack   *tfo.Stack
+		objects []runtime.Object
+		store   *Store
+		ctx     = context.TODO()
+	)
+
+	BeforeEach(func() {
+		stack = &tfo.Stack{
+			ObjectMeta: metav1.ObjectMeta{Name: "stack", Namespace: namespace, UID: "uid"},
+		}
+		objects = []runtime.Object{}
+	})
+
+	JustBeforeEach(func() {
+		sch := runtime.NewScheme()
+		Expect(tfo.AddToScheme(sch)).To(Succeed())
+		Expect(corev1.AddToScheme(sch)).To(Succeed())
+		c = fake.NewFakeClientWithScheme(sch, append(objects, stack)...)
+
+		current := &tfo.Stack{}
+		Expect(c.Get(ctx, client.ObjectKey{Name: stack.Name, Namespace: namespace}, current)).To(Succeed())
+		store = New(c, current)
+	})
+
+	It("Should store the state recording its versions", func() {
+		content, err := store.Read(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(content).To(BeNil())
+
+		version, err := store.Write(ctx, state(1), 1, "apply")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(version.Number).To(Equal(int64(1)))
+		Expect(version.Secret).To(Equal("stack-tfstate-v1"))
+
+		_, err = store.Write(ctx, state(2), 0, "push")
+		Expect(err).NotTo(HaveOccurred())
+
+		content, err = store.Read(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(content).To(Equal(state(2)))
+
+		updated := &tfo.Stack{}
+		Expect(c.Get(ctx, client.ObjectKey{Name: stack.Name, Namespace: namespace}, updated)).To(Succeed())
+		Expect(updated.Status.TfState.Name).To(Equal("stack-tfstate"))
+
+		versions, err := store.Versions(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(versions).To(HaveLen(2))
+		Expect(versions[0].Serial).To(Equal(int64(1)))
+		Expect(versions[0].Run).To(Equal(int64(1)))
+		Expect(versions[0].Operation).To(Equal("apply"))
+		Expect(versions[1].Number).To(Equal(int64(2)))
+		Expect(versions[1].Operation).To(Equal("push"))
+		Expect(versions[1].Created).NotTo(BeZero())
+
+		version, content, err = store.Version(ctx, 1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(version.Lineage).To(Equal("a"))
+		Expect(content).To(Equal(state(1)))
+
+		secret := &corev1.Secret{}
+		Expect(c.Get(ctx, client.ObjectKey{Name: "stack-tfstate-v1", Namespace: namespace}, secret)).To(Succeed())
+		Expect(metav1.IsControlledBy(secret, stack)).To(BeTrue())
+
+		version, _, err = store.Version(ctx, 3)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(version).To(BeNil())
+	})
+
+	It("Should record a single version for the states stored by a run", func() {
+		for serial := int64(1); serial <= 3; serial++ {
+			version, err := store.Write(ctx, state(serial), 1, "run")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version.Number).To(Equal(int64(1)))
+		}
+		_, err := store.Write(ctx, state(4), 2, "run")
+		Expect(err).NotTo(HaveOccurred())
+
+		versions, err := store.Versions(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(versions).To(HaveLen(2))
+		Expect(versions[0].Run).To(Equal(int64(1)))
+		Expect(versions[0].Serial).To(Equal(int64(3)))
+		Expect(versions[1].Run).To(Equal(int64(2)))
+
+		_, content, err := store.Version(ctx, 1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(content).To(Equal(state(3)))
+	})
+
+	It("Should reject an invalid state", func() {
+		_, err := store.Write(ctx, []byte("not a state"), 0, "push")
+		Expect(err).To(HaveOccurred())
+	})
+
+	Context("with a state stored before keeping its history", func() {
+		BeforeEach(func() {
+			stack.Status.TfState.Name = "stack-tfstate"
+			objects = append(objects, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "stack-tfstate", Namespace: namespace},
+				Data:       map[string][]byte{terraform.StateKey: state(5)},
+			})
+		})
+
+		It("Should record it as the initial version", func() {
+			version, err := store.Write(ctx, state(6), 2, "apply")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version.Number).To(Equal(int64(2)))
+
+			versions, err := store.Versions(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(versions).To(HaveLen(2))
+			Expect(versions[0].Serial).To(Equal(int64(5)))
+			Expect(versions[0].Operation).To(Equal("initial"))
+		})
+	})
+
+	Context("with encryption keys", func() {
+		var keys *corev1.Secret
+
+		BeforeEach(func() {
+			keys = &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "keys", Namespace: namespace},
+				Data: map[string][]byte{
+					encryption.ActiveKey: []byte("k1"),
+					"k1":                 bytes.Repeat([]byte{1}, encryption.KeySize),
+				},
+			}
+			stack.Spec.Encryption = &tfo.StackEncryption{KeySecret: corev1.LocalObjectReference{Name: "keys"}}
+			objects = append(objects, keys)
+		})
+
+		// stored returns the content stored in a Secret
+		stored := func(name string) []byte {
+			secret := &corev1.Secret{}
+			Expect(c.Get(ctx, client.ObjectKey{Name: name, Namespace: namespace}, secret)).To(Succeed())
+			return secret.Data[terraform.StateKey]
+		}
+
+		It("Should encrypt the state and its versions", func() {
+			_, err := store.Write(ctx, state(1), 1, "apply")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(encryption.IsEncrypted(stored("stack-tfstate"))).To(BeTrue())
+			Expect(encryption.IsEncrypted(stored("stack-tfstate-v1"))).To(BeTrue())
+
+			content, err := store.Read(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(content).To(Equal(state(1)))
+
+			_, content, err = store.Version(ctx, 1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(content).To(Equal(state(1)))
+		})
+
+		It("Should re-encrypt the state and its versions with a rotated key", func() {
+			_, err := store.Write(ctx, state(1), 1, "apply")
+			Expect(err).NotTo(HaveOccurred())
+			previous := stored("stack-tfstate-v1")
+
+			keys.Data["k2"] = bytes.Repeat([]byte{2}, encryption.KeySize)
+			keys.Data[encryption.ActiveKey] = []byte("k2")
+			Expect(c.Update(ctx, keys)).To(Succeed())
+
+			reencrypted, err := store.Reencrypt(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reencrypted).To(Equal([]string{"stack-tfstate", "stack-tfstate-v1"}))
+			Expect(stored("stack-tfstate-v1")).NotTo(Equal(previous))
+
+			// the previous key is no longer needed
+			delete(keys.Data, "k1")
+			Expect(c.Update(ctx, keys)).To(Succeed())
+			_, content, err := store.Version(ctx, 1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(content).To(Equal(state(1)))
+		})
+	})
+
+	Context("with a history limit", func() {
+		BeforeEach(func() {
+			limit := int32(2)
+			stack.Spec.StateHistoryLimit = &limit
+		})
+
+		It("Should delete the oldest versions", func() {
+			for serial := int64(1); serial <= 4; serial++ {
+				_, err := store.Write(ctx, state(serial), serial, "apply")
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			versions, err := store.Versions(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(versions).To(HaveLen(2))
+			Expect(versions[0].Number).To(Equal(int64(3)))
+			Expect(versions[1].Number).To(Equal(int64(4)))
+
+			err = c.Get(ctx, client.ObjectKey{Name: "stack-tfstate-v2", Namespace: namespace}, &corev1.Secret{})
+			Expect(apierr.IsNotFound(err)).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,90 @@
+package terraform
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const (
+	// backend keeping the state in the working directory, which is lost
+	// once the run completes
+	localBackend = "local"
+)
+
+// ValidateBackend checks the type of a backend and the names of its settings
+// are valid, and that the backend keeps the state out of the working directory
+func ValidateBackend(backendType string, settings []string) error {
+	if !isIdentifier(backendType) {
+		return fmt.Errorf("invalid backend type %q", backendType)
+	}
+	if backendType == localBackend {
+		return fmt.Errorf("backend %s keeps the state in the run's working directory", localBackend)
+	}
+	for _, name := range settings {
+		if !isIdentifier(name) {
+			return fmt.Errorf("invalid backend setting %q", name)
+		}
+	}
+	return nil
+}
+
+// ValidateWorkspace checks a workspace name can be used in the path of the
+// state, as terraform requires
+func ValidateWorkspace(name string) error {
+	if name == "" || url.PathEscape(name) != name {
+		return fmt.Errorf("invalid workspace name %q", name)
+	}
+	return nil
+}
+
+// BackendOverride returns a terraform configuration overriding the backend
+// with one of the given type, whose settings are passed to terraform init
+func BackendOverride(backendType string) []byte {
+	return []byte(fmt.Sprintf("terraform {\n  backend %q {}\n}\n", backendType))
+}
+
+// BackendConfigFile returns a backend config file with the given settings, in
+// alphabetical order, for passing them to terraform init
+func BackendConfigFile(config map[string]string) []byte {
+	content := &strings.Builder{}
+	for _, name := range sortedKeys(config) {
+		fmt.Fprintf(content, "%s = \"%s\"\n", name, escapeString(config[name]))
+	}
+	return []byte(content.String())
+}
+
+// backendConfigArgs returns the settings of a backend as arguments to
+// -backend-config, in alphabetical order
+func backendConfigArgs(config map[string]string) []string {
+	args := []string{}
+	for _, name := range sortedKeys(config) {
+		args = append(args, name+"="+config[name])
+	}
+	return args
+}
+
+// escapeString escapes a value for a quoted string in a terraform file,
+// including the sequences that would start a template
+func escapeString(value string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+		"\n", `\n`,
+		"\r", `\r`,
+		"\t", `\t`,
+		"${", "$${",
+		"%{", "%%{",
+	).Replace(value)
+}
+
+// sortedKeys returns the keys of a map in alphabetical order
+func sortedKeys(values map[string]string) []string {
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
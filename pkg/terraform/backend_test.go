@@ -0,0 +1,137 @@
+package terraform
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pablochacin/tf-operator/pkg/cmdrunner"
+)
+
+var _ = Describe("Backends", func() {
+	var (
+		workDir    string
+		mockRunner *MockRunner
+		tfRunner   *TfWorkspace
+	)
+
+	BeforeEach(func() {
+		var err error
+		workDir, err = ioutil.TempDir("", "tfworkspace")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		mockRunner = NewMockRunner()
+		tfRunner = NewWithCmdRunner(mockRunner, "/path/to/tfvars", "/path/to/tfconfig", "/path/to/tfstate", workDir)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(workDir)
+	})
+
+	It("Should init the backend with its settings", func() {
+		config := map[string]string{"bucket": "states", "key": "net/terraform.tfstate"}
+		Expect(tfRunner.UseBackend("s3", config)).To(Succeed())
+		tfRunner.UseBackendConfig("/path/to/backend.hcl")
+		Expect(tfRunner.Init()).To(Succeed())
+
+		Expect(mockRunner.args).To(Equal([]string{"init",
+			"-input=false",
+			"-backend-config=bucket=states",
+			"-backend-config=key=net/terraform.tfstate",
+			"-backend-config=/path/to/backend.hcl",
+		}))
+		override, err := ioutil.ReadFile(filepath.Join(workDir, "backend_override.tf"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(override)).To(ContainSubstring(`backend "s3" {}`))
+
+		Expect(tfRunner.Apply()).To(Succeed())
+		Expect(mockRunner.args).NotTo(ContainElement("-state"))
+	})
+
+	It("Should pull and push the state", func() {
+		Expect(tfRunner.UseBackend("pg", nil)).To(Succeed())
+
+		mockRunner.result = &cmdrunner.CmdResult{Output: tfstateJSON}
+		content, err := tfRunner.PullState()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(content)).To(Equal(tfstateJSON))
+		Expect(mockRunner.args).To(Equal([]string{"state", "pull"}))
+
+		Expect(tfRunner.PushState(content, true)).To(Succeed())
+		Expect(mockRunner.args).To(Equal([]string{"state", "push", "-force", filepath.Join(workDir, "push.tfstate")}))
+		Expect(filepath.Join(workDir, "push.tfstate")).To(BeARegularFile())
+
+		mockRunner.result = &cmdrunner.CmdResult{ExitCode: 1, Output: "Error: Failed to write state: lineage mismatch\n"}
+		err = tfRunner.PushState(content, false)
+		Expect(err).To(MatchError(ContainSubstring("lineage mismatch")))
+		Expect(mockRunner.args).NotTo(ContainElement("-force"))
+	})
+
+	It("Should report no state when the backend has none", func() {
+		Expect(tfRunner.UseBackend("pg", nil)).To(Succeed())
+		mockRunner.result = &cmdrunner.CmdResult{}
+		content, err := tfRunner.PullState()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(content).To(BeNil())
+	})
+
+	It("Should select the workspace after init", func() {
+		tfRunner.UseWorkspace("stage")
+		Expect(tfRunner.Init()).To(Succeed())
+		Expect(mockRunner.calls).To(Equal([][]string{
+			{"init", "-input=false"},
+			{"workspace", "select", "stage"},
+		}))
+	})
+
+	It("Should create the workspace if it doesn't exist", func() {
+		mockRunner.results = []*cmdrunner.CmdResult{
+			{ExitCode: 0},
+			{ExitCode: 1, Output: `Workspace "stage" doesn't exist.`},
+			{ExitCode: 0},
+		}
+		tfRunner.UseWorkspace("stage")
+		Expect(tfRunner.Init()).To(Succeed())
+		Expect(mockRunner.calls[2]).To(Equal([]string{"workspace", "new", "stage"}))
+	})
+
+	It("Should fail if the workspace can't be selected", func() {
+		mockRunner.results = []*cmdrunner.CmdResult{
+			{ExitCode: 0},
+			{ExitCode: 1, Output: "Error: Failed to get existing workspaces: AccessDenied\n"},
+		}
+		tfRunner.UseWorkspace("stage")
+		Expect(tfRunner.Init()).To(MatchError("terraform workspace select failed: Error: Failed to get existing workspaces: AccessDenied"))
+		Expect(mockRunner.calls).To(HaveLen(2))
+	})
+
+	It("Should keep the default workspace", func() {
+		Expect(tfRunner.Init()).To(Succeed())
+		Expect(mockRunner.calls).To(HaveLen(1))
+	})
+
+	It("Should validate the workspace name", func() {
+		Expect(ValidateWorkspace("stage-eu_1")).To(Succeed())
+		Expect(ValidateWorkspace("")).NotTo(Succeed())
+		Expect(ValidateWorkspace("dev/eu")).NotTo(Succeed())
+	})
+
+	It("Should render the settings in a backend config file", func() {
+		content := BackendConfigFile(map[string]string{
+			"conn_str": "postgres://user:p\"w@db/states",
+			"schema":   "${net}",
+		})
+		Expect(string(content)).To(Equal("conn_str = \"postgres://user:p\\\"w@db/states\"\nschema = \"$${net}\"\n"))
+	})
+
+	It("Should validate the type and settings", func() {
+		Expect(ValidateBackend("s3", []string{"bucket", "access_key"})).To(Succeed())
+		Expect(ValidateBackend("", nil)).NotTo(Succeed())
+		Expect(ValidateBackend("s3\" {}", nil)).NotTo(Succeed())
+		Expect(ValidateBackend("local", nil)).NotTo(Succeed())
+		Expect(ValidateBackend("pg", []string{"conn str"})).NotTo(Succeed())
+	})
+})
@@ -0,0 +1,52 @@
+package terraform
+
+import (
+	"regexp"
+	"strconv"
+)
+
+var (
+	// escape sequences coloring terraform's output
+	colorPattern = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+	// summaries of the changes in the output of plan, apply and destroy
+	planPattern    = regexp.MustCompile(`Plan: (?:\d+ to import, )?(\d+) to add, (\d+) to change, (\d+) to destroy\.`)
+	noChanges      = regexp.MustCompile(`No changes\.`)
+	appliedPattern = regexp.MustCompile(`Apply complete! Resources: (?:\d+ imported, )?(\d+) added, (\d+) changed, (\d+) destroyed\.`)
+	destroyPattern = regexp.MustCompile(`Destroy complete! Resources: (\d+) destroyed\.`)
+)
+
+// Changes counts the resources added, changed and destroyed by a run, or to
+// be by a plan
+type Changes struct {
+	Add     int
+	Change  int
+	Destroy int
+}
+
+// ParseChanges returns the changes reported in the output of a terraform plan,
+// apply or destroy, or nil if the output doesn't report them
+func ParseChanges(output string) *Changes {
+	output = colorPattern.ReplaceAllString(output, "")
+
+	// apply and destroy show their plan before the changes they made
+	if match := appliedPattern.FindStringSubmatch(output); match != nil {
+		return &Changes{Add: atoi(match[1]), Change: atoi(match[2]), Destroy: atoi(match[3])}
+	}
+	if match := destroyPattern.FindStringSubmatch(output); match != nil {
+		return &Changes{Destroy: atoi(match[1])}
+	}
+	if match := planPattern.FindStringSubmatch(output); match != nil {
+		return &Changes{Add: atoi(match[1]), Change: atoi(match[2]), Destroy: atoi(match[3])}
+	}
+	if noChanges.MatchString(output) {
+		return &Changes{}
+	}
+	return nil
+}
+
+// atoi converts the digits matched by a pattern
+func atoi(digits string) int {
+	n, _ := strconv.Atoi(digits)
+	return n
+}
@@ -0,0 +1,34 @@
+package terraform
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Terraform Changes", func() {
+	It("Should parse the changes of a plan", func() {
+		output := "Terraform will perform the following actions:\n\n" +
+			"Plan: 2 to add, 1 to change, 3 to destroy.\n"
+		Expect(ParseChanges(output)).To(Equal(&Changes{Add: 2, Change: 1, Destroy: 3}))
+	})
+
+	It("Should parse a plan without changes", func() {
+		output := "No changes. Infrastructure is up-to-date.\n"
+		Expect(ParseChanges(output)).To(Equal(&Changes{}))
+	})
+
+	It("Should parse the changes of an apply", func() {
+		output := "Plan: 1 to add, 1 to change, 2 to destroy.\n" +
+			"\x1b[0m\x1b[1m\x1b[32mApply complete! Resources: 1 added, 0 changed, 2 destroyed.\x1b[0m\n"
+		Expect(ParseChanges(output)).To(Equal(&Changes{Add: 1, Destroy: 2}))
+	})
+
+	It("Should parse the changes of a destroy", func() {
+		output := "Destroy complete! Resources: 4 destroyed.\n"
+		Expect(ParseChanges(output)).To(Equal(&Changes{Destroy: 4}))
+	})
+
+	It("Should not report changes missing in the output", func() {
+		Expect(ParseChanges("Error: Invalid provider configuration\n")).To(BeNil())
+	})
+})
@@ -0,0 +1,174 @@
+package terraform
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ImportStatus is the result of importing a resource
+type ImportStatus string
+
+const (
+	// The resource was imported into the state
+	ImportStatusImported ImportStatus = "Imported"
+
+	// The resource was already in the state
+	ImportStatusSkipped ImportStatus = "Skipped"
+
+	// The resource could not be imported
+	ImportStatusFailed ImportStatus = "Failed"
+)
+
+// ImportResult reports the import of a resource
+type ImportResult struct {
+	// Address of the resource
+	Address string
+
+	// ID of the resource in the provider
+	ID string
+
+	// Result of the import
+	Status ImportStatus
+
+	// Reason of the failure, if any
+	Message string
+}
+
+// ValidateImportAddress checks an address can be used for importing a
+// resource: the address of a managed resource or of one of its instances
+func ValidateImportAddress(address string) error {
+	addr, err := parseAddress(address)
+	if err != nil {
+		return err
+	}
+	if addr.isModule() || addr.mode != managedMode {
+		return fmt.Errorf("invalid address %s: only managed resources can be imported", address)
+	}
+	return nil
+}
+
+// Import imports existing resources into the state, given their addresses and
+// their IDs in the provider, in the order of their addresses. Resources already
+// in the state are skipped. A failed import doesn't prevent the following ones,
+// so the result of each import is reported. Once a resource is imported, the
+// updated state is used by the following commands.
+func (w *TfWorkspace) Import(imports map[string]string) ([]ImportResult, error) {
+	inState, err := w.stateAddresses()
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := []string{}
+	for address := range imports {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	results := []ImportResult{}
+	for _, address := range addresses {
+		results = append(results, w.importMissing(address, imports[address], inState))
+	}
+
+	return results, nil
+}
+
+// importMissing imports a resource if its address is not in the state
+func (w *TfWorkspace) importMissing(address string, id string, inState map[string]bool) ImportResult {
+	result := ImportResult{Address: address, ID: id, Status: ImportStatusImported}
+	err := ValidateImportAddress(address)
+	if err != nil {
+		result.Status = ImportStatusFailed
+		result.Message = err.Error()
+		return result
+	}
+
+	// addresses are compared as formatted in the state
+	addr, _ := parseAddress(address)
+	if inState[addr.String()] {
+		result.Status = ImportStatusSkipped
+		return result
+	}
+
+	err = w.importResource(address, id)
+	if err != nil {
+		result.Status = ImportStatusFailed
+		result.Message = err.Error()
+	}
+	return result
+}
+
+// importResource runs terraform import for a resource, writing the state in
+// the working directory
+func (w *TfWorkspace) importResource(address string, id string) error {
+	args := []string{"import",
+		"-input=false",
+		"-var-file", w.tfvars,
+	}
+	args = append(args, w.stateArgs()...)
+	args = append(args, address, id)
+	result, err := w.runner.Run("terraform", args...)
+	if err != nil {
+		return err
+	}
+	if result != nil && result.ExitCode != 0 {
+		return fmt.Errorf("terraform import failed: %s", lastLine(result.Output))
+	}
+
+	w.stateWritten()
+	return nil
+}
+
+// stateAddresses returns the addresses of the resources in the workspace's
+// state. A missing state has no resources.
+func (w *TfWorkspace) stateAddresses() (map[string]bool, error) {
+	addresses := map[string]bool{}
+	content, err := w.readState()
+	if err != nil {
+		return nil, err
+	}
+	if len(content) == 0 {
+		return addresses, nil
+	}
+
+	resources, err := ParseStateResources(content)
+	if err != nil {
+		return nil, err
+	}
+	for _, res := range resources {
+		addresses[res.Address] = true
+	}
+	return addresses, nil
+}
+
+// readState returns the workspace's state, pulling it from the backend if it
+// is kept in one, or nil if there is none
+func (w *TfWorkspace) readState() ([]byte, error) {
+	if !w.backend {
+		content, err := ioutil.ReadFile(w.tfstate)
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return content, err
+	}
+
+	result, err := w.runner.Run("terraform", "state", "pull")
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, nil
+	}
+	if result.ExitCode != 0 {
+		return nil, fmt.Errorf("terraform state pull failed: %s", lastLine(result.Output))
+	}
+	return []byte(result.Output), nil
+}
+
+// lastLine returns the last line of an output that is not empty
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
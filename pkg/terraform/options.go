@@ -0,0 +1,83 @@
+package terraform
+
+import (
+	"fmt"
+	"sort"
+)
+
+// RunOptions are the options of a single terraform run
+type RunOptions struct {
+	// Addresses of the modules or resources the run is limited to
+	Targets []string
+
+	// Addresses of the resources to replace
+	Replace []string
+
+	// Only update the state with the resources' current settings
+	RefreshOnly bool
+
+	// Values of variables overriding the ones in the tfvars
+	Vars map[string]string
+}
+
+// Validate checks the addresses and variable names of the options, and that
+// they can be combined
+func (o RunOptions) Validate() error {
+	for _, target := range o.Targets {
+		_, err := parseAddress(target)
+		if err != nil {
+			return fmt.Errorf("invalid target: %v", err)
+		}
+	}
+	for _, address := range o.Replace {
+		err := ValidateImportAddress(address)
+		if err != nil {
+			return fmt.Errorf("invalid replace: %v", err)
+		}
+	}
+	if o.RefreshOnly && len(o.Replace) > 0 {
+		return fmt.Errorf("refresh-only can't be combined with replace")
+	}
+	for name := range o.Vars {
+		if !isIdentifier(name) {
+			return fmt.Errorf("invalid variable name %q", name)
+		}
+	}
+	return nil
+}
+
+// Args returns the terraform arguments for the options, with variables in
+// alphabetical order
+func (o RunOptions) Args() []string {
+	args := []string{}
+	for _, target := range o.Targets {
+		args = append(args, "-target="+target)
+	}
+	for _, address := range o.Replace {
+		args = append(args, "-replace="+address)
+	}
+	if o.RefreshOnly {
+		args = append(args, "-refresh-only")
+	}
+
+	names := []string{}
+	for name := range o.Vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, "-var", name+"="+o.Vars[name])
+	}
+
+	return args
+}
+
+// isIdentifier indicates if a name is a valid variable name
+func isIdentifier(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if !isIdentChar(name[i], i == 0) {
+			return false
+		}
+	}
+	return name != ""
+}
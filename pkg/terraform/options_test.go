@@ -0,0 +1,38 @@
+package terraform
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Run options", func() {
+	It("Should return the terraform arguments", func() {
+		opts := RunOptions{
+			Targets: []string{"module.db"},
+			Replace: []string{`aws_instance.web["a"]`},
+			Vars:    map[string]string{"size": "large", "count": "2"},
+		}
+		Expect(opts.Validate()).To(Succeed())
+		Expect(opts.Args()).To(Equal([]string{
+			"-target=module.db",
+			`-replace=aws_instance.web["a"]`,
+			"-var", "count=2",
+			"-var", "size=large",
+		}))
+	})
+
+	It("Should apply with the options", func() {
+		mockRunner := NewMockRunner()
+		tfRunner := NewWithCmdRunner(mockRunner, "/path/to/tfvars", "/path/to/tfconfig", "/path/to/tfstate", "/path/to/workDir")
+		Expect(tfRunner.ApplyWithOptions(RunOptions{RefreshOnly: true})).To(Succeed())
+		Expect(mockRunner.args[0]).To(Equal("apply"))
+		Expect(mockRunner.args).To(ContainElement("-refresh-only"))
+	})
+
+	It("Should reject invalid options", func() {
+		Expect(RunOptions{Targets: []string{"aws_instance"}}.Validate()).NotTo(Succeed())
+		Expect(RunOptions{Replace: []string{"module.db"}}.Validate()).NotTo(Succeed())
+		Expect(RunOptions{Replace: []string{"aws_instance.web"}, RefreshOnly: true}.Validate()).NotTo(Succeed())
+		Expect(RunOptions{Vars: map[string]string{"1st": "x"}}.Validate()).NotTo(Succeed())
+	})
+})
@@ -0,0 +1,106 @@
+package terraform
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// Output is a terraform output as reported by terraform output -json
+type Output struct {
+	Sensitive bool            `json:"sensitive"`
+	Type      json.RawMessage `json:"type"`
+	Value     json.RawMessage `json:"value"`
+}
+
+// DecodeOutputs decodes the base64 encoded output of terraform output -json
+// as stored in the Stack's status. An empty tfout has no outputs.
+func DecodeOutputs(tfout string) (map[string]Output, error) {
+	outputs := map[string]Output{}
+	if tfout == "" {
+		return outputs, nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(tfout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tfout encoding: %v", err)
+	}
+
+	err = json.Unmarshal(data, &outputs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tfout content: %v", err)
+	}
+
+	return outputs, nil
+}
+
+// Outputs returns the outputs in the workspace's state, as reported by
+// terraform output -json
+func (w *TfWorkspace) Outputs() (map[string]Output, error) {
+	args := []string{"output", "-json"}
+	if !w.backend {
+		args = append(args, "-state", w.tfstate)
+	}
+	result, err := w.runner.Run("terraform", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := map[string]Output{}
+	if result == nil {
+		return outputs, nil
+	}
+	if result.ExitCode != 0 {
+		return nil, fmt.Errorf("terraform output failed: %s", lastLine(result.Output))
+	}
+	err = json.Unmarshal([]byte(result.Output), &outputs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid terraform output: %v", err)
+	}
+	return outputs, nil
+}
+
+// VarValue returns the output's value in the format expected by terraform
+// for a variable set from the environment: strings are passed verbatim and
+// any other type as its json representation, which is valid HCL.
+func (o Output) VarValue() string {
+	var str string
+	if err := json.Unmarshal(o.Value, &str); err == nil {
+		return str
+	}
+	return string(o.Value)
+}
+
+// SplitSensitive removes the values of the sensitive outputs, so they are not
+// exposed in the Stack's status, and returns them as the json representation
+// of each value, indexed by output name, for storing them in a Secret
+func SplitSensitive(outputs map[string]Output) map[string][]byte {
+	values := map[string][]byte{}
+	for name, output := range outputs {
+		if !output.Sensitive {
+			continue
+		}
+		values[name] = []byte(output.Value)
+		output.Value = nil
+		outputs[name] = output
+	}
+	return values
+}
+
+// RestoreSensitive sets the values of the sensitive outputs from the content
+// of the Secret with their values
+func RestoreSensitive(outputs map[string]Output, values map[string][]byte) {
+	for name, output := range outputs {
+		value, found := values[name]
+		if !output.Sensitive || !found {
+			continue
+		}
+		output.Value = json.RawMessage(value)
+		outputs[name] = output
+	}
+}
+
+// Redacted indicates if the output is sensitive and its value is not known
+func (o Output) Redacted() bool {
+	return o.Sensitive && (len(o.Value) == 0 || string(o.Value) == "null")
+}
@@ -0,0 +1,133 @@
+package terraform
+
+import (
+	"encoding/base64"
+
+	"github.com/pablochacin/tf-operator/pkg/cmdrunner"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	tfoutJSON = `{
+  "vpc_id": {"sensitive": false, "type": "string", "value": "vpc-1234"},
+  "subnets": {"sensitive": false, "type": ["list", "string"], "value": ["a", "b"]},
+  "password": {"sensitive": true, "type": "string", "value": "secret"}
+}`
+)
+
+var _ = Describe("Terraform Outputs", func() {
+	var (
+		err     error
+		outputs map[string]Output
+		tfout   string
+	)
+
+	JustBeforeEach(func() {
+		outputs, err = DecodeOutputs(tfout)
+	})
+
+	Context("Decode valid tfout", func() {
+		BeforeEach(func() {
+			tfout = base64.StdEncoding.EncodeToString([]byte(tfoutJSON))
+		})
+
+		It("Should not fail", func() {
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(outputs).To(HaveLen(3))
+		})
+
+		It("Should keep sensitive flag", func() {
+			Expect(outputs["password"].Sensitive).To(BeTrue())
+			Expect(outputs["vpc_id"].Sensitive).To(BeFalse())
+		})
+
+		It("Should return string values verbatim", func() {
+			Expect(outputs["vpc_id"].VarValue()).To(Equal("vpc-1234"))
+		})
+
+		It("Should return complex values as json", func() {
+			Expect(outputs["subnets"].VarValue()).To(Equal(`["a", "b"]`))
+		})
+	})
+
+	Context("Decode empty tfout", func() {
+		BeforeEach(func() {
+			tfout = ""
+		})
+
+		It("Should return no outputs", func() {
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(outputs).To(BeEmpty())
+		})
+	})
+
+	Context("Decode invalid tfout", func() {
+		BeforeEach(func() {
+			tfout = "not base64!"
+		})
+
+		It("Should fail", func() {
+			Expect(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("Split sensitive outputs", func() {
+		var values map[string][]byte
+
+		BeforeEach(func() {
+			tfout = base64.StdEncoding.EncodeToString([]byte(tfoutJSON))
+		})
+
+		JustBeforeEach(func() {
+			values = SplitSensitive(outputs)
+		})
+
+		It("Should redact only the sensitive values", func() {
+			Expect(values).To(Equal(map[string][]byte{"password": []byte(`"secret"`)}))
+			Expect(outputs["password"].Redacted()).To(BeTrue())
+			Expect(outputs["vpc_id"].Redacted()).To(BeFalse())
+		})
+
+		It("Should restore the sensitive values", func() {
+			RestoreSensitive(outputs, values)
+			Expect(outputs["password"].Redacted()).To(BeFalse())
+			Expect(outputs["password"].VarValue()).To(Equal("secret"))
+		})
+	})
+})
+
+var _ = Describe("Workspace outputs", func() {
+	var (
+		mockRunner *MockRunner
+		tfRunner   *TfWorkspace
+	)
+
+	BeforeEach(func() {
+		mockRunner = NewMockRunner()
+		mockRunner.result = &cmdrunner.CmdResult{Output: tfoutJSON}
+		tfRunner = NewWithCmdRunner(mockRunner, "/path/to/tfvars", "/path/to/tfconfig", "/path/to/tfstate", "/path/to/workDir")
+	})
+
+	It("Should read the outputs of the state", func() {
+		outputs, err := tfRunner.Outputs()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(outputs).To(HaveLen(3))
+		Expect(outputs["password"].Sensitive).To(BeTrue())
+		Expect(mockRunner.args).To(Equal([]string{"output", "-json", "-state", "/path/to/tfstate"}))
+	})
+
+	It("Should read the state written by an apply", func() {
+		Expect(tfRunner.Apply()).To(Succeed())
+		_, err := tfRunner.Outputs()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(mockRunner.args).To(Equal([]string{"output", "-json", "-state", "/path/to/workDir/terraform.tfstate"}))
+	})
+
+	It("Should fail with the error reported by terraform", func() {
+		mockRunner.result = &cmdrunner.CmdResult{ExitCode: 1, Output: "Error: Failed to load state\n"}
+		_, err := tfRunner.Outputs()
+		Expect(err).To(MatchError("terraform output failed: Error: Failed to load state"))
+	})
+})
@@ -1,11 +1,22 @@
 package terraform
 
 import (
+	"fmt"
+	"io/ioutil"
 	"path"
+	"strings"
 
 	"github.com/pablochacin/tf-operator/pkg/cmdrunner"
 )
 
+const (
+	// name of the file with the backend override in the working directory
+	backendOverrideFile = "backend_override.tf"
+
+	// message of terraform workspace select when the workspace doesn't exist
+	missingWorkspaceMessage = "doesn't exist"
+)
+
 type TfRunner interface {
 	Apply() error
 }
@@ -17,6 +28,19 @@ type TfWorkspace struct {
 	tfconfig string
 	tfstate  string
 	workDir  string
+
+	// the state is kept in the backend of an override, instead of the
+	// tfstate files
+	backend bool
+
+	// settings of the backend, as arguments to -backend-config
+	backendConfig []string
+
+	// terraform workspace selected when initializing, if any
+	workspace string
+
+	// changes reported by the last plan, apply or destroy, if any
+	changes *Changes
 }
 
 // NewWithCmdRunner builds a TfWorkspace with a given command runner
@@ -39,31 +63,218 @@ func New(tfvars string, tfconfig string, tfstate string, workDir string) *TfWork
 // Init initializes terraform
 func (w *TfWorkspace)Init() error {
 	args := []string{"init",
-		" -input=false",
+		"-input=false",
+	}
+	for _, config := range w.backendConfig {
+		args = append(args, "-backend-config="+config)
+	}
+
+	result, err := w.runner.Run("terraform", args...)
+	if err != nil {
+		return err
+	}
+	if result != nil && result.ExitCode != 0 {
+		return fmt.Errorf("terraform init failed: %s", lastLine(result.Output))
+	}
+
+	if w.workspace != "" {
+		return w.selectWorkspace()
+	}
+	return nil
+}
+
+// UseWorkspace selects a terraform workspace when initializing terraform,
+// creating it if it doesn't exist. An empty name keeps the default workspace.
+func (w *TfWorkspace) UseWorkspace(name string) {
+	w.workspace = name
+}
+
+// selectWorkspace selects the workspace, creating it if select fails because
+// it doesn't exist. Other failures, as accessing the backend, are returned.
+func (w *TfWorkspace) selectWorkspace() error {
+	result, err := w.runner.Run("terraform", "workspace", "select", w.workspace)
+	if err != nil {
+		return err
+	}
+	if result == nil || result.ExitCode == 0 {
+		return nil
+	}
+	if !strings.Contains(result.Output, missingWorkspaceMessage) {
+		return fmt.Errorf("terraform workspace select failed: %s", lastLine(result.Output))
+	}
+	return w.runCommand("workspace new", "workspace", "new", w.workspace)
+}
+
+// UseBackendOverride keeps the state in the backend configured by an
+// override file, which is added to the working directory
+func (w *TfWorkspace) UseBackendOverride(override []byte) error {
+	err := ioutil.WriteFile(path.Join(w.workDir, backendOverrideFile), override, 0600)
+	if err != nil {
+		return err
+	}
+
+	w.backend = true
+	return nil
+}
+
+// UseBackend keeps the state in a backend of the given type, overriding the
+// one in the configuration, with its settings passed to terraform init
+func (w *TfWorkspace) UseBackend(backendType string, config map[string]string) error {
+	err := w.UseBackendOverride(BackendOverride(backendType))
+	if err != nil {
+		return err
+	}
+
+	w.backendConfig = append(w.backendConfig, backendConfigArgs(config)...)
+	return nil
+}
+
+// UseBackendConfig passes settings of the backend to terraform init, either a
+// backend config file or a setting as key=value
+func (w *TfWorkspace) UseBackendConfig(config ...string) {
+	w.backendConfig = append(w.backendConfig, config...)
+}
+
+// PullState returns the state kept in the backend, or nil if there is none
+func (w *TfWorkspace) PullState() ([]byte, error) {
+	content, err := w.readState()
+	if err != nil || len(content) == 0 {
+		return nil, err
 	}
+	return content, nil
+}
 
-	_, err := w.runner.Run("terraform", args...)
+// PushState replaces the state kept in the backend. Unless forced, terraform
+// rejects a state with another lineage or a lower serial.
+func (w *TfWorkspace) PushState(content []byte, force bool) error {
+	stateFile := path.Join(w.workDir, "push.tfstate")
+	err := ioutil.WriteFile(stateFile, content, 0600)
 	if err != nil {
 		return err
 	}
 
+	args := []string{"state", "push"}
+	if force {
+		args = append(args, "-force")
+	}
+	args = append(args, stateFile)
+	return w.runCommand("state push", args...)
+}
+
+// ForceUnlock releases the lock of the state in the backend with the given ID
+func (w *TfWorkspace) ForceUnlock(id string) error {
+	return w.runCommand("force-unlock", "force-unlock", "-force", id)
+}
+
+// runCommand runs a terraform command, failing with the command's name if it
+// exits with an error
+func (w *TfWorkspace) runCommand(name string, args ...string) error {
+	_, err := w.runCommandOutput(name, args...)
+	return err
+}
+
+// runCommandOutput runs a terraform command as runCommand does, returning its
+// output
+func (w *TfWorkspace) runCommandOutput(name string, args ...string) (string, error) {
+	result, err := w.runner.Run("terraform", args...)
+	if err != nil {
+		return "", err
+	}
+	if result == nil {
+		return "", nil
+	}
+	if result.ExitCode != 0 {
+		return "", fmt.Errorf("terraform %s failed: %s", name, lastLine(result.Output))
+	}
+	return result.Output, nil
+}
+
+// runChanges runs a terraform command planning or making changes, keeping the
+// changes it reports
+func (w *TfWorkspace) runChanges(name string, args ...string) error {
+	w.changes = nil
+	output, err := w.runCommandOutput(name, args...)
+	if err != nil {
+		return err
+	}
+	w.changes = ParseChanges(output)
 	return nil
+}
 
+// Changes returns the changes reported by the last plan, apply or destroy, or
+// nil if it didn't report them
+func (w *TfWorkspace) Changes() *Changes {
+	return w.changes
+}
+
+// stateArgs returns the arguments for reading the state from its file and
+// writing it in the working directory, unless it is kept in a backend
+func (w *TfWorkspace) stateArgs() []string {
+	if w.backend {
+		return nil
+	}
+	return []string{
+		"-state", w.tfstate,
+		"-state-out", path.Join(w.workDir, "terraform.tfstate"),
+	}
+}
+
+// stateWritten reads the state written in the working directory in the
+// following commands, unless the state is kept in a backend
+func (w *TfWorkspace) stateWritten() {
+	if !w.backend {
+		w.tfstate = path.Join(w.workDir, "terraform.tfstate")
+	}
 }
 
 // Apply applies terraform plan
 func (w *TfWorkspace) Apply() error {
+	return w.ApplyWithOptions(RunOptions{})
+}
+
+// ApplyWithOptions applies terraform plan with the options of a single run
+func (w *TfWorkspace) ApplyWithOptions(opts RunOptions) error {
 	args := []string{"apply",
 		"-input=false",
-		"-auto-aprove",
+		"-auto-approve",
 		"-var-file", w.tfvars,
-		"-state", w.tfstate,
-		"-state-out", path.Join(w.workDir, "terraform.tfstate"),
 	}
-	_, err := w.runner.Run("terraform", args...)
+	args = append(args, w.stateArgs()...)
+	args = append(args, opts.Args()...)
+	err := w.runChanges("apply", args...)
 	if err != nil {
 		return err
 	}
+	w.stateWritten()
+	return nil
+}
+
+// Plan shows the changes an apply with the options of a single run would
+// make, without changing the state
+func (w *TfWorkspace) Plan(opts RunOptions) error {
+	args := []string{"plan",
+		"-input=false",
+		"-var-file", w.tfvars,
+	}
+	if !w.backend {
+		args = append(args, "-state", w.tfstate)
+	}
+	args = append(args, opts.Args()...)
+	return w.runChanges("plan", args...)
+}
 
+// Destroy destroys the resources in the terraform state
+func (w *TfWorkspace) Destroy() error {
+	args := []string{"destroy",
+		"-input=false",
+		"-auto-approve",
+		"-var-file", w.tfvars,
+	}
+	args = append(args, w.stateArgs()...)
+	err := w.runChanges("destroy", args...)
+	if err != nil {
+		return err
+	}
+	w.stateWritten()
 	return nil
 }
@@ -1,6 +1,9 @@
 package terraform
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	. "github.com/onsi/ginkgo"
@@ -19,13 +22,28 @@ type MockRunner struct {
 	args     []string
     workDir  string
     env      map[string]string
+
+    // arguments of all the commands run
+    calls    [][]string
+
+    // result to return, if any
+    result   *cmdrunner.CmdResult
+
+    // results to return from the successive runs, before returning result
+    results  []*cmdrunner.CmdResult
 }
 
 func (r *MockRunner) Run(cmd string, args ...string) (*cmdrunner.CmdResult, error) {
 	r.shellCmd = cmd
 	r.args = args
+	r.calls = append(r.calls, args)
 
-	return nil, nil
+	if len(r.results) > 0 {
+		result := r.results[0]
+		r.results = r.results[1:]
+		return result, nil
+	}
+	return r.result, nil
 }
 
 func (r *MockRunner) SetWorkDir(path string) error {
@@ -98,8 +116,8 @@ var _ = Describe("Terraform Runner", func() {
 			Expect(mockRunner.args).To(ContainElement("apply"))
 		})
 
-		It("Should use auto-aprove option", func() {
-			Expect(mockRunner.args).To(ContainElement("-auto-aprove"))
+		It("Should use auto-approve option", func() {
+			Expect(mockRunner.args).To(ContainElement("-auto-approve"))
 		})
 
 		It("Should prevent variable inputs", func() {
@@ -116,4 +134,182 @@ var _ = Describe("Terraform Runner", func() {
 		})
 
 	})
+
+	Context("Run Apply with a backend override", func() {
+		var workDir string
+
+		BeforeEach(func() {
+			workDir, err = ioutil.TempDir("", "tfworkspace")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			mockRunner = NewMockRunner()
+			tfRunner := NewWithCmdRunner(mockRunner, "/path/to/tfvars", "/path/to/tfconfig", "/path/to/tfstate", workDir)
+			Expect(tfRunner.UseBackendOverride([]byte(`terraform { backend "http" {} }`))).To(Succeed())
+			err = tfRunner.Apply()
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(workDir)
+		})
+
+		It("Should add the override to the working directory", func() {
+			Expect(filepath.Join(workDir, "backend_override.tf")).To(BeARegularFile())
+		})
+
+		It("Should not set the state source and destination", func() {
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(mockRunner.args).NotTo(ContainElement("-state"))
+			Expect(mockRunner.args).NotTo(ContainElement("-state-out"))
+		})
+	})
+
+	Context("Run Plan", func() {
+		BeforeEach(func() {
+			mockRunner = NewMockRunner()
+			tfRunner := NewWithCmdRunner(mockRunner, "/path/to/tfvars", "/path/to/tfconfig", "/path/to/tfstate", "/path/to/workDir")
+			err = tfRunner.Plan(RunOptions{Targets: []string{"module.db"}})
+		})
+
+		It("Should plan with the options without writing the state", func() {
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(mockRunner.args).To(Equal([]string{"plan",
+				"-input=false",
+				"-var-file", "/path/to/tfvars",
+				"-state", "/path/to/tfstate",
+				"-target=module.db",
+			}))
+		})
+	})
+
+	Context("Run Plan with changes", func() {
+		var tfRunner *TfWorkspace
+
+		BeforeEach(func() {
+			mockRunner = NewMockRunner()
+			mockRunner.result = &cmdrunner.CmdResult{Output: "\x1b[1mPlan:\x1b[0m 2 to add, 1 to change, 0 to destroy.\n"}
+			tfRunner = NewWithCmdRunner(mockRunner, "/path/to/tfvars", "/path/to/tfconfig", "/path/to/tfstate", "/path/to/workDir")
+			err = tfRunner.Plan(RunOptions{})
+		})
+
+		It("Should keep the changes reported by the plan", func() {
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(tfRunner.Changes()).To(Equal(&Changes{Add: 2, Change: 1}))
+		})
+	})
+
+	Context("Run a failing command", func() {
+		BeforeEach(func() {
+			mockRunner = NewMockRunner()
+			mockRunner.result = &cmdrunner.CmdResult{ExitCode: 1, Output: "Error: Invalid provider configuration\n"}
+			tfRunner := NewWithCmdRunner(mockRunner, "/path/to/tfvars", "/path/to/tfconfig", "/path/to/tfstate", "/path/to/workDir")
+			err = tfRunner.Apply()
+		})
+
+		It("Should fail with the error reported by terraform", func() {
+			Expect(err).To(MatchError("terraform apply failed: Error: Invalid provider configuration"))
+		})
+	})
+
+	Context("Run Destroy", func() {
+		BeforeEach(func() {
+			mockRunner = NewMockRunner()
+			tfRunner := NewWithCmdRunner(mockRunner, "/path/to/tfvars", "/path/to/tfconfig", "/path/to/tfstate", "/path/to/workDir")
+			err = tfRunner.Destroy()
+		})
+
+		It("Should not fail", func() {
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("Should call terraform destroy without confirmation", func() {
+			Expect(mockRunner.shellCmd).To(Equal("terraform"))
+			Expect(mockRunner.args).To(ContainElement("destroy"))
+			Expect(mockRunner.args).To(ContainElement("-auto-approve"))
+		})
+
+		It("Should set the state source and destination", func() {
+			Expect(mockRunner.args).To(ContainElement("-state"))
+			Expect(mockRunner.args).To(ContainElement("-state-out"))
+		})
+	})
+
+	Context("Run Import", func() {
+		var (
+			workDir  string
+			tfRunner *TfWorkspace
+			results  []ImportResult
+			imports  map[string]string
+		)
+
+		BeforeEach(func() {
+			workDir, err = ioutil.TempDir("", "tfworkspace")
+			Expect(err).ShouldNot(HaveOccurred())
+			tfstate := filepath.Join(workDir, "input.tfstate")
+			Expect(ioutil.WriteFile(tfstate, []byte(tfstateJSON), 0644)).To(Succeed())
+
+			mockRunner = NewMockRunner()
+			tfRunner = NewWithCmdRunner(mockRunner, "/path/to/tfvars", "/path/to/tfconfig", tfstate, workDir)
+			imports = map[string]string{
+				"aws_vpc.main":                `vpc-1`,
+				`aws_s3_bucket.logs["prod"]`:  "logs-prod",
+				`aws_s3_bucket.logs["stage"]`: "logs-stage",
+			}
+		})
+
+		JustBeforeEach(func() {
+			results, err = tfRunner.Import(imports)
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(workDir)
+		})
+
+		It("Should import only the resources not in the state", func() {
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(results).To(Equal([]ImportResult{
+				{Address: `aws_s3_bucket.logs["prod"]`, ID: "logs-prod", Status: ImportStatusSkipped},
+				{Address: `aws_s3_bucket.logs["stage"]`, ID: "logs-stage", Status: ImportStatusImported},
+				{Address: "aws_vpc.main", ID: "vpc-1", Status: ImportStatusSkipped},
+			}))
+			Expect(mockRunner.calls).To(HaveLen(1))
+			Expect(mockRunner.args[0]).To(Equal("import"))
+			Expect(mockRunner.args[len(mockRunner.args)-2:]).To(Equal([]string{`aws_s3_bucket.logs["stage"]`, "logs-stage"}))
+		})
+
+		It("Should use the imported state in the following commands", func() {
+			Expect(tfRunner.Apply()).To(Succeed())
+			Expect(mockRunner.args).To(ContainElement(filepath.Join(workDir, "terraform.tfstate")))
+			Expect(mockRunner.args).NotTo(ContainElement(filepath.Join(workDir, "input.tfstate")))
+		})
+
+		Context("with an invalid address", func() {
+			BeforeEach(func() {
+				imports = map[string]string{"module.net": "net-1"}
+			})
+
+			It("Should report the failure without running terraform", func() {
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(results[0].Status).To(Equal(ImportStatusFailed))
+				Expect(mockRunner.calls).To(BeEmpty())
+			})
+		})
+
+		Context("with a failing import", func() {
+			BeforeEach(func() {
+				mockRunner.result = &cmdrunner.CmdResult{
+					ExitCode: 1,
+					Output:   "aws_subnet.a: Importing from ID \"subnet-1\"...\n\nError: Cannot import non-existent remote object\n",
+				}
+				imports = map[string]string{"aws_subnet.a": "subnet-1", "aws_subnet.b": "subnet-2"}
+			})
+
+			It("Should report the failure of each import", func() {
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(results).To(HaveLen(2))
+				Expect(results[0].Status).To(Equal(ImportStatusFailed))
+				Expect(results[0].Message).To(Equal("terraform import failed: Error: Cannot import non-existent remote object"))
+				Expect(mockRunner.calls).To(HaveLen(2))
+			})
+		})
+	})
 })
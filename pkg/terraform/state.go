@@ -0,0 +1,108 @@
+package terraform
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+const (
+	// StateKey is the key of the terraform state in the stack's tfstate Secret
+	StateKey = "terraform.tfstate"
+
+	// version of the state format supported
+	stateVersion = 4
+
+	// mode of the resources managed by terraform, as opposed to data sources
+	managedMode = "managed"
+)
+
+// StateResource is a resource managed by terraform, as recorded in its state
+type StateResource struct {
+	// Address of the resource, such as module.net.aws_subnet.private[0]
+	Address string
+
+	// Type of the resource
+	Type string
+
+	// Provider managing the resource
+	Provider string
+
+	// ID of the resource in the provider, if any
+	ID string
+}
+
+// state is the subset of the terraform state used for listing its resources
+type state struct {
+	Version   int             `json:"version"`
+	Resources []stateResource `json:"resources"`
+}
+
+type stateResource struct {
+	Module    string          `json:"module"`
+	Mode      string          `json:"mode"`
+	Type      string          `json:"type"`
+	Name      string          `json:"name"`
+	Provider  string          `json:"provider"`
+	Instances []stateInstance `json:"instances"`
+}
+
+type stateInstance struct {
+	IndexKey   json.RawMessage `json:"index_key"`
+	Attributes struct {
+		ID string `json:"id"`
+	} `json:"attributes"`
+}
+
+// ParseStateResources returns the resources managed by terraform in a state,
+// one for each instance of the resources, in the order of the state. Data
+// sources are not included. Only the state format of terraform 0.12 and later
+// is supported.
+func ParseStateResources(content []byte) ([]StateResource, error) {
+	st := state{}
+	err := json.Unmarshal(content, &st)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state: %v", err)
+	}
+	if st.Version != stateVersion {
+		return nil, fmt.Errorf("unsupported state version %d", st.Version)
+	}
+
+	resources := []StateResource{}
+	for _, res := range st.Resources {
+		if res.Mode != managedMode {
+			continue
+		}
+
+		address := res.Type + "." + res.Name
+		if res.Module != "" {
+			address = res.Module + "." + address
+		}
+
+		for _, instance := range res.Instances {
+			resources = append(resources, StateResource{
+				Address:  address + indexSuffix(instance.IndexKey),
+				Type:     res.Type,
+				Provider: res.Provider,
+				ID:       instance.Attributes.ID,
+			})
+		}
+	}
+
+	return resources, nil
+}
+
+// indexSuffix returns the index of a resource instance created with count or
+// for_each in the syntax of resource addresses: [0] or ["key"]
+func indexSuffix(key json.RawMessage) string {
+	if len(key) == 0 || string(key) == "null" {
+		return ""
+	}
+
+	var index int
+	if err := json.Unmarshal(key, &index); err == nil {
+		return "[" + strconv.Itoa(index) + "]"
+	}
+
+	return "[" + string(key) + "]"
+}
@@ -0,0 +1,188 @@
+package terraform
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	tfstateJSON = `{
+  "version": 4,
+  "terraform_version": "0.12.24",
+  "resources": [
+    {
+      "mode": "data",
+      "type": "aws_ami",
+      "name": "ubuntu",
+      "provider": "provider.aws",
+      "instances": [{"attributes": {"id": "ami-1"}}]
+    },
+    {
+      "mode": "managed",
+      "type": "aws_vpc",
+      "name": "main",
+      "provider": "provider.aws",
+      "instances": [{"attributes": {"id": "vpc-1"}}]
+    },
+    {
+      "module": "module.net",
+      "mode": "managed",
+      "type": "aws_subnet",
+      "name": "private",
+      "each": "list",
+      "provider": "provider.aws",
+      "instances": [
+        {"index_key": 0, "attributes": {"id": "subnet-1"}},
+        {"index_key": 1, "attributes": {"id": "subnet-2"}}
+      ]
+    },
+    {
+      "mode": "managed",
+      "type": "aws_s3_bucket",
+      "name": "logs",
+      "each": "map",
+      "provider": "provider.aws",
+      "instances": [{"index_key": "prod", "attributes": {"id": "logs-prod"}}]
+    }
+  ]
+}`
+)
+
+var _ = Describe("Terraform State", func() {
+	It("Should list the managed resources with their addresses", func() {
+		resources, err := ParseStateResources([]byte(tfstateJSON))
+		Expect(err).ShouldNot(HaveOccurred())
+
+		addresses := []string{}
+		for _, res := range resources {
+			addresses = append(addresses, res.Address)
+		}
+		Expect(addresses).To(Equal([]string{
+			"aws_vpc.main",
+			"module.net.aws_subnet.private[0]",
+			"module.net.aws_subnet.private[1]",
+			`aws_s3_bucket.logs["prod"]`,
+		}))
+		Expect(resources[0].ID).To(Equal("vpc-1"))
+		Expect(resources[0].Type).To(Equal("aws_vpc"))
+	})
+
+	It("Should reject old state versions", func() {
+		_, err := ParseStateResources([]byte(`{"version": 3, "modules": []}`))
+		Expect(err).Should(HaveOccurred())
+	})
+})
+
+var _ = Describe("Terraform State changes", func() {
+	// addresses returns the addresses of the managed resources in a state
+	addresses := func(content []byte) []string {
+		resources, err := ParseStateResources(content)
+		Expect(err).ShouldNot(HaveOccurred())
+		addresses := []string{}
+		for _, res := range resources {
+			addresses = append(addresses, res.Address)
+		}
+		return addresses
+	}
+
+	It("Should show the attributes of a resource's instances", func() {
+		instances, err := ShowStateResource([]byte(tfstateJSON), "module.net.aws_subnet.private[1]")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(instances).To(HaveLen(1))
+		Expect(instances[0].Address).To(Equal("module.net.aws_subnet.private[1]"))
+		Expect(string(instances[0].Attributes)).To(MatchJSON(`{"id": "subnet-2"}`))
+
+		instances, err = ShowStateResource([]byte(tfstateJSON), "data.aws_ami.ubuntu")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(instances[0].Address).To(Equal("data.aws_ami.ubuntu"))
+
+		_, err = ShowStateResource([]byte(tfstateJSON), "aws_vpc.other")
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("Should rename a resource increasing the serial", func() {
+		updated, err := MoveStateResource([]byte(tfstateJSON), "aws_vpc.main", "module.net.aws_vpc.this")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(addresses(updated)).To(ContainElement("module.net.aws_vpc.this"))
+		Expect(addresses(updated)).NotTo(ContainElement("aws_vpc.main"))
+
+		meta, err := ParseStateMeta(updated)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(meta.Serial).To(Equal(int64(1)))
+	})
+
+	It("Should move a module", func() {
+		updated, err := MoveStateResource([]byte(tfstateJSON), "module.net", "module.network")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(addresses(updated)).To(ContainElement("module.network.aws_subnet.private[0]"))
+		Expect(addresses(updated)).To(ContainElement("module.network.aws_subnet.private[1]"))
+	})
+
+	It("Should move an instance out of a resource", func() {
+		updated, err := MoveStateResource([]byte(tfstateJSON), "module.net.aws_subnet.private[1]", "aws_subnet.public")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(addresses(updated)).To(ContainElement("module.net.aws_subnet.private[0]"))
+		Expect(addresses(updated)).NotTo(ContainElement("module.net.aws_subnet.private[1]"))
+		Expect(addresses(updated)).To(ContainElement("aws_subnet.public"))
+	})
+
+	It("Should move an instance to another index", func() {
+		updated, err := MoveStateResource([]byte(tfstateJSON), `aws_s3_bucket.logs["prod"]`, `aws_s3_bucket.logs["prd.eu"]`)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(addresses(updated)).To(ContainElement(`aws_s3_bucket.logs["prd.eu"]`))
+	})
+
+	It("Should reject invalid moves", func() {
+		_, err := MoveStateResource([]byte(tfstateJSON), "aws_vpc.main", "aws_subnet.main")
+		Expect(err).Should(HaveOccurred())
+		_, err = MoveStateResource([]byte(tfstateJSON), "module.net.aws_subnet.private[0]", "module.net.aws_subnet.private[1]")
+		Expect(err).Should(HaveOccurred())
+		_, err = MoveStateResource([]byte(tfstateJSON), "aws_vpc.main", "module.net")
+		Expect(err).Should(HaveOccurred())
+		_, err = MoveStateResource([]byte(tfstateJSON), "aws_vpc.main[", "aws_vpc.other")
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("Should remove resources, instances and modules", func() {
+		updated, err := RemoveStateResources([]byte(tfstateJSON), []string{"module.net.aws_subnet.private[0]", `aws_s3_bucket.logs["prod"]`})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(addresses(updated)).To(Equal([]string{"aws_vpc.main", "module.net.aws_subnet.private[1]"}))
+
+		updated, err = RemoveStateResources(updated, []string{"module.net"})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(addresses(updated)).To(Equal([]string{"aws_vpc.main"}))
+
+		_, err = RemoveStateResources(updated, []string{"module.net"})
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("Should diff the resource instances of two states", func() {
+		updated, err := MoveStateResource([]byte(tfstateJSON), "module.net.aws_subnet.private[1]", "aws_subnet.public")
+		Expect(err).ShouldNot(HaveOccurred())
+		updated = []byte(strings.Replace(string(updated), "vpc-1", "vpc-2", 1))
+		updated = []byte(strings.Replace(string(updated), "ami-1", "ami-2", 1))
+
+		diff, err := DiffStates([]byte(tfstateJSON), updated)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(diff.Added).To(Equal([]string{"aws_subnet.public"}))
+		Expect(diff.Removed).To(Equal([]string{"module.net.aws_subnet.private[1]"}))
+		Expect(diff.Changed).To(Equal([]string{"aws_vpc.main"}))
+
+		diff, err = DiffStates(updated, updated)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(diff.Added).To(BeEmpty())
+		Expect(diff.Removed).To(BeEmpty())
+		Expect(diff.Changed).To(BeEmpty())
+	})
+
+	It("Should set the serial of a state", func() {
+		updated, err := SetStateSerial([]byte(tfstateJSON), 7)
+		Expect(err).ShouldNot(HaveOccurred())
+		meta, err := ParseStateMeta(updated)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(meta.Serial).To(Equal(int64(7)))
+		Expect(addresses(updated)).To(Equal(addresses([]byte(tfstateJSON))))
+	})
+})
@@ -0,0 +1,624 @@
+package terraform
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	// mode of the data sources in the state
+	dataMode = "data"
+)
+
+// StateMeta identifies a version of a state: its lineage is set when the
+// state is created and its serial increases with each change
+type StateMeta struct {
+	Lineage string
+	Serial  int64
+}
+
+// StateInstance is an instance of a resource in a state, with its attributes
+type StateInstance struct {
+	Address    string
+	Attributes json.RawMessage
+}
+
+// StateDiff lists the addresses of the resource instances added, removed and
+// changed between two states
+type StateDiff struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+// ParseStateMeta returns the lineage and serial of a state
+func ParseStateMeta(content []byte) (StateMeta, error) {
+	st, err := decodeStateFile(content)
+	if err != nil {
+		return StateMeta{}, err
+	}
+	return StateMeta{Lineage: st.lineage, Serial: st.serial}, nil
+}
+
+// ShowStateResource returns the instances of the resources matching an
+// address: a resource, an instance of a resource or a module
+func ShowStateResource(content []byte, address string) ([]StateInstance, error) {
+	st, err := decodeStateFile(content)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	instances := []StateInstance{}
+	for _, res := range st.resources {
+		if !addr.matchesResource(res) {
+			continue
+		}
+		for _, instance := range res.instances {
+			if !addr.matchesInstance(instance) {
+				continue
+			}
+			instances = append(instances, StateInstance{
+				Address:    res.address() + indexSuffix(instance["index_key"]),
+				Attributes: instance["attributes"],
+			})
+		}
+	}
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("no resources found at %s", address)
+	}
+
+	return instances, nil
+}
+
+// DiffStates compares the resource instances in two states, reporting the
+// ones added, removed or with changed attributes in the second state. Data
+// sources are not compared.
+func DiffStates(from []byte, to []byte) (*StateDiff, error) {
+	fromInstances, err := managedInstances(from)
+	if err != nil {
+		return nil, err
+	}
+	toInstances, err := managedInstances(to)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &StateDiff{Added: []string{}, Removed: []string{}, Changed: []string{}}
+	for address, attributes := range toInstances {
+		previous, found := fromInstances[address]
+		switch {
+		case !found:
+			diff.Added = append(diff.Added, address)
+		case !jsonEqual(previous, attributes):
+			diff.Changed = append(diff.Changed, address)
+		}
+	}
+	for address := range fromInstances {
+		if _, found := toInstances[address]; !found {
+			diff.Removed = append(diff.Removed, address)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+
+	return diff, nil
+}
+
+// SetStateSerial returns a state with its serial replaced, as when restoring
+// a previous version of a state over a newer one
+func SetStateSerial(content []byte, serial int64) ([]byte, error) {
+	st, err := decodeStateFile(content)
+	if err != nil {
+		return nil, err
+	}
+
+	// encode increases the serial
+	st.serial = serial - 1
+	return st.encode()
+}
+
+// MoveStateResource moves a module, a resource or an instance of a resource
+// to another address in a state and returns the updated state, with its
+// serial increased. The destination must not exist, except when moving an
+// instance into an existing resource of the same type.
+func MoveStateResource(content []byte, src string, dest string) ([]byte, error) {
+	st, err := decodeStateFile(content)
+	if err != nil {
+		return nil, err
+	}
+	from, err := parseAddress(src)
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseAddress(dest)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case from.isModule() && to.isModule():
+		err = st.moveModule(from, to)
+	case from.isModule() || to.isModule():
+		err = fmt.Errorf("can't move %s to %s: a module can only be moved to another module", src, dest)
+	case from.index == nil && to.index == nil:
+		err = st.moveResource(from, to)
+	case from.index != nil:
+		err = st.moveInstance(from, to)
+	default:
+		err = fmt.Errorf("can't move the resource %s to the instance %s", src, dest)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return st.encode()
+}
+
+// RemoveStateResources removes the modules, resources or instances at the
+// given addresses from a state and returns the updated state, with its serial
+// increased. All the addresses must match some resource.
+func RemoveStateResources(content []byte, addresses []string) ([]byte, error) {
+	st, err := decodeStateFile(content)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, address := range addresses {
+		addr, err := parseAddress(address)
+		if err != nil {
+			return nil, err
+		}
+
+		removed := false
+		resources := []*stateFileResource{}
+		for _, res := range st.resources {
+			if addr.matchesResource(res) {
+				instances := []map[string]json.RawMessage{}
+				for _, instance := range res.instances {
+					if addr.matchesInstance(instance) {
+						removed = true
+						continue
+					}
+					instances = append(instances, instance)
+				}
+				res.instances = instances
+				if len(instances) == 0 {
+					removed = true
+					continue
+				}
+			}
+			resources = append(resources, res)
+		}
+		if !removed {
+			return nil, fmt.Errorf("no resources found at %s", address)
+		}
+		st.resources = resources
+	}
+
+	return st.encode()
+}
+
+// stateFile is a state decoded keeping all its fields, for modifying its
+// resources
+type stateFile struct {
+	fields    map[string]json.RawMessage
+	lineage   string
+	serial    int64
+	resources []*stateFileResource
+}
+
+// stateFileResource is a resource in a state, keeping all its fields
+type stateFileResource struct {
+	fields    map[string]json.RawMessage
+	module    string
+	mode      string
+	typ       string
+	name      string
+	instances []map[string]json.RawMessage
+}
+
+// address returns the address of the resource, without instance index
+func (r *stateFileResource) address() string {
+	address := r.typ + "." + r.name
+	if r.mode == dataMode {
+		address = dataMode + "." + address
+	}
+	if r.module != "" {
+		address = r.module + "." + address
+	}
+	return address
+}
+
+// decodeStateFile decodes a state in the format of terraform 0.12 and later
+func decodeStateFile(content []byte) (*stateFile, error) {
+	st := &stateFile{}
+	err := json.Unmarshal(content, &st.fields)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state: %v", err)
+	}
+
+	var version int
+	err = unmarshalField(st.fields, "version", &version)
+	if err == nil && version != stateVersion {
+		err = fmt.Errorf("unsupported state version %d", version)
+	}
+	if err == nil {
+		err = unmarshalField(st.fields, "lineage", &st.lineage)
+	}
+	if err == nil {
+		err = unmarshalField(st.fields, "serial", &st.serial)
+	}
+	resources := []map[string]json.RawMessage{}
+	if err == nil {
+		err = unmarshalField(st.fields, "resources", &resources)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid state: %v", err)
+	}
+
+	for _, fields := range resources {
+		res := &stateFileResource{fields: fields}
+		for name, value := range map[string]interface{}{
+			"module":    &res.module,
+			"mode":      &res.mode,
+			"type":      &res.typ,
+			"name":      &res.name,
+			"instances": &res.instances,
+		} {
+			err = unmarshalField(fields, name, value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid state: %v", err)
+			}
+		}
+		st.resources = append(st.resources, res)
+	}
+
+	return st, nil
+}
+
+// managedInstances returns the attributes of the instances of the managed
+// resources in a state by their address
+func managedInstances(content []byte) (map[string]json.RawMessage, error) {
+	st, err := decodeStateFile(content)
+	if err != nil {
+		return nil, err
+	}
+
+	instances := map[string]json.RawMessage{}
+	for _, res := range st.resources {
+		if res.mode == dataMode {
+			continue
+		}
+		for _, instance := range res.instances {
+			instances[res.address()+indexSuffix(instance["index_key"])] = instance["attributes"]
+		}
+	}
+	return instances, nil
+}
+
+// jsonEqual indicates if two json values are equal, regardless of their
+// formatting and the order of their fields
+func jsonEqual(a json.RawMessage, b json.RawMessage) bool {
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return string(a) == string(b)
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// unmarshalField decodes a field, if present
+func unmarshalField(fields map[string]json.RawMessage, name string, value interface{}) error {
+	raw, found := fields[name]
+	if !found || string(raw) == "null" {
+		return nil
+	}
+	err := json.Unmarshal(raw, value)
+	if err != nil {
+		return fmt.Errorf("field %s: %v", name, err)
+	}
+	return nil
+}
+
+// encode returns the state with its resources, increasing its serial
+func (st *stateFile) encode() ([]byte, error) {
+	resources := []map[string]json.RawMessage{}
+	for _, res := range st.resources {
+		fields := res.fields
+		delete(fields, "module")
+		if res.module != "" {
+			fields["module"] = marshalField(res.module)
+		}
+		fields["mode"] = marshalField(res.mode)
+		fields["type"] = marshalField(res.typ)
+		fields["name"] = marshalField(res.name)
+		fields["instances"] = marshalField(res.instances)
+		resources = append(resources, fields)
+	}
+
+	st.serial++
+	st.fields["serial"] = marshalField(st.serial)
+	st.fields["resources"] = marshalField(resources)
+
+	return json.MarshalIndent(st.fields, "", "  ")
+}
+
+// marshalField encodes a value that is known to be valid json
+func marshalField(value interface{}) json.RawMessage {
+	raw, _ := json.Marshal(value)
+	return raw
+}
+
+// find returns the resource at an address, if any
+func (st *stateFile) find(addr *address) *stateFileResource {
+	for _, res := range st.resources {
+		if addr.matchesResource(res) {
+			return res
+		}
+	}
+	return nil
+}
+
+// remove removes a resource from the state
+func (st *stateFile) remove(removed *stateFileResource) {
+	resources := []*stateFileResource{}
+	for _, res := range st.resources {
+		if res != removed {
+			resources = append(resources, res)
+		}
+	}
+	st.resources = resources
+}
+
+// moveModule moves the resources of a module and its submodules to another
+// module, which must have no resources
+func (st *stateFile) moveModule(from *address, to *address) error {
+	for _, res := range st.resources {
+		if to.matchesResource(res) {
+			return fmt.Errorf("destination %s already has resources", to.module)
+		}
+	}
+
+	moved := false
+	for _, res := range st.resources {
+		if from.matchesResource(res) {
+			res.module = to.module + strings.TrimPrefix(res.module, from.module)
+			moved = true
+		}
+	}
+	if !moved {
+		return fmt.Errorf("no resources found at %s", from.module)
+	}
+	return nil
+}
+
+// moveResource renames a resource with all its instances
+func (st *stateFile) moveResource(from *address, to *address) error {
+	res := st.find(from)
+	if res == nil {
+		return fmt.Errorf("no resources found at %s", from)
+	}
+	if st.find(to) != nil {
+		return fmt.Errorf("destination %s already exists", to)
+	}
+	if res.mode != to.mode || res.typ != to.typ {
+		return fmt.Errorf("can't move %s to %s: resource types must match", from, to)
+	}
+
+	res.module = to.module
+	res.name = to.name
+	return nil
+}
+
+// moveInstance moves an instance of a resource to an instance of another or
+// the same resource, creating the resource if it doesn't exist
+func (st *stateFile) moveInstance(from *address, to *address) error {
+	src := st.find(from)
+	if src == nil {
+		return fmt.Errorf("no resources found at %s", from)
+	}
+	if src.mode != to.mode || src.typ != to.typ {
+		return fmt.Errorf("can't move %s to %s: resource types must match", from, to)
+	}
+
+	var instance map[string]json.RawMessage
+	remaining := []map[string]json.RawMessage{}
+	for _, inst := range src.instances {
+		if from.matchesInstance(inst) {
+			instance = inst
+			continue
+		}
+		remaining = append(remaining, inst)
+	}
+	if instance == nil {
+		return fmt.Errorf("no resources found at %s", from)
+	}
+
+	dest := st.find(to)
+	if dest != nil {
+		for _, inst := range dest.instances {
+			if to.index == nil || to.matchesInstance(inst) {
+				return fmt.Errorf("destination %s already exists", to)
+			}
+		}
+	}
+
+	delete(instance, "index_key")
+	if to.index != nil {
+		instance["index_key"] = to.index
+	}
+	src.instances = remaining
+
+	if dest == nil {
+		dest = &stateFileResource{fields: map[string]json.RawMessage{}}
+		for name, value := range src.fields {
+			dest.fields[name] = value
+		}
+		delete(dest.fields, "each")
+		if to.index != nil {
+			dest.fields["each"] = marshalField(to.eachMode())
+		}
+		dest.module = to.module
+		dest.mode = src.mode
+		dest.typ = src.typ
+		dest.name = to.name
+		st.resources = append(st.resources, dest)
+	}
+	dest.instances = append(dest.instances, instance)
+
+	if len(src.instances) == 0 {
+		st.remove(src)
+	}
+	return nil
+}
+
+// address is a parsed address of a module, a resource or an instance of a
+// resource, such as module.net.aws_subnet.private[0]
+type address struct {
+	module string
+	mode   string
+	typ    string
+	name   string
+
+	// index key of the instance, as json
+	index json.RawMessage
+}
+
+// parseAddress parses a resource address
+func parseAddress(s string) (*address, error) {
+	parts, err := splitAddress(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %s: %v", s, err)
+	}
+
+	addr := &address{mode: managedMode}
+	modules := []string{}
+	i := 0
+	for i+1 < len(parts) && parts[i] == "module" {
+		modules = append(modules, "module."+parts[i+1])
+		i += 2
+	}
+	addr.module = strings.Join(modules, ".")
+
+	rest := parts[i:]
+	if len(rest) > 0 && rest[0] == dataMode {
+		addr.mode = dataMode
+		rest = rest[1:]
+	}
+	switch {
+	case len(rest) == 0 && addr.mode == managedMode && addr.module != "":
+		return addr, nil
+	case len(rest) != 2:
+		return nil, fmt.Errorf("invalid address %s", s)
+	}
+
+	addr.typ = rest[0]
+	addr.name = rest[1]
+	if open := strings.Index(addr.name, "["); open >= 0 {
+		key := addr.name[open+1 : len(addr.name)-1]
+		addr.name = addr.name[:open]
+		var index interface{}
+		err = json.Unmarshal([]byte(key), &index)
+		if err != nil {
+			return nil, fmt.Errorf("invalid index in address %s", s)
+		}
+		addr.index = json.RawMessage(key)
+	}
+	if addr.typ == "" || addr.name == "" {
+		return nil, fmt.Errorf("invalid address %s", s)
+	}
+
+	return addr, nil
+}
+
+// splitAddress splits an address by dots, except the ones in index keys
+func splitAddress(s string) ([]string, error) {
+	parts := []string{}
+	start := 0
+	inIndex := false
+	inString := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case inString && c == '\\':
+			i++
+		case c == '"' && inIndex:
+			inString = !inString
+		case inString:
+		case c == '[':
+			inIndex = true
+		case c == ']':
+			if !inIndex {
+				return nil, fmt.Errorf("unexpected ]")
+			}
+			inIndex = false
+			if i+1 < len(s) && s[i+1] != '.' {
+				return nil, fmt.Errorf("unexpected %c after index", s[i+1])
+			}
+		case c == '.' && !inIndex:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	if inIndex {
+		return nil, fmt.Errorf("unterminated index")
+	}
+	parts = append(parts, s[start:])
+
+	for _, part := range parts {
+		if part == "" {
+			return nil, fmt.Errorf("empty name")
+		}
+	}
+	return parts, nil
+}
+
+// isModule indicates if the address is of a module
+func (a *address) isModule() bool {
+	return a.typ == ""
+}
+
+// matchesResource indicates if a resource is at the address or, for
+// modules, in the module or its submodules
+func (a *address) matchesResource(res *stateFileResource) bool {
+	if a.isModule() {
+		return res.module == a.module || strings.HasPrefix(res.module, a.module+".")
+	}
+	return res.module == a.module && res.mode == a.mode && res.typ == a.typ && res.name == a.name
+}
+
+// matchesInstance indicates if an instance of a matching resource is at the
+// address. Addresses without index match all the instances.
+func (a *address) matchesInstance(instance map[string]json.RawMessage) bool {
+	if a.index == nil {
+		return true
+	}
+	var expected, actual interface{}
+	if json.Unmarshal(a.index, &expected) != nil || json.Unmarshal(instance["index_key"], &actual) != nil {
+		return false
+	}
+	return reflect.DeepEqual(expected, actual)
+}
+
+// eachMode returns the mode of a resource with instances indexed as the
+// address: list for count and map for for_each
+func (a *address) eachMode() string {
+	if strings.HasPrefix(string(a.index), `"`) {
+		return "map"
+	}
+	return "list"
+}
+
+func (a *address) String() string {
+	res := &stateFileResource{module: a.module, mode: a.mode, typ: a.typ, name: a.name}
+	if a.isModule() {
+		return a.module
+	}
+	return res.address() + indexSuffix(a.index)
+}
@@ -0,0 +1,237 @@
+package terraform
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ParseVars returns the variables assigned in a tfvars file, with their values
+// as they appear in the file. Comments and indentation are removed from the
+// values, so they can be compared for detecting changes. Files with a .json
+// extension are parsed as json.
+func ParseVars(fileName string, content []byte) (map[string]string, error) {
+	if strings.HasSuffix(fileName, ".json") {
+		return parseJSONVars(content)
+	}
+	return parseHCLVars(string(content))
+}
+
+// parseJSONVars returns the variables in a tfvars.json file
+func parseJSONVars(content []byte) (map[string]string, error) {
+	raw := map[string]json.RawMessage{}
+	err := json.Unmarshal(content, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid json tfvars: %v", err)
+	}
+
+	vars := map[string]string{}
+	for name, value := range raw {
+		compact := new(bytes.Buffer)
+		err = json.Compact(compact, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %v", name, err)
+		}
+		vars[name] = compact.String()
+	}
+
+	return vars, nil
+}
+
+// parseHCLVars returns the variables in a tfvars file, as a sequence of
+// name = value assignments. Values end at the end of the line, unless they
+// have open brackets, strings or heredocs.
+func parseHCLVars(src string) (map[string]string, error) {
+	vars := map[string]string{}
+	i := 0
+	for {
+		i = skipSpaceAndComments(src, i)
+		if i >= len(src) {
+			return vars, nil
+		}
+
+		start := i
+		for i < len(src) && isIdentChar(src[i], i == start) {
+			i++
+		}
+		if i == start {
+			return nil, fmt.Errorf("line %d: expected a variable name", lineNumber(src, i))
+		}
+		name := src[start:i]
+
+		for i < len(src) && (src[i] == ' ' || src[i] == '\t') {
+			i++
+		}
+		if i >= len(src) || src[i] != '=' {
+			return nil, fmt.Errorf("line %d: expected = after %s", lineNumber(src, i), name)
+		}
+
+		value, next, err := scanValue(src, i+1)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid value for %s: %v", lineNumber(src, start), name, err)
+		}
+		vars[name] = value
+		i = next
+	}
+}
+
+// skipSpaceAndComments returns the position of the next character that is
+// not a white space or part of a comment
+func skipSpaceAndComments(src string, i int) int {
+	for i < len(src) {
+		switch {
+		case strings.ContainsRune(" \t\r\n", rune(src[i])):
+			i++
+		case src[i] == '#' || strings.HasPrefix(src[i:], "//"):
+			i = skipLine(src, i)
+		case strings.HasPrefix(src[i:], "/*"):
+			i = skipBlockComment(src, i)
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// skipLine returns the position of the end of the current line
+func skipLine(src string, i int) int {
+	end := strings.IndexByte(src[i:], '\n')
+	if end < 0 {
+		return len(src)
+	}
+	return i + end
+}
+
+// skipBlockComment returns the position after the block comment starting at i
+func skipBlockComment(src string, i int) int {
+	end := strings.Index(src[i+2:], "*/")
+	if end < 0 {
+		return len(src)
+	}
+	return i + 2 + end + 2
+}
+
+// isIdentChar indicates if a character is valid in a variable name
+func isIdentChar(c byte, first bool) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		return true
+	case c >= '0' && c <= '9', c == '-':
+		return !first
+	}
+	return false
+}
+
+// lineNumber returns the line of a position in the source
+func lineNumber(src string, i int) int {
+	if i > len(src) {
+		i = len(src)
+	}
+	return strings.Count(src[:i], "\n") + 1
+}
+
+// scanValue reads the value starting at position i and returns it, without
+// comments and indentation, and the position after it
+func scanValue(src string, i int) (string, int, error) {
+	value := strings.Builder{}
+	depth := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == '"':
+			end, err := scanString(src, i)
+			if err != nil {
+				return "", 0, err
+			}
+			value.WriteString(src[i:end])
+			i = end
+			continue
+		case strings.HasPrefix(src[i:], "<<"):
+			end, err := scanHeredoc(src, i)
+			if err != nil {
+				return "", 0, err
+			}
+			value.WriteString(src[i:end])
+			i = end
+			continue
+		case c == '#' || strings.HasPrefix(src[i:], "//"):
+			i = skipLine(src, i)
+			continue
+		case strings.HasPrefix(src[i:], "/*"):
+			i = skipBlockComment(src, i)
+			continue
+		case strings.ContainsRune("{[(", rune(c)):
+			depth++
+		case strings.ContainsRune("}])", rune(c)):
+			depth--
+			if depth < 0 {
+				return "", 0, fmt.Errorf("unexpected %c", c)
+			}
+		case c == '\n' && depth == 0:
+			normalized, err := normalizeValue(value.String())
+			return normalized, i + 1, err
+		}
+		value.WriteByte(c)
+		i++
+	}
+
+	if depth != 0 {
+		return "", 0, fmt.Errorf("unclosed brackets")
+	}
+	normalized, err := normalizeValue(value.String())
+	return normalized, i, err
+}
+
+// scanString returns the position after the quoted string starting at i
+func scanString(src string, i int) (int, error) {
+	for j := i + 1; j < len(src); j++ {
+		switch src[j] {
+		case '\\':
+			j++
+		case '\n':
+			return 0, fmt.Errorf("unterminated string")
+		case '"':
+			return j + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("unterminated string")
+}
+
+// scanHeredoc returns the position after the heredoc starting at i, which ends
+// with a line with only its marker
+func scanHeredoc(src string, i int) (int, error) {
+	start := i + 2
+	if start < len(src) && src[start] == '-' {
+		start++
+	}
+	eol := skipLine(src, start)
+	marker := strings.TrimSpace(src[start:eol])
+	if marker == "" {
+		return 0, fmt.Errorf("missing heredoc marker")
+	}
+
+	for pos := eol; pos < len(src); {
+		next := skipLine(src, pos+1)
+		if strings.TrimSpace(src[pos+1:next]) == marker {
+			return next, nil
+		}
+		pos = next
+	}
+	return 0, fmt.Errorf("unterminated heredoc %s", marker)
+}
+
+// normalizeValue removes indentation and empty lines from a value
+func normalizeValue(value string) (string, error) {
+	lines := []string{}
+	for _, line := range strings.Split(value, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return "", fmt.Errorf("missing value")
+	}
+	return strings.Join(lines, "\n"), nil
+}
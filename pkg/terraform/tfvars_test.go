@@ -0,0 +1,78 @@
+package terraform
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	tfvarsHCL = `
+# network settings
+region = "eu-west-1" // primary
+cidr   = "10.0.0.0/16"
+
+subnets = [
+  "10.0.1.0/24", # public
+  "10.0.2.0/24",
+]
+
+tags = {
+  env = "prod"
+}
+
+user_data = <<-EOT
+  #!/bin/sh
+  echo "hello"
+EOT
+count = 3
+`
+)
+
+var _ = Describe("Terraform tfvars", func() {
+	It("Should parse hcl assignments", func() {
+		vars, err := ParseVars("terraform.tfvars", []byte(tfvarsHCL))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(vars).To(HaveLen(6))
+		Expect(vars).To(HaveKeyWithValue("region", `"eu-west-1"`))
+		Expect(vars).To(HaveKeyWithValue("subnets", "[\n\"10.0.1.0/24\",\n\"10.0.2.0/24\",\n]"))
+		Expect(vars).To(HaveKeyWithValue("tags", "{\nenv = \"prod\"\n}"))
+		Expect(vars["user_data"]).To(ContainSubstring(`echo "hello"`))
+		Expect(vars).To(HaveKeyWithValue("count", "3"))
+	})
+
+	It("Should ignore comments and indentation", func() {
+		vars, _ := ParseVars("terraform.tfvars", []byte(tfvarsHCL))
+		reformatted, err := ParseVars("terraform.tfvars", []byte(`
+region = "eu-west-1"
+cidr = "10.0.0.0/16"
+subnets = [
+    "10.0.1.0/24",
+    "10.0.2.0/24",
+]
+tags = {
+    env = "prod"
+}
+user_data = <<-EOT
+  #!/bin/sh
+  echo "hello"
+EOT
+count = 3
+`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reformatted).To(Equal(vars))
+	})
+
+	It("Should parse json tfvars", func() {
+		vars, err := ParseVars("terraform.tfvars.json", []byte(`{"region": "eu-west-1", "tags": {"env": "prod"}}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(vars).To(HaveKeyWithValue("region", `"eu-west-1"`))
+		Expect(vars).To(HaveKeyWithValue("tags", `{"env":"prod"}`))
+	})
+
+	It("Should fail for invalid content", func() {
+		for _, invalid := range []string{`region "eu-west-1"`, `region = "eu-west-1`, `tags = {`, `count =`} {
+			_, err := ParseVars("terraform.tfvars", []byte(invalid))
+			Expect(err).To(HaveOccurred(), invalid)
+		}
+	})
+})
@@ -0,0 +1,138 @@
+package tfconfig
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// createTarGz returns a tar.gz archive with the files. The archive only
+// depends on the files' paths, permissions and content, so the same
+// configuration always produces the same bundle.
+func createTarGz(files []file) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+
+	for _, f := range files {
+		header := &tar.Header{
+			Name:     f.path,
+			Mode:     int64(f.mode),
+			Size:     int64(len(f.content)),
+			Typeflag: tar.TypeReg,
+			Format:   tar.FormatPAX,
+		}
+		err := tw.WriteHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tw.Write(f.content)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := tw.Close()
+	if err != nil {
+		return nil, err
+	}
+	err = gz.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// ExtractTarGz extracts a tar.gz stream into a destination directory
+func ExtractTarGz(r io.Reader, dest string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("invalid archive: %v", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid archive: %v", err)
+		}
+
+		// entries are rooted at the destination, so they can't escape from it
+		destPath := filepath.Join(dest, filepath.Clean("/"+header.Name))
+		if destPath == filepath.Clean(dest) {
+			continue
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(destPath, os.ModePerm)
+		case tar.TypeReg:
+			err = writeFile(tr, destPath, os.FileMode(header.Mode).Perm())
+		default:
+			// links and special files are not expected in a configuration
+			err = fmt.Errorf("unsupported archive entry %s", header.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readTarGz returns the content of the regular files in a tar.gz stream,
+// indexed by their path
+func readTarGz(r io.Reader) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive: %v", err)
+	}
+	defer gz.Close()
+
+	files := map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive: %v", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive: %v", err)
+		}
+		files[filepath.ToSlash(filepath.Clean(header.Name))] = content
+	}
+}
+
+// writeFile writes the content of a reader in a file, creating its
+// parent directories
+func writeFile(r io.Reader, path string, mode os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, r)
+	return err
+}
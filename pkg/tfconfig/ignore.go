@@ -0,0 +1,125 @@
+package tfconfig
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// name of the file with the ignore rules in the configuration directory
+	IgnoreFile = ".terraformignore"
+)
+
+var (
+	// rules applied to every configuration, as terraform does
+	defaultIgnoreRules = []string{
+		".git/",
+		".terraform/",
+		"*.tfstate",
+		"*.tfstate.backup",
+	}
+)
+
+// ignoreRule is a pattern from a .terraformignore file
+type ignoreRule struct {
+	pattern *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreRules decides which files of a configuration are excluded, following
+// the .terraformignore syntax: one pattern per line, comments starting with #,
+// ! for negating a pattern, a trailing / for matching only directories and
+// ** for matching any number of directories. Patterns without a / (other than
+// a trailing one) match at any level.
+type ignoreRules []ignoreRule
+
+// loadIgnoreRules returns the default rules plus the ones in the
+// .terraformignore file of a directory, if any
+func loadIgnoreRules(dir string) (ignoreRules, error) {
+	rules := ignoreRules{}
+	for _, line := range defaultIgnoreRules {
+		rules = append(rules, parseIgnoreRule(line))
+	}
+
+	file, err := os.Open(filepath.Join(dir, IgnoreFile))
+	if os.IsNotExist(err) {
+		return rules, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rules = append(rules, parseIgnoreRule(line))
+	}
+
+	return rules, scanner.Err()
+}
+
+// parseIgnoreRule converts a pattern into a rule
+func parseIgnoreRule(line string) ignoreRule {
+	rule := ignoreRule{}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	expr := ""
+	for i := 0; i < len(line); i++ {
+		switch {
+		case strings.HasPrefix(line[i:], "**/"):
+			expr += "(.*/)?"
+			i += 2
+		case strings.HasPrefix(line[i:], "**"):
+			expr += ".*"
+			i++
+		case line[i] == '*':
+			expr += "[^/]*"
+		case line[i] == '?':
+			expr += "[^/]"
+		default:
+			expr += regexp.QuoteMeta(string(line[i]))
+		}
+	}
+
+	if !anchored {
+		expr = "(.*/)?" + expr
+	}
+	rule.pattern = regexp.MustCompile("^" + expr + "$")
+
+	return rule
+}
+
+// ignored indicates if a path, relative to the configuration directory, is
+// excluded. The last matching rule decides.
+func (rules ignoreRules) ignored(relPath string, isDir bool) bool {
+	relPath = filepath.ToSlash(relPath)
+	ignored := false
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.pattern.MatchString(relPath) {
+			ignored = !rule.negate
+		}
+	}
+
+	return ignored
+}
@@ -0,0 +1,44 @@
+package tfconfig
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Ignore rules", func() {
+	var (
+		rules ignoreRules
+	)
+
+	BeforeEach(func() {
+		rules = ignoreRules{}
+		for _, line := range []string{"*.md", "/build", "tmp/", "**/fixtures/*.json", "!keep.md"} {
+			rules = append(rules, parseIgnoreRule(line))
+		}
+	})
+
+	It("Should match unanchored patterns at any level", func() {
+		Expect(rules.ignored("README.md", false)).To(BeTrue())
+		Expect(rules.ignored("modules/net/README.md", false)).To(BeTrue())
+	})
+
+	It("Should match anchored patterns only at the root", func() {
+		Expect(rules.ignored("build", false)).To(BeTrue())
+		Expect(rules.ignored("modules/build", false)).To(BeFalse())
+	})
+
+	It("Should match directory patterns only for directories", func() {
+		Expect(rules.ignored("modules/tmp", true)).To(BeTrue())
+		Expect(rules.ignored("modules/tmp", false)).To(BeFalse())
+	})
+
+	It("Should match any number of directories", func() {
+		Expect(rules.ignored("fixtures/a.json", false)).To(BeTrue())
+		Expect(rules.ignored("test/unit/fixtures/a.json", false)).To(BeTrue())
+		Expect(rules.ignored("test/fixtures/sub/a.json", false)).To(BeFalse())
+	})
+
+	It("Should apply negated patterns", func() {
+		Expect(rules.ignored("keep.md", false)).To(BeFalse())
+	})
+})
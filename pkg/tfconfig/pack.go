@@ -0,0 +1,245 @@
+package tfconfig
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// key of the bundle in the ConfigMap's binary data
+	BundleKey = "tfconfig.tar.gz"
+
+	// maximum size of a configuration stored as individual files.
+	// Larger configurations are bundled.
+	BundleThreshold = 512 * 1024
+
+	// maximum size of a packed configuration, leaving room in the ConfigMap
+	// (limited to 1MiB) for its metadata
+	MaxSize = 1000 * 1024
+)
+
+var (
+	// ErrEmpty is returned when there are no files to pack
+	ErrEmpty = errors.New("configuration has no files")
+
+	// ErrTooLarge is returned when the packed configuration doesn't fit in a ConfigMap
+	ErrTooLarge = errors.New("configuration is too large")
+
+	// valid ConfigMap keys
+	keyRegexp = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+)
+
+// Config is a packed terraform configuration, ready to be stored in a ConfigMap
+type Config struct {
+	Data       map[string]string
+	BinaryData map[string][]byte
+}
+
+// Bundled indicates if the configuration is packed as a tar.gz bundle
+func (c *Config) Bundled() bool {
+	_, found := c.BinaryData[BundleKey]
+	return found
+}
+
+// Files returns the content of the configuration's files, indexed by their
+// path relative to the configuration directory
+func (c *Config) Files() (map[string][]byte, error) {
+	if c.Bundled() {
+		return readTarGz(bytes.NewReader(c.BinaryData[BundleKey]))
+	}
+
+	files := map[string][]byte{}
+	for path, content := range c.Data {
+		files[path] = []byte(content)
+	}
+	for path, content := range c.BinaryData {
+		files[path] = content
+	}
+
+	return files, nil
+}
+
+// Hash returns the hex encoded sha256 of the configuration's content, which
+// identifies a revision of the configuration
+func (c *Config) Hash() string {
+	keys := []string{}
+	for key := range c.Data {
+		keys = append(keys, key)
+	}
+	for key := range c.BinaryData {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, key := range keys {
+		content, found := c.BinaryData[key]
+		if !found {
+			content = []byte(c.Data[key])
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00", key, len(content))
+		h.Write(content)
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// file is a file of the configuration
+type file struct {
+	path    string
+	mode    os.FileMode
+	content []byte
+}
+
+// Pack packs the files in a configuration directory and its subdirectories,
+// except those excluded by the .terraformignore file or in the exclude list
+// (as paths relative to the directory). A flat configuration is stored as one
+// key per file, using binary data for non text files. Configurations with
+// subdirectories or larger than BundleThreshold are stored as a tar.gz bundle.
+func Pack(dir string, exclude ...string) (*Config, error) {
+	files, err := collectFiles(dir, exclude)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, ErrEmpty
+	}
+
+	config := &Config{
+		Data:       map[string]string{},
+		BinaryData: map[string][]byte{},
+	}
+
+	if !needsBundle(files) {
+		for _, f := range files {
+			if utf8.Valid(f.content) {
+				config.Data[f.path] = string(f.content)
+			} else {
+				config.BinaryData[f.path] = f.content
+			}
+		}
+		return config, nil
+	}
+
+	bundle, err := createTarGz(files)
+	if err != nil {
+		return nil, err
+	}
+	if len(bundle) > MaxSize {
+		return nil, fmt.Errorf("%w: bundle has %d bytes, maximum is %d", ErrTooLarge, len(bundle), MaxSize)
+	}
+	config.BinaryData[BundleKey] = bundle
+
+	return config, nil
+}
+
+// collectFiles returns the files in a directory, sorted by path
+func collectFiles(dir string, exclude []string) ([]file, error) {
+	rules, err := loadIgnoreRules(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	excluded := map[string]bool{IgnoreFile: true}
+	for _, path := range exclude {
+		excluded[filepath.ToSlash(filepath.Clean(path))] = true
+	}
+
+	files := []file{}
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if relPath == "." {
+			return nil
+		}
+		relPath = filepath.ToSlash(relPath)
+
+		if rules.ignored(relPath, info.IsDir()) || excluded[relPath] {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files = append(files, file{path: relPath, mode: info.Mode().Perm(), content: content})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+	return files, nil
+}
+
+// needsBundle indicates if the files can't be stored as individual keys
+func needsBundle(files []file) bool {
+	size := 0
+	for _, f := range files {
+		if strings.Contains(f.path, "/") || !keyRegexp.MatchString(f.path) {
+			return true
+		}
+		size += len(f.content)
+	}
+
+	return size > BundleThreshold
+}
+
+// Unpack writes a configuration packed in a ConfigMap, as mounted in a
+// volume, into a destination directory
+func Unpack(src string, dest string) error {
+	bundle, err := os.Open(filepath.Join(src, BundleKey))
+	if err == nil {
+		defer bundle.Close()
+		return ExtractTarGz(bundle, dest)
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		// skip the hidden entries created when mounting a ConfigMap
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), "..") {
+			continue
+		}
+		// keys are mounted as links, read the content they point to
+		content, err := ioutil.ReadFile(filepath.Join(src, entry.Name()))
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(filepath.Join(dest, entry.Name()), content, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
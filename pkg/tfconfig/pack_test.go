@@ -0,0 +1,228 @@
+package tfconfig
+
+import (
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	main_tf = `
+module "net" {
+  source = "./modules/net"
+}
+`
+	module_tf = `
+variable "cidr" {}
+`
+)
+
+func TestTfConfig(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "TfConfig Suite")
+}
+
+// createConfigDir creates a temporary dir with the given files
+func createConfigDir(files map[string][]byte) string {
+	dir, err := ioutil.TempDir("", "tfconfig")
+	Expect(err).NotTo(HaveOccurred())
+	for path, content := range files {
+		absPath := filepath.Join(dir, path)
+		Expect(os.MkdirAll(filepath.Dir(absPath), os.ModePerm)).To(Succeed())
+		Expect(ioutil.WriteFile(absPath, content, 0644)).To(Succeed())
+	}
+	return dir
+}
+
+// mountConfig writes a packed config as a ConfigMap is mounted in a volume
+func mountConfig(config *Config) string {
+	dir, err := ioutil.TempDir("", "mount")
+	Expect(err).NotTo(HaveOccurred())
+	for key, value := range config.Data {
+		Expect(ioutil.WriteFile(filepath.Join(dir, key), []byte(value), 0644)).To(Succeed())
+	}
+	for key, value := range config.BinaryData {
+		Expect(ioutil.WriteFile(filepath.Join(dir, key), value, 0644)).To(Succeed())
+	}
+	return dir
+}
+
+// randomContent returns n random bytes
+func randomContent(n int) []byte {
+	content := make([]byte, n)
+	rand.Read(content)
+	return content
+}
+
+var _ = Describe("Pack configuration", func() {
+	var (
+		files   map[string][]byte
+		exclude []string
+		dir     string
+		config  *Config
+		err     error
+	)
+
+	JustBeforeEach(func() {
+		dir = createConfigDir(files)
+		config, err = Pack(dir, exclude...)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+		exclude = nil
+	})
+
+	Context("flat configuration", func() {
+		BeforeEach(func() {
+			files = map[string][]byte{
+				"main.tf":     []byte(main_tf),
+				"empty.tf":    []byte{},
+				"lambda.zip":  {0x50, 0x4b, 0x03, 0x04, 0xff, 0xfe},
+				"prod.tfvars": []byte("cidr = \"10.0.0.0/8\""),
+			}
+			exclude = []string{"prod.tfvars"}
+		})
+
+		It("Should store text files as data", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.Bundled()).To(BeFalse())
+			Expect(config.Data["main.tf"]).To(Equal(main_tf))
+		})
+
+		It("Should accept empty files", func() {
+			Expect(config.Data).To(HaveKeyWithValue("empty.tf", ""))
+		})
+
+		It("Should store binary files as binary data", func() {
+			Expect(config.BinaryData).To(HaveKey("lambda.zip"))
+			Expect(config.Data).NotTo(HaveKey("lambda.zip"))
+		})
+
+		It("Should skip excluded files", func() {
+			Expect(config.Data).NotTo(HaveKey("prod.tfvars"))
+		})
+
+		It("Should hash the content", func() {
+			same, _ := Pack(dir, exclude...)
+			Expect(config.Hash()).To(Equal(same.Hash()))
+
+			ioutil.WriteFile(filepath.Join(dir, "main.tf"), []byte(module_tf), 0666)
+			changed, _ := Pack(dir, exclude...)
+			Expect(config.Hash()).NotTo(Equal(changed.Hash()))
+		})
+	})
+
+	Context("configuration with local modules", func() {
+		BeforeEach(func() {
+			files = map[string][]byte{
+				"main.tf":                  []byte(main_tf),
+				"modules/net/main.tf":      []byte(module_tf),
+				".terraform/plugins/p":     []byte("plugin"),
+				".git/HEAD":                []byte("ref"),
+				"terraform.tfstate":        []byte("{}"),
+				"modules/net/README.md":    []byte("docs"),
+				"modules/net/docs/LICENSE": []byte("license"),
+				".terraformignore":         []byte("# docs\n**/*.md\ndocs/\n"),
+			}
+		})
+
+		It("Should bundle the configuration", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.Bundled()).To(BeTrue())
+			Expect(config.Data).To(BeEmpty())
+		})
+
+		It("Should unpack the same files, except the ignored ones", func() {
+			dest := mountConfig(config)
+			defer os.RemoveAll(dest)
+			out, _ := ioutil.TempDir("", "unpacked")
+			defer os.RemoveAll(out)
+
+			Expect(Unpack(dest, out)).To(Succeed())
+			content, _ := ioutil.ReadFile(filepath.Join(out, "modules", "net", "main.tf"))
+			Expect(string(content)).To(Equal(module_tf))
+			for _, ignored := range []string{".terraform", ".git", "terraform.tfstate", "modules/net/README.md", "modules/net/docs", ".terraformignore"} {
+				_, statErr := os.Stat(filepath.Join(out, ignored))
+				Expect(os.IsNotExist(statErr)).To(BeTrue(), ignored)
+			}
+		})
+
+		It("Should list the bundled files", func() {
+			bundled, err := config.Files()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(bundled).To(HaveLen(2))
+			Expect(bundled).To(HaveKeyWithValue("modules/net/main.tf", []byte(module_tf)))
+		})
+
+		It("Should produce the same bundle for the same files", func() {
+			again, _ := Pack(dir)
+			Expect(again.BinaryData[BundleKey]).To(Equal(config.BinaryData[BundleKey]))
+		})
+	})
+
+	Context("large flat configuration", func() {
+		BeforeEach(func() {
+			// text content compresses well
+			files = map[string][]byte{
+				"main.tf":  []byte(main_tf),
+				"large.tf": []byte(strings.Repeat("# comment\n", BundleThreshold/10+1)),
+			}
+		})
+
+		It("Should bundle the configuration", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.Bundled()).To(BeTrue())
+		})
+	})
+
+	Context("configuration larger than a ConfigMap", func() {
+		BeforeEach(func() {
+			// random content doesn't compress
+			files = map[string][]byte{
+				"blob.bin": randomContent(MaxSize + 1),
+			}
+		})
+
+		It("Should fail", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(err).To(MatchError(ContainSubstring(ErrTooLarge.Error())))
+		})
+	})
+
+	Context("configuration with only ignored files", func() {
+		BeforeEach(func() {
+			files = map[string][]byte{
+				".terraform/plugins/p": []byte("plugin"),
+			}
+		})
+
+		It("Should fail", func() {
+			Expect(err).To(Equal(ErrEmpty))
+		})
+	})
+})
+
+var _ = Describe("Unpack configuration", func() {
+	It("Should copy flat configurations skipping mount internals", func() {
+		src := createConfigDir(map[string][]byte{
+			"main.tf":           []byte(main_tf),
+			"..data/main.tf":    []byte(main_tf),
+			"..2020_01_01/x.tf": []byte(main_tf),
+		})
+		defer os.RemoveAll(src)
+		dest, _ := ioutil.TempDir("", "unpacked")
+		defer os.RemoveAll(dest)
+
+		Expect(Unpack(src, dest)).To(Succeed())
+		entries, _ := ioutil.ReadDir(dest)
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Name()).To(Equal("main.tf"))
+	})
+})
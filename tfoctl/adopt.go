@@ -0,0 +1,55 @@
+/*
+Copyright © 2020 Pablo Chacin <pablochacin@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/pablochacin/tf-operator/pkg/client"
+)
+
+type adoptOpts struct {
+	client    client.Client
+	stack     string
+	namespace string
+	configDir string
+	tfvars    string
+	tfstate   string
+	confirm   bool
+	force     bool
+	out       io.Writer
+}
+
+// run executes the adopt command
+func (o *adoptOpts) run() error {
+	if o.confirm {
+		_, err := o.client.ConfirmPlan(o.stack, o.namespace, o.force)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(o.out, "Plan of stack %s confirmed, the stack will be applied\n", o.stack)
+		return err
+	}
+
+	_, err := o.client.AdoptStack(o.stack, o.namespace, o.configDir, o.tfvars, o.tfstate)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(o.out, "Stack %s adopted as plan-only. Check its plan with 'tfoctl describe %s' and confirm it with 'tfoctl adopt %s --confirm'\n", o.stack, o.stack, o.stack)
+	return err
+}
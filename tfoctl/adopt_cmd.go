@@ -0,0 +1,82 @@
+/*
+Copyright © 2020 Pablo Chacin <pablochacin@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+
+	"github.com/pablochacin/tf-operator/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+func newAdoptCmd() *cobra.Command {
+
+	var kubeconfig string
+
+	opts := &adoptOpts{}
+
+	cmd := &cobra.Command{
+		Use:   "adopt STACK",
+		Short: "Adopt an existing terraform project as a terraform operator stack",
+		Long: `Create a terraform operator stack from a terraform configuration, a tfvars
+file and the state of the resources already created with them. The
+configuration is packed as in create, and the state is stored as the stack's
+state.
+
+The stack is created as plan-only: its runs only plan the changes to its
+resources, which are shown by tfoctl describe. Once the plan shows no changes,
+confirm it with --confirm for the stack to be applied. With --force, the plan
+is confirmed even if it has changes. Deleting a plan-only stack doesn't
+destroy its resources.`,
+		Example: `
+# Adopt the project in the working directory, using its local state
+tfoctl adopt MyStack
+
+# Adopt a project with a remote state
+terraform state pull > /tmp/terraform.tfstate
+tfoctl adopt MyStack -c ./network --state /tmp/terraform.tfstate
+
+# Confirm the plan, once it shows no changes
+tfoctl adopt MyStack --confirm`,
+		Args: cobra.ExactArgs(1),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if opts.force && !opts.confirm {
+				return fmt.Errorf("force can only be used with confirm")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := client.NewFromKubeconfig(kubeconfig)
+			if err != nil {
+				return err
+			}
+			opts.client = client
+			opts.stack = args[0]
+			opts.out = cmd.OutOrStdout()
+			return opts.run()
+		},
+	}
+
+	cmd.Flags().StringVarP(&kubeconfig, "kubeconfig", "k", "", "path to kubeconfig for cluster. If not specified, default discovery rules will apply")
+	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "default", "namespace for stack")
+	cmd.Flags().StringVarP(&opts.configDir, "config", "c", "./", "path to the terraform configuration directory. All files not excluded by .terraformignore will be used as the stack configuration. Default is current directory")
+	cmd.Flags().StringVarP(&opts.tfvars, "vars", "v", "terraform.tfvars", "path to terraform vars file")
+	cmd.Flags().StringVar(&opts.tfstate, "state", "terraform.tfstate", "path to the state of the resources")
+	cmd.Flags().BoolVar(&opts.confirm, "confirm", false, "confirm the plan of an adopted stack, so it is applied")
+	cmd.Flags().BoolVar(&opts.force, "force", false, "confirm the plan even if it has changes")
+
+	return cmd
+}
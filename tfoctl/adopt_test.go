@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pablochacin/tf-operator/pkg/client"
+)
+
+var _ = Describe("adopt", func() {
+	var (
+		opts   *adoptOpts
+		fake   *fakeClient
+		output *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		output = new(bytes.Buffer)
+		fake = &fakeClient{stack: newReadyStack(stackName, "default")}
+		opts = &adoptOpts{
+			client:    fake,
+			stack:     stackName,
+			namespace: "default",
+			configDir: "./",
+			tfvars:    "terraform.tfvars",
+			tfstate:   "terraform.tfstate",
+			out:       output,
+		}
+	})
+
+	It("Should adopt the stack with its state", func() {
+		Expect(opts.run()).To(Succeed())
+		Expect(fake.adopted).To(Equal("terraform.tfstate"))
+		Expect(fake.confirmed).To(BeFalse())
+		Expect(output.String()).To(ContainSubstring("adopted as plan-only"))
+	})
+
+	It("Should confirm the plan", func() {
+		opts.confirm = true
+		opts.force = true
+		Expect(opts.run()).To(Succeed())
+		Expect(fake.adopted).To(BeEmpty())
+		Expect(fake.confirmed).To(BeTrue())
+		Expect(fake.forced).To(BeTrue())
+	})
+
+	It("Should report a plan with changes", func() {
+		fake.withError(client.NewTFOError("the plan has changes", client.ErrorReasonConflict))
+		opts.confirm = true
+		Expect(opts.run()).NotTo(Succeed())
+		Expect(output.String()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,239 @@
+/*
+Copyright © 2020 Pablo Chacin <pablochacin@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+	"github.com/pablochacin/tf-operator/pkg/client"
+	yamlutil "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// file in the manifests' directory with the local files applied with
+	// each stack, as an alternative to the stack's annotations
+	companionFile = ".tfoctl.yaml"
+
+	// size of the buffer for detecting if a manifest is yaml or json
+	manifestBufferSize = 4096
+)
+
+// stackFiles defines the local configuration directory and tfvars file of
+// a stack
+type stackFiles struct {
+	Config string `json:"config,omitempty"`
+	Vars   string `json:"vars,omitempty"`
+}
+
+// companion defines the content of the companion file: the local files of
+// each stack, by name
+type companion struct {
+	Stacks map[string]stackFiles `json:"stacks"`
+}
+
+// stackManifest is a stack read from a manifest with its local files
+type stackManifest struct {
+	stack *tfo.Stack
+	opts  client.ApplyOptions
+}
+
+type applyOpts struct {
+	client    client.Client
+	files     []string
+	namespace string
+	out       io.Writer
+}
+
+// run executes the apply command. All the manifests are read before
+// applying any stack.
+func (o *applyOpts) run() error {
+	manifests := []stackManifest{}
+	for _, path := range o.files {
+		read, err := readManifests(path, o.namespace)
+		if err != nil {
+			return err
+		}
+		manifests = append(manifests, read...)
+	}
+	if len(manifests) == 0 {
+		return fmt.Errorf("no stacks found in %s", strings.Join(o.files, ", "))
+	}
+
+	for _, manifest := range manifests {
+		result, err := o.client.ApplyStack(manifest.stack, manifest.opts)
+		if err != nil {
+			return err
+		}
+		for _, obj := range result.Objects {
+			fmt.Fprintf(o.out, "%s/%s %s\n", strings.ToLower(obj.Kind), obj.Name, obj.Operation)
+		}
+	}
+
+	return nil
+}
+
+// readManifests reads the stacks in a manifest file or in the yaml and json
+// files of a directory, in alphabetical order. Stacks without namespace are
+// set in the given namespace.
+func readManifests(path string, namespace string) ([]stackManifest, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		files = []string{}
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || strings.HasPrefix(name, ".") {
+				continue
+			}
+			switch filepath.Ext(name) {
+			case ".yaml", ".yml", ".json":
+				files = append(files, filepath.Join(path, name))
+			}
+		}
+		sort.Strings(files)
+	}
+
+	manifests := []stackManifest{}
+	for _, file := range files {
+		read, err := readManifestFile(file, namespace)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		manifests = append(manifests, read...)
+	}
+	return manifests, nil
+}
+
+// readManifestFile reads the stacks in a file with one or more yaml
+// documents or json objects, with their local files
+func readManifestFile(file string, namespace string) ([]stackManifest, error) {
+	dir := filepath.Dir(file)
+	files, err := readCompanion(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	manifests := []stackManifest{}
+	decoder := yamlutil.NewYAMLOrJSONDecoder(f, manifestBufferSize)
+	for {
+		doc := map[string]interface{}{}
+		err = decoder.Decode(&doc)
+		if err == io.EOF {
+			return manifests, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(doc) == 0 {
+			continue
+		}
+
+		stack, err := decodeStack(doc)
+		if err != nil {
+			return nil, err
+		}
+		if stack.Namespace == "" {
+			stack.Namespace = namespace
+		}
+
+		local := files.Stacks[stack.Name]
+		if path, found := stack.Annotations[client.ConfigDirAnnotation]; found {
+			local.Config = path
+		}
+		if path, found := stack.Annotations[client.TfVarsFileAnnotation]; found {
+			local.Vars = path
+		}
+
+		manifests = append(manifests, stackManifest{
+			stack: stack,
+			opts: client.ApplyOptions{
+				TfConfig: relativeTo(dir, local.Config),
+				TfVars:   relativeTo(dir, local.Vars),
+			},
+		})
+	}
+}
+
+// decodeStack returns the stack defined by a manifest's document
+func decodeStack(doc map[string]interface{}) (*tfo.Stack, error) {
+	if doc["apiVersion"] != tfo.GroupVersion.String() || doc["kind"] != "Stack" {
+		return nil, fmt.Errorf("unsupported object %v %v: only %s Stacks can be applied", doc["apiVersion"], doc["kind"], tfo.GroupVersion)
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	stack := &tfo.Stack{}
+	err = json.Unmarshal(data, stack)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stack: %v", err)
+	}
+	if stack.Name == "" {
+		return nil, fmt.Errorf("stack without name")
+	}
+
+	return stack, nil
+}
+
+// readCompanion reads the companion file of a directory, if any
+func readCompanion(dir string) (*companion, error) {
+	c := &companion{}
+	content, err := ioutil.ReadFile(filepath.Join(dir, companionFile))
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = yaml.Unmarshal(content, c)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", companionFile, err)
+	}
+	return c, nil
+}
+
+// relativeTo returns a path relative to a directory, unless it is absolute
+func relativeTo(dir string, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
@@ -0,0 +1,77 @@
+/*
+Copyright © 2020 Pablo Chacin <pablochacin@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+
+	"github.com/pablochacin/tf-operator/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+func newApplyCmd() *cobra.Command {
+
+	var kubeconfig string
+
+	opts := &applyOpts{}
+
+	cmd := &cobra.Command{
+		Use:   "apply",
+		Short: "Create or update terraform operator stacks from manifests",
+		Long: `Create or update terraform operator stacks declared in manifests, applying
+them server-side. The manifests are read from a file or from the yaml and json
+files of a directory.
+
+The local configuration directory and tfvars file of a stack are declared in
+its tfoctl.tf-operator.io/config and tfoctl.tf-operator.io/vars annotations or
+in a .tfoctl.yaml file in the manifests' directory:
+
+  stacks:
+    network:
+      config: ../terraform/network
+      vars: prod.tfvars
+
+Relative paths are relative to the manifests' directory. The configuration and
+tfvars are stored as revisions, as with create and update, and the stacks are
+updated to reference them. The ConfigMaps, Secrets and stacks created,
+configured or unchanged are reported.`,
+		Example: `
+# Apply the stacks of an environment
+tfoctl apply -f environments/prod`,
+		Args: cobra.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if len(opts.files) == 0 {
+				return fmt.Errorf("argument filename must be specified")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := client.NewFromKubeconfig(kubeconfig)
+			if err != nil {
+				return err
+			}
+			opts.client = client
+			opts.out = cmd.OutOrStdout()
+			return opts.run()
+		},
+	}
+
+	cmd.Flags().StringVarP(&kubeconfig, "kubeconfig", "k", "", "path to kubeconfig for cluster. If not specified, default discovery rules will apply")
+	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "default", "namespace for stacks without namespace in their manifest")
+	cmd.Flags().StringSliceVarP(&opts.files, "filename", "f", nil, "manifest file or directory with the stacks to apply. Can be repeated")
+
+	return cmd
+}
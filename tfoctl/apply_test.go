@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("apply", func() {
+	var (
+		opts   *applyOpts
+		fake   *fakeClient
+		output *bytes.Buffer
+		dir    string
+		err    error
+	)
+
+	// writeFile writes a file in the manifests directory
+	writeFile := func(name string, content string) {
+		Expect(ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "manifests")
+		Expect(err).NotTo(HaveOccurred())
+
+		output = new(bytes.Buffer)
+		fake = &fakeClient{}
+		opts = &applyOpts{
+			client:    fake,
+			files:     []string{dir},
+			namespace: "default",
+			out:       output,
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("Should apply the stacks with their local files", func() {
+		writeFile("network.yaml", `
+apiVersion: tf.tf-operator.io/v1alpha1
+kind: Stack
+metadata:
+  name: network
+  annotations:
+    tfoctl.tf-operator.io/config: ../terraform/network
+---
+apiVersion: tf.tf-operator.io/v1alpha1
+kind: Stack
+metadata:
+  name: cluster
+  namespace: infra
+spec:
+  dependsOn:
+  - name: network
+`)
+		writeFile(companionFile, `
+stacks:
+  network:
+    config: ignored
+    vars: prod.tfvars
+`)
+
+		Expect(opts.run()).To(Succeed())
+		Expect(fake.applied).To(HaveLen(2))
+		Expect(fake.applied[0].Namespace).To(Equal("default"))
+		Expect(fake.applyOpts[0].TfConfig).To(Equal(filepath.Join(filepath.Dir(dir), "terraform/network")))
+		Expect(fake.applyOpts[0].TfVars).To(Equal(filepath.Join(dir, "prod.tfvars")))
+		Expect(fake.applied[1].Namespace).To(Equal("infra"))
+		Expect(fake.applied[1].Spec.DependsOn).To(HaveLen(1))
+		Expect(fake.applyOpts[1].TfConfig).To(BeEmpty())
+		Expect(output.String()).To(Equal("stack/network created\nstack/cluster created\n"))
+	})
+
+	It("Should not apply any stack if a manifest is invalid", func() {
+		writeFile("a.yaml", "apiVersion: tf.tf-operator.io/v1alpha1\nkind: Stack\nmetadata:\n  name: a\n")
+		writeFile("b.yaml", "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: b\n")
+		Expect(opts.run()).NotTo(Succeed())
+		Expect(fake.applied).To(BeEmpty())
+	})
+
+	It("Should fail if there are no stacks", func() {
+		Expect(opts.run()).NotTo(Succeed())
+	})
+})
@@ -16,6 +16,8 @@ limitations under the License.
 package main
 
 import (
+	"io"
+
 	"github.com/pablochacin/tf-operator/pkg/client"
 )
 
@@ -25,10 +27,16 @@ type createOpts struct {
 	namespace string
 	configDir string
 	tfvars    string
+	dryRun    bool
+	manifests manifestOpts
+	out       io.Writer
 }
 
 // run executes the create stack command
 func (o *createOpts) run() error {
+	if o.dryRun {
+		return o.manifests.render(o.out, o.stack, o.namespace, o.configDir, o.tfvars)
+	}
 
 	_, err := o.client.CreateStack(o.stack, o.namespace, o.configDir, o.tfvars)
 
@@ -37,15 +37,32 @@ func newCreateCmd() *cobra.Command {
 		Short: "Create a terraform operator stack",
 		Long: `Create a terraform operator stack from a terrafrom configuration
 and a tfvars file. The terraform configuration is obtained from a local
-directory.`,
+directory, including its subdirectories (for example, local modules).
+Files matching the patterns in a .terraformignore file are excluded, as well
+as the .terraform and .git directories and state files. Large configurations
+and configurations with subdirectories are stored as a tar.gz bundle.
+
+With --dry-run, the stack, the ConfigMap with the configuration and the Secret
+with the tfvars are rendered as yaml, or written in a kustomization directory,
+without accessing the cluster. The Secret can be sealed for the sealed secrets
+controller with the certificate obtained with kubeseal --fetch-cert.`,
 		Example: `
-# Create stack from working directory. All .tf files will be used as config
-# and the terraform.tfvars file will be used to provider the input variables
-tfoctl -s MyStack`,
+# Create stack from working directory. All files, except the ignored ones, will
+# be used as config and the terraform.tfvars file will be used to provide the
+# input variables
+tfoctl create -s MyStack
+
+# Render the manifests of the stack for committing them to a GitOps
+# repository, sealing the tfvars Secret
+tfoctl create -s MyStack --dry-run -o kustomization=deploy/my-stack --seal-cert cert.pem`,
 		PreRunE: func(cmd *cobra.Command, args []string) error {
 			return opts.validateArgs(cmd)
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.out = cmd.OutOrStdout()
+			if opts.dryRun {
+				return opts.run()
+			}
 			client, err := client.NewFromKubeconfig(kubeconfig)
 			if err != nil {
 				return err
@@ -58,16 +75,31 @@ tfoctl -s MyStack`,
 	cmd.Flags().StringVarP(&kubeconfig, "kubeconfig", "k", "", "path to kubeconfig for cluster. If not specified, default discovery rules will apply")
 	cmd.Flags().StringVarP(&opts.stack, "stack", "s", "", "stack name")
 	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "default", "namespace for stack")
-	cmd.Flags().StringVarP(&opts.configDir, "config", "c", "./", "path to the terraform configuration directory. All .tf files will be used as the stack configuration. Default is current directory")
+	cmd.Flags().StringVarP(&opts.configDir, "config", "c", "./", "path to the terraform configuration directory. All files not excluded by .terraformignore will be used as the stack configuration. Default is current directory")
 	cmd.Flags().StringVarP(&opts.tfvars, "vars", "v", "terraform.tfvars", "Path toterraform vars file.")
+	cmd.Flags().BoolVar(&opts.dryRun, "dry-run", false, "only render the manifests of the stack, without accessing the cluster")
+	cmd.Flags().StringVarP(&opts.manifests.output, "output", "o", "", "format of the manifests rendered in a dry run. One of yaml (default) or kustomization=DIR")
+	cmd.Flags().StringVar(&opts.manifests.sealCert, "seal-cert", "", "path to the certificate of the sealed secrets controller for rendering the tfvars as a SealedSecret")
 
 	return cmd
 }
 
 // validateArgs validates the arguments
 func (opts *createOpts) validateArgs(cmd *cobra.Command) error {
-	// check for required arguments
-	for _, arg := range requiredArgs {
+	err := validateRequired(cmd, requiredArgs)
+	if err != nil {
+		return err
+	}
+
+	if opts.dryRun && opts.manifests.output == "" {
+		opts.manifests.output = manifestYAML
+	}
+	return opts.manifests.validate(opts.dryRun)
+}
+
+// validateRequired checks the required arguments are specified
+func validateRequired(cmd *cobra.Command, required []string) error {
+	for _, arg := range required {
 		if !cmd.Flags().Lookup(arg).Changed {
 			return fmt.Errorf("argument %s must be specified", arg)
 		}
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"fmt"
+	"io"
 	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
 	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
     "github.com/pablochacin/tf-operator/pkg/client"
+	"github.com/pablochacin/tf-operator/pkg/statestore"
+	"github.com/pablochacin/tf-operator/pkg/terraform"
 )
 
 func TestCreate(t *testing.T) {
@@ -22,6 +27,74 @@ type fakeClient struct {
 
 	// if err not set, stack to return
 	stack *tfo.Stack
+
+	// if err not set, diff to return
+	diff *client.StackDiff
+
+	// stack updated, if any
+	updated bool
+
+	// options of the stack deleted, if any
+	deleted *client.DeleteOptions
+
+	// stacks returned by successive gets after the stack is deleted, before
+	// reporting it as not found
+	deleting []*tfo.Stack
+
+	// log to return and the options it was requested with
+	log     string
+	logOpts *client.LogOptions
+
+	// if err not set, description to return
+	description *client.StackDescription
+
+	// stacks applied and the options they were applied with
+	applied   []*tfo.Stack
+	applyOpts []client.ApplyOptions
+
+	// if err not set, state to return and result of its updates
+	state       []byte
+	stateUpdate *client.StateUpdate
+
+	// state pushed, if any, and whether the push or the plan confirmation
+	// was forced
+	pushed []byte
+	forced bool
+
+	// addresses moved or removed from the state, if any
+	moved   []string
+	removed []string
+
+	// state file of the stack adopted, if any, and whether its plan was
+	// confirmed
+	adopted   string
+	confirmed bool
+
+	// run requested, if any
+	requested *tfo.RunRequest
+
+	// imports recorded, if any, and the run that recorded them
+	imports    []tfo.ImportResult
+	importsRun int64
+
+	// outputs recorded, if any
+	outputs map[string]terraform.Output
+
+	// changes recorded, if any, also in the last run of the stack returned
+	changes *tfo.ChangeSummary
+
+	// ID of the state lock force unlocked, if any
+	unlocked string
+
+	// if err not set, versions in the state history and their states
+	versions      []statestore.Version
+	versionStates map[int64][]byte
+
+	// version of the state rolled back to, if any
+	rolledBack int64
+
+	// if err not set, result of rotating the encryption key
+	rotation *client.KeyRotation
 }
 
 // GetStack return a stack or an error set in the fakeClient struct
@@ -31,9 +104,47 @@ func (c *fakeClient) GetStack(stackName string, namespace string) (*tfo.Stack, e
 		return nil, c.err
 	}
 
+	if c.deleted != nil {
+		if len(c.deleting) == 0 {
+			return nil, client.NewNotFoundError(stackName, "Stack", namespace)
+		}
+		stack := c.deleting[0]
+		c.deleting = c.deleting[1:]
+		return stack, nil
+	}
+
 	return c.stack, nil
 }
 
+// GetOutputs returns the outputs of the stack or an error set in the fakeClient
+// struct, redacting sensitive outputs unless showSensitive is set
+func (c *fakeClient) GetOutputs(stackName string, namespace string, showSensitive bool) (map[string]terraform.Output, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	outputs, err := terraform.DecodeOutputs(c.stack.Status.TfOutput)
+	if err != nil {
+		return nil, err
+	}
+	if !showSensitive {
+		terraform.SplitSensitive(outputs)
+	}
+	return outputs, nil
+}
+
+// ListStacks returns a list with the stack or an error set in the fakeClient struct
+func (c *fakeClient) ListStacks(opts client.ListOptions) (*tfo.StackList, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	list := &tfo.StackList{}
+	if c.stack != nil {
+		list.Items = append(list.Items, *c.stack)
+	}
+	return list, nil
+}
 
 func (c *fakeClient) CreateStack(stackName string, namespace string, tfconf string, tfvars string) (*tfo.Stack, error) {
 	if c.err != nil {
@@ -43,6 +154,245 @@ func (c *fakeClient) CreateStack(stackName string, namespace string, tfconf stri
 	return c.stack, nil
 }
 
+// AdoptStack records the state file adopted or returns an error set in the fakeClient struct
+func (c *fakeClient) AdoptStack(stackName string, namespace string, tfconf string, tfvars string, tfstate string) (*tfo.Stack, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	c.adopted = tfstate
+	return c.stack, nil
+}
+
+// ConfirmPlan records the plan confirmed or returns an error set in the fakeClient struct
+func (c *fakeClient) ConfirmPlan(stackName string, namespace string, force bool) (*tfo.Stack, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	c.confirmed = true
+	c.forced = force
+	return c.stack, nil
+}
+
+// DiffStack returns the diff or an error set in the fakeClient struct
+func (c *fakeClient) DiffStack(stackName string, namespace string, tfconf string, tfvars string) (*client.StackDiff, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	return c.diff, nil
+}
+
+func (c *fakeClient) UpdateStack(stackName string, namespace string, tfconf string, tfvars string) (*tfo.Stack, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	c.updated = true
+	return c.stack, nil
+}
+
+func (c *fakeClient) RollbackStack(stackName string, namespace string, revision int64) (*tfo.Stack, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	return c.stack, nil
+}
+
+func (c *fakeClient) DeleteStack(stackName string, namespace string, opts client.DeleteOptions) error {
+	if c.err != nil {
+		return c.err
+	}
+
+	c.deleted = &opts
+	return nil
+}
+
+// WaitStack returns the stack or an error set in the fakeClient struct
+func (c *fakeClient) WaitStack(stackName string, namespace string, condition client.WaitCondition, timeout time.Duration) (*tfo.Stack, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	return c.stack, nil
+}
+
+// StackLogs writes the log set in the fakeClient struct or returns an error
+func (c *fakeClient) StackLogs(stackName string, namespace string, opts client.LogOptions, out io.Writer) error {
+	if c.err != nil {
+		return c.err
+	}
+
+	c.logOpts = &opts
+	_, err := io.WriteString(out, c.log)
+	return err
+}
+
+// DescribeStack returns the description or an error set in the fakeClient struct
+func (c *fakeClient) DescribeStack(stackName string, namespace string, opts client.DescribeOptions) (*client.StackDescription, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	return c.description, nil
+}
+
+// ApplyStack records the stack applied and reports it as created or returns
+// an error set in the fakeClient struct
+func (c *fakeClient) ApplyStack(stack *tfo.Stack, opts client.ApplyOptions) (*client.ApplyResult, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	c.applied = append(c.applied, stack)
+	c.applyOpts = append(c.applyOpts, opts)
+	return &client.ApplyResult{
+		Stack:   stack,
+		Objects: []client.AppliedObject{{Kind: "Stack", Name: stack.Name, Operation: client.ApplyOperationCreated}},
+	}, nil
+}
+
+// RequestRun records the run requested or returns an error set in the fakeClient struct
+func (c *fakeClient) RequestRun(stackName string, namespace string, request tfo.RunRequest) (*tfo.RunRequest, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	if request.ID == "" {
+		request.ID = "generated"
+	}
+	c.requested = &request
+	return c.requested, nil
+}
+
+// RecordImports records the results of the imports or returns an error set in the fakeClient struct
+func (c *fakeClient) RecordImports(stackName string, namespace string, run int64, results []tfo.ImportResult) error {
+	if c.err != nil {
+		return c.err
+	}
+
+	c.imports = results
+	c.importsRun = run
+	return nil
+}
+
+// RecordChanges records the changes or returns an error set in the fakeClient struct
+func (c *fakeClient) RecordChanges(stackName string, namespace string, run int64, changes *tfo.ChangeSummary) error {
+	if c.err != nil {
+		return c.err
+	}
+
+	c.changes = changes
+	if c.stack != nil && c.stack.Status.LastRun != nil {
+		c.stack.Status.LastRun.Changes = changes
+	}
+	return nil
+}
+
+// RecordOutputs records the outputs or returns an error set in the fakeClient struct
+func (c *fakeClient) RecordOutputs(stackName string, namespace string, run int64, outputs map[string]terraform.Output) error {
+	if c.err != nil {
+		return c.err
+	}
+
+	c.outputs = outputs
+	return nil
+}
+
+// PullState returns the state or an error set in the fakeClient struct
+func (c *fakeClient) PullState(stackName string, namespace string) ([]byte, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	return c.state, nil
+}
+
+// PushState records the state pushed or returns an error set in the fakeClient struct
+func (c *fakeClient) PushState(stackName string, namespace string, content []byte, force bool) (*client.StateUpdate, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	c.pushed = content
+	c.forced = force
+	return c.stateUpdate, nil
+}
+
+// MoveState records the addresses moved or returns an error set in the fakeClient struct
+func (c *fakeClient) MoveState(stackName string, namespace string, src string, dest string) (*client.StateUpdate, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	c.moved = []string{src, dest}
+	return c.stateUpdate, nil
+}
+
+// RemoveState records the addresses removed or returns an error set in the fakeClient struct
+func (c *fakeClient) RemoveState(stackName string, namespace string, addresses []string) (*client.StateUpdate, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	c.removed = addresses
+	return c.stateUpdate, nil
+}
+
+// ForceUnlockState records the lock ID released or returns an error set in the fakeClient struct
+func (c *fakeClient) ForceUnlockState(stackName string, namespace string, id string) error {
+	if c.err != nil {
+		return c.err
+	}
+
+	c.unlocked = id
+	return nil
+}
+
+// RollbackState records the version rolled back to or returns an error set in the fakeClient struct
+func (c *fakeClient) RollbackState(stackName string, namespace string, version int64) (*client.StateUpdate, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	c.rolledBack = version
+	return c.stateUpdate, nil
+}
+
+// ListStateVersions returns the state versions or an error set in the fakeClient struct
+func (c *fakeClient) ListStateVersions(stackName string, namespace string) ([]statestore.Version, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	return c.versions, nil
+}
+
+// GetStateVersion returns a state version or an error set in the fakeClient struct
+func (c *fakeClient) GetStateVersion(stackName string, namespace string, version int64) (*statestore.Version, []byte, error) {
+	if c.err != nil {
+		return nil, nil, c.err
+	}
+
+	for i := range c.versions {
+		if c.versions[i].Number == version {
+			return &c.versions[i], c.versionStates[version], nil
+		}
+	}
+	return nil, nil, client.NewNotFoundError(fmt.Sprintf("%s state version %d", stackName, version), "Stack", namespace)
+}
+
+// RotateEncryptionKey returns the key rotation or an error set in the fakeClient struct
+func (c *fakeClient) RotateEncryptionKey(stackName string, namespace string) (*client.KeyRotation, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	return c.rotation, nil
+}
+
 // withError sets the error to return on
 func (c *fakeClient) withError(err error) {
 	c.err = err
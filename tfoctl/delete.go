@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+	"github.com/pablochacin/tf-operator/pkg/client"
+)
+
+const (
+	// interval between checks of the state of a stack being deleted
+	deletePollInterval = 2 * time.Second
+)
+
+type deleteOpts struct {
+	client    client.Client
+	stack     string
+	namespace string
+	orphan    bool
+	cascade   bool
+	wait      bool
+	timeout   time.Duration
+	interval  time.Duration
+	out       io.Writer
+}
+
+// run executes the delete stack command
+func (o *deleteOpts) run() error {
+	err := o.client.DeleteStack(o.stack, o.namespace, client.DeleteOptions{
+		Orphan:  o.orphan,
+		Cascade: o.cascade,
+	})
+	if err != nil {
+		return err
+	}
+
+	if !o.wait {
+		fmt.Fprintf(o.out, "stack %s marked for deletion\n", o.stack)
+		return nil
+	}
+
+	err = o.waitDeleted()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(o.out, "stack %s deleted\n", o.stack)
+	return nil
+}
+
+// waitDeleted waits until the stack is gone, showing the changes in its phase
+// while its resources are destroyed
+func (o *deleteOpts) waitDeleted() error {
+	interval := o.interval
+	if interval == 0 {
+		interval = deletePollInterval
+	}
+
+	var deadline time.Time
+	if o.timeout > 0 {
+		deadline = time.Now().Add(o.timeout)
+	}
+
+	var lastPhase tfo.StackPhase
+	var lastMessage string
+	for {
+		stack, err := o.client.GetStack(o.stack, o.namespace)
+		if client.Is(err, client.ErrorReasonNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if stack.Status.Phase != lastPhase || stack.Status.Message != lastMessage {
+			lastPhase = stack.Status.Phase
+			lastMessage = stack.Status.Message
+			fmt.Fprintf(o.out, "stack %s: %s\n", o.stack, describePhase(stack))
+		}
+
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for stack %s to be deleted", o.stack)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// describePhase returns the stack's phase followed by its message, if any
+func describePhase(stack *tfo.Stack) string {
+	phase := valueOrNone(string(stack.Status.Phase))
+	if stack.Status.Message == "" {
+		return phase
+	}
+	return fmt.Sprintf("%s (%s)", phase, stack.Status.Message)
+}
@@ -0,0 +1,84 @@
+/*
+Copyright © 2020 Pablo Chacin <pablochacin@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+
+	"github.com/pablochacin/tf-operator/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+func newDeleteCmd() *cobra.Command {
+
+	var kubeconfig string
+
+	opts := &deleteOpts{}
+
+	cmd := &cobra.Command{
+		Use:   "delete STACK",
+		Short: "Delete a terraform operator stack",
+		Long: `Delete a terraform operator stack. Before the stack is removed, the
+operator runs a Job destroying its resources, unless they are orphaned. The
+ConfigMaps and Secrets with the revisions of the stack's configuration and
+tfvars are kept, unless cascade is specified.`,
+		Example: `
+# Delete a stack and wait until its resources are destroyed
+tfoctl delete MyStack --wait
+
+# Delete a stack keeping its resources
+tfoctl delete MyStack --orphan
+
+# Delete a stack and its configuration and tfvars
+tfoctl delete MyStack --cascade`,
+		Args: cobra.ExactArgs(1),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return opts.validateArgs(cmd)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := client.NewFromKubeconfig(kubeconfig)
+			if err != nil {
+				return err
+			}
+			opts.client = client
+			opts.stack = args[0]
+			opts.out = cmd.OutOrStdout()
+			return opts.run()
+		},
+	}
+
+	cmd.Flags().StringVarP(&kubeconfig, "kubeconfig", "k", "", "path to kubeconfig for cluster. If not specified, default discovery rules will apply")
+	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "default", "namespace for stack")
+	cmd.Flags().BoolVar(&opts.wait, "wait", false, "wait until the stack's resources are destroyed and the stack is deleted")
+	cmd.Flags().DurationVar(&opts.timeout, "timeout", 0, "maximum time to wait for the stack to be deleted. 0 means no limit")
+	cmd.Flags().BoolVar(&opts.orphan, "orphan", false, "keep the stack's resources instead of destroying them")
+	cmd.Flags().BoolVar(&opts.cascade, "cascade", false, "delete also the ConfigMaps and Secrets with the stack's configuration and tfvars")
+
+	return cmd
+}
+
+// validateArgs validates the arguments
+func (opts *deleteOpts) validateArgs(cmd *cobra.Command) error {
+	if opts.timeout < 0 {
+		return fmt.Errorf("timeout must be a positive duration")
+	}
+
+	if cmd.Flags().Lookup("timeout").Changed && !opts.wait {
+		return fmt.Errorf("timeout can only be specified when waiting for the delete")
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+	"github.com/pablochacin/tf-operator/pkg/client"
+)
+
+var _ = Describe("delete", func() {
+	var (
+		opts   *deleteOpts
+		fake   *fakeClient
+		output *bytes.Buffer
+		err    error
+	)
+
+	BeforeEach(func() {
+		output = new(bytes.Buffer)
+		fake = &fakeClient{}
+		opts = &deleteOpts{
+			client:    fake,
+			stack:     stackName,
+			namespace: "default",
+			interval:  time.Millisecond,
+			out:       output,
+		}
+	})
+
+	It("Should pass the delete options", func() {
+		opts.orphan = true
+		opts.cascade = true
+		err = opts.run()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fake.deleted).To(Equal(&client.DeleteOptions{Orphan: true, Cascade: true}))
+		Expect(output.String()).To(ContainSubstring("marked for deletion"))
+	})
+
+	It("Should show the progress until the stack is deleted", func() {
+		destroying := newReadyStack(stackName, "default")
+		destroying.Status.Phase = tfo.StackPhaseDestroying
+		destroying.Status.Message = ""
+		fake.deleting = []*tfo.Stack{destroying, destroying}
+
+		opts.wait = true
+		err = opts.run()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output.String()).To(Equal(
+			"stack " + stackName + ": Destroying\n" +
+				"stack " + stackName + " deleted\n",
+		))
+	})
+
+	It("Should time out if the stack is not deleted", func() {
+		stuck := newReadyStack(stackName, "default")
+		stuck.Status.Phase = tfo.StackPhaseDestroying
+		stuck.Status.Message = "destroy failed: BackoffLimitExceeded"
+		for i := 0; i < 100; i++ {
+			fake.deleting = append(fake.deleting, stuck)
+		}
+
+		opts.wait = true
+		opts.timeout = 5 * time.Millisecond
+		err = opts.run()
+		Expect(err).To(HaveOccurred())
+		Expect(output.String()).To(ContainSubstring("destroy failed"))
+	})
+
+	It("Should Return an error for a non existing stack", func() {
+		fake.withError(client.NewNotFoundError(stackName, "Stack", "default"))
+		err = opts.run()
+		Expect(err).To(HaveOccurred())
+		Expect(output.String()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,311 @@
+/*
+Copyright © 2020 Pablo Chacin <pablochacin@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+	"github.com/pablochacin/tf-operator/pkg/client"
+	"k8s.io/apimachinery/pkg/util/duration"
+)
+
+type describeOpts struct {
+	client    client.Client
+	stack     string
+	namespace string
+	runs      int
+	out       io.Writer
+}
+
+// run executes the describe command
+func (o *describeOpts) run() error {
+	desc, err := o.client.DescribeStack(o.stack, o.namespace, client.DescribeOptions{Runs: o.runs})
+	if err != nil {
+		return err
+	}
+
+	return printDescription(o.out, desc)
+}
+
+// printDescription prints the description of a stack in sections, as
+// kubectl describe does
+func printDescription(out io.Writer, desc *client.StackDescription) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	stack := desc.Stack
+
+	fmt.Fprintf(w, "Name:\t%s\n", stack.Name)
+	fmt.Fprintf(w, "Namespace:\t%s\n", stack.Namespace)
+	fmt.Fprintf(w, "Labels:\t%s\n", valueOrNone(labelList(stack.Labels)))
+	fmt.Fprintf(w, "Age:\t%s\n", age(stack.CreationTimestamp))
+
+	fmt.Fprintln(w, "Spec:")
+	if stack.Spec.Source != nil {
+		fmt.Fprintf(w, "  Source:\t%s\n", sourceSummary(stack.Spec.Source))
+	} else {
+		fmt.Fprintf(w, "  Config:\t%s\n", referencedObject(desc.TfConfig, stack.Spec.TfConfig.Name))
+	}
+	fmt.Fprintf(w, "  TfVars:\t%s\n", referencedObject(desc.TfVars, stack.Spec.TfVars.Name))
+	dependsOn := []string{}
+	for _, dep := range stack.Spec.DependsOn {
+		dependsOn = append(dependsOn, dep.Name)
+	}
+	fmt.Fprintf(w, "  Depends On:\t%s\n", valueOrNone(strings.Join(dependsOn, ",")))
+	if stack.Spec.RevisionHistoryLimit != nil {
+		fmt.Fprintf(w, "  Revision History Limit:\t%d\n", *stack.Spec.RevisionHistoryLimit)
+	}
+	if stack.Spec.StateHistoryLimit != nil {
+		fmt.Fprintf(w, "  State History Limit:\t%d\n", *stack.Spec.StateHistoryLimit)
+	}
+	if stack.Spec.PlanOnly {
+		fmt.Fprintf(w, "  Plan Only:\ttrue\n")
+	}
+	if stack.Spec.Encryption != nil {
+		fmt.Fprintf(w, "  Encryption Keys:\t%s\n", stack.Spec.Encryption.KeySecret.Name)
+	}
+	if stack.Spec.Backend != nil {
+		fmt.Fprintf(w, "  Backend:\t%s\n", stack.Spec.Backend.Type)
+	}
+	if stack.Spec.Workspace != "" {
+		fmt.Fprintf(w, "  Workspace:\t%s\n", stack.Spec.Workspace)
+	}
+
+	fmt.Fprintln(w, "Status:")
+	fmt.Fprintf(w, "  Phase:\t%s\n", valueOrNone(string(stack.Status.Phase)))
+	fmt.Fprintf(w, "  Message:\t%s\n", valueOrNone(stack.Status.Message))
+	fmt.Fprintf(w, "  Last Run:\t%s\n", lastRun(stack))
+	fmt.Fprintf(w, "  Changes:\t%s\n", changes(stack))
+	if stack.Status.LastRun != nil && stack.Status.LastRun.Request != nil {
+		fmt.Fprintf(w, "  Request:\t%s\n", requestSummary(stack.Status.LastRun.Request))
+	}
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+
+	sections := []struct {
+		title string
+		empty bool
+		print func(w io.Writer)
+	}{
+		{"Conditions", len(stack.Status.Conditions) == 0, func(w io.Writer) { printConditions(w, stack.Status.Conditions) }},
+		{"Runs", len(desc.Runs) == 0, func(w io.Writer) { printRuns(w, desc.Runs) }},
+		{"Imports", len(stack.Spec.Imports) == 0, func(w io.Writer) { printImports(w, stack) }},
+		{"Resources", len(desc.Resources) == 0, func(w io.Writer) { printResources(w, desc) }},
+		{"Events", len(desc.Events) == 0, func(w io.Writer) { printEvents(w, desc) }},
+	}
+	for _, section := range sections {
+		if section.empty {
+			fmt.Fprintf(out, "%s:\t%s\n", section.title, noneValue)
+			continue
+		}
+		fmt.Fprintf(out, "%s:\n", section.title)
+		w = tabwriter.NewWriter(out, 0, 8, 3, ' ', 0)
+		section.print(w)
+		err = w.Flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, warning := range desc.Warnings {
+		fmt.Fprintf(out, "Warning: %s\n", warning)
+	}
+
+	return nil
+}
+
+// printConditions prints the stack's conditions as a table
+func printConditions(w io.Writer, conditions []tfo.StackCondition) {
+	fmt.Fprintln(w, "  TYPE\tSTATUS\tREASON\tAGE\tMESSAGE")
+	for _, cond := range conditions {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\n",
+			cond.Type,
+			cond.Status,
+			valueOrNone(cond.Reason),
+			age(cond.LastTransitionTime),
+			cond.Message,
+		)
+	}
+}
+
+// printRuns prints the runs as a table, from the last one
+func printRuns(w io.Writer, runs []client.Run) {
+	fmt.Fprintln(w, "  NUMBER\tCOMMAND\tJOB\tRESULT\tDURATION\tAGE\tMESSAGE")
+	for _, run := range runs {
+		number := "-"
+		if run.Number > 0 {
+			number = fmt.Sprint(run.Number)
+		}
+		started := "<unknown>"
+		if run.StartTime != nil {
+			started = age(*run.StartTime)
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			number,
+			valueOrNone(run.Command),
+			run.Job,
+			run.Result,
+			runDuration(run),
+			started,
+			run.Message,
+		)
+	}
+}
+
+// runDuration returns how long a run took or, if still running, how long it
+// has been running
+func runDuration(run client.Run) string {
+	if run.StartTime == nil {
+		return "<unknown>"
+	}
+	end := time.Now()
+	if run.CompletionTime != nil {
+		end = run.CompletionTime.Time
+	}
+	return duration.HumanDuration(end.Sub(run.StartTime.Time))
+}
+
+// printImports prints the resources to import into the stack's state with
+// the result of their import by the last run, if any
+func printImports(w io.Writer, stack *tfo.Stack) {
+	results := map[string]tfo.ImportResult{}
+	if stack.Status.LastRun != nil {
+		for _, result := range stack.Status.LastRun.Imports {
+			results[result.Address] = result
+		}
+	}
+
+	addresses := []string{}
+	for address := range stack.Spec.Imports {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	fmt.Fprintln(w, "  ADDRESS\tID\tRESULT\tMESSAGE")
+	for _, address := range addresses {
+		result, found := results[address]
+		if !found || result.ID != stack.Spec.Imports[address] {
+			result = tfo.ImportResult{Result: "Pending"}
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", address, stack.Spec.Imports[address], result.Result, result.Message)
+	}
+}
+
+// requestSummary summarizes the options of a run request. Only the names of
+// the variables are shown
+func requestSummary(request *tfo.RunRequest) string {
+	options := []string{}
+	for _, target := range request.Targets {
+		options = append(options, "target "+target)
+	}
+	for _, address := range request.Replace {
+		options = append(options, "replace "+address)
+	}
+	if request.RefreshOnly {
+		options = append(options, "refresh-only")
+	}
+	names := []string{}
+	for name := range request.Vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) > 0 {
+		options = append(options, "vars "+strings.Join(names, ","))
+	}
+	return fmt.Sprintf("%s (%s)", request.ID, strings.Join(options, ", "))
+}
+
+// printResources prints the resources in the stack's state as a table
+func printResources(w io.Writer, desc *client.StackDescription) {
+	fmt.Fprintln(w, "  ADDRESS\tID")
+	for _, resource := range desc.Resources {
+		fmt.Fprintf(w, "  %s\t%s\n", resource.Address, valueOrNone(resource.ID))
+	}
+}
+
+// printEvents prints the events of the stack and its Jobs as a table
+func printEvents(w io.Writer, desc *client.StackDescription) {
+	fmt.Fprintln(w, "  TYPE\tREASON\tAGE\tOBJECT\tMESSAGE")
+	for _, event := range desc.Events {
+		last := event.LastTimestamp
+		if last.IsZero() {
+			last = event.FirstTimestamp
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\n",
+			event.Type,
+			event.Reason,
+			age(last),
+			strings.ToLower(event.InvolvedObject.Kind)+"/"+event.InvolvedObject.Name,
+			strings.TrimSpace(event.Message),
+		)
+	}
+}
+
+// referencedObject summarizes an object referenced by the stack, with its
+// items, or indicates it is missing
+func referencedObject(ref *client.ReferencedObject, name string) string {
+	switch {
+	case name == "":
+		return noneValue
+	case ref == nil:
+		return name
+	case !ref.Found:
+		return fmt.Sprintf("%s %s (not found)", ref.Kind, ref.Name)
+	}
+	return fmt.Sprintf("%s %s [%s]", ref.Kind, ref.Name, strings.Join(ref.Items, ", "))
+}
+
+// sourceSummary describes the remote source of the stack's configuration
+func sourceSummary(source *tfo.StackSource) string {
+	switch {
+	case source.Git != nil:
+		ref := source.Git.Branch
+		for _, other := range []string{source.Git.Tag, source.Git.Commit} {
+			if other != "" {
+				ref = other
+			}
+		}
+		summary := "git " + source.Git.URL
+		if ref != "" {
+			summary += " " + ref
+		}
+		if source.Git.Subdirectory != "" {
+			summary += " " + source.Git.Subdirectory
+		}
+		return summary
+	case source.Archive != nil:
+		return "archive " + source.Archive.URL
+	case source.OCI != nil:
+		return "oci " + source.OCI.Reference
+	}
+	return noneValue
+}
+
+// labelList returns labels as a sorted list of name=value pairs
+func labelList(labels map[string]string) string {
+	pairs := []string{}
+	for name, value := range labels {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,65 @@
+/*
+Copyright © 2020 Pablo Chacin <pablochacin@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+
+	"github.com/pablochacin/tf-operator/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+func newDescribeCmd() *cobra.Command {
+
+	var kubeconfig string
+
+	opts := &describeOpts{}
+
+	cmd := &cobra.Command{
+		Use:   "describe STACK",
+		Short: "Show details of a terraform operator stack",
+		Long: `Show the details of a terraform operator stack: a summary of its spec, the
+ConfigMap and Secret it references, its conditions, its last runs with their
+duration and result, the resources in its state and the recent events of the
+stack and its Jobs.`,
+		Example: `
+# Describe a stack with its last 10 runs
+tfoctl describe MyStack --runs 10`,
+		Args: cobra.ExactArgs(1),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if opts.runs < 0 {
+				return fmt.Errorf("runs must be a positive number")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := client.NewFromKubeconfig(kubeconfig)
+			if err != nil {
+				return err
+			}
+			opts.client = client
+			opts.stack = args[0]
+			opts.out = cmd.OutOrStdout()
+			return opts.run()
+		},
+	}
+
+	cmd.Flags().StringVarP(&kubeconfig, "kubeconfig", "k", "", "path to kubeconfig for cluster. If not specified, default discovery rules will apply")
+	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "default", "namespace for stack")
+	cmd.Flags().IntVar(&opts.runs, "runs", 5, "number of runs to show, from the last one. 0 shows all")
+
+	return cmd
+}
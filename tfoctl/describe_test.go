@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+	"github.com/pablochacin/tf-operator/pkg/client"
+	"github.com/pablochacin/tf-operator/pkg/terraform"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("describe", func() {
+	var (
+		opts   *describeOpts
+		output *bytes.Buffer
+		desc   *client.StackDescription
+	)
+
+	BeforeEach(func() {
+		output = new(bytes.Buffer)
+		stack := newReadyStack(stackName, "default")
+		stack.Spec.TfVars.Name = stackName + "-tfvars"
+		started := metav1.NewTime(time.Now().Add(-90 * time.Second))
+		completed := metav1.Now()
+		desc = &client.StackDescription{
+			Stack: stack,
+			TfConfig: &client.ReferencedObject{
+				Kind:  "ConfigMap",
+				Name:  stack.Spec.TfConfig.Name,
+				Found: true,
+				Items: []string{"main.tf", "vars.tf"},
+			},
+			TfVars: &client.ReferencedObject{Kind: "Secret", Name: stack.Spec.TfVars.Name},
+			Runs: []client.Run{
+				{
+					Number:         3,
+					Command:        "apply",
+					Job:            stackName + "-apply",
+					StartTime:      &started,
+					CompletionTime: &completed,
+					Result:         client.RunResultSucceeded,
+				},
+			},
+			Events: []corev1.Event{
+				{
+					InvolvedObject: corev1.ObjectReference{Kind: "Job", Name: stackName + "-apply"},
+					Type:           corev1.EventTypeNormal,
+					Reason:         "Completed",
+					Message:        "Job completed",
+					LastTimestamp:  completed,
+				},
+			},
+			Resources: []terraform.StateResource{
+				{Address: "aws_vpc.main", Type: "aws_vpc", ID: "vpc-123"},
+			},
+		}
+		opts = &describeOpts{
+			client:    &fakeClient{description: desc},
+			stack:     stackName,
+			namespace: "default",
+			out:       output,
+		}
+	})
+
+	It("Should print the spec and referenced objects", func() {
+		Expect(opts.run()).To(Succeed())
+		Expect(output.String()).To(MatchRegexp(`Config:\s+ConfigMap ` + stackName + `-tfconf-a \[main.tf, vars.tf\]`))
+		Expect(output.String()).To(MatchRegexp(`TfVars:\s+Secret ` + stackName + `-tfvars \(not found\)`))
+		Expect(output.String()).To(MatchRegexp(`Phase:\s+Ready`))
+	})
+
+	It("Should print the backend and workspace configured in the stack", func() {
+		desc.Stack.Spec.Backend = &tfo.StackBackend{Type: "s3"}
+		desc.Stack.Spec.Workspace = "stage"
+		Expect(opts.run()).To(Succeed())
+		Expect(output.String()).To(MatchRegexp(`Backend:\s+s3`))
+		Expect(output.String()).To(MatchRegexp(`Workspace:\s+stage`))
+	})
+
+	It("Should print the runs, resources and events", func() {
+		Expect(opts.run()).To(Succeed())
+		Expect(output.String()).To(MatchRegexp(`3\s+apply\s+` + stackName + `-apply\s+Succeeded\s+90s`))
+		Expect(output.String()).To(MatchRegexp(`aws_vpc.main\s+vpc-123`))
+		Expect(output.String()).To(MatchRegexp(`Normal\s+Completed\s+.*job/` + stackName + `-apply\s+Job completed`))
+	})
+
+	It("Should print the imports with the results of the last run", func() {
+		desc.Stack.Spec.Imports = map[string]string{"aws_vpc.main": "vpc-123", "aws_subnet.a": "subnet-1"}
+		desc.Stack.Status.LastRun.Imports = []tfo.ImportResult{
+			{Address: "aws_vpc.main", ID: "vpc-123", Result: tfo.ImportResultSkipped},
+		}
+		Expect(opts.run()).To(Succeed())
+		Expect(output.String()).To(MatchRegexp(`aws_subnet.a\s+subnet-1\s+Pending`))
+		Expect(output.String()).To(MatchRegexp(`aws_vpc.main\s+vpc-123\s+Skipped`))
+	})
+
+	It("Should print the request of the last run", func() {
+		desc.Stack.Status.LastRun.Request = &tfo.RunRequest{
+			ID:      "r1",
+			Targets: []string{"module.db"},
+			Vars:    map[string]string{"size": "large"},
+		}
+		Expect(opts.run()).To(Succeed())
+		Expect(output.String()).To(MatchRegexp(`Request:\s+r1 \(target module.db, vars size\)`))
+	})
+
+	It("Should print the missing sections and warnings", func() {
+		desc.Runs = nil
+		desc.Events = nil
+		desc.Warnings = []string{"state not available: forbidden"}
+		Expect(opts.run()).To(Succeed())
+		Expect(output.String()).To(MatchRegexp(`Runs:\s+<none>`))
+		Expect(output.String()).To(MatchRegexp(`Events:\s+<none>`))
+		Expect(output.String()).To(ContainSubstring("Warning: state not available: forbidden"))
+	})
+
+	It("Should Return the error getting the stack", func() {
+		opts.client = &fakeClient{err: client.NewNotFoundError(stackName, "Stack", "default")}
+		Expect(opts.run()).NotTo(Succeed())
+	})
+})
@@ -0,0 +1,190 @@
+/*
+Copyright © 2020 Pablo Chacin <pablochacin@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pablochacin/tf-operator/pkg/source"
+	"github.com/pablochacin/tf-operator/pkg/tfconfig"
+)
+
+type fetchGitOpts struct {
+	url         string
+	branch      string
+	tag         string
+	commit      string
+	subdir      string
+	credentials string
+	dest        string
+	out         io.Writer
+}
+
+// run fetches the tf config from a git repository
+func (o *fetchGitOpts) run() error {
+	var credentials *source.GitCredentials
+	if o.credentials != "" {
+		data, err := readCredentials(o.credentials)
+		if err != nil {
+			return err
+		}
+		credentials = source.NewGitCredentials(data)
+	}
+
+	git := source.NewGit(o.url, credentials)
+	commit, err := git.ResolveRef(o.branch, o.tag, o.commit)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(o.dest, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	err = git.Fetch(commit, o.subdir, o.dest)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(o.out, "fetched %s at commit %s\n", o.url, commit)
+	return nil
+}
+
+type fetchArchiveOpts struct {
+	url         string
+	sha256      string
+	credentials string
+	dest        string
+	out         io.Writer
+}
+
+// run fetches the tf config from a tar.gz archive
+func (o *fetchArchiveOpts) run() error {
+	credentials, err := readHTTPCredentials(o.credentials)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(o.dest, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	err = source.NewArchive(o.url, credentials).Fetch(o.sha256, o.dest)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(o.out, "fetched %s with digest %s\n", o.url, o.sha256)
+	return nil
+}
+
+type fetchOCIOpts struct {
+	reference   string
+	digest      string
+	insecure    bool
+	credentials string
+	dest        string
+	out         io.Writer
+}
+
+// run fetches the tf config from an artifact in an OCI registry
+func (o *fetchOCIOpts) run() error {
+	credentials, err := readHTTPCredentials(o.credentials)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(o.dest, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	err = source.NewOCI(o.insecure, credentials).Fetch(o.reference, o.digest, o.dest)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(o.out, "fetched %s\n", o.reference)
+	return nil
+}
+
+type fetchConfigMapOpts struct {
+	src  string
+	dest string
+	out  io.Writer
+}
+
+// run unpacks the tf config from a ConfigMap mounted as a volume
+func (o *fetchConfigMapOpts) run() error {
+	err := os.MkdirAll(o.dest, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	err = tfconfig.Unpack(o.src, o.dest)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(o.out, "unpacked %s\n", o.src)
+	return nil
+}
+
+// readHTTPCredentials reads the credentials for basic authentication from
+// a directory, if given
+func readHTTPCredentials(dir string) (*source.HTTPCredentials, error) {
+	if dir == "" {
+		return nil, nil
+	}
+
+	data, err := readCredentials(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return source.NewHTTPCredentials(data), nil
+}
+
+// readCredentials reads the credentials from a directory with a file per key,
+// as a Secret is mounted as a volume
+func readCredentials(dir string) (map[string][]byte, error) {
+	fileList, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error accessing credentials %s: %v", dir, err)
+	}
+
+	data := map[string][]byte{}
+	for _, file := range fileList {
+		// skip the hidden entries created when mounting a Secret
+		if file.IsDir() || strings.HasPrefix(file.Name(), "..") {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("error reading credentials %s: %v", file.Name(), err)
+		}
+		data[file.Name()] = content
+	}
+
+	return data, nil
+}
@@ -0,0 +1,175 @@
+/*
+Copyright © 2020 Pablo Chacin <pablochacin@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func newFetchCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "fetch",
+		Short: "Fetch a terraform configuration from a remote source",
+		Long: `Fetch a terraform configuration from a remote source into a local
+directory. Used by the stack's Jobs for preparing the configuration before
+running terraform.`,
+	}
+
+	cmd.AddCommand(
+		newFetchGitCmd(),
+		newFetchArchiveCmd(),
+		newFetchOCICmd(),
+		newFetchConfigMapCmd(),
+	)
+
+	return cmd
+}
+
+func newFetchGitCmd() *cobra.Command {
+	opts := &fetchGitOpts{}
+
+	cmd := &cobra.Command{
+		Use:   "git",
+		Short: "Fetch a terraform configuration from a git repository",
+		Example: `
+# Fetch the configuration in the stacks/network directory of the main branch
+tfoctl fetch git --url https://example.com/infra.git --branch main --subdir stacks/network --dest ./tfconfig`,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return opts.validateArgs(cmd)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.out = cmd.OutOrStdout()
+			return opts.run()
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.url, "url", "", "url of the git repository")
+	cmd.Flags().StringVar(&opts.branch, "branch", "", "branch to fetch")
+	cmd.Flags().StringVar(&opts.tag, "tag", "", "tag to fetch")
+	cmd.Flags().StringVar(&opts.commit, "commit", "", "commit to fetch")
+	cmd.Flags().StringVar(&opts.subdir, "subdir", "", "subdirectory of the repository with the configuration. Default is the repository's root")
+	cmd.Flags().StringVar(&opts.credentials, "credentials", "", "path to a directory with the credentials for the repository")
+	cmd.Flags().StringVar(&opts.dest, "dest", "./", "path to the destination directory")
+
+	return cmd
+}
+
+func newFetchArchiveCmd() *cobra.Command {
+	opts := &fetchArchiveOpts{}
+
+	cmd := &cobra.Command{
+		Use:   "archive",
+		Short: "Fetch a terraform configuration from a tar.gz archive",
+		Example: `
+# Fetch the configuration from an archive, verifying its digest
+tfoctl fetch archive --url https://example.com/network-1.0.tar.gz --sha256 9f86d08... --dest ./tfconfig`,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if opts.url == "" || opts.sha256 == "" {
+				return fmt.Errorf("arguments url and sha256 must be specified")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.out = cmd.OutOrStdout()
+			return opts.run()
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.url, "url", "", "url of the archive")
+	cmd.Flags().StringVar(&opts.sha256, "sha256", "", "expected sha256 of the archive")
+	cmd.Flags().StringVar(&opts.credentials, "credentials", "", "path to a directory with the credentials for the archive")
+	cmd.Flags().StringVar(&opts.dest, "dest", "./", "path to the destination directory")
+
+	return cmd
+}
+
+func newFetchOCICmd() *cobra.Command {
+	opts := &fetchOCIOpts{}
+
+	cmd := &cobra.Command{
+		Use:   "oci",
+		Short: "Fetch a terraform configuration from an OCI artifact",
+		Example: `
+# Fetch the configuration from an artifact, verifying its digest
+tfoctl fetch oci --reference registry.example.com/modules/network:1.0 --digest sha256:9f86d08... --dest ./tfconfig`,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if opts.reference == "" {
+				return fmt.Errorf("argument reference must be specified")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.out = cmd.OutOrStdout()
+			return opts.run()
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.reference, "reference", "", "reference to the artifact")
+	cmd.Flags().StringVar(&opts.digest, "digest", "", "digest the artifact's manifest must have")
+	cmd.Flags().BoolVar(&opts.insecure, "insecure", false, "access the registry using http")
+	cmd.Flags().StringVar(&opts.credentials, "credentials", "", "path to a directory with the credentials for the registry")
+	cmd.Flags().StringVar(&opts.dest, "dest", "./", "path to the destination directory")
+
+	return cmd
+}
+
+func newFetchConfigMapCmd() *cobra.Command {
+	opts := &fetchConfigMapOpts{}
+
+	cmd := &cobra.Command{
+		Use:   "configmap",
+		Short: "Unpack a terraform configuration from a ConfigMap mounted as a volume",
+		Example: `
+# Unpack the configuration created by tfoctl create, including bundled configurations
+tfoctl fetch configmap --src /var/lib/tfoperator/tfconfig-src --dest ./tfconfig`,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if opts.src == "" {
+				return fmt.Errorf("argument src must be specified")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.out = cmd.OutOrStdout()
+			return opts.run()
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.src, "src", "", "path to the directory where the ConfigMap is mounted")
+	cmd.Flags().StringVar(&opts.dest, "dest", "./", "path to the destination directory")
+
+	return cmd
+}
+
+// validateArgs validates the arguments
+func (opts *fetchGitOpts) validateArgs(cmd *cobra.Command) error {
+	if opts.url == "" {
+		return fmt.Errorf("argument url must be specified")
+	}
+
+	refs := 0
+	for _, ref := range []string{"branch", "tag", "commit"} {
+		if cmd.Flags().Lookup(ref).Changed {
+			refs++
+		}
+	}
+	if refs > 1 {
+		return fmt.Errorf("only one of branch, tag or commit can be specified")
+	}
+
+	return nil
+}
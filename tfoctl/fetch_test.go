@@ -0,0 +1,160 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// createGitRepo creates a bare git repository with a commit adding the
+// given files and returns its path
+func createGitRepo(files map[string]string) string {
+	root, err := ioutil.TempDir("", "repo")
+	Expect(err).NotTo(HaveOccurred())
+
+	work := filepath.Join(root, "work")
+	for path, content := range files {
+		absPath := filepath.Join(work, path)
+		Expect(os.MkdirAll(filepath.Dir(absPath), os.ModePerm)).To(Succeed())
+		Expect(ioutil.WriteFile(absPath, []byte(content), 0666)).To(Succeed())
+	}
+
+	bare := filepath.Join(root, "repo.git")
+	for _, args := range [][]string{
+		{"init", "--quiet", work},
+		{"-C", work, "add", "."},
+		{"-C", work, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "init"},
+		{"clone", "--quiet", "--bare", work, bare},
+	} {
+		output, err := exec.Command("git", args...).CombinedOutput()
+		Expect(err).NotTo(HaveOccurred(), string(output))
+	}
+
+	return bare
+}
+
+var _ = Describe("fetch", func() {
+	var (
+		bare string
+		dest string
+		opts *fetchGitOpts
+		err  error
+	)
+
+	BeforeEach(func() {
+		bare = createGitRepo(map[string]string{
+			"network/main.tf": "variable \"cidr\" {}\n",
+		})
+		dest, _ = ioutil.TempDir("", "tfconfig")
+		opts = &fetchGitOpts{
+			url:    "file://" + bare,
+			subdir: "network",
+			dest:   dest,
+			out:    new(bytes.Buffer),
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(filepath.Dir(bare))
+		os.RemoveAll(dest)
+	})
+
+	Context("fetch from local repository", func() {
+		BeforeEach(func() {
+			err = opts.run()
+		})
+
+		It("Should fetch the configuration", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(filepath.Join(dest, "main.tf")).To(BeAnExistingFile())
+		})
+	})
+
+	Context("fetch with credentials", func() {
+		BeforeEach(func() {
+			credentials, _ := ioutil.TempDir("", "credentials")
+			ioutil.WriteFile(filepath.Join(credentials, "username"), []byte("user"), 0600)
+			ioutil.WriteFile(filepath.Join(credentials, "password"), []byte("pass"), 0600)
+			opts.credentials = credentials
+			err = opts.run()
+			os.RemoveAll(credentials)
+		})
+
+		It("Should fetch the configuration", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(filepath.Join(dest, "main.tf")).To(BeAnExistingFile())
+		})
+	})
+
+	Context("fetch from missing repository", func() {
+		BeforeEach(func() {
+			opts.url = "file:///missing/repo.git"
+			err = opts.run()
+		})
+
+		It("Should fail", func() {
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
+
+var _ = Describe("fetch archive", func() {
+	var (
+		server  *httptest.Server
+		archive []byte
+		dest    string
+		opts    *fetchArchiveOpts
+		err     error
+	)
+
+	BeforeEach(func() {
+		buf := new(bytes.Buffer)
+		gz := gzip.NewWriter(buf)
+		tw := tar.NewWriter(gz)
+		content := []byte("variable \"cidr\" {}\n")
+		tw.WriteHeader(&tar.Header{Name: "main.tf", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		tw.Write(content)
+		tw.Close()
+		gz.Close()
+		archive = buf.Bytes()
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(archive)
+		}))
+		dest, _ = ioutil.TempDir("", "tfconfig")
+		opts = &fetchArchiveOpts{
+			url:    server.URL + "/network.tar.gz",
+			sha256: fmt.Sprintf("%x", sha256.Sum256(archive)),
+			dest:   dest,
+			out:    new(bytes.Buffer),
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(dest)
+	})
+
+	It("Should fetch an archive with the expected digest", func() {
+		err = opts.run()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(filepath.Join(dest, "main.tf")).To(BeAnExistingFile())
+	})
+
+	It("Should reject an archive with other digest", func() {
+		opts.sha256 = fmt.Sprintf("%x", sha256.Sum256([]byte("other")))
+		err = opts.run()
+		Expect(err).To(HaveOccurred())
+	})
+})
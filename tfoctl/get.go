@@ -0,0 +1,92 @@
+/*
+Copyright © 2020 Pablo Chacin <pablochacin@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"io"
+
+	"github.com/pablochacin/tf-operator/pkg/client"
+)
+
+const (
+	// number of stacks requested in each page when listing
+	listPageSize = 500
+)
+
+type getOpts struct {
+	client    client.Client
+	stack     string
+	namespace string
+	output    string
+	out       io.Writer
+}
+
+// run executes the get stack command
+func (o *getOpts) run() error {
+	printer, err := newStackPrinter(o.output, false)
+	if err != nil {
+		return err
+	}
+
+	stack, err := o.client.GetStack(o.stack, o.namespace)
+	if err != nil {
+		return err
+	}
+
+	return printer.printStack(o.out, stack)
+}
+
+type listOpts struct {
+	client        client.Client
+	namespace     string
+	allNamespaces bool
+	selector      string
+	output        string
+	out           io.Writer
+}
+
+// run executes the list stacks command, requesting the stacks in pages
+func (o *listOpts) run() error {
+	printer, err := newStackPrinter(o.output, o.allNamespaces)
+	if err != nil {
+		return err
+	}
+
+	listOptions := client.ListOptions{
+		Namespace:     o.namespace,
+		LabelSelector: o.selector,
+		Limit:         listPageSize,
+	}
+	if o.allNamespaces {
+		listOptions.Namespace = ""
+	}
+
+	stacks, err := o.client.ListStacks(listOptions)
+	if err != nil {
+		return err
+	}
+	for stacks.Continue != "" {
+		listOptions.Continue = stacks.Continue
+		page, err := o.client.ListStacks(listOptions)
+		if err != nil {
+			return err
+		}
+		stacks.Items = append(stacks.Items, page.Items...)
+		stacks.Continue = page.Continue
+	}
+
+	return printer.printList(o.out, stacks)
+}
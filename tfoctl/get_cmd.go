@@ -0,0 +1,115 @@
+/*
+Copyright © 2020 Pablo Chacin <pablochacin@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+
+	"github.com/pablochacin/tf-operator/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+const (
+	outputFlagUsage = "output format. One of table, wide, json, yaml or jsonpath=TEMPLATE"
+)
+
+func newGetCmd() *cobra.Command {
+
+	var kubeconfig string
+
+	opts := &getOpts{}
+
+	cmd := &cobra.Command{
+		Use:   "get STACK",
+		Short: "Show the state of a terraform operator stack",
+		Long: `Show the state of a terraform operator stack: its phase, the status of
+its Ready condition, when it was last applied and the resources changed.
+The wide output adds the Job of the last run, the configuration applied and
+the stack's outputs, hiding the value of sensitive outputs.`,
+		Example: `
+# Show a stack
+tfoctl get MyStack
+
+# Show only the stack's phase
+tfoctl get MyStack -o jsonpath='{.status.phase}'`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := client.NewFromKubeconfig(kubeconfig)
+			if err != nil {
+				return err
+			}
+			opts.client = client
+			opts.stack = args[0]
+			opts.out = cmd.OutOrStdout()
+			return opts.run()
+		},
+	}
+
+	cmd.Flags().StringVarP(&kubeconfig, "kubeconfig", "k", "", "path to kubeconfig for cluster. If not specified, default discovery rules will apply")
+	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "default", "namespace for stack")
+	cmd.Flags().StringVarP(&opts.output, "output", "o", outputTable, outputFlagUsage)
+
+	return cmd
+}
+
+func newListCmd() *cobra.Command {
+
+	var kubeconfig string
+
+	opts := &listOpts{}
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List terraform operator stacks",
+		Long: `List the terraform operator stacks in a namespace or in all
+namespaces, optionally filtered by a label selector.`,
+		Example: `
+# List the stacks in all namespaces
+tfoctl list -A
+
+# List the production stacks as yaml
+tfoctl list -l env=prod -o yaml`,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return opts.validateArgs(cmd)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := client.NewFromKubeconfig(kubeconfig)
+			if err != nil {
+				return err
+			}
+			opts.client = client
+			opts.out = cmd.OutOrStdout()
+			return opts.run()
+		},
+	}
+
+	cmd.Flags().StringVarP(&kubeconfig, "kubeconfig", "k", "", "path to kubeconfig for cluster. If not specified, default discovery rules will apply")
+	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "default", "namespace for stacks")
+	cmd.Flags().BoolVarP(&opts.allNamespaces, "all-namespaces", "A", false, "list stacks in all namespaces")
+	cmd.Flags().StringVarP(&opts.selector, "selector", "l", "", "label selector for filtering stacks, such as env=prod")
+	cmd.Flags().StringVarP(&opts.output, "output", "o", outputTable, outputFlagUsage)
+
+	return cmd
+}
+
+// validateArgs validates the arguments
+func (opts *listOpts) validateArgs(cmd *cobra.Command) error {
+	if opts.allNamespaces && cmd.Flags().Lookup("namespace").Changed {
+		return fmt.Errorf("only one of namespace or all-namespaces can be specified")
+	}
+
+	return nil
+}
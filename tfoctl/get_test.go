@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pablochacin/tf-operator/pkg/client"
+)
+
+var _ = Describe("get", func() {
+	var (
+		output *bytes.Buffer
+		err    error
+	)
+
+	BeforeEach(func() {
+		output = new(bytes.Buffer)
+	})
+
+	It("Should print the stack", func() {
+		opts := &getOpts{
+			client:    &fakeClient{stack: newReadyStack(stackName, "default")},
+			stack:     stackName,
+			namespace: "default",
+			out:       output,
+		}
+		err = opts.run()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output.String()).To(ContainSubstring(stackName))
+	})
+
+	It("Should Return an error if the stack doesn't exist", func() {
+		opts := &getOpts{
+			client:    &fakeClient{err: client.NewNotFoundError(stackName, "Stack", "default")},
+			stack:     stackName,
+			namespace: "default",
+			out:       output,
+		}
+		err = opts.run()
+		Expect(err).To(HaveOccurred())
+		Expect(client.Is(err, client.ErrorReasonNotFound)).To(BeTrue())
+	})
+
+	It("Should list stacks", func() {
+		opts := &listOpts{
+			client:        &fakeClient{stack: newReadyStack(stackName, "default")},
+			allNamespaces: true,
+			output:        outputJSON,
+			out:           output,
+		}
+		err = opts.run()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output.String()).To(ContainSubstring(`"kind": "StackList"`))
+	})
+})
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"io"
+
+	"github.com/pablochacin/tf-operator/pkg/client"
+)
+
+type logsOpts struct {
+	client    client.Client
+	stack     string
+	namespace string
+	follow    bool
+	runNumber int64
+	previous  bool
+	out       io.Writer
+}
+
+// run executes the logs command
+func (o *logsOpts) run() error {
+	return o.client.StackLogs(o.stack, o.namespace, client.LogOptions{
+		Follow:   o.follow,
+		Run:      o.runNumber,
+		Previous: o.previous,
+	}, o.out)
+}
@@ -0,0 +1,83 @@
+/*
+Copyright © 2020 Pablo Chacin <pablochacin@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+
+	"github.com/pablochacin/tf-operator/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+func newLogsCmd() *cobra.Command {
+
+	var kubeconfig string
+
+	opts := &logsOpts{}
+
+	cmd := &cobra.Command{
+		Use:   "logs STACK",
+		Short: "Show the log of a terraform operator stack's run",
+		Long: `Show the log of the Job running the last apply or destroy of a stack,
+or of one of its previous runs. Runs are numbered in sequence, as shown in the
+stack's status. Once the Job's pods are deleted, the log archived by the
+operator when the Job finished is shown.`,
+		Example: `
+# Follow the log of the current run
+tfoctl logs MyStack -f
+
+# Show the log of the run before the last one
+tfoctl logs MyStack --previous
+
+# Show the log of run 3
+tfoctl logs MyStack --run 3`,
+		Args: cobra.ExactArgs(1),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return opts.validateArgs(cmd)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := client.NewFromKubeconfig(kubeconfig)
+			if err != nil {
+				return err
+			}
+			opts.client = client
+			opts.stack = args[0]
+			opts.out = cmd.OutOrStdout()
+			return opts.run()
+		},
+	}
+
+	cmd.Flags().StringVarP(&kubeconfig, "kubeconfig", "k", "", "path to kubeconfig for cluster. If not specified, default discovery rules will apply")
+	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "default", "namespace for stack")
+	cmd.Flags().BoolVarP(&opts.follow, "follow", "f", false, "follow the log of a running Job")
+	cmd.Flags().Int64Var(&opts.runNumber, "run", 0, "number of the run. Default is the last run")
+	cmd.Flags().BoolVar(&opts.previous, "previous", false, "show the log of the run before the last one")
+
+	return cmd
+}
+
+// validateArgs validates the arguments
+func (opts *logsOpts) validateArgs(cmd *cobra.Command) error {
+	if opts.runNumber < 0 {
+		return fmt.Errorf("run must be a positive number")
+	}
+
+	if opts.runNumber > 0 && opts.previous {
+		return fmt.Errorf("only one of run and previous can be specified")
+	}
+
+	return nil
+}
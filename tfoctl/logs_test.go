@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/cobra"
+
+	"github.com/pablochacin/tf-operator/pkg/client"
+)
+
+var _ = Describe("logs", func() {
+	var (
+		opts   *logsOpts
+		fake   *fakeClient
+		output *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		output = new(bytes.Buffer)
+		fake = &fakeClient{log: "Apply complete!\n"}
+		opts = &logsOpts{
+			client:    fake,
+			stack:     stackName,
+			namespace: "default",
+			out:       output,
+		}
+	})
+
+	It("Should write the log of the selected run", func() {
+		opts.follow = true
+		opts.runNumber = 3
+		Expect(opts.run()).To(Succeed())
+		Expect(output.String()).To(Equal("Apply complete!\n"))
+		Expect(fake.logOpts).To(Equal(&client.LogOptions{Follow: true, Run: 3}))
+	})
+
+	It("Should reject selecting a run and the previous run", func() {
+		opts.runNumber = 3
+		opts.previous = true
+		Expect(opts.validateArgs(&cobra.Command{})).NotTo(Succeed())
+	})
+})
@@ -0,0 +1,154 @@
+/*
+Copyright © 2020 Pablo Chacin <pablochacin@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pablochacin/tf-operator/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// supported formats for rendering manifests
+	manifestYAML          = "yaml"
+	manifestKustomization = "kustomization"
+
+	// name of the kustomization file in a kustomization directory
+	kustomizationFile = "kustomization.yaml"
+)
+
+var (
+	// files of the objects in a kustomization directory, in the order of
+	// the rendered objects
+	kustomizationResources = []string{"tfconfig.yaml", "tfvars.yaml", "stack.yaml"}
+)
+
+// manifestOpts defines how the manifests of a stack are rendered for
+// applying them with GitOps tools, instead of creating them in the cluster
+type manifestOpts struct {
+	// yaml or kustomization=DIR
+	output string
+
+	// path to the certificate for sealing the tfvars Secret, if any
+	sealCert string
+
+	// directory for the kustomization format
+	dir string
+}
+
+// validate checks the manifests are only rendered in a dry run, checks the
+// output format and sets the kustomization directory
+func (o *manifestOpts) validate(dryRun bool) error {
+	if o.output == "" {
+		if o.sealCert != "" {
+			return fmt.Errorf("--seal-cert requires an output format")
+		}
+		return nil
+	}
+	if !dryRun {
+		return fmt.Errorf("output format requires --dry-run")
+	}
+
+	switch {
+	case o.output == manifestYAML:
+	case strings.HasPrefix(o.output, manifestKustomization+"="):
+		o.dir = strings.TrimPrefix(o.output, manifestKustomization+"=")
+		if o.dir == "" {
+			return fmt.Errorf("kustomization directory must be specified")
+		}
+	default:
+		return fmt.Errorf("unsupported output format %q: use one of yaml or kustomization=DIR", o.output)
+	}
+	return nil
+}
+
+// render renders the manifests of a stack from local files, without
+// accessing the cluster, and prints them as a yaml stream or writes them in
+// a kustomization directory
+func (o *manifestOpts) render(out io.Writer, stack string, namespace string, configDir string, tfvars string) error {
+	renderOpts := client.RenderOptions{}
+	if o.sealCert != "" {
+		cert, err := ioutil.ReadFile(o.sealCert)
+		if err != nil {
+			return fmt.Errorf("error reading sealing certificate: %v", err)
+		}
+		renderOpts.SealingKey = cert
+	}
+
+	manifests, err := client.RenderStack(stack, namespace, configDir, tfvars, renderOpts)
+	if err != nil {
+		return err
+	}
+	objs, err := manifests.Objects()
+	if err != nil {
+		return err
+	}
+
+	docs := [][]byte{}
+	for _, obj := range objs {
+		doc, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		docs = append(docs, doc)
+	}
+
+	if o.dir == "" {
+		for _, doc := range docs {
+			fmt.Fprintf(out, "---\n%s", doc)
+		}
+		return nil
+	}
+
+	err = writeKustomization(o.dir, docs)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "manifests of stack %s written to %s\n", stack, o.dir)
+	return nil
+}
+
+// writeKustomization writes the manifests in a directory, one per file, with
+// a kustomization file listing them. Existing files are overwritten.
+func writeKustomization(dir string, docs [][]byte) error {
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	for i, doc := range docs {
+		err = ioutil.WriteFile(filepath.Join(dir, kustomizationResources[i]), doc, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	kustomization, err := yaml.Marshal(map[string]interface{}{
+		"apiVersion": "kustomize.config.k8s.io/v1beta1",
+		"kind":       "Kustomization",
+		"resources":  kustomizationResources,
+	})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, kustomizationFile), kustomization, 0644)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"sigs.k8s.io/yaml"
+)
+
+var _ = Describe("render manifests", func() {
+	var (
+		opts   *createOpts
+		output *bytes.Buffer
+		tfDir  string
+		err    error
+	)
+
+	BeforeEach(func() {
+		tfDir, err = ioutil.TempDir("", "terraform")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ioutil.WriteFile(filepath.Join(tfDir, "main.tf"), []byte(`variable "region" {}`), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(tfDir, "terraform.tfvars"), []byte(`region = "eu"`), 0644)).To(Succeed())
+
+		output = new(bytes.Buffer)
+		opts = &createOpts{
+			stack:     stackName,
+			namespace: "default",
+			configDir: tfDir,
+			tfvars:    filepath.Join(tfDir, "terraform.tfvars"),
+			dryRun:    true,
+			manifests: manifestOpts{output: manifestYAML},
+			out:       output,
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tfDir)
+	})
+
+	It("Should print the manifests without accessing the cluster", func() {
+		Expect(opts.manifests.validate(true)).To(Succeed())
+		Expect(opts.run()).To(Succeed())
+		Expect(output.String()).To(MatchRegexp(`(?s)kind: ConfigMap.*---\n.*kind: Secret.*---\n.*kind: Stack`))
+		Expect(output.String()).To(ContainSubstring("immutable: true"))
+		Expect(output.String()).NotTo(ContainSubstring("creationTimestamp"))
+	})
+
+	It("Should write a kustomization directory", func() {
+		dir := filepath.Join(tfDir, "deploy")
+		opts.manifests.output = "kustomization=" + dir
+		Expect(opts.manifests.validate(true)).To(Succeed())
+		Expect(opts.run()).To(Succeed())
+
+		content, err := ioutil.ReadFile(filepath.Join(dir, kustomizationFile))
+		Expect(err).NotTo(HaveOccurred())
+		kustomization := map[string]interface{}{}
+		Expect(yaml.Unmarshal(content, &kustomization)).To(Succeed())
+		Expect(kustomization["resources"]).To(ConsistOf("tfconfig.yaml", "tfvars.yaml", "stack.yaml"))
+
+		stack, err := ioutil.ReadFile(filepath.Join(dir, "stack.yaml"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(stack)).To(ContainSubstring("name: " + stackName))
+	})
+
+	It("Should only render manifests in a dry run", func() {
+		Expect(opts.manifests.validate(false)).NotTo(Succeed())
+		Expect((&manifestOpts{sealCert: "cert.pem"}).validate(true)).NotTo(Succeed())
+		Expect((&manifestOpts{output: "json"}).validate(true)).NotTo(Succeed())
+		Expect((&manifestOpts{output: "kustomization="}).validate(true)).NotTo(Succeed())
+	})
+})
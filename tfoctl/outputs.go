@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pablochacin/tf-operator/pkg/client"
+	"github.com/pablochacin/tf-operator/pkg/terraform"
+)
+
+const (
+	// output formats supported only for outputs
+	outputExport = "export"
+	outputDotenv = "dotenv"
+	outputTfvars = "tfvars"
+)
+
+type outputsOpts struct {
+	client        client.Client
+	stack         string
+	namespace     string
+	output        string
+	showSensitive bool
+	out           io.Writer
+}
+
+// run executes the outputs command
+func (o *outputsOpts) run() error {
+	outputs, err := o.client.GetOutputs(o.stack, o.namespace, o.showSensitive)
+	if err != nil {
+		return err
+	}
+
+	switch o.output {
+	case "", outputTable:
+		return printOutputsTable(o.out, outputs)
+	case outputJSON:
+		return printOutputsJSON(o.out, outputs)
+	case outputExport, outputDotenv, outputTfvars:
+		return printOutputsAssignments(o.out, outputs, o.output)
+	}
+
+	return fmt.Errorf("unsupported output format %q", o.output)
+}
+
+// validateOutputsFormat checks the output format is supported for outputs
+func validateOutputsFormat(output string) error {
+	switch output {
+	case "", outputTable, outputJSON, outputExport, outputDotenv, outputTfvars:
+		return nil
+	}
+	return fmt.Errorf("unsupported output format %q: use one of table, json, export, dotenv or tfvars", output)
+}
+
+// sortedNames returns the names of the outputs in alphabetical order
+func sortedNames(outputs map[string]terraform.Output) []string {
+	names := []string{}
+	for name := range outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// printOutputsTable prints the outputs as a table with their name, whether
+// they are sensitive and their value
+func printOutputsTable(out io.Writer, outputs map[string]terraform.Output) error {
+	if len(outputs) == 0 {
+		_, err := fmt.Fprintln(out, "No outputs found")
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSENSITIVE\tVALUE")
+	for _, name := range sortedNames(outputs) {
+		output := outputs[name]
+		value := sensitiveValue
+		if !output.Redacted() {
+			value = output.VarValue()
+		}
+		fmt.Fprintf(w, "%s\t%t\t%s\n", name, output.Sensitive, value)
+	}
+	return w.Flush()
+}
+
+// printOutputsJSON prints the outputs in the format of terraform output -json,
+// with a null value for redacted outputs
+func printOutputsJSON(out io.Writer, outputs map[string]terraform.Output) error {
+	data, err := json.MarshalIndent(outputs, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, string(data))
+	return err
+}
+
+// printOutputsAssignments prints the outputs as shell exports, a dotenv file or
+// a tfvars file. Redacted outputs are listed as comments.
+func printOutputsAssignments(out io.Writer, outputs map[string]terraform.Output, format string) error {
+	for _, name := range sortedNames(outputs) {
+		output := outputs[name]
+		if output.Redacted() {
+			fmt.Fprintf(out, "# %s is sensitive, use --show-sensitive for including it\n", name)
+			continue
+		}
+
+		var line string
+		switch format {
+		case outputExport:
+			line = fmt.Sprintf("export %s=%s", envName(name), shellQuote(envValue(output)))
+		case outputDotenv:
+			line = fmt.Sprintf("%s=%s", envName(name), dotenvQuote(envValue(output)))
+		case outputTfvars:
+			line = fmt.Sprintf("%s = %s", name, hclValue(output.Value))
+		}
+		_, err := fmt.Fprintln(out, line)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// envName returns the name of the environment variable for an output: in
+// uppercase, replacing characters not valid in variable names with _
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// envValue returns the value of an output for an environment variable: strings
+// verbatim and any other type as compact json
+func envValue(output terraform.Output) string {
+	var str string
+	if err := json.Unmarshal(output.Value, &str); err == nil {
+		return str
+	}
+	return compactValue(output.Value)
+}
+
+// shellQuote quotes a value for the shell, using single quotes
+func shellQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+// dotenvQuote quotes a value for a dotenv file, using double quotes and
+// escaping new lines
+func dotenvQuote(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "\n", `\n`)
+	return `"` + replacer.Replace(value) + `"`
+}
+
+// hclValue returns the HCL representation of an output's json value. Json
+// values are valid HCL, except for the template sequences in strings, which
+// are escaped.
+func hclValue(value json.RawMessage) string {
+	replacer := strings.NewReplacer("${", "$${", "%{", "%%{")
+	return replacer.Replace(compactValue(value))
+}
+
+// compactValue returns a json value without insignificant white space
+func compactValue(value json.RawMessage) string {
+	compact := new(bytes.Buffer)
+	err := json.Compact(compact, value)
+	if err != nil {
+		return string(value)
+	}
+	return compact.String()
+}
@@ -0,0 +1,70 @@
+/*
+Copyright © 2020 Pablo Chacin <pablochacin@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"github.com/pablochacin/tf-operator/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+func newOutputsCmd() *cobra.Command {
+
+	var kubeconfig string
+
+	opts := &outputsOpts{}
+
+	cmd := &cobra.Command{
+		Use:   "outputs STACK",
+		Short: "Show the outputs of a terraform operator stack",
+		Long: `Show the outputs of a terraform operator stack as a table, as json (in
+the format of terraform output -json), as shell export commands, as a dotenv
+file or as a tfvars file to be used by another terraform configuration. In the
+export and dotenv formats, variable names are the output names in uppercase.
+
+The values of sensitive outputs are only shown with --show-sensitive, which
+requires permission to read the Secret with the stack's sensitive outputs.`,
+		Example: `
+# Show the outputs of a stack
+tfoctl outputs MyStack
+
+# Set the outputs as environment variables
+eval "$(tfoctl outputs MyStack -o export)"
+
+# Use the outputs, including sensitive ones, as variables of another project
+tfoctl outputs MyStack -o tfvars --show-sensitive > mystack.auto.tfvars`,
+		Args: cobra.ExactArgs(1),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return validateOutputsFormat(opts.output)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := client.NewFromKubeconfig(kubeconfig)
+			if err != nil {
+				return err
+			}
+			opts.client = client
+			opts.stack = args[0]
+			opts.out = cmd.OutOrStdout()
+			return opts.run()
+		},
+	}
+
+	cmd.Flags().StringVarP(&kubeconfig, "kubeconfig", "k", "", "path to kubeconfig for cluster. If not specified, default discovery rules will apply")
+	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "default", "namespace for stack")
+	cmd.Flags().StringVarP(&opts.output, "output", "o", outputTable, "output format. One of table, json, export, dotenv or tfvars")
+	cmd.Flags().BoolVar(&opts.showSensitive, "show-sensitive", false, "show the values of sensitive outputs")
+
+	return cmd
+}
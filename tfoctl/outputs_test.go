@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	tfoutAllTypes = `{
+  "vpc-id": {"sensitive": false, "type": "string", "value": "it's ${vpc}"},
+  "subnets": {"sensitive": false, "type": ["list", "string"], "value": [ "a", "b" ]},
+  "password": {"sensitive": true, "type": "string", "value": "s3cret"}
+}`
+)
+
+var _ = Describe("outputs", func() {
+	var (
+		opts   *outputsOpts
+		output *bytes.Buffer
+		err    error
+	)
+
+	BeforeEach(func() {
+		output = new(bytes.Buffer)
+		stack := newReadyStack(stackName, "default")
+		stack.Status.TfOutput = base64.StdEncoding.EncodeToString([]byte(tfoutAllTypes))
+		opts = &outputsOpts{
+			client:    &fakeClient{stack: stack},
+			stack:     stackName,
+			namespace: "default",
+			out:       output,
+		}
+	})
+
+	JustBeforeEach(func() {
+		err = opts.run()
+	})
+
+	Context("as a table", func() {
+		It("Should hide sensitive values", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output.String()).To(ContainSubstring("NAME"))
+			Expect(output.String()).To(ContainSubstring(sensitiveValue))
+			Expect(output.String()).NotTo(ContainSubstring("s3cret"))
+		})
+	})
+
+	Context("as json", func() {
+		BeforeEach(func() {
+			opts.output = outputJSON
+		})
+
+		It("Should print a null value for sensitive outputs", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output.String()).To(ContainSubstring(`"value": null`))
+			Expect(output.String()).NotTo(ContainSubstring("s3cret"))
+		})
+	})
+
+	Context("as shell exports", func() {
+		BeforeEach(func() {
+			opts.output = outputExport
+			opts.showSensitive = true
+		})
+
+		It("Should quote the values", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output.String()).To(Equal(
+				"export PASSWORD='s3cret'\n" +
+					`export SUBNETS='["a","b"]'` + "\n" +
+					`export VPC_ID='it'\''s ${vpc}'` + "\n",
+			))
+		})
+	})
+
+	Context("as dotenv", func() {
+		BeforeEach(func() {
+			opts.output = outputDotenv
+		})
+
+		It("Should escape the values and skip sensitive outputs", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output.String()).To(Equal(
+				"# password is sensitive, use --show-sensitive for including it\n" +
+					`SUBNETS="[\"a\",\"b\"]"` + "\n" +
+					`VPC_ID="it's \${vpc}"` + "\n",
+			))
+		})
+	})
+
+	Context("as tfvars", func() {
+		BeforeEach(func() {
+			opts.output = outputTfvars
+			opts.showSensitive = true
+		})
+
+		It("Should print HCL values", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output.String()).To(Equal(
+				`password = "s3cret"` + "\n" +
+					`subnets = ["a","b"]` + "\n" +
+					`vpc-id = "it's $${vpc}"` + "\n",
+			))
+		})
+	})
+
+	It("Should reject unsupported formats", func() {
+		Expect(validateOutputsFormat("yaml")).To(HaveOccurred())
+		Expect(validateOutputsFormat(outputTfvars)).To(Succeed())
+	})
+})
@@ -0,0 +1,271 @@
+/*
+Copyright © 2020 Pablo Chacin <pablochacin@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+	"github.com/pablochacin/tf-operator/pkg/terraform"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/client-go/util/jsonpath"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// supported output formats
+	outputTable    = "table"
+	outputWide     = "wide"
+	outputJSON     = "json"
+	outputYAML     = "yaml"
+	outputJSONPath = "jsonpath"
+
+	// value shown for missing fields in tables
+	noneValue = "<none>"
+
+	// value shown instead of sensitive outputs
+	sensitiveValue = "<sensitive>"
+)
+
+// stackPrinter prints stacks in one of the supported output formats
+type stackPrinter struct {
+	format        string
+	jsonPath      *jsonpath.JSONPath
+	showNamespace bool
+}
+
+// newStackPrinter returns a printer for an output format: table, wide, json,
+// yaml or jsonpath=TEMPLATE. Tables include the stacks' namespace if
+// showNamespace is set.
+func newStackPrinter(output string, showNamespace bool) (*stackPrinter, error) {
+	p := &stackPrinter{format: output, showNamespace: showNamespace}
+
+	switch {
+	case output == "":
+		p.format = outputTable
+	case output == outputTable, output == outputWide, output == outputJSON, output == outputYAML:
+	case strings.HasPrefix(output, outputJSONPath+"="):
+		p.format = outputJSONPath
+		p.jsonPath = jsonpath.New("output").AllowMissingKeys(true)
+		err := p.jsonPath.Parse(strings.TrimPrefix(output, outputJSONPath+"="))
+		if err != nil {
+			return nil, fmt.Errorf("invalid jsonpath template: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported output format %q: use one of table, wide, json, yaml or jsonpath=TEMPLATE", output)
+	}
+
+	return p, nil
+}
+
+// printStack prints a stack
+func (p *stackPrinter) printStack(out io.Writer, stack *tfo.Stack) error {
+	if p.format == outputTable || p.format == outputWide {
+		return p.printTable(out, []tfo.Stack{*stack})
+	}
+
+	obj := stack.DeepCopy()
+	obj.APIVersion = tfo.GroupVersion.String()
+	obj.Kind = "Stack"
+	return p.printObject(out, obj)
+}
+
+// printList prints a list of stacks
+func (p *stackPrinter) printList(out io.Writer, list *tfo.StackList) error {
+	if p.format == outputTable || p.format == outputWide {
+		if len(list.Items) == 0 {
+			_, err := fmt.Fprintln(out, "No stacks found")
+			return err
+		}
+		return p.printTable(out, list.Items)
+	}
+
+	obj := list.DeepCopy()
+	obj.APIVersion = tfo.GroupVersion.String()
+	obj.Kind = "StackList"
+	for i := range obj.Items {
+		obj.Items[i].APIVersion = obj.APIVersion
+		obj.Items[i].Kind = "Stack"
+	}
+	return p.printObject(out, obj)
+}
+
+// printObject prints an object as json, yaml or using a jsonpath template
+func (p *stackPrinter) printObject(out io.Writer, obj interface{}) error {
+	data, err := json.MarshalIndent(obj, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	switch p.format {
+	case outputYAML:
+		data, err = yaml.JSONToYAML(data)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(data)
+		return err
+	case outputJSONPath:
+		// templates refer to the json field names
+		var generic interface{}
+		err = json.Unmarshal(data, &generic)
+		if err != nil {
+			return err
+		}
+		err = p.jsonPath.Execute(out, generic)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out)
+		return err
+	}
+
+	_, err = fmt.Fprintln(out, string(data))
+	return err
+}
+
+// printTable prints stacks as a table, with additional columns for the wide format
+func (p *stackPrinter) printTable(out io.Writer, stacks []tfo.Stack) error {
+	w := tabwriter.NewWriter(out, 0, 8, 3, ' ', 0)
+
+	header := []string{"NAME", "PHASE", "READY", "LAST RUN", "CHANGES", "AGE"}
+	if p.showNamespace {
+		header = append([]string{"NAMESPACE"}, header...)
+	}
+	if p.format == outputWide {
+		header = append(header, "JOB", "CONFIG", "OUTPUTS", "MESSAGE")
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+
+	for i := range stacks {
+		stack := &stacks[i]
+		row := []string{
+			stack.Name,
+			valueOrNone(string(stack.Status.Phase)),
+			readyStatus(stack),
+			lastRun(stack),
+			changes(stack),
+			age(stack.CreationTimestamp),
+		}
+		if p.showNamespace {
+			row = append([]string{stack.Namespace}, row...)
+		}
+		if p.format == outputWide {
+			row = append(row,
+				valueOrNone(stack.Status.Job),
+				valueOrNone(config(stack)),
+				valueOrNone(outputs(stack)),
+				stack.Status.Message,
+			)
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+
+	return w.Flush()
+}
+
+// valueOrNone returns the value or a placeholder if it is empty
+func valueOrNone(value string) string {
+	if value == "" {
+		return noneValue
+	}
+	return value
+}
+
+// readyStatus returns the status of the stack's Ready condition
+func readyStatus(stack *tfo.Stack) string {
+	for _, cond := range stack.Status.Conditions {
+		if cond.Type == tfo.StackConditionReady {
+			return string(cond.Status)
+		}
+	}
+	return "Unknown"
+}
+
+// lastRun returns how long ago the last run of the stack completed or, if
+// it is still running, started
+func lastRun(stack *tfo.Stack) string {
+	run := stack.Status.LastRun
+	switch {
+	case run == nil:
+		return noneValue
+	case run.CompletionTime != nil:
+		return age(*run.CompletionTime)
+	case run.StartTime != nil:
+		return age(*run.StartTime)
+	}
+	return noneValue
+}
+
+// changes summarizes the resources added, changed and destroyed by the last run,
+// or to be by a plan, as recorded by the runner
+func changes(stack *tfo.Stack) string {
+	if stack.Status.LastRun == nil || stack.Status.LastRun.Changes == nil {
+		return noneValue
+	}
+	c := stack.Status.LastRun.Changes
+	return fmt.Sprintf("+%d ~%d -%d", c.Add, c.Change, c.Destroy)
+}
+
+// config returns the configuration applied to the stack: the ConfigMap or
+// the revision of the remote source
+func config(stack *tfo.Stack) string {
+	if stack.Spec.TfConfig.Name != "" {
+		return stack.Spec.TfConfig.Name
+	}
+	return stack.Status.SourceRevision
+}
+
+// outputs returns the stack's outputs as name=value pairs, hiding the value
+// of sensitive outputs
+func outputs(stack *tfo.Stack) string {
+	decoded, err := terraform.DecodeOutputs(stack.Status.TfOutput)
+	if err != nil {
+		return "<invalid>"
+	}
+
+	names := []string{}
+	for name := range decoded {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := []string{}
+	for _, name := range names {
+		value := sensitiveValue
+		if !decoded[name].Sensitive {
+			value = decoded[name].VarValue()
+		}
+		pairs = append(pairs, name+"="+value)
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// age returns the time elapsed since a timestamp in a human readable format
+func age(t metav1.Time) string {
+	if t.IsZero() {
+		return "<unknown>"
+	}
+	return duration.HumanDuration(time.Since(t.Time))
+}
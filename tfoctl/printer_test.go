@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	tfout = `{
+  "vpc_id": {"sensitive": false, "type": "string", "value": "vpc-123"},
+  "password": {"sensitive": true, "type": "string", "value": "s3cret"}
+}`
+)
+
+// newReadyStack returns a Ready stack with outputs
+func newReadyStack(name string, namespace string) *tfo.Stack {
+	now := metav1.Now()
+	return &tfo.Stack{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         namespace,
+			CreationTimestamp: now,
+		},
+		Spec: tfo.StackSpec{
+			TfConfig: corev1.LocalObjectReference{Name: name + "-tfconf-a"},
+		},
+		Status: tfo.StackStatus{
+			Phase: tfo.StackPhaseReady,
+			Conditions: []tfo.StackCondition{
+				{Type: tfo.StackConditionReady, Status: corev1.ConditionTrue},
+			},
+			Job: name + "-apply",
+			LastRun: &tfo.StackRun{
+				StartTime:      &now,
+				CompletionTime: &now,
+				Changes:        &tfo.ChangeSummary{Add: 2, Change: 1},
+			},
+			TfOutput: base64.StdEncoding.EncodeToString([]byte(tfout)),
+		},
+	}
+}
+
+var _ = Describe("print stacks", func() {
+	var (
+		output  *bytes.Buffer
+		printer *stackPrinter
+		stack   *tfo.Stack
+		err     error
+	)
+
+	BeforeEach(func() {
+		output = new(bytes.Buffer)
+		stack = newReadyStack(stackName, "default")
+	})
+
+	It("Should print a table", func() {
+		printer, err = newStackPrinter(outputTable, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(printer.printStack(output, stack)).To(Succeed())
+		Expect(output.String()).To(MatchRegexp(`NAME\s+PHASE\s+READY\s+LAST RUN\s+CHANGES\s+AGE`))
+		Expect(output.String()).To(MatchRegexp(stackName + `\s+Ready\s+True\s+.*\+2 ~1 -0`))
+		Expect(output.String()).NotTo(ContainSubstring("vpc-123"))
+	})
+
+	It("Should print outputs in the wide table hiding sensitive values", func() {
+		printer, _ = newStackPrinter(outputWide, false)
+		Expect(printer.printStack(output, stack)).To(Succeed())
+		Expect(output.String()).To(ContainSubstring("password=<sensitive>,vpc_id=vpc-123"))
+		Expect(output.String()).NotTo(ContainSubstring("s3cret"))
+	})
+
+	It("Should print the namespace when listing all namespaces", func() {
+		printer, _ = newStackPrinter(outputTable, true)
+		list := &tfo.StackList{Items: []tfo.Stack{*stack, *newReadyStack("other", "test")}}
+		Expect(printer.printList(output, list)).To(Succeed())
+		Expect(output.String()).To(MatchRegexp(`NAMESPACE\s+NAME`))
+		Expect(output.String()).To(MatchRegexp(`test\s+other`))
+	})
+
+	It("Should report an empty list", func() {
+		printer, _ = newStackPrinter(outputTable, false)
+		Expect(printer.printList(output, &tfo.StackList{})).To(Succeed())
+		Expect(output.String()).To(ContainSubstring("No stacks found"))
+	})
+
+	It("Should print json with the stack's kind", func() {
+		printer, _ = newStackPrinter(outputJSON, false)
+		Expect(printer.printStack(output, stack)).To(Succeed())
+		Expect(output.String()).To(ContainSubstring(`"kind": "Stack"`))
+		Expect(output.String()).To(ContainSubstring(`"phase": "Ready"`))
+	})
+
+	It("Should print yaml", func() {
+		printer, _ = newStackPrinter(outputYAML, false)
+		list := &tfo.StackList{Items: []tfo.Stack{*stack}}
+		Expect(printer.printList(output, list)).To(Succeed())
+		Expect(output.String()).To(ContainSubstring("kind: StackList"))
+		Expect(output.String()).To(ContainSubstring("name: " + stackName))
+	})
+
+	It("Should print a jsonpath template", func() {
+		printer, err = newStackPrinter("jsonpath={.metadata.name}={.status.phase}", false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(printer.printStack(output, stack)).To(Succeed())
+		Expect(output.String()).To(Equal(stackName + "=Ready\n"))
+	})
+
+	It("Should reject unsupported formats", func() {
+		_, err = newStackPrinter("xml", false)
+		Expect(err).To(HaveOccurred())
+		_, err = newStackPrinter("jsonpath={.metadata", false)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,42 @@
+/*
+Copyright © 2020 Pablo Chacin <pablochacin@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/pablochacin/tf-operator/pkg/client"
+)
+
+type rollbackOpts struct {
+	client    client.Client
+	stack     string
+	namespace string
+	revision  int64
+	out       io.Writer
+}
+
+// run executes the rollback stack command
+func (o *rollbackOpts) run() error {
+	stack, err := o.client.RollbackStack(o.stack, o.namespace, o.revision)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(o.out, "stack %s rolled back to configuration %s\n", stack.Name, stack.Spec.TfConfig.Name)
+	return nil
+}
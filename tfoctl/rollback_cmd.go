@@ -0,0 +1,78 @@
+/*
+Copyright © 2020 Pablo Chacin <pablochacin@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+
+	"github.com/pablochacin/tf-operator/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+func newRollbackCmd() *cobra.Command {
+
+	var kubeconfig string
+
+	opts := &rollbackOpts{}
+
+	cmd := &cobra.Command{
+		Use:   "rollback",
+		Short: "Roll back a stack to a previous configuration revision",
+		Long: `Roll back a stack to one of the configuration revisions in its
+history. Each configuration and tfvars applied to the stack are kept as an
+immutable ConfigMap and Secret, up to the stack's revisionHistoryLimit, and
+identified by a revision number shown in the stack's status. Rolling back
+applies the configuration and tfvars of the revision again as a new revision.`,
+		Example: `
+# Roll back to the previous revision
+tfoctl rollback -s MyStack
+
+# Roll back to revision 3
+tfoctl rollback -s MyStack --to-revision 3`,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return opts.validateArgs(cmd)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := client.NewFromKubeconfig(kubeconfig)
+			if err != nil {
+				return err
+			}
+			opts.client = client
+			opts.out = cmd.OutOrStdout()
+			return opts.run()
+		},
+	}
+
+	cmd.Flags().StringVarP(&kubeconfig, "kubeconfig", "k", "", "path to kubeconfig for cluster. If not specified, default discovery rules will apply")
+	cmd.Flags().StringVarP(&opts.stack, "stack", "s", "", "stack name")
+	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "default", "namespace for stack")
+	cmd.Flags().Int64Var(&opts.revision, "to-revision", 0, "revision to roll back to. Default is the previous revision")
+
+	return cmd
+}
+
+// validateArgs validates the arguments
+func (opts *rollbackOpts) validateArgs(cmd *cobra.Command) error {
+	if !cmd.Flags().Lookup("stack").Changed {
+		return fmt.Errorf("argument stack must be specified")
+	}
+
+	if opts.revision < 0 {
+		return fmt.Errorf("revision must be a positive number")
+	}
+
+	return nil
+}
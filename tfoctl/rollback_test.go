@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+	"github.com/pablochacin/tf-operator/pkg/client"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("rollback", func() {
+	var (
+		opts   *rollbackOpts
+		output *bytes.Buffer
+		err    error
+	)
+
+	BeforeEach(func() {
+		output = new(bytes.Buffer)
+		opts = &rollbackOpts{
+			stack:     stackName,
+			namespace: "default",
+			out:       output,
+		}
+	})
+
+	It("Should report the configuration rolled back to", func() {
+		opts.client = &fakeClient{
+			stack: &tfo.Stack{
+				ObjectMeta: metav1.ObjectMeta{Name: stackName},
+				Spec: tfo.StackSpec{
+					TfConfig: corev1.LocalObjectReference{Name: stackName + "-tfconf-a"},
+				},
+			},
+		}
+		err = opts.run()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output.String()).To(ContainSubstring(stackName + "-tfconf-a"))
+	})
+
+	It("Should Return an error for an unknown revision", func() {
+		opts.client = &fakeClient{
+			err: client.NewTFOError("revision not found", client.ErrorReasonNotFound),
+		}
+		err = opts.run()
+		Expect(err).To(HaveOccurred())
+		Expect(output.String()).To(BeEmpty())
+	})
+})
@@ -30,6 +30,21 @@ Operator Stacks.`,
 	// register subcommands
 	cmd.AddCommand(
 		newCreateCmd(),
+		newApplyCmd(),
+		newAdoptCmd(),
+		newGetCmd(),
+		newListCmd(),
+		newUpdateCmd(),
+		newFetchCmd(),
+		newRollbackCmd(),
+		newRunCmd(),
+		newDeleteCmd(),
+		newOutputsCmd(),
+		newLogsCmd(),
+		newWaitCmd(),
+		newDescribeCmd(),
+		newStateCmd(),
+		newRunnerCmd(),
 	)
 
 	return cmd
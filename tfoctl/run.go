@@ -0,0 +1,65 @@
+/*
+Copyright © 2020 Pablo Chacin <pablochacin@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+	"github.com/pablochacin/tf-operator/pkg/client"
+)
+
+type runOpts struct {
+	client      client.Client
+	stack       string
+	namespace   string
+	id          string
+	targets     []string
+	replace     []string
+	refreshOnly bool
+	vars        []string
+	out         io.Writer
+}
+
+// run executes the run command
+func (o *runOpts) run() error {
+	request := tfo.RunRequest{
+		ID:          o.id,
+		Targets:     o.targets,
+		Replace:     o.replace,
+		RefreshOnly: o.refreshOnly,
+	}
+	if len(o.vars) > 0 {
+		request.Vars = map[string]string{}
+		for _, assignment := range o.vars {
+			parts := strings.SplitN(assignment, "=", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				return fmt.Errorf("invalid variable %q: expected NAME=VALUE", assignment)
+			}
+			request.Vars[parts[0]] = parts[1]
+		}
+	}
+
+	requested, err := o.client.RequestRun(o.stack, o.namespace, request)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(o.out, "Run %s requested for stack %s. Follow it with 'tfoctl logs %s -f'\n", requested.ID, o.stack, o.stack)
+	return err
+}
@@ -0,0 +1,79 @@
+/*
+Copyright © 2020 Pablo Chacin <pablochacin@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+
+	"github.com/pablochacin/tf-operator/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+func newRunCmd() *cobra.Command {
+
+	var kubeconfig string
+
+	opts := &runOpts{}
+
+	cmd := &cobra.Command{
+		Use:   "run STACK",
+		Short: "Request a single run of a terraform operator stack",
+		Long: `Request a single run of a terraform operator stack with options that only
+apply to it: limiting the run to some modules or resources, replacing
+resources (as terraform taint does), only refreshing the state or overriding
+variables. The following runs of the stack use its spec as usual.
+
+The run starts once the running one, if any, completes. Variables overriding
+the stack's tfvars are visible in the stack and its Job, so don't use them
+for secrets.`,
+		Example: `
+# Apply only the database module
+tfoctl run MyStack --target module.db
+
+# Recreate an instance
+tfoctl run MyStack --replace aws_instance.web
+
+# Update the state with changes made outside terraform
+tfoctl run MyStack --refresh-only`,
+		Args: cobra.ExactArgs(1),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if len(opts.targets) == 0 && len(opts.replace) == 0 && !opts.refreshOnly && len(opts.vars) == 0 {
+				return fmt.Errorf("at least one of target, replace, refresh-only or var must be specified")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := client.NewFromKubeconfig(kubeconfig)
+			if err != nil {
+				return err
+			}
+			opts.client = client
+			opts.stack = args[0]
+			opts.out = cmd.OutOrStdout()
+			return opts.run()
+		},
+	}
+
+	cmd.Flags().StringVarP(&kubeconfig, "kubeconfig", "k", "", "path to kubeconfig for cluster. If not specified, default discovery rules will apply")
+	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "default", "namespace for stack")
+	cmd.Flags().StringVar(&opts.id, "id", "", "identifier of the request. If not specified, one is generated")
+	cmd.Flags().StringArrayVar(&opts.targets, "target", nil, "address of a module or resource to limit the run to. Can be repeated")
+	cmd.Flags().StringArrayVar(&opts.replace, "replace", nil, "address of a resource to replace. Can be repeated")
+	cmd.Flags().BoolVar(&opts.refreshOnly, "refresh-only", false, "only update the state with the resources' current settings")
+	cmd.Flags().StringArrayVar(&opts.vars, "var", nil, "variable overriding the stack's tfvars, as NAME=VALUE. Can be repeated")
+
+	return cmd
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+)
+
+var _ = Describe("run", func() {
+	var (
+		opts   *runOpts
+		fake   *fakeClient
+		output *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		output = new(bytes.Buffer)
+		fake = &fakeClient{}
+		opts = &runOpts{
+			client:    fake,
+			stack:     stackName,
+			namespace: "default",
+			out:       output,
+		}
+	})
+
+	It("Should request a run with the options", func() {
+		opts.targets = []string{"module.db"}
+		opts.replace = []string{"aws_instance.web"}
+		opts.vars = []string{"size=large", "tags={a = \"b=c\"}"}
+		Expect(opts.run()).To(Succeed())
+		Expect(fake.requested).To(Equal(&tfo.RunRequest{
+			ID:      "generated",
+			Targets: []string{"module.db"},
+			Replace: []string{"aws_instance.web"},
+			Vars:    map[string]string{"size": "large", "tags": "{a = \"b=c\"}"},
+		}))
+		Expect(output.String()).To(ContainSubstring("Run generated requested for stack " + stackName))
+	})
+
+	It("Should reject an invalid variable", func() {
+		opts.vars = []string{"size"}
+		Expect(opts.run()).NotTo(Succeed())
+		Expect(fake.requested).To(BeNil())
+	})
+})
@@ -0,0 +1,279 @@
+/*
+Copyright © 2020 Pablo Chacin <pablochacin@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+	"github.com/pablochacin/tf-operator/pkg/client"
+	"github.com/pablochacin/tf-operator/pkg/cmdrunner"
+	"github.com/pablochacin/tf-operator/pkg/terraform"
+)
+
+const (
+	// paths the stack's Jobs mount the configuration and tfvars at
+	defaultRunnerTfConfig = "/var/lib/tfoperator/tfconfig"
+	defaultRunnerTfVars   = "/var/lib/tfoperator"
+)
+
+var (
+	// terraform commands whose output is written to the run's log. The
+	// output of other commands, such as state pull, may have sensitive values
+	loggedCommands = map[string]bool{
+		"init":      true,
+		"workspace": true,
+		"import":    true,
+		"plan":      true,
+		"apply":     true,
+		"destroy":   true,
+	}
+)
+
+type runnerOpts struct {
+	command         string
+	stack           string
+	namespace       string
+	tfconfig        string
+	tfvars          string
+	workspace       string
+	backendOverride string
+	backendConfig   string
+	runNumber       int64
+	imports         []string
+	targets         []string
+	replace         []string
+	refreshOnly     bool
+	vars            []string
+	out             io.Writer
+
+	// records the results of the run in the stack's status
+	client client.Client
+
+	// runs the terraform commands. If not set, they are run in the
+	// runner's environment
+	cmdRunner cmdrunner.Runner
+}
+
+// run executes the runner command: initializes terraform in the tf config
+// directory and runs the command with the options of the run
+func (o *runnerOpts) run() error {
+	runOpts, err := o.runOptions()
+	if err != nil {
+		return err
+	}
+
+	// a state written in the Job's volumes would be lost with its pod, so
+	// the state is only kept in a backend
+	if o.backendOverride == "" {
+		return fmt.Errorf("stack %s has no backend for keeping its state", o.stack)
+	}
+
+	tfvars, err := findTfVars(o.tfvars)
+	if err != nil {
+		return err
+	}
+
+	runner := o.cmdRunner
+	if runner == nil {
+		// the environment has the variables taken from other stacks
+		runner = cmdrunner.New()
+		runner.SetInheritEnv(true)
+	}
+	w := terraform.NewWithCmdRunner(&loggingRunner{Runner: runner, out: o.out}, tfvars, o.tfconfig, "", o.tfconfig)
+	w.UseWorkspace(o.workspace)
+
+	// the state is kept in the backend of the override
+	override, err := ioutil.ReadFile(o.backendOverride)
+	if err != nil {
+		return err
+	}
+	err = w.UseBackendOverride(override)
+	if err != nil {
+		return err
+	}
+	if o.backendConfig != "" {
+		w.UseBackendConfig(o.backendConfig)
+	}
+
+	err = w.Init()
+	if err != nil {
+		return err
+	}
+
+	// resources are imported before planning their changes
+	if len(o.imports) > 0 && o.command != "destroy" {
+		err = o.importResources(w)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch o.command {
+	case "apply":
+		err = w.ApplyWithOptions(runOpts)
+	case "plan":
+		err = w.Plan(runOpts)
+	case "destroy":
+		err = w.Destroy()
+	default:
+		err = fmt.Errorf("unknown command %s", o.command)
+	}
+	if err != nil {
+		return err
+	}
+
+	// plans are confirmed by their changes
+	if changes := w.Changes(); changes != nil {
+		err = o.client.RecordChanges(o.stack, o.namespace, o.runNumber, &tfo.ChangeSummary{
+			Add:     int32(changes.Add),
+			Change:  int32(changes.Change),
+			Destroy: int32(changes.Destroy),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// the sensitive values are stored encrypted as they are recorded
+	outputs, err := w.Outputs()
+	if err != nil {
+		return err
+	}
+	err = o.client.RecordOutputs(o.stack, o.namespace, o.runNumber, outputs)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(o.out, "%s of stack %s completed\n", o.command, o.stack)
+	return nil
+}
+
+// importResources imports the resources missing in the state, recording
+// the result of each import in the stack's status. The run fails if any of
+// them fails, as its changes would create the resource again.
+func (o *runnerOpts) importResources(w *terraform.TfWorkspace) error {
+	imports := map[string]string{}
+	for _, assignment := range o.imports {
+		address, id, err := parseImport(assignment)
+		if err != nil {
+			return err
+		}
+		imports[address] = id
+	}
+
+	results, err := w.Import(imports)
+	if err != nil {
+		return err
+	}
+
+	recorded := []tfo.ImportResult{}
+	var failed error
+	for _, result := range results {
+		recorded = append(recorded, tfo.ImportResult{
+			Address: result.Address,
+			ID:      result.ID,
+			Result:  tfo.ImportResultType(result.Status),
+			Message: result.Message,
+		})
+		fmt.Fprintf(o.out, "Import of %s: %s\n", result.Address, result.Status)
+		if result.Status == terraform.ImportStatusFailed && failed == nil {
+			failed = fmt.Errorf("import of %s failed: %s", result.Address, result.Message)
+		}
+	}
+
+	err = o.client.RecordImports(o.stack, o.namespace, o.runNumber, recorded)
+	if err != nil {
+		return err
+	}
+	return failed
+}
+
+// parseImport returns the address and ID of a resource to import, given as
+// ADDRESS=ID. The keys of instances, as in aws_subnet.private["a=b"], may
+// have an equal sign too.
+func parseImport(assignment string) (string, string, error) {
+	split := strings.Index(assignment, "]=") + 1
+	if split == 0 {
+		split = strings.Index(assignment, "=")
+	}
+	if split <= 0 || split == len(assignment)-1 {
+		return "", "", fmt.Errorf("invalid import %q: expected ADDRESS=ID", assignment)
+	}
+	return assignment[:split], assignment[split+1:], nil
+}
+
+// runOptions returns the options of the run
+func (o *runnerOpts) runOptions() (terraform.RunOptions, error) {
+	runOpts := terraform.RunOptions{
+		Targets:     o.targets,
+		Replace:     o.replace,
+		RefreshOnly: o.refreshOnly,
+	}
+	if len(o.vars) > 0 {
+		runOpts.Vars = map[string]string{}
+		for _, assignment := range o.vars {
+			parts := strings.SplitN(assignment, "=", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				return runOpts, fmt.Errorf("invalid variable %q: expected NAME=VALUE", assignment)
+			}
+			runOpts.Vars[parts[0]] = parts[1]
+		}
+	}
+	return runOpts, runOpts.Validate()
+}
+
+// findTfVars returns the path of the tfvars file in a directory, the only
+// file in it besides the state, as mounted from the stack's tfvars Secret
+func findTfVars(dir string) (string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	found := []string{}
+	for _, file := range files {
+		// mounted Secrets have their files linked from hidden directories
+		// with the current content
+		if strings.HasPrefix(file.Name(), ".") || file.IsDir() || file.Name() == terraform.StateKey {
+			continue
+		}
+		found = append(found, file.Name())
+	}
+	if len(found) != 1 {
+		return "", fmt.Errorf("expected a tfvars file in %s, found %d files", dir, len(found))
+	}
+
+	return filepath.Join(dir, found[0]), nil
+}
+
+// loggingRunner writes the output of the terraform commands to the run's log
+type loggingRunner struct {
+	cmdrunner.Runner
+	out io.Writer
+}
+
+func (r *loggingRunner) Run(shellCmd string, args ...string) (*cmdrunner.CmdResult, error) {
+	result, err := r.Runner.Run(shellCmd, args...)
+	if err == nil && result != nil && len(args) > 0 && loggedCommands[args[0]] {
+		io.WriteString(r.out, result.Output)
+	}
+	return result, err
+}
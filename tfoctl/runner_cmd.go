@@ -0,0 +1,79 @@
+/*
+Copyright © 2020 Pablo Chacin <pablochacin@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+
+	"github.com/pablochacin/tf-operator/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+func newRunnerCmd() *cobra.Command {
+	return newRunnerCmdWithOpts(&runnerOpts{})
+}
+
+// newRunnerCmdWithOpts builds the runner command with the given options,
+// which may set the command runner for running terraform
+func newRunnerCmdWithOpts(opts *runnerOpts) *cobra.Command {
+	var kubeconfig string
+	cmd := &cobra.Command{
+		Use:   "runner COMMAND",
+		Short: "Run terraform for a stack in its Job",
+		Long: `Run a terraform command for a terraform operator stack: apply, plan or
+destroy. The configuration and tfvars are taken from the paths the stack's
+Jobs mount them at, and the state is kept in the backend of the override.
+Used by the stack's Jobs for running terraform.`,
+		Args:      cobra.ExactValidArgs(1),
+		ValidArgs: []string{"apply", "plan", "destroy"},
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if opts.stack == "" {
+				return fmt.Errorf("argument stack must be specified")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.client == nil {
+				client, err := client.NewFromKubeconfig(kubeconfig)
+				if err != nil {
+					return err
+				}
+				opts.client = client
+			}
+			opts.command = args[0]
+			opts.out = cmd.OutOrStdout()
+			return opts.run()
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.stack, "stack", "", "name of the stack")
+	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "default", "namespace for stack")
+	cmd.Flags().StringVar(&opts.tfconfig, "tfconfig", defaultRunnerTfConfig, "path to the directory with the configuration, where terraform runs")
+	cmd.Flags().StringVar(&opts.tfvars, "tfvars", defaultRunnerTfVars, "path to the directory with the tfvars file")
+	cmd.Flags().StringVar(&opts.workspace, "workspace", "", "terraform workspace to select before running the command, created if it doesn't exist")
+	cmd.Flags().StringVar(&opts.backendOverride, "backend-override", "", "path to a terraform file overriding the backend keeping the state. Required")
+	cmd.Flags().StringVar(&opts.backendConfig, "backend-config", "", "path to a backend config file with the settings of the backend for terraform init")
+	cmd.Flags().Int64Var(&opts.runNumber, "run", 0, "number of the stack's run executed by the command")
+	cmd.Flags().StringArrayVar(&opts.imports, "import", nil, "resource to import before running the command if missing in the state, as ADDRESS=ID. Can be repeated")
+	cmd.Flags().StringArrayVar(&opts.targets, "target", nil, "address of a module or resource to limit the run to. Can be repeated")
+	cmd.Flags().StringArrayVar(&opts.replace, "replace", nil, "address of a resource to replace. Can be repeated")
+	cmd.Flags().BoolVar(&opts.refreshOnly, "refresh-only", false, "only update the state with the resources' current settings")
+	cmd.Flags().StringArrayVar(&opts.vars, "var", nil, "variable overriding the stack's tfvars, as NAME=VALUE. Can be repeated")
+
+	cmd.Flags().StringVarP(&kubeconfig, "kubeconfig", "k", "", "path to kubeconfig for cluster. If not specified, default discovery rules will apply")
+
+	return cmd
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/cobra"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+	"github.com/pablochacin/tf-operator/pkg/cmdrunner"
+	"github.com/pablochacin/tf-operator/pkg/jobs"
+)
+
+// fakeCmdRunner records the commands run, returning the given results in
+// order and then the same result. terraform output returns the outputs and
+// terraform state pull an empty state.
+type fakeCmdRunner struct {
+	calls   [][]string
+	results []*cmdrunner.CmdResult
+	result  *cmdrunner.CmdResult
+	outputs string
+}
+
+func (r *fakeCmdRunner) Run(shellCmd string, args ...string) (*cmdrunner.CmdResult, error) {
+	r.calls = append(r.calls, args)
+	if args[0] == "output" {
+		return &cmdrunner.CmdResult{Output: r.outputs}, nil
+	}
+	if len(args) > 1 && args[0] == "state" && args[1] == "pull" {
+		return &cmdrunner.CmdResult{}, nil
+	}
+	if len(r.results) > 0 {
+		result := r.results[0]
+		r.results = r.results[1:]
+		return result, nil
+	}
+	return r.result, nil
+}
+
+func (r *fakeCmdRunner) SetWorkDir(path string) error         { return nil }
+func (r *fakeCmdRunner) SetInheritEnv(inherit bool)           {}
+func (r *fakeCmdRunner) SetEnv(env map[string]string)         {}
+func (r *fakeCmdRunner) AddEnv(variable string, value string) {}
+
+// jobArgs returns the arguments of the runner command in the Job built for
+// a config, checking the Job runs it
+func jobArgs(cfg *jobs.JobConfig) []string {
+	job, err := jobs.BuildJob(cfg)
+	Expect(err).NotTo(HaveOccurred())
+	container := job.Spec.Template.Spec.Containers[0]
+	Expect(container.Command[:2]).To(Equal([]string{"tfoctl", "runner"}))
+	return append(container.Command[2:], container.Args...)
+}
+
+// backend override of the state backend mounted in the Jobs
+const stateBackendOverride = "terraform {\n  backend \"http\" {}\n}\n"
+
+var _ = Describe("runner", func() {
+	var (
+		cfg        *jobs.JobConfig
+		fake       *fakeCmdRunner
+		client     *fakeClient
+		opts       *runnerOpts
+		output     *bytes.Buffer
+		dir        string
+		tfconfig   string
+		tfvarsDir  string
+		backendDir string
+	)
+
+	BeforeEach(func() {
+		cfg = &jobs.JobConfig{
+			Command:   "plan",
+			Args:      []string{"--target", "module.db", "--var", "size=large"},
+			Namespace: "default",
+			Stack:     stackName,
+			TfConfig:  stackName + "-tfconf",
+			Tfvars:    stackName + "-tfvars",
+			Backend:   stackName + "-backend",
+		}
+
+		var err error
+		dir, err = ioutil.TempDir("", "runner")
+		Expect(err).NotTo(HaveOccurred())
+		tfconfig = filepath.Join(dir, "tfconfig")
+		tfvarsDir = filepath.Join(dir, "tfvars")
+		backendDir = filepath.Join(dir, "backend")
+		Expect(os.MkdirAll(tfconfig, os.ModePerm)).To(Succeed())
+		Expect(os.MkdirAll(tfvarsDir, os.ModePerm)).To(Succeed())
+		Expect(os.MkdirAll(backendDir, os.ModePerm)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(tfvarsDir, "prod.tfvars"), []byte(`size = "small"`), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(backendDir, "backend_override.tf"), []byte(stateBackendOverride), 0644)).To(Succeed())
+
+		output = new(bytes.Buffer)
+		fake = &fakeCmdRunner{
+			result:  &cmdrunner.CmdResult{Output: "terraform output\n"},
+			outputs: `{"password": {"sensitive": true, "type": "string", "value": "secret"}}`,
+		}
+		client = &fakeClient{}
+		opts = &runnerOpts{cmdRunner: fake, client: client}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	// execute runs the runner command with the arguments of the Job built
+	// for the config, taking the files from the test directories
+	execute := func() error {
+		args := append(jobArgs(cfg), "--tfconfig", tfconfig, "--tfvars", tfvarsDir)
+		if cfg.Backend != "" {
+			args = append(args, "--backend-override", filepath.Join(backendDir, "backend_override.tf"))
+		}
+		cmd := newRunnerCmdWithOpts(opts)
+		cmd.SetOutput(output)
+		cmd.SetArgs(args)
+		return cmd.Execute()
+	}
+
+	It("Should parse the arguments of the stack's Jobs", func() {
+		var command []string
+		root := newRootCmd()
+		job, err := jobs.BuildJob(cfg)
+		Expect(err).NotTo(HaveOccurred())
+		container := job.Spec.Template.Spec.Containers[0]
+		args := append(container.Command[1:], container.Args...)
+
+		runner, _, err := root.Find(args)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runner.Name()).To(Equal("runner"))
+		runner.RunE = func(cmd *cobra.Command, args []string) error {
+			command = args
+			return nil
+		}
+		root.SetArgs(args)
+		Expect(root.Execute()).To(Succeed())
+
+		Expect(command).To(Equal([]string{"plan"}))
+		Expect(runner.Flags().Lookup("stack").Value.String()).To(Equal(stackName))
+		Expect(runner.Flags().Lookup("namespace").Value.String()).To(Equal("default"))
+		Expect(runner.Flags().GetStringArray("target")).To(Equal([]string{"module.db"}))
+	})
+
+	It("Should run the command with the options of the run", func() {
+		Expect(execute()).To(Succeed())
+		Expect(fake.calls).To(Equal([][]string{
+			{"init", "-input=false"},
+			{"plan",
+				"-input=false",
+				"-var-file", filepath.Join(tfvarsDir, "prod.tfvars"),
+				"-target=module.db",
+				"-var", "size=large",
+			},
+			{"output", "-json"},
+		}))
+		Expect(output.String()).To(ContainSubstring("terraform output"))
+		Expect(output.String()).To(ContainSubstring("plan of stack " + stackName + " completed"))
+	})
+
+	It("Should record the outputs without logging them", func() {
+		Expect(execute()).To(Succeed())
+		Expect(client.outputs).To(HaveKey("password"))
+		Expect(client.outputs["password"].VarValue()).To(Equal("secret"))
+		Expect(output.String()).NotTo(ContainSubstring("secret"))
+	})
+
+	It("Should record the changes reported by the plan", func() {
+		fake.result = &cmdrunner.CmdResult{Output: "Plan: 1 to add, 2 to change, 0 to destroy.\n"}
+		Expect(execute()).To(Succeed())
+		Expect(client.changes).To(Equal(&tfo.ChangeSummary{Add: 1, Change: 2}))
+	})
+
+	It("Should print the changes recorded by the plan in the stack's table", func() {
+		client.stack = newReadyStack(stackName, "default")
+		client.stack.Status.LastRun.Changes = nil
+		fake.result = &cmdrunner.CmdResult{Output: "Plan: 1 to add, 0 to change, 2 to destroy.\n"}
+		Expect(execute()).To(Succeed())
+
+		printer, err := newStackPrinter(outputTable, false)
+		Expect(err).NotTo(HaveOccurred())
+		table := new(bytes.Buffer)
+		Expect(printer.printStack(table, client.stack)).To(Succeed())
+		Expect(table.String()).To(MatchRegexp(stackName + `\s+Ready\s+True\s+.*\+1 ~0 -2`))
+	})
+
+	It("Should not record changes not reported", func() {
+		Expect(execute()).To(Succeed())
+		Expect(client.changes).To(BeNil())
+	})
+
+	It("Should select the stack's workspace", func() {
+		cfg.Workspace = "stage"
+		Expect(execute()).To(Succeed())
+		Expect(fake.calls[:2]).To(Equal([][]string{
+			{"init", "-input=false"},
+			{"workspace", "select", "stage"},
+		}))
+		Expect(fake.calls[2][0]).To(Equal("plan"))
+	})
+
+	It("Should keep the state in the state backend", func() {
+		Expect(jobArgs(cfg)).To(ContainElement("--backend-override"))
+		Expect(execute()).To(Succeed())
+
+		written, err := ioutil.ReadFile(filepath.Join(tfconfig, "backend_override.tf"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(written)).To(Equal(stateBackendOverride))
+		Expect(fake.calls[1][0]).To(Equal("plan"))
+		Expect(fake.calls[1]).NotTo(ContainElement("-state"))
+	})
+
+	It("Should fail before running terraform without a backend for the state", func() {
+		cfg.Backend = ""
+		Expect(execute()).To(MatchError("stack " + stackName + " has no backend for keeping its state"))
+		Expect(fake.calls).To(BeEmpty())
+		Expect(client.outputs).To(BeNil())
+	})
+
+	It("Should keep the state in the stack's backend", func() {
+		cfg.BackendConfig = true
+		args := jobArgs(cfg)
+		Expect(args).To(ContainElement("--backend-override"))
+		Expect(args).To(ContainElement("--backend-config"))
+
+		// the files mounted from the backend Secret are taken from the
+		// test directory
+		override := []byte("terraform {\n  backend \"s3\" {}\n}\n")
+		Expect(ioutil.WriteFile(filepath.Join(backendDir, "backend_override.tf"), override, 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(backendDir, "backend.hcl"), []byte(`bucket = "states"`), 0644)).To(Succeed())
+
+		cmd := newRunnerCmdWithOpts(opts)
+		cmd.SetOutput(output)
+		cmd.SetArgs(append(args,
+			"--tfconfig", tfconfig,
+			"--tfvars", tfvarsDir,
+			"--backend-override", filepath.Join(backendDir, "backend_override.tf"),
+			"--backend-config", filepath.Join(backendDir, "backend.hcl"),
+		))
+		Expect(cmd.Execute()).To(Succeed())
+
+		written, err := ioutil.ReadFile(filepath.Join(tfconfig, "backend_override.tf"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(written).To(Equal(override))
+		Expect(fake.calls[0]).To(Equal([]string{"init", "-input=false", "-backend-config=" + filepath.Join(backendDir, "backend.hcl")}))
+		Expect(fake.calls[1][0]).To(Equal("plan"))
+		Expect(fake.calls[1]).NotTo(ContainElement("-state"))
+	})
+
+	It("Should import the resources before planning", func() {
+		cfg.Run = 3
+		cfg.Imports = map[string]string{
+			"aws_vpc.main":              "vpc-1",
+			`aws_subnet.private["a=b"]`: "subnet-1",
+		}
+		Expect(execute()).To(Succeed())
+
+		// the resources in the state are read from the backend
+		Expect(fake.calls[1]).To(Equal([]string{"state", "pull"}))
+		Expect(fake.calls[2][0]).To(Equal("import"))
+		Expect(fake.calls[2][len(fake.calls[2])-2:]).To(Equal([]string{`aws_subnet.private["a=b"]`, "subnet-1"}))
+		Expect(fake.calls[3][0]).To(Equal("import"))
+		Expect(fake.calls[3][len(fake.calls[3])-2:]).To(Equal([]string{"aws_vpc.main", "vpc-1"}))
+		Expect(fake.calls[4][0]).To(Equal("plan"))
+
+		Expect(client.importsRun).To(Equal(int64(3)))
+		Expect(client.imports).To(Equal([]tfo.ImportResult{
+			{Address: `aws_subnet.private["a=b"]`, ID: "subnet-1", Result: tfo.ImportResultImported},
+			{Address: "aws_vpc.main", ID: "vpc-1", Result: tfo.ImportResultImported},
+		}))
+	})
+
+	It("Should fail if an import fails", func() {
+		cfg.Imports = map[string]string{"aws_vpc.main": "vpc-1"}
+		fake.result = &cmdrunner.CmdResult{ExitCode: 1, Output: "Error: Cannot import non-existent remote object\n"}
+		// init succeeds
+		fake.results = []*cmdrunner.CmdResult{{}}
+		Expect(execute()).To(MatchError("import of aws_vpc.main failed: terraform import failed: Error: Cannot import non-existent remote object"))
+		Expect(client.imports).To(HaveLen(1))
+		Expect(client.imports[0].Result).To(Equal(tfo.ImportResultFailed))
+		Expect(fake.calls).To(HaveLen(3))
+	})
+
+	It("Should not import resources before destroying", func() {
+		cfg.Command = "destroy"
+		cfg.Args = nil
+		cfg.Imports = map[string]string{"aws_vpc.main": "vpc-1"}
+		Expect(execute()).To(Succeed())
+		Expect(fake.calls).To(HaveLen(3))
+		Expect(fake.calls[1][0]).To(Equal("destroy"))
+		Expect(client.imports).To(BeNil())
+	})
+
+	It("Should fail if terraform fails", func() {
+		fake.result = &cmdrunner.CmdResult{ExitCode: 1, Output: "Error: Unsupported argument\n"}
+		Expect(execute()).To(MatchError("terraform init failed: Error: Unsupported argument"))
+	})
+
+	It("Should fail without a tfvars file", func() {
+		Expect(os.Remove(filepath.Join(tfvarsDir, "prod.tfvars"))).To(Succeed())
+		Expect(execute()).NotTo(Succeed())
+		Expect(fake.calls).To(BeEmpty())
+	})
+
+	It("Should reject unknown commands", func() {
+		cfg.Command = "import"
+		Expect(execute()).NotTo(Succeed())
+		Expect(fake.calls).To(BeEmpty())
+	})
+})
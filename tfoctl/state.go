@@ -0,0 +1,271 @@
+/*
+Copyright © 2020 Pablo Chacin <pablochacin@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pablochacin/tf-operator/pkg/client"
+	"github.com/pablochacin/tf-operator/pkg/terraform"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type stateOpts struct {
+	client    client.Client
+	stack     string
+	namespace string
+	in        io.Reader
+	out       io.Writer
+}
+
+// pull writes the stored state of the stack
+func (o *stateOpts) pull() error {
+	content, err := o.client.PullState(o.stack, o.namespace)
+	if err != nil {
+		return err
+	}
+
+	_, err = o.out.Write(content)
+	return err
+}
+
+// push replaces the stored state of the stack with the content of a file, or
+// the standard input if the file is -
+func (o *stateOpts) push(file string, force bool) error {
+	var content []byte
+	var err error
+	if file == "-" {
+		content, err = ioutil.ReadAll(o.in)
+	} else {
+		content, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return err
+	}
+
+	update, err := o.client.PushState(o.stack, o.namespace, content, force)
+	if err != nil {
+		return err
+	}
+
+	return printStateUpdate(o.out, o.stack, update)
+}
+
+// list prints the addresses of the resources in the stored state, optionally
+// only the ones in the given addresses
+func (o *stateOpts) list(addresses []string) error {
+	content, err := o.client.PullState(o.stack, o.namespace)
+	if err != nil {
+		return err
+	}
+
+	resources, err := terraform.ParseStateResources(content)
+	if err != nil {
+		return err
+	}
+
+	for _, res := range resources {
+		if !inAddresses(res.Address, addresses) {
+			continue
+		}
+		_, err = fmt.Fprintln(o.out, res.Address)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// show prints the attributes of the instances of a resource in the stored state
+func (o *stateOpts) show(address string) error {
+	content, err := o.client.PullState(o.stack, o.namespace)
+	if err != nil {
+		return err
+	}
+
+	instances, err := terraform.ShowStateResource(content, address)
+	if err != nil {
+		return err
+	}
+
+	for i, instance := range instances {
+		if i > 0 {
+			fmt.Fprintln(o.out)
+		}
+		attributes := new(bytes.Buffer)
+		err = json.Indent(attributes, instance.Attributes, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(o.out, "# %s:\n%s\n", instance.Address, attributes.String())
+	}
+
+	return nil
+}
+
+// mv moves a module, resource or instance to another address in the stored state
+func (o *stateOpts) mv(src string, dest string) error {
+	update, err := o.client.MoveState(o.stack, o.namespace, src, dest)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(o.out, "Moved %s to %s\n", src, dest)
+	return printStateUpdate(o.out, o.stack, update)
+}
+
+// rm removes modules, resources or instances from the stored state
+func (o *stateOpts) rm(addresses []string) error {
+	update, err := o.client.RemoveState(o.stack, o.namespace, addresses)
+	if err != nil {
+		return err
+	}
+
+	for _, address := range addresses {
+		fmt.Fprintf(o.out, "Removed %s\n", address)
+	}
+	return printStateUpdate(o.out, o.stack, update)
+}
+
+// forceUnlock releases the state lock with the given ID
+func (o *stateOpts) forceUnlock(id string) error {
+	err := o.client.ForceUnlockState(o.stack, o.namespace, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(o.out, "State lock %s of stack %s released\n", id, o.stack)
+	return err
+}
+
+// history prints the versions in the state history of the stack
+func (o *stateOpts) history() error {
+	versions, err := o.client.ListStateVersions(o.stack, o.namespace)
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		_, err = fmt.Fprintf(o.out, "No state versions found for stack %s\n", o.stack)
+		return err
+	}
+
+	w := tabwriter.NewWriter(o.out, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tSERIAL\tLINEAGE\tRUN\tOPERATION\tAGE")
+	for _, v := range versions {
+		run := noneValue
+		if v.Run > 0 {
+			run = strconv.FormatInt(v.Run, 10)
+		}
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\t%s\n", v.Number, v.Serial, valueOrNone(v.Lineage), run, v.Operation, age(metav1.NewTime(v.Created)))
+	}
+	return w.Flush()
+}
+
+// diff prints the resource instances added, removed and changed from one
+// version of the state history to another
+func (o *stateOpts) diff(from int64, to int64) error {
+	_, fromState, err := o.client.GetStateVersion(o.stack, o.namespace, from)
+	if err != nil {
+		return err
+	}
+	_, toState, err := o.client.GetStateVersion(o.stack, o.namespace, to)
+	if err != nil {
+		return err
+	}
+
+	diff, err := terraform.DiffStates(fromState, toState)
+	if err != nil {
+		return err
+	}
+	if len(diff.Added)+len(diff.Removed)+len(diff.Changed) == 0 {
+		_, err = fmt.Fprintf(o.out, "No resource changes from version %d to %d\n", from, to)
+		return err
+	}
+
+	for _, address := range diff.Added {
+		fmt.Fprintf(o.out, "+ %s\n", address)
+	}
+	for _, address := range diff.Removed {
+		fmt.Fprintf(o.out, "- %s\n", address)
+	}
+	for _, address := range diff.Changed {
+		fmt.Fprintf(o.out, "~ %s\n", address)
+	}
+	_, err = fmt.Fprintf(o.out, "\n%d added, %d removed, %d changed\n", len(diff.Added), len(diff.Removed), len(diff.Changed))
+	return err
+}
+
+// rollback replaces the stored state with a version of the state history
+func (o *stateOpts) rollback(version int64) error {
+	update, err := o.client.RollbackState(o.stack, o.namespace, version)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(o.out, "Rolled back to version %d\n", version)
+	return printStateUpdate(o.out, o.stack, update)
+}
+
+// rotateKey encrypts the state, its versions and the sensitive outputs again
+// with the stack's active encryption key
+func (o *stateOpts) rotateKey() error {
+	rotation, err := o.client.RotateEncryptionKey(o.stack, o.namespace)
+	if err != nil {
+		return err
+	}
+
+	for _, secret := range rotation.States {
+		fmt.Fprintf(o.out, "Re-encrypted state in secret %s\n", secret)
+	}
+	if rotation.SensitiveOutputs != "" {
+		fmt.Fprintf(o.out, "Re-encrypted sensitive outputs in secret %s\n", rotation.SensitiveOutputs)
+	}
+	_, err = fmt.Fprintf(o.out, "Encryption key of stack %s rotated\n", o.stack)
+	return err
+}
+
+// printStateUpdate prints the serial of the updated state and its version in
+// the state history, or the backend configured in the stack keeping it
+func printStateUpdate(out io.Writer, stack string, update *client.StateUpdate) error {
+	if update.Backend != "" {
+		_, err := fmt.Fprintf(out, "State of stack %s updated to serial %d in its %s backend\n", stack, update.Serial, update.Backend)
+		return err
+	}
+	_, err := fmt.Fprintf(out, "State of stack %s updated to serial %d in secret %s (version %d)\n", stack, update.Serial, update.Secret, update.Version)
+	return err
+}
+
+// inAddresses indicates if a resource address is one of the given addresses,
+// or is contained in one of them. Any address matches an empty list.
+func inAddresses(address string, addresses []string) bool {
+	if len(addresses) == 0 {
+		return true
+	}
+	for _, prefix := range addresses {
+		if address == prefix || strings.HasPrefix(address, prefix+".") || strings.HasPrefix(address, prefix+"[") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,284 @@
+/*
+Copyright © 2020 Pablo Chacin <pablochacin@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/pablochacin/tf-operator/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+func newStateCmd() *cobra.Command {
+
+	var kubeconfig string
+
+	opts := &stateOpts{}
+
+	cmd := &cobra.Command{
+		Use:   "state",
+		Short: "Inspect and modify the terraform state of a stack",
+		Long: `Inspect and modify the terraform state stored for a terraform operator stack,
+as terraform state does for a local or remote state.
+
+Commands modifying the state lock it while they run, so they fail if the stack
+has a run in progress or its state is locked by another user or run, and the
+stack waits for the lock to be released before starting a new run. A lock left
+by a failed holder is released with force-unlock. Each state stored is kept as
+a version in the stack's state history, for comparing and rolling back to it.`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			client, err := client.NewFromKubeconfig(kubeconfig)
+			if err != nil {
+				return err
+			}
+			opts.client = client
+			opts.stack = args[0]
+			opts.in = cmd.InOrStdin()
+			opts.out = cmd.OutOrStdout()
+			return nil
+		},
+	}
+
+	cmd.PersistentFlags().StringVarP(&kubeconfig, "kubeconfig", "k", "", "path to kubeconfig for cluster. If not specified, default discovery rules will apply")
+	cmd.PersistentFlags().StringVarP(&opts.namespace, "namespace", "n", "default", "namespace for stack")
+
+	cmd.AddCommand(
+		newStatePullCmd(opts),
+		newStatePushCmd(opts),
+		newStateListCmd(opts),
+		newStateShowCmd(opts),
+		newStateMvCmd(opts),
+		newStateRmCmd(opts),
+		newStateForceUnlockCmd(opts),
+		newStateHistoryCmd(opts),
+		newStateDiffCmd(opts),
+		newStateRollbackCmd(opts),
+		newStateRotateKeyCmd(opts),
+	)
+
+	return cmd
+}
+
+func newStatePullCmd(opts *stateOpts) *cobra.Command {
+	return &cobra.Command{
+		Use:   "pull STACK",
+		Short: "Write the state of a stack to the standard output",
+		Example: `
+# Save the state of a stack to a local file
+tfoctl state pull MyStack > terraform.tfstate`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.pull()
+		},
+	}
+}
+
+func newStatePushCmd(opts *stateOpts) *cobra.Command {
+
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:   "push STACK FILE",
+		Short: "Replace the state of a stack",
+		Long: `Replace the state of a stack with a local state file, or the standard input
+if FILE is -. The state must have the lineage of the stored state and a higher
+serial, unless --force is set.`,
+		Example: `
+# Push a state modified locally
+tfoctl state push MyStack terraform.tfstate`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.push(args[1], force)
+		},
+	}
+
+	cmd.Flags().BoolVar(&force, "force", false, "replace the state even if its lineage or serial don't match the stored state")
+
+	return cmd
+}
+
+func newStateListCmd(opts *stateOpts) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list STACK [ADDRESS...]",
+		Short: "List the resources in the state of a stack",
+		Long: `List the addresses of the resources in the state of a stack. If addresses
+are given, only the resources in them are listed.`,
+		Example: `
+# List the resources of the network module
+tfoctl state list MyStack module.network`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.list(args[1:])
+		},
+	}
+}
+
+func newStateShowCmd(opts *stateOpts) *cobra.Command {
+	return &cobra.Command{
+		Use:   "show STACK ADDRESS",
+		Short: "Show the attributes of a resource in the state of a stack",
+		Example: `
+# Show the attributes of all the instances of a resource
+tfoctl state show MyStack aws_instance.web`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.show(args[1])
+		},
+	}
+}
+
+func newStateMvCmd(opts *stateOpts) *cobra.Command {
+	return &cobra.Command{
+		Use:   "mv STACK SOURCE DESTINATION",
+		Short: "Move an item in the state of a stack",
+		Long: `Move a module, resource or resource instance to another address in the
+state of a stack, for example after renaming a resource in the configuration.`,
+		Example: `
+# Move a resource into a module
+tfoctl state mv MyStack aws_instance.web module.web.aws_instance.web`,
+		Args: cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.mv(args[1], args[2])
+		},
+	}
+}
+
+func newStateRmCmd(opts *stateOpts) *cobra.Command {
+	return &cobra.Command{
+		Use:   "rm STACK ADDRESS...",
+		Short: "Remove items from the state of a stack",
+		Long: `Remove modules, resources or resource instances from the state of a stack.
+The removed items are no longer managed by the stack, but are not destroyed.`,
+		Example: `
+# Stop managing a bucket without destroying it
+tfoctl state rm MyStack aws_s3_bucket.logs`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.rm(args[1:])
+		},
+	}
+}
+
+func newStateForceUnlockCmd(opts *stateOpts) *cobra.Command {
+	return &cobra.Command{
+		Use:   "force-unlock STACK LOCK_ID",
+		Short: "Release the state lock of a stack",
+		Long: `Release the state lock of a stack left by a holder that failed to release it,
+such as a run whose Job was killed. The lock ID is reported by the commands
+failing because the state is locked. Releasing the lock of a holder still
+running may corrupt the state.`,
+		Example: `
+# Release the lock left by a failed run
+tfoctl state force-unlock MyStack 5c2b0f7e-2c1a-7a39-53d4-0e1e43b2f1a6`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.forceUnlock(args[1])
+		},
+	}
+}
+
+func newStateHistoryCmd(opts *stateOpts) *cobra.Command {
+	return &cobra.Command{
+		Use:   "history STACK",
+		Short: "List the versions in the state history of a stack",
+		Long: `List the versions of the state of a stack kept in its history, with their
+serial and lineage, the run or command that stored them and their age. The
+number of versions kept is set by the stack's stateHistoryLimit.`,
+		Example: `
+# List the state versions of a stack
+tfoctl state history MyStack`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.history()
+		},
+	}
+}
+
+func newStateDiffCmd(opts *stateOpts) *cobra.Command {
+	return &cobra.Command{
+		Use:   "diff STACK VERSION1 VERSION2",
+		Short: "Compare two versions of the state of a stack",
+		Long: `Compare two versions in the state history of a stack, listing the resource
+instances added (+), removed (-) and with changed attributes (~) in VERSION2.`,
+		Example: `
+# Show the resources changed by the last two versions
+tfoctl state diff MyStack 4 5`,
+		Args: cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			from, err := parseVersion(args[1])
+			if err != nil {
+				return err
+			}
+			to, err := parseVersion(args[2])
+			if err != nil {
+				return err
+			}
+			return opts.diff(from, to)
+		},
+	}
+}
+
+func newStateRollbackCmd(opts *stateOpts) *cobra.Command {
+	return &cobra.Command{
+		Use:   "rollback STACK VERSION",
+		Short: "Replace the state of a stack with a version in its history",
+		Long: `Replace the state of a stack with a version in its state history. The restored
+state gets a serial higher than the current one and is recorded as a new
+version. Resources created since the version are no longer managed by the
+stack, but are not destroyed.`,
+		Example: `
+# Restore the state before a failed change
+tfoctl state rollback MyStack 4`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			version, err := parseVersion(args[1])
+			if err != nil {
+				return err
+			}
+			return opts.rollback(version)
+		},
+	}
+}
+
+func newStateRotateKeyCmd(opts *stateOpts) *cobra.Command {
+	return &cobra.Command{
+		Use:   "rotate-key STACK",
+		Short: "Encrypt the state of a stack again with its active key",
+		Long: `Encrypt the state of a stack, its versions and its sensitive outputs again
+with new data keys wrapped by the active key in the stack's encryption keys
+Secret. After adding a new key and making it active, rotating the key
+re-encrypts the existing data, so the previous key can be removed. Data
+stored before enabling the encryption is encrypted.`,
+		Example: `
+# Rotate the key after activating the new key k2
+kubectl patch secret network-keys -p '{"stringData": {"active": "k2"}}'
+tfoctl state rotate-key network`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.rotateKey()
+		},
+	}
+}
+
+// parseVersion parses the number of a state version
+func parseVersion(arg string) (int64, error) {
+	version, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("invalid state version %q", arg)
+	}
+	return version, nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pablochacin/tf-operator/pkg/client"
+	"github.com/pablochacin/tf-operator/pkg/statestore"
+)
+
+const stackState = `{
+  "version": 4,
+  "serial": 3,
+  "lineage": "6a2c5e1d",
+  "resources": [
+    {
+      "mode": "managed",
+      "type": "aws_vpc",
+      "name": "main",
+      "provider": "provider.aws",
+      "instances": [{"attributes": {"id": "vpc-123", "cidr_block": "10.0.0.0/16"}}]
+    },
+    {
+      "module": "module.net",
+      "mode": "managed",
+      "type": "aws_subnet",
+      "name": "private",
+      "provider": "provider.aws",
+      "each": "list",
+      "instances": [
+        {"index_key": 0, "attributes": {"id": "subnet-1"}},
+        {"index_key": 1, "attributes": {"id": "subnet-2"}}
+      ]
+    }
+  ]
+}`
+
+var _ = Describe("state", func() {
+	var (
+		opts   *stateOpts
+		fake   *fakeClient
+		output *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		output = new(bytes.Buffer)
+		fake = &fakeClient{
+			state:       []byte(stackState),
+			stateUpdate: &client.StateUpdate{Secret: stackName + "-tfstate", Version: 2, Serial: 4},
+		}
+		opts = &stateOpts{
+			client:    fake,
+			stack:     stackName,
+			namespace: "default",
+			out:       output,
+		}
+	})
+
+	It("Should pull the state", func() {
+		Expect(opts.pull()).To(Succeed())
+		Expect(output.String()).To(Equal(stackState))
+	})
+
+	It("Should list the resources in the state", func() {
+		Expect(opts.list(nil)).To(Succeed())
+		Expect(output.String()).To(Equal("aws_vpc.main\nmodule.net.aws_subnet.private[0]\nmodule.net.aws_subnet.private[1]\n"))
+	})
+
+	It("Should list the resources in the given addresses", func() {
+		Expect(opts.list([]string{"module.net"})).To(Succeed())
+		Expect(output.String()).To(Equal("module.net.aws_subnet.private[0]\nmodule.net.aws_subnet.private[1]\n"))
+	})
+
+	It("Should show the attributes of a resource", func() {
+		Expect(opts.show("module.net.aws_subnet.private")).To(Succeed())
+		Expect(output.String()).To(ContainSubstring("# module.net.aws_subnet.private[0]:\n{\n    \"id\": \"subnet-1\"\n}"))
+		Expect(output.String()).To(ContainSubstring("# module.net.aws_subnet.private[1]:"))
+	})
+
+	It("Should fail showing a resource not in the state", func() {
+		Expect(opts.show("aws_vpc.other")).NotTo(Succeed())
+	})
+
+	It("Should push a state from a file", func() {
+		dir, err := ioutil.TempDir("", "tfoctl-state")
+		Expect(err).ShouldNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		file := filepath.Join(dir, "terraform.tfstate")
+		Expect(ioutil.WriteFile(file, []byte(stackState), 0644)).To(Succeed())
+
+		Expect(opts.push(file, true)).To(Succeed())
+		Expect(string(fake.pushed)).To(Equal(stackState))
+		Expect(fake.forced).To(BeTrue())
+		Expect(output.String()).To(ContainSubstring("updated to serial 4 in secret " + stackName + "-tfstate (version 2)"))
+	})
+
+	It("Should report a state pushed to the backend configured in the stack", func() {
+		fake.stateUpdate = &client.StateUpdate{Backend: "s3", Serial: 4}
+		opts.in = strings.NewReader(stackState)
+		Expect(opts.push("-", false)).To(Succeed())
+		Expect(output.String()).To(ContainSubstring("State of stack " + stackName + " updated to serial 4 in its s3 backend"))
+	})
+
+	It("Should push a state from the standard input", func() {
+		opts.in = strings.NewReader(stackState)
+		Expect(opts.push("-", false)).To(Succeed())
+		Expect(string(fake.pushed)).To(Equal(stackState))
+		Expect(fake.forced).To(BeFalse())
+	})
+
+	It("Should move a resource", func() {
+		Expect(opts.mv("aws_vpc.main", "module.net.aws_vpc.main")).To(Succeed())
+		Expect(fake.moved).To(Equal([]string{"aws_vpc.main", "module.net.aws_vpc.main"}))
+		Expect(output.String()).To(ContainSubstring("Moved aws_vpc.main to module.net.aws_vpc.main"))
+	})
+
+	It("Should remove resources", func() {
+		Expect(opts.rm([]string{"aws_vpc.main", "module.net"})).To(Succeed())
+		Expect(fake.removed).To(Equal([]string{"aws_vpc.main", "module.net"}))
+		Expect(output.String()).To(ContainSubstring("Removed module.net\n"))
+	})
+
+	It("Should report the state lock error", func() {
+		fake.withError(client.NewTFOError("state of stack is locked", client.ErrorReasonConflict))
+		err := opts.rm([]string{"aws_vpc.main"})
+		Expect(err).To(HaveOccurred())
+		Expect(fake.removed).To(BeNil())
+	})
+
+	It("Should force unlock the state", func() {
+		Expect(opts.forceUnlock("l1")).To(Succeed())
+		Expect(fake.unlocked).To(Equal("l1"))
+		Expect(output.String()).To(ContainSubstring("State lock l1 of stack"))
+	})
+
+	It("Should rotate the encryption key", func() {
+		fake.rotation = &client.KeyRotation{
+			States:           []string{stackName + "-tfstate", stackName + "-tfstate-v1"},
+			SensitiveOutputs: stackName + "-outputs",
+		}
+		Expect(opts.rotateKey()).To(Succeed())
+		Expect(output.String()).To(Equal(
+			"Re-encrypted state in secret " + stackName + "-tfstate\n" +
+				"Re-encrypted state in secret " + stackName + "-tfstate-v1\n" +
+				"Re-encrypted sensitive outputs in secret " + stackName + "-outputs\n" +
+				"Encryption key of stack " + stackName + " rotated\n",
+		))
+	})
+
+	Context("with a state history", func() {
+		BeforeEach(func() {
+			previous := strings.Replace(stackState, `"serial": 3`, `"serial": 2`, 1)
+			previous = strings.Replace(previous, "10.0.0.0/16", "10.1.0.0/16", 1)
+			previous = strings.Replace(previous, `{"index_key": 1, "attributes": {"id": "subnet-2"}}`, `{"index_key": 2, "attributes": {"id": "subnet-3"}}`, 1)
+			fake.versions = []statestore.Version{
+				{Number: 1, Serial: 2, Lineage: "6a2c5e1d", Operation: "initial", Created: time.Now()},
+				{Number: 2, Serial: 3, Lineage: "6a2c5e1d", Run: 4, Operation: "run", Created: time.Now()},
+			}
+			fake.versionStates = map[int64][]byte{1: []byte(previous), 2: []byte(stackState)}
+		})
+
+		It("Should list the versions", func() {
+			Expect(opts.history()).To(Succeed())
+			lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+			Expect(lines).To(HaveLen(3))
+			Expect(strings.Fields(lines[0])).To(Equal([]string{"VERSION", "SERIAL", "LINEAGE", "RUN", "OPERATION", "AGE"}))
+			Expect(strings.Fields(lines[1])[:5]).To(Equal([]string{"1", "2", "6a2c5e1d", "<none>", "initial"}))
+			Expect(strings.Fields(lines[2])[:5]).To(Equal([]string{"2", "3", "6a2c5e1d", "4", "run"}))
+		})
+
+		It("Should diff two versions", func() {
+			Expect(opts.diff(1, 2)).To(Succeed())
+			Expect(output.String()).To(Equal(
+				"+ module.net.aws_subnet.private[1]\n" +
+					"- module.net.aws_subnet.private[2]\n" +
+					"~ aws_vpc.main\n" +
+					"\n1 added, 1 removed, 1 changed\n",
+			))
+		})
+
+		It("Should report no changes between the same version", func() {
+			Expect(opts.diff(2, 2)).To(Succeed())
+			Expect(output.String()).To(Equal("No resource changes from version 2 to 2\n"))
+		})
+
+		It("Should fail diffing a version not in the history", func() {
+			Expect(opts.diff(1, 3)).NotTo(Succeed())
+		})
+
+		It("Should roll back to a version", func() {
+			Expect(opts.rollback(1)).To(Succeed())
+			Expect(fake.rolledBack).To(Equal(int64(1)))
+			Expect(output.String()).To(ContainSubstring("Rolled back to version 1\n"))
+		})
+	})
+})
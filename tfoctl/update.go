@@ -0,0 +1,85 @@
+/*
+Copyright © 2020 Pablo Chacin <pablochacin@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/pablochacin/tf-operator/pkg/client"
+)
+
+type updateOpts struct {
+	client    client.Client
+	stack     string
+	namespace string
+	configDir string
+	tfvars    string
+	dryRun    bool
+	manifests manifestOpts
+	out       io.Writer
+}
+
+// run executes the update stack command, showing the changes before updating.
+// If an output format is given in a dry run, only the manifests are rendered.
+func (o *updateOpts) run() error {
+	if o.dryRun && o.manifests.output != "" {
+		return o.manifests.render(o.out, o.stack, o.namespace, o.configDir, o.tfvars)
+	}
+
+	diff, err := o.client.DiffStack(o.stack, o.namespace, o.configDir, o.tfvars)
+	if err != nil {
+		return err
+	}
+
+	if diff.Empty() {
+		fmt.Fprintf(o.out, "stack %s is up to date\n", o.stack)
+		return nil
+	}
+
+	printChanges(o.out, "Configuration files", diff.Files)
+	printChanges(o.out, "Variables", diff.Vars)
+
+	if o.dryRun {
+		return nil
+	}
+
+	_, err = o.client.UpdateStack(o.stack, o.namespace, o.configDir, o.tfvars)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(o.out, "stack %s updated\n", o.stack)
+	return nil
+}
+
+// printChanges prints the items added (+), modified (~) and removed (-)
+func printChanges(out io.Writer, title string, changes client.Changes) {
+	if changes.Empty() {
+		return
+	}
+
+	fmt.Fprintf(out, "%s:\n", title)
+	for _, name := range changes.Added {
+		fmt.Fprintf(out, "  + %s\n", name)
+	}
+	for _, name := range changes.Modified {
+		fmt.Fprintf(out, "  ~ %s\n", name)
+	}
+	for _, name := range changes.Removed {
+		fmt.Fprintf(out, "  - %s\n", name)
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Copyright © 2020 Pablo Chacin <pablochacin@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"github.com/pablochacin/tf-operator/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+func newUpdateCmd() *cobra.Command {
+
+	var kubeconfig string
+
+	opts := &updateOpts{}
+
+	cmd := &cobra.Command{
+		Use:   "update",
+		Short: "Update the configuration and tfvars of a terraform operator stack",
+		Long: `Update a terraform operator stack from a terraform configuration
+directory and a tfvars file. The files and variables added, modified or
+removed with respect to the stack in the cluster are shown before updating.
+Variable values are not shown, as they may be sensitive. The new configuration
+and tfvars are stored as new revisions and the stack is updated to reference
+both at once.
+
+With --dry-run and an output format, the manifests of the stack are rendered
+as with tfoctl create --dry-run, without accessing the cluster.`,
+		Example: `
+# Show the changes in the working directory without updating the stack
+tfoctl update -s MyStack --dry-run
+
+# Update the stack from the working directory
+tfoctl update -s MyStack
+
+# Render the updated manifests of the stack as yaml
+tfoctl update -s MyStack --dry-run -o yaml`,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return opts.validateArgs(cmd)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.out = cmd.OutOrStdout()
+			if opts.dryRun && opts.manifests.output != "" {
+				return opts.run()
+			}
+			client, err := client.NewFromKubeconfig(kubeconfig)
+			if err != nil {
+				return err
+			}
+			opts.client = client
+			return opts.run()
+		},
+	}
+
+	cmd.Flags().StringVarP(&kubeconfig, "kubeconfig", "k", "", "path to kubeconfig for cluster. If not specified, default discovery rules will apply")
+	cmd.Flags().StringVarP(&opts.stack, "stack", "s", "", "stack name")
+	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "default", "namespace for stack")
+	cmd.Flags().StringVarP(&opts.configDir, "config", "c", "./", "path to the terraform configuration directory. All files not excluded by .terraformignore will be used as the stack configuration. Default is current directory")
+	cmd.Flags().StringVarP(&opts.tfvars, "vars", "v", "terraform.tfvars", "Path toterraform vars file.")
+	cmd.Flags().BoolVar(&opts.dryRun, "dry-run", false, "only show the changes, without updating the stack")
+	cmd.Flags().StringVarP(&opts.manifests.output, "output", "o", "", "in a dry run, render the manifests of the stack instead of showing the changes. One of yaml or kustomization=DIR")
+	cmd.Flags().StringVar(&opts.manifests.sealCert, "seal-cert", "", "path to the certificate of the sealed secrets controller for rendering the tfvars as a SealedSecret")
+
+	return cmd
+}
+
+// validateArgs validates the arguments
+func (opts *updateOpts) validateArgs(cmd *cobra.Command) error {
+	err := validateRequired(cmd, requiredArgs)
+	if err != nil {
+		return err
+	}
+	return opts.manifests.validate(opts.dryRun)
+}
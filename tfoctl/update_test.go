@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	tfo "github.com/pablochacin/tf-operator/api/v1alpha1"
+	"github.com/pablochacin/tf-operator/pkg/client"
+)
+
+var _ = Describe("update", func() {
+	var (
+		opts   *updateOpts
+		fake   *fakeClient
+		output *bytes.Buffer
+		err    error
+	)
+
+	BeforeEach(func() {
+		output = new(bytes.Buffer)
+		fake = &fakeClient{
+			stack: &tfo.Stack{},
+			diff: &client.StackDiff{
+				Files: client.Changes{Modified: []string{"main.tf"}},
+				Vars:  client.Changes{Added: []string{"region"}},
+			},
+		}
+		opts = &updateOpts{
+			client:    fake,
+			stack:     stackName,
+			namespace: "default",
+			out:       output,
+		}
+	})
+
+	It("Should show the changes and update the stack", func() {
+		err = opts.run()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output.String()).To(ContainSubstring("Configuration files:\n  ~ main.tf\n"))
+		Expect(output.String()).To(ContainSubstring("Variables:\n  + region\n"))
+		Expect(fake.updated).To(BeTrue())
+	})
+
+	It("Should not update the stack in a dry run", func() {
+		opts.dryRun = true
+		err = opts.run()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output.String()).To(ContainSubstring("main.tf"))
+		Expect(fake.updated).To(BeFalse())
+	})
+
+	It("Should not update a stack without changes", func() {
+		fake.diff = &client.StackDiff{}
+		err = opts.run()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output.String()).To(ContainSubstring("up to date"))
+		Expect(fake.updated).To(BeFalse())
+	})
+	It("Should render the manifests in a dry run with an output format", func() {
+		opts.client = &fakeClient{err: fmt.Errorf("unexpected access to the cluster")}
+		opts.dryRun = true
+		opts.manifests.output = manifestYAML
+		opts.configDir = "missing"
+		err = opts.run()
+		Expect(err).To(HaveOccurred())
+		Expect(client.Is(err, client.ErrorReasonFileCanNotBeAccessed)).To(BeTrue())
+	})
+})
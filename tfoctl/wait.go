@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pablochacin/tf-operator/pkg/client"
+)
+
+type waitOpts struct {
+	client    client.Client
+	stack     string
+	namespace string
+	condition client.WaitCondition
+	timeout   time.Duration
+	out       io.Writer
+}
+
+// run executes the wait command
+func (o *waitOpts) run() error {
+	_, err := o.client.WaitStack(o.stack, o.namespace, o.condition, o.timeout)
+	if err != nil {
+		return err
+	}
+
+	if o.condition == client.WaitConditionDelete {
+		fmt.Fprintf(o.out, "stack %s deleted\n", o.stack)
+		return nil
+	}
+
+	fmt.Fprintf(o.out, "stack %s condition %s met\n", o.stack, o.condition)
+	return nil
+}
+
+// parseWaitFor parses the condition to wait for: condition=Ready, delete or
+// outputs
+func parseWaitFor(waitFor string) (client.WaitCondition, error) {
+	switch {
+	case strings.EqualFold(waitFor, "condition="+string(client.WaitConditionReady)):
+		return client.WaitConditionReady, nil
+	case waitFor == string(client.WaitConditionDelete):
+		return client.WaitConditionDelete, nil
+	case waitFor == string(client.WaitConditionOutputs):
+		return client.WaitConditionOutputs, nil
+	}
+
+	return "", fmt.Errorf("unsupported condition %q: use one of condition=Ready, delete or outputs", waitFor)
+}
@@ -0,0 +1,79 @@
+/*
+Copyright © 2020 Pablo Chacin <pablochacin@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+
+	"github.com/pablochacin/tf-operator/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+func newWaitCmd() *cobra.Command {
+
+	var kubeconfig string
+	var waitFor string
+
+	opts := &waitOpts{}
+
+	cmd := &cobra.Command{
+		Use:   "wait STACK",
+		Short: "Wait for a terraform operator stack to reach a condition",
+		Long: `Wait for a terraform operator stack to be ready, to have outputs or to be
+deleted, watching its changes. The stack is ready when its last apply for the
+current spec completed. Fails with the stack's message if the apply or the
+destroy of the stack fails, or if the timeout expires.`,
+		Example: `
+# Wait for a stack to be applied
+tfoctl wait MyStack --for=condition=Ready --timeout=20m
+
+# Wait for a stack to have outputs
+tfoctl wait MyStack --for=outputs
+
+# Wait for a stack to be deleted
+tfoctl wait MyStack --for=delete`,
+		Args: cobra.ExactArgs(1),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			condition, err := parseWaitFor(waitFor)
+			if err != nil {
+				return err
+			}
+			opts.condition = condition
+
+			if opts.timeout < 0 {
+				return fmt.Errorf("timeout must be a positive duration")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := client.NewFromKubeconfig(kubeconfig)
+			if err != nil {
+				return err
+			}
+			opts.client = client
+			opts.stack = args[0]
+			opts.out = cmd.OutOrStdout()
+			return opts.run()
+		},
+	}
+
+	cmd.Flags().StringVarP(&kubeconfig, "kubeconfig", "k", "", "path to kubeconfig for cluster. If not specified, default discovery rules will apply")
+	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "default", "namespace for stack")
+	cmd.Flags().StringVar(&waitFor, "for", "condition=Ready", "condition to wait for. One of condition=Ready, delete or outputs")
+	cmd.Flags().DurationVar(&opts.timeout, "timeout", 0, "maximum time to wait. 0 means no limit")
+
+	return cmd
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pablochacin/tf-operator/pkg/client"
+)
+
+var _ = Describe("wait", func() {
+	var (
+		opts   *waitOpts
+		output *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		output = new(bytes.Buffer)
+		opts = &waitOpts{
+			client:    &fakeClient{stack: newReadyStack(stackName, "default")},
+			stack:     stackName,
+			namespace: "default",
+			condition: client.WaitConditionReady,
+			out:       output,
+		}
+	})
+
+	It("Should report the condition is met", func() {
+		Expect(opts.run()).To(Succeed())
+		Expect(output.String()).To(ContainSubstring("condition Ready met"))
+	})
+
+	It("Should Return the failure of the stack", func() {
+		opts.client = &fakeClient{err: client.NewTFOError("apply failed", client.ErrorReasonStackFailed)}
+		err := opts.run()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(Equal("apply failed"))
+	})
+
+	It("Should parse the supported conditions", func() {
+		Expect(parseWaitFor("condition=Ready")).To(Equal(client.WaitConditionReady))
+		Expect(parseWaitFor("delete")).To(Equal(client.WaitConditionDelete))
+		Expect(parseWaitFor("outputs")).To(Equal(client.WaitConditionOutputs))
+		_, err := parseWaitFor("condition=Failed")
+		Expect(err).To(HaveOccurred())
+	})
+})